@@ -12,17 +12,18 @@ import (
 )
 
 var (
-	Port         = flag.Int("port", 3000, "the listening port")
-	PrintVersion = flag.Bool("version", false, "print version and exit")
-	PrintHelp    = flag.Bool("help", false, "print help and exit")
-	LogDir       = flag.String("log-dir", "./logs", "specify the log directory")
+	Port          = flag.Int("port", 3000, "the listening port")
+	PrintVersion  = flag.Bool("version", false, "print version and exit")
+	PrintHelp     = flag.Bool("help", false, "print help and exit")
+	LogDir        = flag.String("log-dir", "./logs", "specify the log directory")
+	RotateSecrets = flag.Bool("rotate-secrets", false, "re-encrypt stored secrets with the active key and exit")
 )
 
 func printHelp() {
 	fmt.Println("New API " + Version + " - All in one API service for OpenAI API.")
 	fmt.Println("Copyright (C) 2023 JustSong. All rights reserved.")
 	fmt.Println("GitHub: https://github.com/songquanpeng/one-api")
-	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--rotate-secrets] [--version] [--help]")
 }
 
 func InitEnv() {
@@ -117,4 +118,28 @@ func initConstantEnv() {
 	constant.GenerateDefaultToken = GetEnvOrDefaultBool("GENERATE_DEFAULT_TOKEN", false)
 	// 是否启用错误日志
 	constant.ErrorLogEnabled = GetEnvOrDefaultBool("ERROR_LOG_ENABLED", false)
+	// 文件上传大小上限及批处理并发数
+	constant.MaxUploadFileMB = GetEnvOrDefault("MAX_UPLOAD_FILE_MB", 100)
+	constant.BatchRequestConcurrency = GetEnvOrDefault("BATCH_REQUEST_CONCURRENCY", 4)
+	// Prometheus 指标，METRICS_TOKEN 非空时需携带 Bearer Token 访问
+	constant.MetricsEnabled = GetEnvOrDefaultBool("METRICS_ENABLED", false)
+	constant.MetricsToken = GetEnvOrDefaultString("METRICS_TOKEN", "")
+	// OTLP 链路追踪，导出地址使用 OTEL_EXPORTER_OTLP_ENDPOINT 配置
+	constant.TracingEnabled = GetEnvOrDefaultBool("TRACING_ENABLED", false)
+	constant.TracingSampleRatio = GetEnvOrDefaultFloat("TRACING_SAMPLE_RATIO", 1)
+	// 额度账本对账间隔（分钟），0 表示不定期对账
+	constant.QuotaReconcileInterval = GetEnvOrDefault("QUOTA_RECONCILE_INTERVAL", 1440)
+	// Realtime 桥接模式使用的语音识别与语音合成模型
+	constant.RealtimeBridgeTranscriptionModel = GetEnvOrDefaultString("REALTIME_BRIDGE_TRANSCRIPTION_MODEL", "whisper-1")
+	constant.RealtimeBridgeSpeechModel = GetEnvOrDefaultString("REALTIME_BRIDGE_SPEECH_MODEL", "tts-1")
+	// 流式请求客户端断开后的处理方式：cancel 立即中断上游，drain 继续读取上游并缓存剩余内容供续传
+	constant.StreamDisconnectMode = GetEnvOrDefaultString("STREAM_DISCONNECT_MODE", "cancel")
+	// 续传缓存保留时间（秒）
+	constant.StreamResumeTTL = GetEnvOrDefault("STREAM_RESUME_TTL", 300)
+	// 对话日志内容加密密钥，为空时不加密
+	constant.ChatLogEncryptionKey = GetEnvOrDefaultString("CHAT_LOG_ENCRYPTION_KEY", "")
+	// 对话日志保留策略清理间隔（分钟），0 表示不自动清理
+	constant.ChatLogPurgeInterval = GetEnvOrDefault("CHAT_LOG_PURGE_INTERVAL", 60)
+	// 渠道密钥等敏感字段的主密钥列表，格式为 id:key,id:key，第一个用于加密，为空时不加密
+	constant.SecretEncryptionKeys = GetEnvOrDefaultString("SECRET_ENCRYPTION_KEYS", "")
 }
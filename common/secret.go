@@ -0,0 +1,192 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"one-api/constant"
+	"strings"
+	"sync"
+)
+
+// 信封加密：每个值使用随机数据密钥加密，数据密钥再由主密钥加密后与密文一起保存
+// 格式为 secret:v1:<主密钥ID>:<加密的数据密钥>:<密文>，更换主密钥时只需重新加密数据密钥
+const secretPrefix = "secret:v1:"
+
+type secretKeyRing struct {
+	activeId string
+	keys     map[string][]byte
+}
+
+var (
+	secretKeyRingLock   sync.Mutex
+	secretKeyRingSource string
+	secretKeyRingLoaded bool
+	secretKeys          *secretKeyRing
+	secretKeyRingErr    error
+)
+
+// parseAesKey 32 字节的 base64 密钥直接使用，其余情况派生
+func parseAesKey(secret string) []byte {
+	if key, err := base64.StdEncoding.DecodeString(secret); err == nil && len(key) == 32 {
+		return key
+	}
+	return DeriveAesKey(secret)
+}
+
+// parseSecretKeyRing 解析 SECRET_ENCRYPTION_KEYS，格式为 id:key,id:key，第一个为当前使用的主密钥，其余仅用于解密
+func parseSecretKeyRing(source string) (*secretKeyRing, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	ring := &secretKeyRing{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(source, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(entry, ":")
+		id, key = strings.TrimSpace(id), strings.TrimSpace(key)
+		if !ok || id == "" || key == "" {
+			return nil, fmt.Errorf("invalid SECRET_ENCRYPTION_KEYS entry, expected <id>:<key>")
+		}
+		if _, exists := ring.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate secret key id %s in SECRET_ENCRYPTION_KEYS", id)
+		}
+		if ring.activeId == "" {
+			ring.activeId = id
+		}
+		ring.keys[id] = parseAesKey(key)
+	}
+	if ring.activeId == "" {
+		return nil, nil
+	}
+	return ring, nil
+}
+
+// getSecretKeyRing 返回当前配置的主密钥，配置变化时重新解析
+func getSecretKeyRing() (*secretKeyRing, error) {
+	secretKeyRingLock.Lock()
+	defer secretKeyRingLock.Unlock()
+	if !secretKeyRingLoaded || secretKeyRingSource != constant.SecretEncryptionKeys {
+		secretKeyRingSource = constant.SecretEncryptionKeys
+		secretKeys, secretKeyRingErr = parseSecretKeyRing(secretKeyRingSource)
+		secretKeyRingLoaded = true
+	}
+	return secretKeys, secretKeyRingErr
+}
+
+// CheckSecretEncryptionKeys 校验主密钥配置，启动时调用
+func CheckSecretEncryptionKeys() error {
+	_, err := getSecretKeyRing()
+	return err
+}
+
+// IsSecretEncryptionEnabled 是否配置了主密钥
+func IsSecretEncryptionEnabled() bool {
+	ring, err := getSecretKeyRing()
+	return err == nil && ring != nil
+}
+
+// IsEncryptedSecret 值是否为加密后的格式
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// EncryptSecret 使用当前主密钥加密，未配置主密钥、值为空或已加密时原样返回
+func EncryptSecret(plaintext string) (string, error) {
+	ring, err := getSecretKeyRing()
+	if err != nil {
+		return "", err
+	}
+	if ring == nil || plaintext == "" || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := AesGcmEncrypt(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return ring.wrap(ring.activeId, dataKey, ciphertext)
+}
+
+func (ring *secretKeyRing) wrap(id string, dataKey []byte, ciphertext []byte) (string, error) {
+	wrappedKey, err := AesGcmEncrypt(ring.keys[id], dataKey)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + id + ":" + base64.StdEncoding.EncodeToString(wrappedKey) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// unwrap 解析加密值，返回主密钥ID、数据密钥与密文
+func (ring *secretKeyRing) unwrap(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed encrypted secret")
+	}
+	masterKey, ok := ring.keys[parts[0]]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("secret encrypted with unknown key id %s", parts[0])
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	dataKey, err := AesGcmDecrypt(masterKey, wrappedKey)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return parts[0], dataKey, ciphertext, nil
+}
+
+// DecryptSecret 解密 EncryptSecret 的输出，未加密的值原样返回
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	ring, err := getSecretKeyRing()
+	if err != nil {
+		return "", err
+	}
+	if ring == nil {
+		return "", errors.New("secret is encrypted but SECRET_ENCRYPTION_KEYS is not set")
+	}
+	_, dataKey, ciphertext, err := ring.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := AesGcmDecrypt(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// RewrapSecret 将明文或使用旧主密钥加密的值改为由当前主密钥加密，changed 表示值是否变化
+func RewrapSecret(value string) (result string, changed bool, err error) {
+	ring, err := getSecretKeyRing()
+	if err != nil || ring == nil || value == "" {
+		return value, false, err
+	}
+	if !IsEncryptedSecret(value) {
+		result, err = EncryptSecret(value)
+		return result, err == nil, err
+	}
+	id, dataKey, ciphertext, err := ring.unwrap(value)
+	if err != nil {
+		return value, false, err
+	}
+	if id == ring.activeId {
+		return value, false, nil
+	}
+	result, err = ring.wrap(ring.activeId, dataKey, ciphertext)
+	return result, err == nil, err
+}
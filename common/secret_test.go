@@ -0,0 +1,136 @@
+package common
+
+import (
+	"one-api/constant"
+	"strings"
+	"testing"
+)
+
+// setSecretEncryptionKeys 替换 SECRET_ENCRYPTION_KEYS，测试结束后恢复
+func setSecretEncryptionKeys(t *testing.T, keys string) {
+	t.Helper()
+	previous := constant.SecretEncryptionKeys
+	constant.SecretEncryptionKeys = keys
+	t.Cleanup(func() {
+		constant.SecretEncryptionKeys = previous
+	})
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	setSecretEncryptionKeys(t, "k1:first-master-key")
+	encrypted, err := EncryptSecret("sk-plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, secretPrefix+"k1:") || strings.Contains(encrypted, "sk-plaintext") {
+		t.Fatalf("EncryptSecret() = %q, want an envelope wrapped by k1", encrypted)
+	}
+	again, err := EncryptSecret(encrypted)
+	if err != nil || again != encrypted {
+		t.Errorf("EncryptSecret() on an encrypted value = %q, %v, want it unchanged", again, err)
+	}
+	plaintext, err := DecryptSecret(encrypted)
+	if err != nil || plaintext != "sk-plaintext" {
+		t.Errorf("DecryptSecret() = %q, %v, want sk-plaintext", plaintext, err)
+	}
+	plaintext, err = DecryptSecret("sk-legacy")
+	if err != nil || plaintext != "sk-legacy" {
+		t.Errorf("DecryptSecret() on plaintext = %q, %v, want it unchanged", plaintext, err)
+	}
+	empty, err := EncryptSecret("")
+	if err != nil || empty != "" {
+		t.Errorf("EncryptSecret(\"\") = %q, %v", empty, err)
+	}
+}
+
+func TestDecryptSecretErrors(t *testing.T) {
+	setSecretEncryptionKeys(t, "k1:first-master-key")
+	encrypted, err := EncryptSecret("sk-plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		keys  string
+		value string
+	}{
+		{name: "unknown key id", keys: "k2:second-master-key", value: encrypted},
+		{name: "wrong master key", keys: "k1:another-master-key", value: encrypted},
+		{name: "encryption disabled", keys: "", value: encrypted},
+		{name: "malformed", keys: "k1:first-master-key", value: secretPrefix + "k1:abc"},
+		{name: "tampered ciphertext", keys: "k1:first-master-key", value: encrypted[:len(encrypted)-4] + "AAA="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			constant.SecretEncryptionKeys = tt.keys
+			plaintext, err := DecryptSecret(tt.value)
+			if err == nil {
+				t.Errorf("DecryptSecret() = %q, want an error", plaintext)
+			}
+		})
+	}
+}
+
+func TestRewrapSecret(t *testing.T) {
+	setSecretEncryptionKeys(t, "k1:first-master-key")
+	old, err := EncryptSecret("sk-plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	constant.SecretEncryptionKeys = "k2:second-master-key,k1:first-master-key"
+	rewrapped, changed, err := RewrapSecret(old)
+	if err != nil || !changed {
+		t.Fatalf("RewrapSecret() changed = %v, err = %v", changed, err)
+	}
+	if !strings.HasPrefix(rewrapped, secretPrefix+"k2:") {
+		t.Errorf("RewrapSecret() = %q, want it wrapped by k2", rewrapped)
+	}
+	// 只重新加密数据密钥，密文不变
+	if old[strings.LastIndex(old, ":"):] != rewrapped[strings.LastIndex(rewrapped, ":"):] {
+		t.Errorf("RewrapSecret() re-encrypted the payload")
+	}
+	if _, changed, err := RewrapSecret(rewrapped); err != nil || changed {
+		t.Errorf("RewrapSecret() on the active key changed = %v, err = %v", changed, err)
+	}
+	wrapped, changed, err := RewrapSecret("sk-legacy")
+	if err != nil || !changed || !IsEncryptedSecret(wrapped) {
+		t.Errorf("RewrapSecret() on plaintext = %q, %v, %v", wrapped, changed, err)
+	}
+
+	// 旧主密钥移除后只能解密重新加密过的值
+	constant.SecretEncryptionKeys = "k2:second-master-key"
+	if plaintext, err := DecryptSecret(rewrapped); err != nil || plaintext != "sk-plaintext" {
+		t.Errorf("DecryptSecret() after rotation = %q, %v", plaintext, err)
+	}
+	if _, err := DecryptSecret(old); err == nil {
+		t.Errorf("DecryptSecret() with a removed key id succeeded")
+	}
+}
+
+func TestParseSecretKeyRing(t *testing.T) {
+	tests := []struct {
+		source     string
+		wantActive string
+		wantErr    bool
+	}{
+		{source: "", wantActive: ""},
+		{source: "k1:a, k2:b", wantActive: "k1"},
+		{source: "k1", wantErr: true},
+		{source: "k1:a,k1:b", wantErr: true},
+	}
+	for _, tt := range tests {
+		ring, err := parseSecretKeyRing(tt.source)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSecretKeyRing(%q) err = %v, wantErr %v", tt.source, err, tt.wantErr)
+			continue
+		}
+		active := ""
+		if ring != nil {
+			active = ring.activeId
+		}
+		if active != tt.wantActive {
+			t.Errorf("parseSecretKeyRing(%q) active = %q, want %q", tt.source, active, tt.wantActive)
+		}
+	}
+}
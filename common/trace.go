@@ -0,0 +1,103 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"one-api/constant"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("one-api")
+
+// InitTracing 初始化 OTLP 链路追踪，未启用时使用 otel 默认的空实现，返回的函数用于退出时刷新剩余 span
+func InitTracing() (func(context.Context) error, error) {
+	if !constant.TracingEnabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	// 导出地址等由 OTEL_EXPORTER_OTLP_ENDPOINT 等标准环境变量配置
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "new-api"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(constant.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	SysLog("tracing enabled")
+	return provider.Shutdown, nil
+}
+
+// StartSpan 在当前请求上下文下开启子 span 并设为当前上下文，返回的结束函数可重复调用，会结束 span 并恢复父上下文
+func StartSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) (trace.Span, func()) {
+	parent := c.Request.Context()
+	ctx, span := tracer.Start(parent, name, trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	ended := false
+	return span, func() {
+		if ended {
+			return
+		}
+		ended = true
+		span.End()
+		c.Request = c.Request.WithContext(parent)
+	}
+}
+
+// StartServerSpan 为入站请求开启根 span，会继承请求头中的 W3C traceparent
+func StartServerSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) (trace.Span, func()) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	return span, func() {
+		span.End()
+	}
+}
+
+// RelaySpanAttributes 返回当前请求的模型、渠道和分组属性
+func RelaySpanAttributes(c *gin.Context) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("relay.model", GetContextKeyString(c, constant.ContextKeyOriginalModel)),
+		attribute.String("relay.group", GetContextKeyString(c, constant.ContextKeyUsingGroup)),
+		attribute.Int("relay.channel_id", GetContextKeyInt(c, constant.ContextKeyChannelId)),
+		attribute.Int("relay.channel_type", GetContextKeyInt(c, constant.ContextKeyChannelType)),
+	}
+}
+
+// SetSpanError 将 span 标记为失败
+func SetSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// InjectTraceHeaders 向上游请求头写入 W3C traceparent
+func InjectTraceHeaders(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -5,6 +5,19 @@ type ContextKey string
 const (
 	ContextKeyOriginalModel    ContextKey = "original_model"
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
+	ContextKeyConsumeLogParams ContextKey = "consume_log_params"
+	ContextKeyGuardrailHits    ContextKey = "guardrail_hits"
+	ContextKeyFallbackFrom     ContextKey = "fallback_from"
+	// 暂存响应以便内容过滤后切换备用模型的 writer
+	ContextKeyFallbackWriter ContextKey = "fallback_writer"
+	// 请求用到的渠道能力，选择渠道时排除探测未通过的渠道
+	ContextKeyRequiredCapabilities ContextKey = "required_capabilities"
+	// 上游请求是否随请求上下文取消，仅实时桥接的内部请求开启
+	ContextKeyUpstreamCancellable ContextKey = "upstream_cancellable"
+	// 命中响应缓存，没有请求上游，不计入渠道健康、密钥用量和上游指标
+	ContextKeyResponseCacheHit ContextKey = "response_cache_hit"
+	// 本次尝试从发出上游请求到收到响应头的耗时，没有请求上游时为 0
+	ContextKeyUpstreamLatency ContextKey = "upstream_latency"
 
 	/* token related keys */
 	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
@@ -15,6 +28,11 @@ const (
 	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+	ContextKeyTokenResponseCacheTTL  ContextKey = "token_response_cache_ttl"
+	ContextKeyTokenQuotaBudget       ContextKey = "token_quota_budget"
+	ContextKeyTokenRpmLimit          ContextKey = "token_rpm_limit"
+	ContextKeyTokenOrgId             ContextKey = "token_org_id"
+	ContextKeyTokenGuardrailPolicy   ContextKey = "token_guardrail_policy"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
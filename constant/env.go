@@ -13,3 +13,17 @@ var NotifyLimitCount int
 var NotificationLimitDurationMinute int
 var GenerateDefaultToken bool
 var ErrorLogEnabled bool
+var MaxUploadFileMB int
+var BatchRequestConcurrency int
+var MetricsEnabled bool
+var MetricsToken string
+var TracingEnabled bool
+var TracingSampleRatio float64
+var QuotaReconcileInterval int
+var RealtimeBridgeTranscriptionModel string
+var RealtimeBridgeSpeechModel string
+var StreamDisconnectMode string
+var StreamResumeTTL int
+var ChatLogEncryptionKey string
+var ChatLogPurgeInterval int
+var SecretEncryptionKeys string
@@ -3,6 +3,7 @@ package constant
 type MultiKeyMode string
 
 const (
-	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
-	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	MultiKeyModeRandom    MultiKeyMode = "random"     // 随机
+	MultiKeyModePolling   MultiKeyMode = "polling"    // 轮询
+	MultiKeyModeLeastUsed MultiKeyMode = "least_used" // 最近一分钟用量最少
 )
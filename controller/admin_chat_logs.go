@@ -329,3 +329,31 @@ func AdminDeleteChatLogs(c *gin.Context) {
 		},
 	})
 }
+
+// AdminPreviewChatLogRetention reports how many chat logs the retention policies would purge (admin only)
+func AdminPreviewChatLogRetention(c *gin.Context) {
+	reports, err := service.PurgeChatLogs(c.Request.Context(), true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    reports,
+	})
+}
+
+// AdminPurgeChatLogs applies the retention policies immediately (admin only)
+func AdminPurgeChatLogs(c *gin.Context) {
+	reports, err := service.PurgeChatLogs(c.Request.Context(), false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    reports,
+	})
+}
@@ -0,0 +1,537 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	batchCompletionWindow = "24h"
+	batchMaxRequests      = 50000
+	batchMaxMetadataKeys  = 16
+)
+
+var supportedBatchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+}
+
+func abortWithBatchQueryError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		abortWithInvalidRequest(c, http.StatusNotFound, "not_found", "No such batch object")
+		return
+	}
+	abortWithInvalidRequest(c, http.StatusInternalServerError, "query_data_error", err.Error())
+}
+
+func CreateBatch(c *gin.Context) {
+	userId := c.GetInt("id")
+	var req dto.BatchCreateRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !supportedBatchEndpoints[req.Endpoint] {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_endpoint", fmt.Sprintf("Unsupported endpoint: %s", req.Endpoint))
+		return
+	}
+	if req.CompletionWindow != batchCompletionWindow {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_completion_window", "completion_window must be 24h")
+		return
+	}
+	if len(req.Metadata) > batchMaxMetadataKeys {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_metadata", fmt.Sprintf("metadata can have at most %d keys", batchMaxMetadataKeys))
+		return
+	}
+	file, err := model.GetUserFileById(req.InputFileId, userId)
+	if err != nil {
+		abortWithFileQueryError(c, err)
+		return
+	}
+	if file.Purpose != model.FilePurposeBatch {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_input_file", "input file must be uploaded with purpose batch")
+		return
+	}
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		ClientIp:         c.ClientIP(),
+		Endpoint:         req.Endpoint,
+		InputFileId:      file.Id,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedAt:        now,
+		ExpiresAt:        now + int64((24 * time.Hour).Seconds()),
+	}
+	batch.SetMetadata(req.Metadata)
+	if err = batch.Insert(); err != nil {
+		abortWithInvalidRequest(c, http.StatusInternalServerError, "update_data_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch, err := model.GetUserBatchById(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		abortWithBatchQueryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+func CancelBatch(c *gin.Context) {
+	batch, err := model.CancelUserBatch(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		abortWithBatchQueryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+func ListBatches(c *gin.Context) {
+	limit := getListLimit(c, 20, 100)
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		abortWithBatchQueryError(c, err)
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]dto.OpenAIBatch, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, batch.ToOpenAIBatch())
+	}
+	resp := dto.OpenAIListResponse{
+		Object:  "list",
+		Data:    data,
+		HasMore: hasMore,
+	}
+	if len(data) > 0 {
+		resp.FirstId = data[0].Id
+		resp.LastId = data[len(data)-1].Id
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// runningBatches 记录当前节点正在执行的批处理任务
+var runningBatches sync.Map
+
+// batchRunnerId 标识当前进程，写入执行中的任务，重启后的进程可据此识别被中断的任务
+var batchRunnerId = common.GetUUID()
+
+const (
+	batchHeartbeatInterval = 30 * time.Second
+	// 超过该时间没有心跳的任务视为执行进程已退出
+	batchHeartbeatTimeout = 3 * batchHeartbeatInterval
+)
+
+// isBatchOwnerAlive 任务由当前进程执行，或执行进程的心跳未超时
+func isBatchOwnerAlive(batch *model.Batch) bool {
+	if batch.Owner == "" {
+		return false
+	}
+	if batch.Owner == batchRunnerId {
+		return true
+	}
+	return time.Since(time.Unix(batch.HeartbeatAt, 0)) < batchHeartbeatTimeout
+}
+
+func UpdateBatchBulk() {
+	for {
+		time.Sleep(time.Duration(10) * time.Second)
+		batches, err := model.GetUnfinishedBatches(100)
+		if err != nil {
+			common.SysError("failed to get unfinished batches: " + err.Error())
+			continue
+		}
+		for _, batch := range batches {
+			if _, running := runningBatches.Load(batch.Id); running {
+				continue
+			}
+			switch batch.Status {
+			case model.BatchStatusValidating:
+				runningBatches.Store(batch.Id, true)
+				b := batch
+				gopool.Go(func() {
+					defer runningBatches.Delete(b.Id)
+					runBatch(b)
+				})
+			case model.BatchStatusCancelling:
+				// 执行中的任务由执行进程完成取消，尚未开始执行或执行已中断的任务直接标记为已取消
+				if isBatchOwnerAlive(batch) {
+					continue
+				}
+				batch.Status = model.BatchStatusCancelled
+				batch.CancelledAt = common.GetTimestamp()
+				if _, err := batch.UpdateFromStatus(model.BatchStatusCancelling); err != nil {
+					common.SysError(fmt.Sprintf("failed to cancel batch %s: %s", batch.Id, err.Error()))
+				}
+			default:
+				// 读取后刚执行完成，或仍由其他存活的进程执行
+				if isBatchOwnerAlive(batch) {
+					continue
+				}
+				// 执行进程已退出，说明服务在执行过程中重启
+				if _, err := model.InterruptBatch(batch, []dto.BatchError{{Code: "batch_interrupted", Message: "batch processing was interrupted, please resubmit"}}); err != nil {
+					common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.Id, err.Error()))
+				}
+			}
+		}
+	}
+}
+
+// failBatch 仅当数据库中的状态未被并发修改（例如取消）时才标记为失败
+func failBatch(batch *model.Batch, errs []dto.BatchError) {
+	fromStatus := batch.Status
+	batch.Status = model.BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	batch.SetErrors(errs)
+	if _, err := batch.UpdateFromStatus(fromStatus); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.Id, err.Error()))
+	}
+}
+
+func parseBatchInput(batch *model.Batch, content []byte) ([]*dto.BatchRequestInput, []dto.BatchError) {
+	var inputs []*dto.BatchRequestInput
+	var errs []dto.BatchError
+	customIds := make(map[string]bool)
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		lineNo := i + 1
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var input dto.BatchRequestInput
+		if err := common.Unmarshal(line, &input); err != nil {
+			errs = append(errs, dto.BatchError{Code: "invalid_json_line", Message: err.Error(), Line: lineNo})
+			continue
+		}
+		if input.CustomId == "" {
+			errs = append(errs, dto.BatchError{Code: "missing_required_parameter", Message: "custom_id is required", Param: "custom_id", Line: lineNo})
+			continue
+		}
+		if customIds[input.CustomId] {
+			errs = append(errs, dto.BatchError{Code: "duplicate_custom_id", Message: fmt.Sprintf("duplicate custom_id: %s", input.CustomId), Param: "custom_id", Line: lineNo})
+			continue
+		}
+		customIds[input.CustomId] = true
+		if strings.ToUpper(input.Method) != http.MethodPost {
+			errs = append(errs, dto.BatchError{Code: "invalid_method", Message: "method must be POST", Param: "method", Line: lineNo})
+			continue
+		}
+		if input.Url != batch.Endpoint {
+			errs = append(errs, dto.BatchError{Code: "mismatched_endpoint", Message: fmt.Sprintf("url %s does not match batch endpoint %s", input.Url, batch.Endpoint), Param: "url", Line: lineNo})
+			continue
+		}
+		var body struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}
+		if err := common.Unmarshal(input.Body, &body); err != nil {
+			errs = append(errs, dto.BatchError{Code: "invalid_body", Message: err.Error(), Param: "body", Line: lineNo})
+			continue
+		}
+		if body.Model == "" {
+			errs = append(errs, dto.BatchError{Code: "missing_required_parameter", Message: "body.model is required", Param: "body.model", Line: lineNo})
+			continue
+		}
+		if body.Stream {
+			errs = append(errs, dto.BatchError{Code: "invalid_body", Message: "stream is not supported in batch requests", Param: "body.stream", Line: lineNo})
+			continue
+		}
+		inputs = append(inputs, &input)
+	}
+	if len(errs) == 0 && len(inputs) == 0 {
+		errs = append(errs, dto.BatchError{Code: "empty_file", Message: "input file contains no requests"})
+	}
+	if len(inputs) > batchMaxRequests {
+		errs = append(errs, dto.BatchError{Code: "too_many_requests", Message: fmt.Sprintf("batch can contain at most %d requests", batchMaxRequests)})
+	}
+	return inputs, errs
+}
+
+func runBatch(batch *model.Batch) {
+	file, err := model.GetUserFileContent(batch.InputFileId, batch.UserId)
+	if err != nil {
+		failBatch(batch, []dto.BatchError{{Code: "invalid_input_file", Message: err.Error()}})
+		return
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		failBatch(batch, []dto.BatchError{{Code: "invalid_token", Message: err.Error()}})
+		return
+	}
+	inputs, errs := parseBatchInput(batch, file.Content)
+	if len(errs) > 0 {
+		failBatch(batch, errs)
+		return
+	}
+
+	batch.Status = model.BatchStatusInProgress
+	batch.InProgressAt = common.GetTimestamp()
+	batch.RequestTotal = len(inputs)
+	batch.Owner = batchRunnerId
+	batch.HeartbeatAt = batch.InProgressAt
+	if ok, err := batch.UpdateFromStatus(model.BatchStatusValidating); err != nil || !ok {
+		// 校验期间被取消，交由下一轮轮询处理
+		return
+	}
+	common.SysLog(fmt.Sprintf("batch %s started with %d requests", batch.Id, len(inputs)))
+
+	// 单个请求可能耗时较长，定期刷新心跳
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	gopool.Go(func() {
+		ticker := time.NewTicker(batchHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatDone:
+				return
+			case <-ticker.C:
+				if err := model.UpdateBatchHeartbeat(batch.Id, batchRunnerId); err != nil {
+					common.SysError(fmt.Sprintf("failed to update batch %s heartbeat: %s", batch.Id, err.Error()))
+				}
+			}
+		}
+	})
+
+	outputs := make([]*dto.BatchRequestOutput, len(inputs))
+	var progressLock sync.Mutex
+	var stopped bool
+	stopStatus := ""
+
+	concurrency := constant.BatchRequestConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		if !stopped {
+			if status, err := model.GetBatchStatus(batch.Id); err == nil && status == model.BatchStatusCancelling {
+				stopped = true
+				stopStatus = model.BatchStatusCancelled
+			} else if common.GetTimestamp() > batch.ExpiresAt {
+				stopped = true
+				stopStatus = model.BatchStatusExpired
+			}
+		}
+		if stopped {
+			if stopStatus == model.BatchStatusExpired {
+				outputs[i] = &dto.BatchRequestOutput{
+					Id:       "batch_req_" + common.GetRandomString(24),
+					CustomId: input.CustomId,
+					Error:    &dto.BatchError{Code: "batch_expired", Message: "This request could not be executed before the completion window expired."},
+				}
+			}
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		idx, in := i, input
+		gopool.Go(func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			output, usage := executeBatchRequest(batch, token.Key, in)
+			progressLock.Lock()
+			defer progressLock.Unlock()
+			outputs[idx] = output
+			if output.Error == nil {
+				batch.RequestCompleted++
+			} else {
+				batch.RequestFailed++
+			}
+			if usage != nil {
+				batch.PromptTokens += usage.PromptTokens + usage.InputTokens
+				batch.CompletionTokens += usage.CompletionTokens + usage.OutputTokens
+			}
+			if err := batch.UpdateProgress(); err != nil {
+				common.SysError(fmt.Sprintf("failed to update batch %s progress: %s", batch.Id, err.Error()))
+			}
+		})
+	}
+	wg.Wait()
+
+	if stopStatus == "" {
+		batch.Status = model.BatchStatusFinalizing
+		batch.FinalizingAt = common.GetTimestamp()
+		if err := batch.Update(); err != nil {
+			common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.Id, err.Error()))
+		}
+	}
+	if err := writeBatchResultFiles(batch, outputs); err != nil {
+		failBatch(batch, []dto.BatchError{{Code: "output_write_failed", Message: err.Error()}})
+		return
+	}
+	now := common.GetTimestamp()
+	switch stopStatus {
+	case model.BatchStatusCancelled:
+		batch.Status = model.BatchStatusCancelled
+		batch.CancelledAt = now
+	case model.BatchStatusExpired:
+		batch.Status = model.BatchStatusExpired
+		batch.ExpiredAt = now
+	default:
+		batch.Status = model.BatchStatusCompleted
+		batch.CompletedAt = now
+	}
+	if err := batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.Id, err.Error()))
+	}
+	common.SysLog(fmt.Sprintf("batch %s %s, completed %d, failed %d", batch.Id, batch.Status, batch.RequestCompleted, batch.RequestFailed))
+}
+
+func writeBatchResultFiles(batch *model.Batch, outputs []*dto.BatchRequestOutput) error {
+	var outputBuf, errorBuf bytes.Buffer
+	for _, output := range outputs {
+		if output == nil {
+			continue
+		}
+		line, err := common.Marshal(output)
+		if err != nil {
+			return err
+		}
+		if output.Error == nil {
+			outputBuf.Write(line)
+			outputBuf.WriteByte('\n')
+		} else {
+			errorBuf.Write(line)
+			errorBuf.WriteByte('\n')
+		}
+	}
+	if outputBuf.Len() > 0 {
+		file := &model.File{
+			UserId:   batch.UserId,
+			Filename: batch.Id + "_output.jsonl",
+			Purpose:  model.FilePurposeBatchOutput,
+			Content:  outputBuf.Bytes(),
+		}
+		if err := file.Insert(); err != nil {
+			return err
+		}
+		batch.OutputFileId = file.Id
+	}
+	if errorBuf.Len() > 0 {
+		file := &model.File{
+			UserId:   batch.UserId,
+			Filename: batch.Id + "_error.jsonl",
+			Purpose:  model.FilePurposeBatchOutput,
+			Content:  errorBuf.Bytes(),
+		}
+		if err := file.Insert(); err != nil {
+			return err
+		}
+		batch.ErrorFileId = file.Id
+	}
+	return nil
+}
+
+// batchRelayEngine 批处理中的每个请求与 /v1 中继接口经过相同的令牌鉴权、限流和渠道分发中间件
+var batchRelayEngine = sync.OnceValue(func() *gin.Engine {
+	engine := gin.New()
+	engine.Use(middleware.RequestId())
+	engine.Use(middleware.RelayTokenAuth()...)
+	for endpoint := range supportedBatchEndpoints {
+		engine.POST(endpoint, middleware.Distribute(), Relay)
+	}
+	return engine
+})
+
+// batchResponseWriter 在内存中保存单个批处理请求的响应
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *batchResponseWriter) Flush() {}
+
+// executeBatchRequest 将单个请求交给中继路由处理，复用令牌鉴权、限流、渠道选择与 Relay 的重试和计费逻辑
+func executeBatchRequest(batch *model.Batch, tokenKey string, input *dto.BatchRequestInput) (*dto.BatchRequestOutput, *dto.Usage) {
+	output := &dto.BatchRequestOutput{
+		Id:       "batch_req_" + common.GetRandomString(24),
+		CustomId: input.CustomId,
+	}
+
+	req, err := http.NewRequest(http.MethodPost, batch.Endpoint, bytes.NewReader(input.Body))
+	if err != nil {
+		output.Error = &dto.BatchError{Code: "invalid_request", Message: err.Error()}
+		return output, nil
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk-"+tokenKey)
+	req.RemoteAddr = net.JoinHostPort(batch.ClientIp, "0")
+	w := &batchResponseWriter{header: make(http.Header)}
+	batchRelayEngine().ServeHTTP(w, req)
+	requestId := w.header.Get(common.RequestIdKey)
+
+	respBody := w.body.Bytes()
+	if !json.Valid(respBody) {
+		respBody, _ = common.Marshal(string(respBody))
+	}
+	output.Response = &dto.BatchResponseBody{
+		StatusCode: w.status,
+		RequestId:  requestId,
+		Body:       respBody,
+	}
+	if w.status != http.StatusOK {
+		var errResp struct {
+			Error dto.OpenAIError `json:"error"`
+		}
+		_ = common.Unmarshal(respBody, &errResp)
+		code := "upstream_error"
+		if errResp.Error.Code != nil {
+			code = fmt.Sprintf("%v", errResp.Error.Code)
+		}
+		output.Error = &dto.BatchError{
+			Code:    code,
+			Message: errResp.Error.Message,
+		}
+		return output, nil
+	}
+	var usageResp struct {
+		Usage *dto.Usage `json:"usage"`
+	}
+	_ = common.Unmarshal(respBody, &usageResp)
+	return output, usageResp.Usage
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsBatchOwnerAlive(t *testing.T) {
+	now := common.GetTimestamp()
+	tests := []struct {
+		name  string
+		batch model.Batch
+		want  bool
+	}{
+		{name: "no owner", batch: model.Batch{HeartbeatAt: now}, want: false},
+		{name: "current process", batch: model.Batch{Owner: batchRunnerId}, want: true},
+		{name: "other process with fresh heartbeat", batch: model.Batch{Owner: "other", HeartbeatAt: now - 10}, want: true},
+		{name: "other process timed out", batch: model.Batch{Owner: "other", HeartbeatAt: now - int64(batchHeartbeatTimeout.Seconds()) - 1}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBatchOwnerAlive(&tt.batch); got != tt.want {
+				t.Errorf("isBatchOwnerAlive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// createBatchTestToken 创建启用的用户及其令牌
+func createBatchTestToken(t *testing.T, allowIps string, rpmLimit int) *model.Token {
+	t.Helper()
+	user := &model.User{Id: 1, Username: "batch", Status: common.UserStatusEnabled, Group: "default", Quota: 1000000}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := &model.Token{UserId: user.Id, Key: "batchtestkey", Name: "batch", Status: common.TokenStatusEnabled,
+		ExpiredTime: -1, UnlimitedQuota: true, AllowIps: &allowIps, RpmLimit: rpmLimit}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestExecuteBatchRequestUsesRelayMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		allowIps   string
+		rpmLimit   int
+		clientIp   string
+		wantStatus []int
+	}{
+		// 请求的模型没有渠道，通过鉴权后由渠道分发返回 503
+		{name: "no channel", clientIp: "10.0.0.1", wantStatus: []int{http.StatusServiceUnavailable}},
+		{name: "ip not allowed", allowIps: "10.0.0.1", clientIp: "10.0.0.2", wantStatus: []int{http.StatusForbidden}},
+		{name: "ip allowed", allowIps: "10.0.0.1", clientIp: "10.0.0.1", wantStatus: []int{http.StatusServiceUnavailable}},
+		{name: "token rpm limit", rpmLimit: 1, clientIp: "10.0.0.1", wantStatus: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFallbackTest(t, false)
+			token := createBatchTestToken(t, tt.allowIps, tt.rpmLimit)
+			batch := &model.Batch{Id: model.NewBatchId(), UserId: token.UserId, TokenId: token.Id,
+				Endpoint: "/v1/chat/completions", ClientIp: tt.clientIp}
+			for i, wantStatus := range tt.wantStatus {
+				input := &dto.BatchRequestInput{CustomId: fmt.Sprintf("req-%d", i), Method: http.MethodPost, Url: batch.Endpoint,
+					Body: []byte(`{"model":"model-x","messages":[{"role":"user","content":"hi"}]}`)}
+				output, _ := executeBatchRequest(batch, token.Key, input)
+				if output.Response == nil || output.Response.StatusCode != wantStatus {
+					t.Fatalf("request %d response = %+v, want status %d", i, output.Response, wantStatus)
+				}
+				if output.Error == nil || output.Response.RequestId == "" {
+					t.Errorf("request %d output = %+v, want error with request id", i, output)
+				}
+			}
+		})
+	}
+}
+
+func TestFailBatchKeepsConcurrentCancel(t *testing.T) {
+	setupFallbackTest(t, false)
+	batch := &model.Batch{UserId: 1, Endpoint: "/v1/chat/completions", Status: model.BatchStatusValidating}
+	if err := batch.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.CancelUserBatch(batch.Id, batch.UserId); err != nil {
+		t.Fatal(err)
+	}
+	failBatch(batch, []dto.BatchError{{Code: "invalid_input_file", Message: "missing"}})
+	if status, err := model.GetBatchStatus(batch.Id); err != nil || status != model.BatchStatusCancelling {
+		t.Errorf("status = %q, %v, want %q", status, err, model.BatchStatusCancelling)
+	}
+}
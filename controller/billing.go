@@ -21,8 +21,20 @@ func GetSubscription(c *gin.Context) {
 		usedQuota = token.UsedQuota
 	} else {
 		userId := c.GetInt("id")
-		remainQuota, err = model.GetUserQuota(userId, false)
-		usedQuota, err = model.GetUserUsedQuota(userId)
+		if orgId := c.GetInt("token_org_id"); orgId != 0 {
+			// 组织令牌展示组织额度池
+			var org *model.Organization
+			remainQuota, err = model.GetPayerQuota(userId, orgId)
+			if err == nil {
+				org, err = model.GetOrganizationById(orgId)
+				if err == nil {
+					usedQuota = org.UsedQuota
+				}
+			}
+		} else {
+			remainQuota, err = model.GetUserQuota(userId, false)
+			usedQuota, err = model.GetUserUsedQuota(userId)
+		}
 	}
 	if expiredTime <= 0 {
 		expiredTime = 0
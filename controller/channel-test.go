@@ -35,9 +35,16 @@ type testResult struct {
 	context     *gin.Context
 	localErr    error
 	newAPIError *types.NewAPIError
+	// 返回给客户端的响应内容，用于能力探测
+	respBody []byte
 }
 
 func testChannel(channel *model.Channel, testModel string) testResult {
+	return testChannelWithRequest(channel, testModel, nil)
+}
+
+// testChannelWithRequest 使用指定请求测试渠道，request 为空时使用默认测试请求
+func testChannelWithRequest(channel *model.Channel, testModel string, request *dto.GeneralOpenAIRequest) testResult {
 	tik := time.Now()
 	if channel.Type == constant.ChannelTypeMidjourney {
 		return testResult{
@@ -75,7 +82,11 @@ func testChannel(channel *model.Channel, testModel string) testResult {
 	requestPath := "/v1/chat/completions"
 
 	// 先判断是否为 Embedding 模型
-	if strings.Contains(strings.ToLower(testModel), "embedding") ||
+	if request != nil {
+		if request.Input != nil {
+			requestPath = "/v1/embeddings"
+		}
+	} else if strings.Contains(strings.ToLower(testModel), "embedding") ||
 		strings.HasPrefix(testModel, "m3e") || // m3e 系列模型
 		strings.Contains(testModel, "bge-") || // bge 系列模型
 		strings.Contains(testModel, "embed") ||
@@ -149,7 +160,12 @@ func testChannel(channel *model.Channel, testModel string) testResult {
 		}
 	}
 
-	request := buildTestRequest(testModel)
+	if request == nil {
+		request = buildTestRequest(testModel)
+	} else {
+		request.Model = testModel
+	}
+	info.IsStream = request.Stream
 	// 创建一个用于日志的 info 副本，移除 ApiKey
 	logInfo := *info
 	logInfo.ApiKey = ""
@@ -269,7 +285,7 @@ func testChannel(channel *model.Channel, testModel string) testResult {
 		Quota:            quota,
 		Content:          "模型测试",
 		UseTimeSeconds:   int(consumedTime),
-		IsStream:         false,
+		IsStream:         info.IsStream,
 		Group:            info.UsingGroup,
 		Other:            other,
 	})
@@ -278,6 +294,7 @@ func testChannel(channel *model.Channel, testModel string) testResult {
 		context:     c,
 		localErr:    nil,
 		newAPIError: nil,
+		respBody:    respBody,
 	}
 }
 
@@ -348,6 +365,7 @@ func TestChannel(c *gin.Context) {
 	tok := time.Now()
 	milliseconds := tok.Sub(tik).Milliseconds()
 	go channel.UpdateResponseTime(milliseconds)
+	service.RecordChannelHealth(result.context, channel.Id, result.newAPIError, tok.Sub(tik))
 	consumedTime := float64(milliseconds) / 1000.0
 	if result.newAPIError != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -399,6 +417,9 @@ func testAllChannels(notify bool) error {
 			result := testChannel(channel, "")
 			tok := time.Now()
 			milliseconds := tok.Sub(tik).Milliseconds()
+			if result.localErr == nil {
+				service.RecordChannelHealth(result.context, channel.Id, result.newAPIError, tok.Sub(tik))
+			}
 
 			shouldBanChannel := false
 			newAPIError := result.newAPIError
@@ -427,6 +448,9 @@ func testAllChannels(notify bool) error {
 			}
 
 			channel.UpdateResponseTime(milliseconds)
+			if newAPIError == nil {
+				autoProbeChannelCapabilities(channel)
+			}
 			time.Sleep(common.RequestInterval)
 		}
 
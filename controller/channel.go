@@ -356,6 +356,9 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 	if err := channel.ValidateSettings(); err != nil {
 		return fmt.Errorf("渠道额外设置[channel setting] 格式错误：%s", err.Error())
 	}
+	if err := validateParamOverride(channel.ParamOverride); err != nil {
+		return fmt.Errorf("参数覆盖[param override] 格式错误：%s", err.Error())
+	}
 
 	// 如果是添加操作，检查 channel 和 key 是否为空
 	if isAdd {
@@ -394,6 +397,32 @@ type AddChannelRequest struct {
 	Mode         string                `json:"mode"`
 	MultiKeyMode constant.MultiKeyMode `json:"multi_key_mode"`
 	Channel      *model.Channel        `json:"channel"`
+	MultiKeyLimits
+}
+
+// MultiKeyLimits 多密钥渠道单个密钥的速率上限与冷却时间，未传入的字段保持不变
+type MultiKeyLimits struct {
+	MultiKeyRpmLimit        *int `json:"multi_key_rpm_limit"`
+	MultiKeyTpmLimit        *int `json:"multi_key_tpm_limit"`
+	MultiKeyCooldownSeconds *int `json:"multi_key_cooldown_seconds"`
+}
+
+func (limits MultiKeyLimits) apply(info *model.ChannelInfo) error {
+	for _, value := range []*int{limits.MultiKeyRpmLimit, limits.MultiKeyTpmLimit, limits.MultiKeyCooldownSeconds} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("多密钥速率上限与冷却时间不能为负数")
+		}
+	}
+	if limits.MultiKeyRpmLimit != nil {
+		info.MultiKeyRpmLimit = *limits.MultiKeyRpmLimit
+	}
+	if limits.MultiKeyTpmLimit != nil {
+		info.MultiKeyTpmLimit = *limits.MultiKeyTpmLimit
+	}
+	if limits.MultiKeyCooldownSeconds != nil {
+		info.MultiKeyCooldownSeconds = *limits.MultiKeyCooldownSeconds
+	}
+	return nil
 }
 
 func getVertexArrayKeys(keys string) ([]string, error) {
@@ -451,6 +480,13 @@ func AddChannel(c *gin.Context) {
 	case "multi_to_single":
 		addChannelRequest.Channel.ChannelInfo.IsMultiKey = true
 		addChannelRequest.Channel.ChannelInfo.MultiKeyMode = addChannelRequest.MultiKeyMode
+		if err := addChannelRequest.MultiKeyLimits.apply(&addChannelRequest.Channel.ChannelInfo); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 		if addChannelRequest.Channel.Type == constant.ChannelTypeVertexAi {
 			array, err := getVertexArrayKeys(addChannelRequest.Channel.Key)
 			if err != nil {
@@ -669,6 +705,7 @@ func DeleteChannelBatch(c *gin.Context) {
 type PatchChannel struct {
 	model.Channel
 	MultiKeyMode *string `json:"multi_key_mode"`
+	MultiKeyLimits
 }
 
 func UpdateChannel(c *gin.Context) {
@@ -704,6 +741,13 @@ func UpdateChannel(c *gin.Context) {
 	if channel.MultiKeyMode != nil && *channel.MultiKeyMode != "" {
 		channel.ChannelInfo.MultiKeyMode = constant.MultiKeyMode(*channel.MultiKeyMode)
 	}
+	if err := channel.MultiKeyLimits.apply(&channel.ChannelInfo); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		common.ApiError(c, err)
@@ -914,3 +958,31 @@ func CopyChannel(c *gin.Context) {
 	// success
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": gin.H{"id": clone.Id}})
 }
+
+// RevealChannelKey 返回渠道的完整密钥，渠道接口的其他返回中均不包含密钥，每次查看都会记录管理日志
+func RevealChannelKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.ShouldBindJSON(&req)
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	content := fmt.Sprintf("查看渠道 #%d（%s）的完整密钥，IP：%s", channel.Id, channel.Name, c.ClientIP())
+	if req.Reason != "" {
+		content += "，原因：" + req.Reason
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, content)
+	common.SysLog(fmt.Sprintf("user #%d revealed key of channel #%d", c.GetInt("id"), channel.Id))
+	common.ApiSuccess(c, gin.H{
+		"id":  channel.Id,
+		"key": channel.Key,
+	})
+}
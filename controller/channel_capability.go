@@ -0,0 +1,449 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 视觉探测使用的 16x16 纯红色图片
+const capabilityProbeImage = "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAABAAAAAQCAIAAACQkWg2AAAAFklEQVR42mP4z8BAEmIY1TCqYfhqAACQ+f8B8u7oVwAAAABJRU5ErkJggg=="
+
+// capabilityProbe 能力探测请求及对响应的校验，校验通过时返回探测详情
+type capabilityProbe struct {
+	build  func(modelName string) *dto.GeneralOpenAIRequest
+	verify func(respBody []byte) (string, error)
+}
+
+var capabilityProbes = map[string]capabilityProbe{
+	model.ChannelCapabilityStreaming: {
+		build: func(modelName string) *dto.GeneralOpenAIRequest {
+			request := buildTestRequest(modelName)
+			request.Stream = true
+			return request
+		},
+		verify: verifyStreamProbe,
+	},
+	model.ChannelCapabilityTools: {
+		build: func(modelName string) *dto.GeneralOpenAIRequest {
+			request := buildProbeRequest(modelName, "What is the weather in Paris?", 100)
+			request.Tools = []dto.ToolCallRequest{{
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:        "get_weather",
+					Description: "Get the current weather of a city",
+					Parameters: map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"city": map[string]any{"type": "string"}},
+						"required":   []string{"city"},
+					},
+				},
+			}}
+			request.ToolChoice = map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}
+			return request
+		},
+		verify: func(respBody []byte) (string, error) {
+			message, err := parseProbeMessage(respBody)
+			if err != nil {
+				return "", err
+			}
+			var toolCalls []dto.ToolCallResponse
+			if len(message.ToolCalls) == 0 || common.Unmarshal(message.ToolCalls, &toolCalls) != nil || len(toolCalls) == 0 {
+				return "", errors.New("no tool calls in response")
+			}
+			return "called " + toolCalls[0].Function.Name, nil
+		},
+	},
+	model.ChannelCapabilityJsonMode: {
+		build: func(modelName string) *dto.GeneralOpenAIRequest {
+			request := buildProbeRequest(modelName, `Reply with a JSON object with a single key "ok" set to true.`, 50)
+			request.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+			return request
+		},
+		verify: func(respBody []byte) (string, error) {
+			message, err := parseProbeMessage(respBody)
+			if err != nil {
+				return "", err
+			}
+			var object map[string]any
+			if err := common.UnmarshalJsonStr(trimJsonFence(message.StringContent()), &object); err != nil {
+				return "", fmt.Errorf("response is not a json object: %s", truncateProbeContent(message.StringContent()))
+			}
+			return "", nil
+		},
+	},
+	model.ChannelCapabilityJsonSchema: {
+		build: func(modelName string) *dto.GeneralOpenAIRequest {
+			request := buildProbeRequest(modelName, `Reply with "ok" set to true.`, 50)
+			request.ResponseFormat = &dto.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &dto.FormatJsonSchema{
+					Name: "probe",
+					Schema: map[string]any{
+						"type":                 "object",
+						"properties":           map[string]any{"ok": map[string]any{"type": "boolean"}},
+						"required":             []string{"ok"},
+						"additionalProperties": false,
+					},
+					Strict: true,
+				},
+			}
+			return request
+		},
+		verify: func(respBody []byte) (string, error) {
+			message, err := parseProbeMessage(respBody)
+			if err != nil {
+				return "", err
+			}
+			// 上游忽略 schema 时通常返回普通文本或缺少 ok 字段
+			var object map[string]any
+			if err := common.UnmarshalJsonStr(trimJsonFence(message.StringContent()), &object); err != nil {
+				return "", fmt.Errorf("response is not a json object: %s", truncateProbeContent(message.StringContent()))
+			}
+			if _, ok := object["ok"].(bool); !ok {
+				return "", fmt.Errorf("response does not match schema: %s", truncateProbeContent(message.StringContent()))
+			}
+			return "", nil
+		},
+	},
+	model.ChannelCapabilityVision: {
+		build: func(modelName string) *dto.GeneralOpenAIRequest {
+			request := buildProbeRequest(modelName, "", 10)
+			request.Messages[0].SetMediaContent([]dto.MediaContent{
+				{Type: dto.ContentTypeText, Text: "What color is this image? Answer with one word."},
+				{Type: dto.ContentTypeImageURL, ImageUrl: &dto.MessageImageUrl{Url: capabilityProbeImage, Detail: "low"}},
+			})
+			return request
+		},
+		verify: func(respBody []byte) (string, error) {
+			message, err := parseProbeMessage(respBody)
+			if err != nil {
+				return "", err
+			}
+			// 上游忽略图片时无法回答出颜色
+			if !strings.Contains(strings.ToLower(message.StringContent()), "red") {
+				return "", fmt.Errorf("unexpected answer: %s", truncateProbeContent(message.StringContent()))
+			}
+			return "", nil
+		},
+	},
+	model.ChannelCapabilityEmbeddings: {
+		build: func(modelName string) *dto.GeneralOpenAIRequest {
+			return &dto.GeneralOpenAIRequest{Input: []any{"hello world"}}
+		},
+		verify: func(respBody []byte) (string, error) {
+			var response dto.FlexibleEmbeddingResponse
+			if err := common.Unmarshal(respBody, &response); err != nil {
+				return "", err
+			}
+			if len(response.Data) == 0 {
+				return "", errors.New("no embedding in response")
+			}
+			dimensions := 0
+			switch embedding := response.Data[0].Embedding.(type) {
+			case []any:
+				dimensions = len(embedding)
+			case string:
+				// base64 编码的 float32 数组
+				decoded, err := base64.StdEncoding.DecodeString(embedding)
+				if err != nil {
+					return "", err
+				}
+				dimensions = len(decoded) / 4
+			}
+			if dimensions == 0 {
+				return "", errors.New("empty embedding in response")
+			}
+			return fmt.Sprintf("dimensions: %d", dimensions), nil
+		},
+	},
+	model.ChannelCapabilityReasoning: {
+		build: func(modelName string) *dto.GeneralOpenAIRequest {
+			request := buildProbeRequest(modelName, "What is 17 * 23?", 1024)
+			request.ReasoningEffort = "low"
+			return request
+		},
+		verify: func(respBody []byte) (string, error) {
+			var response dto.OpenAITextResponse
+			if err := common.Unmarshal(respBody, &response); err != nil {
+				return "", err
+			}
+			if len(response.Choices) == 0 {
+				return "", errors.New("no choices in response")
+			}
+			if tokens := response.Usage.CompletionTokenDetails.ReasoningTokens; tokens > 0 {
+				return fmt.Sprintf("reasoning tokens: %d", tokens), nil
+			}
+			message := response.Choices[0].Message
+			if message.ReasoningContent == "" && message.Reasoning == "" {
+				return "", errors.New("no reasoning content in response")
+			}
+			return "", nil
+		},
+	},
+}
+
+// buildProbeRequest 在默认测试请求的基础上替换提问，并保证输出长度足够完成探测
+func buildProbeRequest(modelName string, content string, maxTokens uint) *dto.GeneralOpenAIRequest {
+	request := buildTestRequest(modelName)
+	request.Messages = []dto.Message{{Role: "user", Content: content}}
+	if request.MaxCompletionTokens > 0 && request.MaxCompletionTokens < maxTokens {
+		request.MaxCompletionTokens = maxTokens
+	}
+	if request.MaxTokens > 0 && request.MaxTokens < maxTokens {
+		request.MaxTokens = maxTokens
+	}
+	return request
+}
+
+func parseProbeMessage(respBody []byte) (*dto.Message, error) {
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(respBody, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, errors.New("no choices in response")
+	}
+	return &response.Choices[0].Message, nil
+}
+
+// trimJsonFence 去掉模型回复中包裹 JSON 的 Markdown 代码块标记
+func trimJsonFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimPrefix(content, "json")
+	content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+	return strings.TrimSpace(content)
+}
+
+func truncateProbeContent(content string) string {
+	if len(content) > 100 {
+		return content[:100] + "..."
+	}
+	return content
+}
+
+func verifyStreamProbe(respBody []byte) (string, error) {
+	chunks := 0
+	for _, line := range strings.Split(string(respBody), "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		data = strings.TrimSpace(data)
+		if !ok || data == "" || data == "[DONE]" {
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		if common.UnmarshalJsonStr(data, &chunk) == nil && len(chunk.Choices) > 0 {
+			chunks++
+		}
+	}
+	if chunks == 0 {
+		return "", errors.New("no stream chunks in response")
+	}
+	return fmt.Sprintf("%d chunks", chunks), nil
+}
+
+// isCapabilityRejected 上游以请求错误拒绝时视为不支持该能力，鉴权、限流、服务端错误等无法判断
+func isCapabilityRejected(err error) bool {
+	var apiErr *types.NewAPIError
+	if !errors.As(err, &apiErr) || types.IsLocalError(apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// defaultProbeCapabilities 未配置探测能力时，向量模型只探测向量，其他模型探测除向量外的所有能力
+func defaultProbeCapabilities(modelName string) []string {
+	if buildTestRequest(modelName).Input != nil {
+		return []string{model.ChannelCapabilityEmbeddings}
+	}
+	capabilities := make([]string, 0, len(model.ChannelProbeCapabilities))
+	for _, capability := range model.ChannelProbeCapabilities {
+		if capability != model.ChannelCapabilityEmbeddings {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// getProbeModels 依次使用指定模型、渠道探测配置、测试模型及渠道第一个模型
+func getProbeModels(channel *model.Channel, models []string) []string {
+	if len(models) > 0 {
+		return models
+	}
+	if profile := channel.GetSetting().TestProfile; profile != nil && len(profile.Models) > 0 {
+		return profile.Models
+	}
+	if channel.TestModel != nil && *channel.TestModel != "" {
+		return []string{*channel.TestModel}
+	}
+	if channelModels := channel.GetModels(); len(channelModels) > 0 {
+		return channelModels[:1]
+	}
+	return []string{"gpt-4o-mini"}
+}
+
+type capabilityProbeResult struct {
+	*model.ChannelCapability
+	// 上游未给出明确结果（如网络错误、鉴权失败、限流）或回复未通过校验，不保存
+	Inconclusive bool `json:"inconclusive,omitempty"`
+}
+
+// probeChannelCapabilities 依次探测渠道在各模型上的能力，并保存有明确结果的探测
+func probeChannelCapabilities(channel *model.Channel, models []string, capabilities []string) ([]capabilityProbeResult, error) {
+	if len(capabilities) == 0 {
+		if profile := channel.GetSetting().TestProfile; profile != nil {
+			capabilities = profile.Capabilities
+		}
+	}
+	results := make([]capabilityProbeResult, 0)
+	conclusive := make([]*model.ChannelCapability, 0)
+	for _, modelName := range getProbeModels(channel, models) {
+		modelCapabilities := capabilities
+		if len(modelCapabilities) == 0 {
+			modelCapabilities = defaultProbeCapabilities(modelName)
+		}
+		for _, capability := range modelCapabilities {
+			probe, ok := capabilityProbes[capability]
+			if !ok {
+				return nil, fmt.Errorf("unknown capability %s", capability)
+			}
+			tik := time.Now()
+			result := testChannelWithRequest(channel, modelName, probe.build(modelName))
+			capabilityResult := &model.ChannelCapability{
+				ChannelId:  channel.Id,
+				Model:      modelName,
+				Capability: capability,
+				Latency:    int(time.Since(tik).Milliseconds()),
+				TestedAt:   common.GetTimestamp(),
+			}
+			inconclusive := false
+			if result.localErr != nil {
+				capabilityResult.Detail = result.localErr.Error()
+				inconclusive = !isCapabilityRejected(result.localErr)
+			} else if detail, err := probe.verify(result.respBody); err != nil {
+				// 上游接受了请求但回复不符合预期，可能只是模型的回答格式问题，不据此判定为不支持
+				capabilityResult.Detail = err.Error()
+				inconclusive = true
+			} else {
+				capabilityResult.Supported = true
+				capabilityResult.Detail = detail
+			}
+			results = append(results, capabilityProbeResult{ChannelCapability: capabilityResult, Inconclusive: inconclusive})
+			if !inconclusive {
+				conclusive = append(conclusive, capabilityResult)
+			}
+		}
+	}
+	return results, model.SaveChannelCapabilities(conclusive)
+}
+
+type ProbeChannelCapabilitiesRequest struct {
+	Models       []string `json:"models"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// ProbeChannelCapabilities 探测渠道能力，未指定模型与能力时使用渠道的探测配置
+func ProbeChannelCapabilities(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req ProbeChannelCapabilitiesRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	for _, capability := range req.Capabilities {
+		if _, ok := capabilityProbes[capability]; !ok {
+			common.ApiErrorMsg(c, "未知的能力："+capability)
+			return
+		}
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	results, err := probeChannelCapabilities(channel, req.Models, req.Capabilities)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, results)
+}
+
+type capabilityMatrixRow struct {
+	ChannelId    int                                 `json:"channel_id"`
+	Model        string                              `json:"model"`
+	Capabilities map[string]*model.ChannelCapability `json:"capabilities"`
+}
+
+// buildCapabilityMatrix 按渠道、模型汇总探测结果
+func buildCapabilityMatrix(capabilities []*model.ChannelCapability) []*capabilityMatrixRow {
+	rows := make([]*capabilityMatrixRow, 0)
+	for _, capability := range capabilities {
+		if len(rows) == 0 || rows[len(rows)-1].ChannelId != capability.ChannelId || rows[len(rows)-1].Model != capability.Model {
+			rows = append(rows, &capabilityMatrixRow{
+				ChannelId:    capability.ChannelId,
+				Model:        capability.Model,
+				Capabilities: make(map[string]*model.ChannelCapability),
+			})
+		}
+		rows[len(rows)-1].Capabilities[capability.Capability] = capability
+	}
+	return rows
+}
+
+// GetChannelCapabilities 获取能力矩阵，路径中没有渠道 ID 时返回所有渠道，可按模型筛选
+func GetChannelCapabilities(c *gin.Context) {
+	channelId := 0
+	if id := c.Param("id"); id != "" {
+		var err error
+		if channelId, err = strconv.Atoi(id); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	capabilities, err := model.GetChannelCapabilities(channelId, c.Query("model"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, buildCapabilityMatrix(capabilities))
+}
+
+// autoProbeChannelCapabilities 渠道探测配置开启 auto_probe 时，在定时测试后探测能力
+func autoProbeChannelCapabilities(channel *model.Channel) {
+	profile := channel.GetSetting().TestProfile
+	if profile == nil || !profile.AutoProbe {
+		return
+	}
+	results, err := probeChannelCapabilities(channel, nil, nil)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to probe capabilities of channel #%d: %s", channel.Id, err.Error()))
+		return
+	}
+	resultBytes, _ := json.Marshal(results)
+	common.SysLog(fmt.Sprintf("probed capabilities of channel #%d: %s", channel.Id, string(resultBytes)))
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"one-api/model"
+	"testing"
+)
+
+func TestCapabilityProbeVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		capability string
+		body       string
+		wantErr    bool
+	}{
+		{
+			name:       "json mode plain",
+			capability: model.ChannelCapabilityJsonMode,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"{\"ok\":true}"}}]}`,
+		},
+		{
+			name:       "json mode fenced",
+			capability: model.ChannelCapabilityJsonMode,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"` + "```json\\n{\\\"ok\\\":true}\\n```" + `"}}]}`,
+		},
+		{
+			name:       "json mode text",
+			capability: model.ChannelCapabilityJsonMode,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"ok is true"}}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "json schema fenced",
+			capability: model.ChannelCapabilityJsonSchema,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"` + "```\\n{\\\"ok\\\":true}\\n```" + `"}}]}`,
+		},
+		{
+			name:       "json schema missing field",
+			capability: model.ChannelCapabilityJsonSchema,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"{\"result\":true}"}}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "tools called",
+			capability: model.ChannelCapabilityTools,
+			body:       `{"choices":[{"message":{"role":"assistant","tool_calls":[{"id":"c1","type":"function","function":{"name":"get_weather","arguments":"{}"}}]}}]}`,
+		},
+		{
+			name:       "tools ignored",
+			capability: model.ChannelCapabilityTools,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"It is sunny."}}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "vision",
+			capability: model.ChannelCapabilityVision,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"Red."}}]}`,
+		},
+		{
+			name:       "stream",
+			capability: model.ChannelCapabilityStreaming,
+			body:       "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n",
+		},
+		{
+			name:       "stream returned as body",
+			capability: model.ChannelCapabilityStreaming,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"hi"}}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "embeddings",
+			capability: model.ChannelCapabilityEmbeddings,
+			body:       `{"data":[{"embedding":[0.1,0.2,0.3]}]}`,
+		},
+		{
+			name:       "reasoning tokens",
+			capability: model.ChannelCapabilityReasoning,
+			body:       `{"choices":[{"message":{"role":"assistant","content":"391"}}],"usage":{"completion_tokens_details":{"reasoning_tokens":12}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := capabilityProbes[tt.capability].verify([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verify() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type channelHealthItem struct {
+	model.ChannelHealthInfo
+	ChannelName string `json:"channel_name"`
+}
+
+// GetChannelHealth 获取渠道（及多密钥渠道各密钥）的熔断状态与健康统计，仅统计当前节点
+func GetChannelHealth(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	infos := model.GetChannelHealthInfos(channelId)
+	ids := make([]int, 0, len(infos))
+	for _, info := range infos {
+		if info.KeyIndex < 0 {
+			ids = append(ids, info.ChannelId)
+		}
+	}
+	channelNames := make(map[int]string, len(ids))
+	if len(ids) > 0 {
+		channels, err := model.GetChannelsByIds(ids)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		for _, channel := range channels {
+			channelNames[channel.Id] = channel.Name
+		}
+	}
+	items := make([]channelHealthItem, 0, len(infos))
+	for _, info := range infos {
+		items = append(items, channelHealthItem{
+			ChannelHealthInfo: info,
+			ChannelName:       channelNames[info.ChannelId],
+		})
+	}
+	common.ApiSuccess(c, gin.H{
+		"circuit_breaker_enabled": operation_setting.GetChannelHealthSetting().CircuitBreakerEnabled,
+		"items":                   items,
+	})
+}
+
+// ResetChannelHealth 清除渠道的熔断状态，使其立即重新参与分配
+func ResetChannelHealth(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.ResetChannelHealth(channelId)
+	common.ApiSuccess(c, nil)
+}
+
+// GetChannelKeyStatuses 获取多密钥渠道各密钥的状态、冷却时间、用量与最近错误，仅统计当前节点
+func GetChannelKeyStatuses(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !channel.ChannelInfo.IsMultiKey {
+		common.ApiErrorMsg(c, "该渠道不是多密钥渠道")
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"multi_key_mode":             channel.ChannelInfo.MultiKeyMode,
+		"multi_key_rpm_limit":        channel.ChannelInfo.MultiKeyRpmLimit,
+		"multi_key_tpm_limit":        channel.ChannelInfo.MultiKeyTpmLimit,
+		"multi_key_cooldown_seconds": channel.ChannelInfo.MultiKeyCooldownSeconds,
+		"keys":                       model.GetChannelKeyStatuses(channel),
+	})
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateParamOverride 校验渠道参数覆盖配置，支持规则格式与旧版扁平对象
+func validateParamOverride(paramOverride *string) error {
+	if paramOverride == nil || strings.TrimSpace(*paramOverride) == "" {
+		return nil
+	}
+	raw := make(map[string]interface{})
+	if err := common.UnmarshalJsonStr(*paramOverride, &raw); err != nil {
+		return errors.New("必须是 JSON 对象")
+	}
+	_, err := relaycommon.NewParamOverride(raw)
+	return err
+}
+
+type ParamOverrideDryRunRequest struct {
+	Model  string `json:"model"`
+	Group  string `json:"group"`
+	Stream bool   `json:"stream"`
+	// 未保存的参数覆盖配置，为空时使用渠道当前配置
+	ParamOverride *string `json:"param_override"`
+	// OpenAI 格式的请求，为空时使用渠道测试请求
+	Request *dto.GeneralOpenAIRequest `json:"request"`
+}
+
+// DryRunParamOverride 将请求按渠道适配器转换为上游格式并应用参数覆盖规则，返回转换结果，不发送请求
+func DryRunParamOverride(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req ParamOverrideDryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	preview := *channel
+	// 不经过多密钥选择，避免影响密钥用量统计
+	preview.ChannelInfo.IsMultiKey = false
+	if req.ParamOverride != nil {
+		if err := validateParamOverride(req.ParamOverride); err != nil {
+			common.ApiErrorMsg(c, "参数覆盖[param override] 格式错误："+err.Error())
+			return
+		}
+		preview.ParamOverride = req.ParamOverride
+	}
+
+	modelName := req.Model
+	if modelName == "" {
+		if channel.TestModel != nil && *channel.TestModel != "" {
+			modelName = *channel.TestModel
+		} else if models := channel.GetModels(); len(models) > 0 {
+			modelName = models[0]
+		} else {
+			modelName = "gpt-4o-mini"
+		}
+	}
+	group := req.Group
+	if group == "" {
+		group = strings.Split(channel.Group, ",")[0]
+	}
+	request := req.Request
+	if request == nil {
+		request = buildTestRequest(modelName)
+	}
+	request.Model = modelName
+	request.Stream = req.Stream
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: "/v1/chat/completions"},
+		Header: make(http.Header),
+	}
+	ctx.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(ctx, constant.ContextKeyUsingGroup, group)
+	if apiErr := middleware.SetupContextForSelectedChannel(ctx, &preview, modelName); apiErr != nil {
+		common.ApiError(c, apiErr)
+		return
+	}
+	info := relaycommon.GenRelayInfo(ctx)
+	info.IsStream = req.Stream
+	if err := helper.ModelMappedHelper(ctx, info, request); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	adaptor := relay.GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		common.ApiError(c, fmt.Errorf("invalid api type: %d", info.ApiType))
+		return
+	}
+	adaptor.Init(info)
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(ctx, info, request)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	original, err := common.Marshal(convertedRequest)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	transformed, err := relaycommon.ApplyParamOverride(original, info)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	headers := http.Header{}
+	relaycommon.ApplyParamOverrideHeaders(headers, info)
+	removedHeaders := make([]string, 0)
+	matchedRules := info.ParamOverride.MatchedRules(info)
+	for _, i := range matchedRules {
+		if rule := info.ParamOverride.Rules[i]; rule.Op == relaycommon.ParamOverrideOpRemoveHeader {
+			removedHeaders = append(removedHeaders, http.CanonicalHeaderKey(rule.Name))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"model":           info.UpstreamModelName,
+			"group":           info.UsingGroup,
+			"stream":          info.IsStream,
+			"matched_rules":   matchedRules,
+			"original_body":   json.RawMessage(original),
+			"body":            json.RawMessage(transformed),
+			"headers":         headers,
+			"removed_headers": removedHeaders,
+		},
+	})
+}
@@ -114,3 +114,40 @@ func GetLongestConversations(c *gin.Context) {
 		},
 	})
 }
+
+// GetChatLogConversations retrieves the user's chat logs threaded by conversation
+func GetChatLogConversations(c *gin.Context) {
+	userID := c.GetInt("id")
+
+	// Parse query parameters
+	limitStr := c.DefaultQuery("limit", "20")
+	offsetStr := c.DefaultQuery("offset", "0")
+	keyword := c.Query("keyword")
+	modelName := c.Query("model_name")
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	conversations, total, err := service.GetChatLogConversations(userID, keyword, modelName, limit, offset)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"conversations": conversations,
+			"total":         total,
+			"limit":         limit,
+			"offset":        offset,
+		},
+	})
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var supportedFilePurposes = map[string]bool{
+	"batch":      true,
+	"fine-tune":  true,
+	"assistants": true,
+	"vision":     true,
+	"user_data":  true,
+	"evals":      true,
+}
+
+func abortWithInvalidRequest(c *gin.Context, statusCode int, code string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": dto.OpenAIError{
+			Message: common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+			Type:    "invalid_request_error",
+			Param:   "",
+			Code:    code,
+		},
+	})
+}
+
+func abortWithFileQueryError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		abortWithInvalidRequest(c, http.StatusNotFound, "not_found", "No such file object")
+		return
+	}
+	abortWithInvalidRequest(c, http.StatusInternalServerError, "query_data_error", err.Error())
+}
+
+func getListLimit(c *gin.Context, defaultLimit int, maxLimit int) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return defaultLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+func UploadFile(c *gin.Context) {
+	userId := c.GetInt("id")
+	purpose := c.PostForm("purpose")
+	if !supportedFilePurposes[purpose] {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_purpose", fmt.Sprintf("Invalid purpose: %s", purpose))
+		return
+	}
+	maxBytes := int64(constant.MaxUploadFileMB) << 20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+(1<<20))
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_file", "file is required: "+err.Error())
+		return
+	}
+	if fileHeader.Size > maxBytes {
+		abortWithInvalidRequest(c, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("file exceeds the maximum size of %d MB", constant.MaxUploadFileMB))
+		return
+	}
+	f, err := fileHeader.Open()
+	if err != nil {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		abortWithInvalidRequest(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	file := &model.File{
+		UserId:   userId,
+		Filename: fileHeader.Filename,
+		Purpose:  purpose,
+		Content:  content,
+	}
+	if err = file.Insert(); err != nil {
+		abortWithInvalidRequest(c, http.StatusInternalServerError, "update_data_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+func ListFiles(c *gin.Context) {
+	userId := c.GetInt("id")
+	limit := getListLimit(c, 10000, 10000)
+	files, err := model.GetUserFiles(userId, c.Query("purpose"), c.Query("order"), c.Query("after"), limit+1)
+	if err != nil {
+		abortWithFileQueryError(c, err)
+		return
+	}
+	hasMore := len(files) > limit
+	if hasMore {
+		files = files[:limit]
+	}
+	data := make([]dto.OpenAIFile, 0, len(files))
+	for _, file := range files {
+		data = append(data, file.ToOpenAIFile())
+	}
+	resp := dto.OpenAIListResponse{
+		Object:  "list",
+		Data:    data,
+		HasMore: hasMore,
+	}
+	if len(data) > 0 {
+		resp.FirstId = data[0].Id
+		resp.LastId = data[len(data)-1].Id
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func RetrieveFile(c *gin.Context) {
+	file, err := model.GetUserFileById(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		abortWithFileQueryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+func RetrieveFileContent(c *gin.Context) {
+	file, err := model.GetUserFileContent(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		abortWithFileQueryError(c, err)
+		return
+	}
+	contentType := "application/octet-stream"
+	if file.Purpose == model.FilePurposeBatch || file.Purpose == model.FilePurposeBatchOutput {
+		contentType = "application/jsonl"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.Data(http.StatusOK, contentType, file.Content)
+}
+
+func DeleteFile(c *gin.Context) {
+	id := c.Param("id")
+	err := model.DeleteUserFile(id, c.GetInt("id"))
+	if err != nil {
+		abortWithInvalidRequest(c, http.StatusNotFound, "not_found", "No such file object")
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleteResponse{
+		Id:      id,
+		Object:  "file",
+		Deleted: true,
+	})
+}
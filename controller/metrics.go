@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"one-api/constant"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsHandler = promhttp.Handler()
+
+// Metrics 以 Prometheus 格式输出中继指标，配置了 METRICS_TOKEN 时校验 Bearer Token
+func Metrics(c *gin.Context) {
+	if constant.MetricsToken != "" {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(constant.MetricsToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	}
+	metricsHandler.ServeHTTP(c.Writer, c.Request)
+}
@@ -7,9 +7,10 @@ import (
 	"one-api/model"
 	"one-api/setting"
 	"one-api/setting/console_setting"
+	"one-api/setting/model_setting"
+	"one-api/setting/operation_setting"
 	"one-api/setting/ratio_setting"
 	"one-api/setting/system_setting"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,7 +19,7 @@ func GetOptions(c *gin.Context) {
 	var options []*model.Option
 	common.OptionMapRWMutex.Lock()
 	for k, v := range common.OptionMap {
-		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Key") {
+		if model.IsSecretOption(k) {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -112,6 +113,69 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "guardrail_setting.policies":
+		err = operation_setting.CheckGuardrailPolicies(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "model_fallback.chains":
+		err = model_setting.CheckFallbackChains(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "chat_log_setting.redact_rules":
+		err = operation_setting.CheckChatLogRedactRules(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "chat_log_setting.custom_redact_patterns":
+		err = operation_setting.CheckChatLogRedactPatterns(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "chat_log_setting.content_retention_days", "chat_log_setting.metadata_retention_days":
+		err = operation_setting.CheckChatLogRetentionDays(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "chat_log_setting.group_retention":
+		err = operation_setting.CheckChatLogGroupRetention(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "chat_log_setting.user_retention":
+		err = operation_setting.CheckChatLogUserRetention(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	case "ModelRequestRateLimitGroup":
 		err = setting.CheckModelRequestRateLimitGroup(option.Value)
 		if err != nil {
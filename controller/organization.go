@@ -0,0 +1,484 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var organizationRoleLevel = map[string]int{
+	model.OrganizationRoleMember: 1,
+	model.OrganizationRoleAdmin:  2,
+	model.OrganizationRoleOwner:  3,
+}
+
+// getOrganizationForRole 获取路由中的组织并校验当前用户的角色不低于 minRole，校验失败时直接返回错误响应
+func getOrganizationForRole(c *gin.Context, minRole string) (*model.Organization, string, bool) {
+	orgId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, "", false
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		common.ApiErrorMsg(c, "组织不存在")
+		return nil, "", false
+	}
+	role, err := model.GetOrganizationRole(org.Id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, "", false
+	}
+	if organizationRoleLevel[role] < organizationRoleLevel[minRole] {
+		common.ApiErrorMsg(c, "无权进行此操作")
+		return nil, "", false
+	}
+	org.Role = role
+	return org, role, true
+}
+
+type organizationRequest struct {
+	Name     string `json:"name"`
+	ParentId int    `json:"parent_id"`
+}
+
+func GetSelfOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, orgs)
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		common.ApiErrorMsg(c, "组织名称不能为空且不能超过 64 个字符")
+		return
+	}
+	userId := c.GetInt("id")
+	if req.ParentId != 0 {
+		role, err := model.GetOrganizationRole(req.ParentId, userId)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if organizationRoleLevel[role] < organizationRoleLevel[model.OrganizationRoleAdmin] {
+			common.ApiErrorMsg(c, "只有上级组织的所有者或管理员可以创建下级组织")
+			return
+		}
+	}
+	org := &model.Organization{
+		Name:     req.Name,
+		ParentId: req.ParentId,
+		OwnerId:  userId,
+	}
+	if err := model.CreateOrganization(org); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func GetOrganization(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleMember)
+	if !ok {
+		return
+	}
+	children, err := model.GetChildOrganizations(org.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"organization": org,
+		"children":     children,
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		common.ApiErrorMsg(c, "组织名称不能为空且不能超过 64 个字符")
+		return
+	}
+	org.Name = req.Name
+	if err := org.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func DeleteOrganization(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleOwner)
+	if !ok {
+		return
+	}
+	if err := model.DeleteOrganization(org.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleMember)
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, members)
+}
+
+type organizationMemberRequest struct {
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	QuotaLimit int    `json:"quota_limit"`
+}
+
+// checkMemberRoleChange 只有所有者可以设置管理员，所有者角色只能通过转移所有权变更
+func checkMemberRoleChange(operatorRole string, role string) error {
+	if !model.IsValidOrganizationRole(role) || role == model.OrganizationRoleOwner {
+		return errors.New("无效的角色")
+	}
+	if role == model.OrganizationRoleAdmin && operatorRole != model.OrganizationRoleOwner {
+		return errors.New("只有所有者可以设置管理员")
+	}
+	return nil
+}
+
+func AddOrganizationMember(c *gin.Context) {
+	org, role, ok := getOrganizationForRole(c, model.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrganizationRoleMember
+	}
+	if err := checkMemberRoleChange(role, req.Role); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.QuotaLimit < 0 {
+		common.ApiErrorMsg(c, "额度上限不能为负数")
+		return
+	}
+	user := model.User{Username: strings.TrimSpace(req.Username)}
+	if user.Username == "" {
+		common.ApiErrorMsg(c, "用户名不能为空")
+		return
+	}
+	if err := user.FillUserByUsername(); err != nil || user.Id == 0 {
+		common.ApiErrorMsg(c, "用户不存在")
+		return
+	}
+	member := &model.OrganizationMember{
+		OrgId:      org.Id,
+		UserId:     user.Id,
+		Role:       req.Role,
+		QuotaLimit: req.QuotaLimit,
+	}
+	if err := model.AddOrganizationMember(member); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	member.Username = user.Username
+	common.ApiSuccess(c, member)
+}
+
+// getTargetMember 获取被操作的成员，管理员只能操作普通成员
+func getTargetMember(c *gin.Context, org *model.Organization, operatorRole string) (*model.OrganizationMember, bool) {
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	member, err := model.GetOrganizationMember(org.Id, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	if member == nil {
+		common.ApiErrorMsg(c, "成员不存在")
+		return nil, false
+	}
+	if operatorRole != model.OrganizationRoleOwner && member.Role != model.OrganizationRoleMember {
+		common.ApiErrorMsg(c, "无权操作该成员")
+		return nil, false
+	}
+	return member, true
+}
+
+func UpdateOrganizationMember(c *gin.Context) {
+	org, role, ok := getOrganizationForRole(c, model.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+	member, ok := getTargetMember(c, org, role)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.QuotaLimit < 0 {
+		common.ApiErrorMsg(c, "额度上限不能为负数")
+		return
+	}
+	if req.Role != "" && req.Role != member.Role {
+		if member.Role == model.OrganizationRoleOwner {
+			common.ApiErrorMsg(c, "请通过转移所有权变更所有者")
+			return
+		}
+		if err := checkMemberRoleChange(role, req.Role); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		member.Role = req.Role
+	}
+	member.QuotaLimit = req.QuotaLimit
+	if err := member.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, member)
+}
+
+// RemoveOrganizationMember 移除成员或退出组织，成员名下的组织令牌转移给所有者
+func RemoveOrganizationMember(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	minRole := model.OrganizationRoleAdmin
+	if userId == c.GetInt("id") {
+		minRole = model.OrganizationRoleMember
+	}
+	org, role, ok := getOrganizationForRole(c, minRole)
+	if !ok {
+		return
+	}
+	if userId != c.GetInt("id") {
+		if _, ok := getTargetMember(c, org, role); !ok {
+			return
+		}
+	}
+	if err := model.RemoveOrganizationMember(org.Id, userId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func TransferOrganizationOwner(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleOwner)
+	if !ok {
+		return
+	}
+	var req struct {
+		UserId int `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.TransferOrganizationOwner(org.Id, req.UserId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+type organizationQuotaRequest struct {
+	Quota   int `json:"quota"`
+	ChildId int `json:"child_id"`
+}
+
+// DepositOrganizationQuota 成员将个人额度转入组织额度池
+func DepositOrganizationQuota(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleMember)
+	if !ok {
+		return
+	}
+	var req organizationQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userId := c.GetInt("id")
+	if err := model.DepositOrganizationQuota(org.Id, userId, req.Quota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(userId, model.LogTypeManage, fmt.Sprintf("向组织 %s 转入额度 %s", org.Name, common.LogQuota(req.Quota)))
+	common.ApiSuccess(c, nil)
+}
+
+// AllocateOrganizationQuota 向直属下级组织划拨额度，额度为负数时表示收回
+func AllocateOrganizationQuota(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+	var req organizationQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.AllocateOrganizationQuota(org.Id, req.ChildId, req.Quota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetOrganizationTokens 获取组织令牌，非本人令牌的密钥不返回
+func GetOrganizationTokens(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	tokens, total, err := model.GetOrganizationTokens(org.Id, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userId := c.GetInt("id")
+	for _, token := range tokens {
+		if token.UserId != userId {
+			token.Clean()
+		}
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(tokens)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetOrganizationLogs(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	logs, total, err := model.GetOrganizationLogs(org.Id, logType, startTimestamp, endTimestamp, c.Query("model_name"), c.Query("username"), c.Query("token_name"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetOrganizationUsage 获取组织额度池概况及各成员的用量
+func GetOrganizationUsage(c *gin.Context) {
+	org, _, ok := getOrganizationForRole(c, model.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	usages, err := model.GetOrganizationUsage(org.Id, startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"quota":      org.Quota,
+		"used_quota": org.UsedQuota,
+		"members":    members,
+		"usage":      usages,
+	})
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	orgs, total, err := model.GetAllOrganizations(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(orgs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// ManageOrganization 系统管理员设置组织额度和状态
+func ManageOrganization(c *gin.Context) {
+	var req struct {
+		Id     int  `json:"id"`
+		Quota  *int `json:"quota"`
+		Status int  `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	org, err := model.GetOrganizationById(req.Id)
+	if err != nil {
+		common.ApiErrorMsg(c, "组织不存在")
+		return
+	}
+	if req.Quota != nil {
+		if *req.Quota < 0 {
+			common.ApiErrorMsg(c, "额度不能为负数")
+			return
+		}
+		if err := model.SetOrganizationQuota(org.Id, *req.Quota); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		model.RecordLog(org.OwnerId, model.LogTypeManage, fmt.Sprintf("管理员将组织 %s 的额度从 %s 修改为 %s", org.Name, common.LogQuota(org.Quota), common.LogQuota(*req.Quota)))
+		org.Quota = *req.Quota
+	}
+	if req.Status == model.OrganizationStatusEnabled || req.Status == model.OrganizationStatusDisabled {
+		org.Status = req.Status
+		if err := org.Update(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	common.ApiSuccess(c, org)
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ledgerQueryFromContext(c *gin.Context) model.LedgerQuery {
+	accountId, _ := strconv.Atoi(c.Query("account_id"))
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	startTime, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return model.LedgerQuery{
+		AccountType: c.Query("account_type"),
+		AccountId:   accountId,
+		UserId:      userId,
+		Reason:      c.Query("reason"),
+		SourceId:    c.Query("source_id"),
+		StartTime:   startTime,
+		EndTime:     endTime,
+	}
+}
+
+func GetQuotaLedger(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	entries, total, err := model.GetQuotaLedgerEntries(ledgerQueryFromContext(c), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(entries)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetSelfQuotaLedger 用户查看自己的额度流水
+func GetSelfQuotaLedger(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	query := ledgerQueryFromContext(c)
+	query.UserId = c.GetInt("id")
+	entries, total, err := model.GetQuotaLedgerEntries(query, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(entries)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// ReconcileQuotaLedger 立即对账，返回账本与实际余额不一致的账户
+func ReconcileQuotaLedger(c *gin.Context) {
+	report, err := model.ReconcileQuotaLedger()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, report)
+}
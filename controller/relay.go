@@ -19,9 +19,11 @@ import (
 	"one-api/service"
 	"one-api/types"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func relayHandler(c *gin.Context, relayMode int) *types.NewAPIError {
@@ -70,37 +72,16 @@ func relayHandler(c *gin.Context, relayMode int) *types.NewAPIError {
 }
 
 func Relay(c *gin.Context) {
+	if resumeWriter := helper.NewStreamResumeWriter(c); resumeWriter != nil {
+		defer resumeWriter.Finish()
+	}
 	relayMode := relayconstant.Path2RelayMode(c.Request.URL.Path)
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
-	originalModel := c.GetString("original_model")
-	var newAPIError *types.NewAPIError
-
-	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
-		if err != nil {
-			common.LogError(c, err.Error())
-			newAPIError = err
-			break
-		}
-
-		newAPIError = relayRequest(c, relayMode, channel)
 
-		if newAPIError == nil {
-			return // 成功处理请求，直接返回
-		}
-
-		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
-
-		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
-			break
-		}
-	}
-	useChannel := c.GetStringSlice("use_channel")
-	if len(useChannel) > 1 {
-		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
-		common.LogInfo(c, retryLogStr)
-	}
+	newAPIError := relayWithFallback(c, group, func(channel *model.Channel) *types.NewAPIError {
+		return relayRequest(c, relayMode, channel)
+	})
 
 	if newAPIError != nil {
 		//if newAPIError.StatusCode == http.StatusTooManyRequests {
@@ -114,6 +95,96 @@ func Relay(c *gin.Context) {
 	}
 }
 
+// relayWithFallback 在请求模型的渠道间重试，全部失败或被上游内容过滤时依次切换备用模型。
+// 内容过滤降级只对非流式响应生效：流式响应边生成边写给客户端，识别到拒绝时已无法撤回
+func relayWithFallback(c *gin.Context, group string, doRequest func(channel *model.Channel) *types.NewAPIError) *types.NewAPIError {
+	servedModel := c.GetString("original_model")
+	models := append([]string{servedModel}, service.GetRemainingFallbackModels(c, servedModel)...)
+	var newAPIError *types.NewAPIError
+	retryIndex := 0
+
+models:
+	for modelIndex, modelName := range models {
+		if modelIndex > 0 {
+			if !service.ShouldFallback(newAPIError) {
+				break
+			}
+			service.SwitchFallbackModel(c, models[modelIndex-1], modelName)
+		}
+		c.Header(common.ServedModelKey, modelName)
+
+		for i := 0; i <= common.RetryTimes; i++ {
+			retryIndex = i
+			var channel *model.Channel
+			var err *types.NewAPIError
+			if modelIndex == 0 {
+				channel, err = getChannel(c, group, modelName, i)
+			} else {
+				channel, err = selectChannel(c, group, modelName, i)
+			}
+			if err != nil {
+				common.LogError(c, err.Error())
+				newAPIError = err
+				break
+			}
+
+			// 还有备用模型时暂存非流式响应，以便上游拒绝后切换；流式响应直接透传，不检查拒绝
+			var fallbackWriter *service.FallbackResponseWriter
+			if modelIndex < len(models)-1 {
+				fallbackWriter = service.NewFallbackResponseWriter(c)
+			}
+
+			span, endSpan := common.StartSpan(c, "relay.attempt", append(common.RelaySpanAttributes(c), attribute.Int("relay.retry_index", i))...)
+			attemptStart := time.Now()
+			common.SetContextKey(c, constant.ContextKeyUpstreamLatency, time.Duration(0))
+			newAPIError = doRequest(channel)
+			attemptLatency := time.Since(attemptStart)
+			if newAPIError != nil {
+				common.SetSpanError(span, newAPIError)
+			}
+			endSpan()
+			if common.GetContextKeyBool(c, constant.ContextKeyResponseCacheHit) {
+				// 命中响应缓存时没有请求上游，只归还选中渠道时占用的探测名额
+				service.ReleaseChannelHealth(c, channel.Id)
+			} else {
+				service.RecordChannelHealth(c, channel.Id, newAPIError, attemptLatency)
+				service.RecordChannelKeyUsage(c, channel.Id, newAPIError)
+				service.RecordRelayAttemptMetrics(c, i, newAPIError)
+			}
+
+			if fallbackWriter != nil {
+				// 内容过滤在结算前由 CheckFallbackRefusal 识别，被拒绝的请求已退还预扣额度
+				if newAPIError != nil && newAPIError.GetErrorCode() == types.ErrorCodeContentFiltered {
+					fallbackWriter.Discard()
+					common.LogWarn(c, newAPIError.Error())
+					// 内容过滤与模型相关，不在同模型的其他渠道上重试
+					continue models
+				}
+				fallbackWriter.Finish()
+			}
+
+			if newAPIError == nil {
+				service.RecordRelayOutcomeMetrics(c, i, nil)
+				return nil // 成功处理请求，直接返回
+			}
+
+			// 重试时 span 会替换 c.Request，异步处理使用上下文副本
+			go processChannelError(c.Copy(), *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+		}
+	}
+	service.RecordRelayOutcomeMetrics(c, retryIndex, newAPIError)
+	useChannel := c.GetStringSlice("use_channel")
+	if len(useChannel) > 1 {
+		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
+		common.LogInfo(c, retryLogStr)
+	}
+	return newAPIError
+}
+
 var upgrader = websocket.Upgrader{
 	Subprotocols: []string{"realtime"}, // WS 握手支持的协议，如果有使用 Sec-WebSocket-Protocol，则必须在此声明对应的 Protocol TODO add other protocol
 	CheckOrigin: func(r *http.Request) bool {
@@ -153,7 +224,7 @@ func WssRelay(c *gin.Context) {
 			return // 成功处理请求，直接返回
 		}
 
-		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		go processChannelError(c.Copy(), *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
 			break
@@ -175,37 +246,16 @@ func WssRelay(c *gin.Context) {
 }
 
 func RelayClaude(c *gin.Context) {
+	if resumeWriter := helper.NewStreamResumeWriter(c); resumeWriter != nil {
+		defer resumeWriter.Finish()
+	}
 	//relayMode := constant.Path2RelayMode(c.Request.URL.Path)
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
-	originalModel := c.GetString("original_model")
-	var newAPIError *types.NewAPIError
-
-	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
-		if err != nil {
-			common.LogError(c, err.Error())
-			newAPIError = err
-			break
-		}
-
-		newAPIError = claudeRequest(c, channel)
-
-		if newAPIError == nil {
-			return // 成功处理请求，直接返回
-		}
 
-		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
-
-		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
-			break
-		}
-	}
-	useChannel := c.GetStringSlice("use_channel")
-	if len(useChannel) > 1 {
-		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
-		common.LogInfo(c, retryLogStr)
-	}
+	newAPIError := relayWithFallback(c, group, func(channel *model.Channel) *types.NewAPIError {
+		return claudeRequest(c, channel)
+	})
 
 	if newAPIError != nil {
 		newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
@@ -257,6 +307,11 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
+	return selectChannel(c, group, originalModel, retryCount)
+}
+
+// selectChannel 按重试次数对应的优先级为模型选择渠道
+func selectChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, *types.NewAPIError) {
 	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
 	if err != nil {
 		if group == "auto" {
@@ -474,3 +529,26 @@ func shouldRetryTaskRelay(c *gin.Context, channelId int, taskErr *dto.TaskError,
 	}
 	return true
 }
+
+// ResumeStream 客户端断开后重新连接，继续接收流式响应的剩余内容
+func ResumeStream(c *gin.Context) {
+	err := helper.ServeStreamResume(c, c.Param("request_id"), c.GetInt("id"))
+	switch {
+	case errors.Is(err, helper.ErrStreamResumeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": dto.OpenAIError{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Code:    "stream_not_found",
+			},
+		})
+	case errors.Is(err, helper.ErrStreamResumeTruncated):
+		c.JSON(http.StatusGone, gin.H{
+			"error": dto.OpenAIError{
+				Message: err.Error(),
+				Type:    "server_error",
+				Code:    "stream_resume_truncated",
+			},
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupFallbackTest 使用临时 SQLite 数据库为 model-a、model-b、model-c 各创建一个渠道，并配置 a -> b -> c 的降级链
+func setupFallbackTest(t *testing.T, onContentFilter bool) {
+	t.Helper()
+	previousDB, previousLogDB, previousPath := model.DB, model.LOG_DB, common.SQLitePath
+	previousMaster, previousRedis, previousMemoryCache := common.IsMasterNode, common.RedisEnabled, common.MemoryCacheEnabled
+	previousRetryTimes := common.RetryTimes
+	t.Setenv("SQL_DSN", "local")
+	common.SQLitePath = filepath.Join(t.TempDir(), "fallback.db")
+	common.IsMasterNode, common.RedisEnabled, common.MemoryCacheEnabled = true, false, false
+	common.RetryTimes = 0
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	model.LOG_DB = model.DB
+	settings := model_setting.GetFallbackSettings()
+	previousSettings := *settings
+	*settings = model_setting.FallbackSettings{
+		Chains:          map[string][]string{"model-a": {"model-b", "model-c"}},
+		OnContentFilter: onContentFilter,
+	}
+	t.Cleanup(func() {
+		*settings = previousSettings
+		sqlDB, _ := model.DB.DB()
+		_ = sqlDB.Close()
+		model.DB, model.LOG_DB, common.SQLitePath = previousDB, previousLogDB, previousPath
+		common.IsMasterNode, common.RedisEnabled, common.MemoryCacheEnabled = previousMaster, previousRedis, previousMemoryCache
+		common.RetryTimes = previousRetryTimes
+	})
+
+	autoBan := 0
+	for i, modelName := range []string{"model-a", "model-b", "model-c"} {
+		channel := &model.Channel{
+			Id:      i + 1,
+			Type:    constant.ChannelTypeOpenAI,
+			Name:    modelName,
+			Key:     "sk-" + modelName,
+			Status:  common.ChannelStatusEnabled,
+			Models:  modelName,
+			Group:   "default",
+			AutoBan: &autoBan,
+		}
+		if err := channel.Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeFallbackTestResponse(c *gin.Context, contentType string, body string) {
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	_, _ = c.Writer.WriteString(body)
+}
+
+func TestRelayWithFallback(t *testing.T) {
+	refusal := `{"choices":[{"finish_reason":"content_filter"}]}`
+	answer := `{"choices":[{"finish_reason":"stop"}]}`
+	upstreamError := func(status int) func(c *gin.Context) *types.NewAPIError {
+		return func(c *gin.Context) *types.NewAPIError {
+			return types.NewOpenAIError(errors.New("upstream failed"), types.ErrorCodeBadResponseStatusCode, status)
+		}
+	}
+	answered := func(c *gin.Context) *types.NewAPIError {
+		writeFallbackTestResponse(c, "application/json", answer)
+		return nil
+	}
+	refused := func(c *gin.Context) *types.NewAPIError {
+		writeFallbackTestResponse(c, "application/json", refusal)
+		return service.CheckFallbackRefusal(c, c.GetString("original_model"))
+	}
+
+	tests := []struct {
+		name            string
+		onContentFilter bool
+		tokenModelLimit map[string]bool
+		responses       map[string]func(c *gin.Context) *types.NewAPIError
+		wantAttempts    []string
+		wantServed      string
+		wantFallback    bool
+		wantErrStatus   int
+		wantBody        string
+	}{
+		{
+			name:         "first model succeeds",
+			responses:    map[string]func(c *gin.Context) *types.NewAPIError{"model-a": answered},
+			wantAttempts: []string{"model-a#1"},
+			wantServed:   "model-a",
+			wantBody:     answer,
+		},
+		{
+			name:         "server error switches to the next model",
+			responses:    map[string]func(c *gin.Context) *types.NewAPIError{"model-a": upstreamError(http.StatusInternalServerError), "model-b": answered},
+			wantAttempts: []string{"model-a#1", "model-b#2"},
+			wantServed:   "model-b",
+			wantFallback: true,
+			wantBody:     answer,
+		},
+		{
+			name:          "whole chain fails",
+			responses:     map[string]func(c *gin.Context) *types.NewAPIError{"model-a": upstreamError(http.StatusTooManyRequests), "model-b": upstreamError(http.StatusBadGateway), "model-c": upstreamError(http.StatusServiceUnavailable)},
+			wantAttempts:  []string{"model-a#1", "model-b#2", "model-c#3"},
+			wantServed:    "model-c",
+			wantFallback:  true,
+			wantErrStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:          "client error does not switch",
+			responses:     map[string]func(c *gin.Context) *types.NewAPIError{"model-a": upstreamError(http.StatusBadRequest)},
+			wantAttempts:  []string{"model-a#1"},
+			wantServed:    "model-a",
+			wantErrStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "token model limit skips fallback models",
+			tokenModelLimit: map[string]bool{"model-a": true, "model-c": true},
+			responses:       map[string]func(c *gin.Context) *types.NewAPIError{"model-a": upstreamError(http.StatusInternalServerError), "model-c": answered},
+			wantAttempts:    []string{"model-a#1", "model-c#3"},
+			wantServed:      "model-c",
+			wantFallback:    true,
+			wantBody:        answer,
+		},
+		{
+			name:            "content filter switches and discards the refusal",
+			onContentFilter: true,
+			responses:       map[string]func(c *gin.Context) *types.NewAPIError{"model-a": refused, "model-b": answered},
+			wantAttempts:    []string{"model-a#1", "model-b#2"},
+			wantServed:      "model-b",
+			wantFallback:    true,
+			wantBody:        answer,
+		},
+		{
+			name:            "content filter on the last model is returned",
+			onContentFilter: true,
+			responses:       map[string]func(c *gin.Context) *types.NewAPIError{"model-a": refused, "model-b": refused, "model-c": refused},
+			wantAttempts:    []string{"model-a#1", "model-b#2", "model-c#3"},
+			wantServed:      "model-c",
+			wantFallback:    true,
+			wantBody:        refusal,
+		},
+		{
+			name:         "content filter ignored when disabled",
+			responses:    map[string]func(c *gin.Context) *types.NewAPIError{"model-a": refused},
+			wantAttempts: []string{"model-a#1"},
+			wantServed:   "model-a",
+			wantBody:     refusal,
+		},
+		{
+			// 流式响应已写给客户端，不能切换备用模型
+			name:            "content filter in a stream does not switch",
+			onContentFilter: true,
+			responses: map[string]func(c *gin.Context) *types.NewAPIError{"model-a": func(c *gin.Context) *types.NewAPIError {
+				writeFallbackTestResponse(c, "text/event-stream", "data: "+refusal+"\n\n")
+				return service.CheckFallbackRefusal(c, "model-a")
+			}},
+			wantAttempts: []string{"model-a#1"},
+			wantServed:   "model-a",
+			wantBody:     "data: " + refusal + "\n\n",
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFallbackTest(t, tt.onContentFilter)
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			c.Set("original_model", "model-a")
+			c.Set("channel_id", 1)
+			c.Set("channel_type", constant.ChannelTypeOpenAI)
+			c.Set("channel_name", "model-a")
+			if tt.tokenModelLimit != nil {
+				common.SetContextKey(c, constant.ContextKeyTokenModelLimitEnabled, true)
+				common.SetContextKey(c, constant.ContextKeyTokenModelLimit, tt.tokenModelLimit)
+			}
+
+			var attempts []string
+			newAPIError := relayWithFallback(c, "default", func(channel *model.Channel) *types.NewAPIError {
+				modelName := c.GetString("original_model")
+				attempts = append(attempts, fmt.Sprintf("%s#%d", modelName, channel.Id))
+				respond, ok := tt.responses[modelName]
+				if !ok {
+					t.Fatalf("unexpected request to %s", modelName)
+				}
+				return respond(c)
+			})
+
+			if !reflect.DeepEqual(attempts, tt.wantAttempts) {
+				t.Errorf("attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+			if served := recorder.Header().Get(common.ServedModelKey); served != tt.wantServed {
+				t.Errorf("served model = %q, want %q", served, tt.wantServed)
+			}
+			if from := common.GetContextKeyString(c, constant.ContextKeyFallbackFrom); (from == "model-a") != tt.wantFallback {
+				t.Errorf("fallback from = %q, want fallback %v", from, tt.wantFallback)
+			}
+			if tt.wantErrStatus != 0 {
+				if newAPIError == nil || newAPIError.StatusCode != tt.wantErrStatus {
+					t.Errorf("relayWithFallback() error = %v, want status %d", newAPIError, tt.wantErrStatus)
+				}
+			} else if newAPIError != nil && newAPIError.GetErrorCode() != types.ErrorCodeContentFiltered {
+				t.Errorf("relayWithFallback() error = %v", newAPIError)
+			}
+			if recorder.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", recorder.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRelayWithFallbackResponseCacheHit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, cacheHit := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cache hit %v", cacheHit), func(t *testing.T) {
+			setupFallbackTest(t, false)
+			model.ResetChannelHealth(1)
+			t.Cleanup(func() { model.ResetChannelHealth(1) })
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			c.Set("original_model", "model-a")
+			c.Set("channel_id", 1)
+
+			newAPIError := relayWithFallback(c, "default", func(channel *model.Channel) *types.NewAPIError {
+				common.SetContextKey(c, constant.ContextKeyResponseCacheHit, cacheHit)
+				writeFallbackTestResponse(c, "application/json", `{"choices":[]}`)
+				return nil
+			})
+			if newAPIError != nil {
+				t.Fatalf("relayWithFallback() error = %v", newAPIError)
+			}
+			// 命中缓存时没有请求上游，不计入渠道健康统计
+			if recorded := len(model.GetChannelHealthInfos(1)) > 0; recorded == cacheHit {
+				t.Errorf("channel health recorded = %v, want %v", recorded, !cacheHit)
+			}
+		})
+	}
+}
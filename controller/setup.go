@@ -126,6 +126,8 @@ func PostSetup(c *gin.Context) {
 			})
 			return
 		}
+		model.RecordQuotaLedger(model.LedgerSource{Reason: model.LedgerReasonRegister, UserId: rootUser.Id},
+			model.FundingLegs(model.LedgerAccountUser, rootUser.Id, rootUser.Quota)...)
 	}
 
 	// Set operation modes
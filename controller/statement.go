@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeStatement 按 format 参数返回账单，csv 以附件形式下载，默认返回 JSON
+func writeStatement(c *gin.Context, statement *model.UsageStatement) {
+	switch c.Query("format") {
+	case "csv":
+		data, err := statement.CSV()
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		filename := fmt.Sprintf("statement-%d-%s.csv", statement.UserId, statement.Period)
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+	case "json":
+		filename := fmt.Sprintf("statement-%d-%s.json", statement.UserId, statement.Period)
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.JSON(http.StatusOK, statement)
+	default:
+		common.ApiSuccess(c, statement)
+	}
+}
+
+func GetSelfStatements(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	statements, total, err := model.GetUsageStatements(c.GetInt("id"), c.Query("period"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(statements)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetSelfStatement 获取自己指定月份的账单，支持 format=csv/json 导出
+func GetSelfStatement(c *gin.Context) {
+	period := c.Param("period")
+	if _, _, err := model.ParseStatementPeriod(period); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	statement, err := model.GetUserUsageStatement(c.GetInt("id"), period)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	writeStatement(c, statement)
+}
+
+func GetAllStatements(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	statements, total, err := model.GetUsageStatements(userId, c.Query("period"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(statements)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetStatement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	statement, err := model.GetUsageStatementById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	writeStatement(c, statement)
+}
+
+// RegenerateStatements 重新生成指定月份的账单，user_id 为 0 时重新生成该月所有有消费用户的账单
+func RegenerateStatements(c *gin.Context) {
+	var req struct {
+		Period string `json:"period"`
+		UserId int    `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, _, err := model.ParseStatementPeriod(req.Period); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Period >= time.Now().Format("2006-01") {
+		common.ApiErrorMsg(c, "只能生成已结束月份的账单")
+		return
+	}
+	count, err := model.GenerateUsageStatements(req.Period, req.UserId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("重新生成 %s 月度账单 %d 份", req.Period, count))
+	common.ApiSuccess(c, gin.H{"count": count})
+}
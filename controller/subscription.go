@@ -0,0 +1,365 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/subscription"
+)
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" || len(plan.Name) > 64 {
+		return errors.New("套餐名称不能为空且不能超过 64 个字符")
+	}
+	if plan.MonthlyQuota <= 0 {
+		return errors.New("每月额度必须大于 0")
+	}
+	if plan.Price < 0 {
+		return errors.New("价格不能为负数")
+	}
+	if plan.Status != model.SubscriptionPlanStatusEnabled && plan.Status != model.SubscriptionPlanStatusDisabled {
+		plan.Status = model.SubscriptionPlanStatusEnabled
+	}
+	return nil
+}
+
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+// GetEnabledSubscriptionPlans 用户可订阅的套餐列表
+func GetEnabledSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan.Id = 0
+	if err := plan.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetSubscriptionPlanById(plan.Id); err != nil {
+		common.ApiErrorMsg(c, "订阅套餐不存在")
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := plan.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteSubscriptionPlan(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetAllUserSubscriptions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	subs, total, err := model.GetAllUserSubscriptions(userId, c.Query("status"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(subs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// AssignUserSubscription 管理员为用户开通订阅，months 为 0 时按月持续续期直到取消
+func AssignUserSubscription(c *gin.Context) {
+	var req struct {
+		UserId int `json:"user_id"`
+		PlanId int `json:"plan_id"`
+		Months int `json:"months"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Months < 0 {
+		common.ApiErrorMsg(c, "订阅月数不能为负数")
+		return
+	}
+	if _, err := model.GetUserById(req.UserId, false); err != nil {
+		common.ApiErrorMsg(c, "用户不存在")
+		return
+	}
+	if _, err := model.GetSubscriptionPlanById(req.PlanId); err != nil {
+		common.ApiErrorMsg(c, "订阅套餐不存在")
+		return
+	}
+	now := time.Now()
+	sub := &model.UserSubscription{
+		UserId:    req.UserId,
+		PlanId:    req.PlanId,
+		StartTime: now.Unix(),
+	}
+	if req.Months > 0 {
+		sub.EndTime = now.AddDate(0, req.Months, 0).Unix()
+	}
+	if err := model.CreateUserSubscription(sub); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+// CancelUserSubscription 管理员取消订阅，immediately 为 true 时立即失效，否则当前周期结束后失效
+func CancelUserSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req struct {
+		Immediately bool `json:"immediately"`
+	}
+	_ = c.ShouldBindJSON(&req)
+	sub, err := model.GetUserSubscriptionById(id)
+	if err != nil {
+		common.ApiErrorMsg(c, "订阅不存在")
+		return
+	}
+	if sub.Status == model.SubscriptionStatusExpired {
+		common.ApiErrorMsg(c, "订阅已失效")
+		return
+	}
+	if err := cancelSubscription(sub, req.Immediately); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+func cancelSubscription(sub *model.UserSubscription, immediately bool) error {
+	if sub.StripeSubscriptionId != "" {
+		if err := cancelStripeSubscription(sub.StripeSubscriptionId, immediately); err != nil {
+			return err
+		}
+	}
+	return model.CancelUserSubscription(sub, immediately)
+}
+
+func GetSelfSubscription(c *gin.Context) {
+	sub, err := model.GetUserSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub != nil {
+		sub.Plan, _ = model.GetSubscriptionPlanById(sub.PlanId)
+	}
+	common.ApiSuccess(c, sub)
+}
+
+// CancelSelfSubscription 用户取消自己的订阅，当前周期结束后失效
+func CancelSelfSubscription(c *gin.Context) {
+	sub, err := model.GetUserSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub == nil {
+		common.ApiErrorMsg(c, "当前没有有效的订阅")
+		return
+	}
+	if err := cancelSubscription(sub, false); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+func RequestStripeSubscription(c *gin.Context) {
+	var req struct {
+		PlanId int `json:"plan_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || plan.Status != model.SubscriptionPlanStatusEnabled {
+		c.JSON(200, gin.H{"message": "error", "data": "订阅套餐不存在"})
+		return
+	}
+	if plan.StripePriceId == "" {
+		c.JSON(200, gin.H{"message": "error", "data": "该套餐不支持在线订阅"})
+		return
+	}
+	user, err := model.GetUserById(c.GetInt("id"), false)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "用户不存在"})
+		return
+	}
+	payLink, err := genStripeSubscriptionLink(user, plan)
+	if err != nil {
+		log.Println("获取Stripe订阅支付链接失败", err)
+		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "success",
+		"data": gin.H{
+			"pay_link": payLink,
+		},
+	})
+}
+
+func genStripeSubscriptionLink(user *model.User, plan *model.SubscriptionPlan) (string, error) {
+	if !strings.HasPrefix(setting.StripeApiSecret, "sk_") && !strings.HasPrefix(setting.StripeApiSecret, "rk_") {
+		return "", fmt.Errorf("无效的Stripe API密钥")
+	}
+
+	stripe.Key = setting.StripeApiSecret
+
+	// 用户和套餐记录在订阅的 metadata 中，由订阅 webhook 开通和续期
+	metadata := map[string]string{
+		"user_id": strconv.Itoa(user.Id),
+		"plan_id": strconv.Itoa(plan.Id),
+	}
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL: stripe.String(setting.ServerAddress + "/log"),
+		CancelURL:  stripe.String(setting.ServerAddress + "/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(plan.StripePriceId),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode: stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: metadata,
+		},
+	}
+	params.Metadata = metadata
+
+	if "" == user.StripeCustomer {
+		if "" != user.Email {
+			params.CustomerEmail = stripe.String(user.Email)
+		}
+	} else {
+		params.Customer = stripe.String(user.StripeCustomer)
+	}
+
+	result, err := session.New(params)
+	if err != nil {
+		return "", err
+	}
+
+	return result.URL, nil
+}
+
+func cancelStripeSubscription(stripeSubscriptionId string, immediately bool) error {
+	stripe.Key = setting.StripeApiSecret
+	if immediately {
+		_, err := subscription.Cancel(stripeSubscriptionId, nil)
+		return err
+	}
+	_, err := subscription.Update(stripeSubscriptionId, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	})
+	return err
+}
+
+// stripeSubscriptionStatus 将 Stripe 订阅状态转换为本地状态，返回空字符串表示无需处理
+func stripeSubscriptionStatus(status stripe.SubscriptionStatus) string {
+	switch status {
+	case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+		return model.SubscriptionStatusActive
+	case stripe.SubscriptionStatusPastDue, stripe.SubscriptionStatusUnpaid:
+		return model.SubscriptionStatusPastDue
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		return model.SubscriptionStatusExpired
+	}
+	return ""
+}
+
+// subscriptionChanged 同步 Stripe 订阅状态，仅在同步失败需要 Stripe 重试时返回错误
+func subscriptionChanged(event stripe.Event) error {
+	var stripeSub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &stripeSub); err != nil {
+		log.Printf("解析Stripe订阅失败: %v\n", err)
+		return nil
+	}
+	userId, _ := strconv.Atoi(stripeSub.Metadata["user_id"])
+	planId, _ := strconv.Atoi(stripeSub.Metadata["plan_id"])
+	if userId == 0 || planId == 0 {
+		log.Println("Stripe订阅缺少用户或套餐信息", stripeSub.ID)
+		return nil
+	}
+	status := stripeSubscriptionStatus(stripeSub.Status)
+	if event.Type == stripe.EventTypeCustomerSubscriptionDeleted {
+		status = model.SubscriptionStatusExpired
+	}
+	if status == "" {
+		log.Printf("忽略Stripe订阅状态: %s, %s\n", stripeSub.Status, stripeSub.ID)
+		return nil
+	}
+	customerId := ""
+	if stripeSub.Customer != nil {
+		customerId = stripeSub.Customer.ID
+	}
+	err := model.SyncStripeSubscription(userId, planId, customerId, stripeSub.ID, status,
+		stripeSub.CurrentPeriodStart, stripeSub.CurrentPeriodEnd, stripeSub.CancelAtPeriodEnd)
+	if err != nil {
+		log.Println("同步Stripe订阅失败", stripeSub.ID, ", err:", err.Error())
+		return err
+	}
+	log.Printf("Stripe订阅已同步：%s, 用户 %d, 状态 %s", stripeSub.ID, userId, status)
+	return nil
+}
@@ -116,7 +116,7 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 		common.LogInfo(ctx, fmt.Sprintf("Task %s failed: %s", task.TaskID, task.FailReason))
 		quota := task.Quota
 		if quota != 0 {
-			if err := model.IncreaseUserQuota(task.UserId, quota, false); err != nil {
+			if err := model.IncreasePayerQuota(task.UserId, task.OrgId, task.PayerCharge().Refund(quota), model.TaskRefundLedgerSource(task.UserId, task.TaskID)); err != nil {
 				common.LogError(ctx, "Failed to increase user quota: "+err.Error())
 			}
 			logContent := fmt.Sprintf("Video async task failed %s, refund %s", task.TaskID, common.LogQuota(quota))
@@ -1,10 +1,14 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/setting/operation_setting"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -61,6 +65,62 @@ func GetToken(c *gin.Context) {
 	return
 }
 
+// GetTokenBudget 获取令牌当前各统计周期的额度上限与已用额度
+func GetTokenBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt("id")
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	token, err := model.GetTokenByIds(id, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	usage, err := model.GetTokenQuotaUsage(token.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	now := time.Now()
+	periods := make([]gin.H, 0, len(model.TokenBudgetPeriods))
+	for _, period := range model.TokenBudgetPeriods {
+		periods = append(periods, gin.H{
+			"period":     period,
+			"limit":      token.GetQuotaBudgetLimit(period),
+			"used":       usage[period],
+			"start_time": model.GetTokenBudgetPeriodStart(period, now),
+			"reset_time": model.GetTokenBudgetPeriodEnd(period, now),
+		})
+	}
+	common.ApiSuccess(c, gin.H{
+		"rpm_limit": token.RpmLimit,
+		"periods":   periods,
+	})
+}
+
+func validateTokenBudget(token *model.Token) error {
+	if token.DailyQuotaLimit < 0 || token.WeeklyQuotaLimit < 0 || token.MonthlyQuotaLimit < 0 || token.RpmLimit < 0 {
+		return errors.New("额度上限和每分钟请求数不能为负数")
+	}
+	return nil
+}
+
+// validateTokenGuardrailPolicy 护栏策略只能由管理员设置，且必须是已配置的策略
+func validateTokenGuardrailPolicy(c *gin.Context, policy string) error {
+	if policy == "" {
+		return nil
+	}
+	if c.GetInt("role") < common.RoleAdminUser {
+		return errors.New("只有管理员可以设置护栏策略")
+	}
+	if !operation_setting.HasGuardrailPolicy(policy) {
+		return fmt.Errorf("护栏策略 %s 不存在", policy)
+	}
+	return nil
+}
+
 func GetTokenStatus(c *gin.Context) {
 	tokenId := c.GetInt("token_id")
 	userId := c.GetInt("id")
@@ -96,6 +156,26 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := validateTokenBudget(&token); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateTokenGuardrailPolicy(c, token.GuardrailPolicy); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if token.OrgId != 0 {
+		// 组织令牌从组织额度池扣费，只有组织成员可以创建
+		member, err := model.GetOrganizationMember(token.OrgId, c.GetInt("id"))
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if member == nil {
+			common.ApiErrorMsg(c, "不是该组织的成员")
+			return
+		}
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -118,6 +198,13 @@ func AddToken(c *gin.Context) {
 		ModelLimits:        token.ModelLimits,
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
+		ResponseCacheTTL:   token.ResponseCacheTTL,
+		DailyQuotaLimit:    token.DailyQuotaLimit,
+		WeeklyQuotaLimit:   token.WeeklyQuotaLimit,
+		MonthlyQuotaLimit:  token.MonthlyQuotaLimit,
+		RpmLimit:           token.RpmLimit,
+		OrgId:              token.OrgId,
+		GuardrailPolicy:    token.GuardrailPolicy,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -162,11 +249,24 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := validateTokenBudget(&token); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	// 普通用户不能修改护栏策略，沿用原值
+	if c.GetInt("role") < common.RoleAdminUser {
+		token.GuardrailPolicy = cleanToken.GuardrailPolicy
+	} else if token.GuardrailPolicy != cleanToken.GuardrailPolicy {
+		if err := validateTokenGuardrailPolicy(c, token.GuardrailPolicy); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
 	if token.Status == common.TokenStatusEnabled {
 		if cleanToken.Status == common.TokenStatusExpired && cleanToken.ExpiredTime <= common.GetTimestamp() && cleanToken.ExpiredTime != -1 {
 			c.JSON(http.StatusOK, gin.H{
@@ -195,6 +295,12 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
+		cleanToken.ResponseCacheTTL = token.ResponseCacheTTL
+		cleanToken.DailyQuotaLimit = token.DailyQuotaLimit
+		cleanToken.WeeklyQuotaLimit = token.WeeklyQuotaLimit
+		cleanToken.MonthlyQuotaLimit = token.MonthlyQuotaLimit
+		cleanToken.RpmLimit = token.RpmLimit
+		cleanToken.GuardrailPolicy = token.GuardrailPolicy
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -230,6 +230,12 @@ func EpayNotify(c *gin.Context) {
 				log.Printf("易支付回调更新用户失败: %v", topUp)
 				return
 			}
+			model.RecordQuotaLedger(model.LedgerSource{
+				Reason:     model.LedgerReasonTopUp,
+				SourceType: model.LedgerSourceTradeNo,
+				SourceId:   topUp.TradeNo,
+				UserId:     topUp.UserId,
+			}, model.FundingLegs(model.LedgerAccountUser, topUp.UserId, quotaToAdd)...)
 			log.Printf("易支付回调更新用户成功 %v", topUp)
 			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(quotaToAdd), topUp.Money))
 		}
@@ -146,6 +146,14 @@ func StripeWebhook(c *gin.Context) {
 		sessionCompleted(event)
 	case stripe.EventTypeCheckoutSessionExpired:
 		sessionExpired(event)
+	case stripe.EventTypeCustomerSubscriptionCreated,
+		stripe.EventTypeCustomerSubscriptionUpdated,
+		stripe.EventTypeCustomerSubscriptionDeleted:
+		if err := subscriptionChanged(event); err != nil {
+			// 返回错误让 Stripe 稍后重试
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
 	default:
 		log.Printf("不支持的Stripe Webhook事件类型: %s\n", event.Type)
 	}
@@ -154,6 +162,10 @@ func StripeWebhook(c *gin.Context) {
 }
 
 func sessionCompleted(event stripe.Event) {
+	// 订阅由 customer.subscription.* 事件开通
+	if event.GetObjectValue("mode") == string(stripe.CheckoutSessionModeSubscription) {
+		return
+	}
 	customerId := event.GetObjectValue("customer")
 	referenceId := event.GetObjectValue("client_reference_id")
 	status := event.GetObjectValue("status")
@@ -180,6 +192,9 @@ func sessionExpired(event stripe.Event) {
 		log.Println("错误的Stripe Checkout过期状态:", status, ",", referenceId)
 		return
 	}
+	if event.GetObjectValue("mode") == string(stripe.CheckoutSessionModeSubscription) {
+		return
+	}
 
 	if len(referenceId) == 0 {
 		log.Println("未提供支付单号")
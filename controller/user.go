@@ -406,6 +406,12 @@ func GetSelf(c *gin.Context) {
 	}
 	// Hide admin remarks: set to empty to trigger omitempty tag, ensuring the remark field is not included in JSON returned to regular users
 	user.Remark = ""
+	// webhook 密钥加密存储，本人查看时返回解密后的设置
+	if user.Setting != "" {
+		if settingBytes, err := common.Marshal(user.GetSetting()); err == nil {
+			user.Setting = string(settingBytes)
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -4,4 +4,31 @@ type ChannelSettings struct {
 	ForceFormat       bool   `json:"force_format,omitempty"`
 	ThinkingToContent bool   `json:"thinking_to_content,omitempty"`
 	Proxy             string `json:"proxy"`
+	// 渠道相对官方价格的成本倍率，用于最低价格路由，未设置时视为 1
+	CostRatio float64 `json:"cost_ratio,omitempty"`
+	// 上游不支持 Realtime 协议时，通过 chat completions + 语音识别/合成桥接 /v1/realtime
+	RealtimeBridge bool `json:"realtime_bridge,omitempty"`
+	// 能力探测配置
+	TestProfile *ChannelTestProfile `json:"test_profile,omitempty"`
+	// 按模型声明支持的能力，键为模型名称或 *，声明后不再参考能力探测结果
+	Capabilities map[string][]string `json:"capabilities,omitempty"`
+}
+
+// GetModelCapabilities 获取为模型声明的能力，模型没有单独声明时使用 * 的声明
+func (s ChannelSettings) GetModelCapabilities(model string) ([]string, bool) {
+	if capabilities, ok := s.Capabilities[model]; ok {
+		return capabilities, true
+	}
+	capabilities, ok := s.Capabilities["*"]
+	return capabilities, ok
+}
+
+// ChannelTestProfile 渠道能力探测配置
+type ChannelTestProfile struct {
+	// 探测的模型，为空时使用测试模型
+	Models []string `json:"models,omitempty"`
+	// 探测的能力，为空时按模型类型选择
+	Capabilities []string `json:"capabilities,omitempty"`
+	// 定时测试渠道时同时探测能力
+	AutoProbe bool `json:"auto_probe,omitempty"`
 }
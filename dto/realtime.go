@@ -8,6 +8,10 @@ const (
 	RealtimeEventTypeConversationCreate = "conversation.item.create"
 	RealtimeEventTypeResponseCreate     = "response.create"
 	RealtimeEventInputAudioBufferAppend = "input_audio_buffer.append"
+	RealtimeEventInputAudioBufferCommit = "input_audio_buffer.commit"
+	RealtimeEventInputAudioBufferClear  = "input_audio_buffer.clear"
+	RealtimeEventTypeConversationDelete = "conversation.item.delete"
+	RealtimeEventTypeResponseCancel     = "response.cancel"
 )
 
 const (
@@ -19,6 +23,20 @@ const (
 	RealtimeEventResponseFunctionCallArgumentsDelta = "response.function_call_arguments.delta"
 	RealtimeEventResponseFunctionCallArgumentsDone  = "response.function_call_arguments.done"
 	RealtimeEventConversationItemCreated            = "conversation.item.created"
+	RealtimeEventConversationItemDeleted            = "conversation.item.deleted"
+	RealtimeEventInputAudioBufferCommitted          = "input_audio_buffer.committed"
+	RealtimeEventInputAudioBufferCleared            = "input_audio_buffer.cleared"
+	RealtimeEventInputAudioTranscriptionCompleted   = "conversation.item.input_audio_transcription.completed"
+	RealtimeEventInputAudioTranscriptionFailed      = "conversation.item.input_audio_transcription.failed"
+	RealtimeEventResponseCreated                    = "response.created"
+	RealtimeEventResponseOutputItemAdded            = "response.output_item.added"
+	RealtimeEventResponseOutputItemDone             = "response.output_item.done"
+	RealtimeEventResponseContentPartAdded           = "response.content_part.added"
+	RealtimeEventResponseContentPartDone            = "response.content_part.done"
+	RealtimeEventResponseTextDelta                  = "response.text.delta"
+	RealtimeEventResponseTextDone                   = "response.text.done"
+	RealtimeEventResponseAudioDone                  = "response.audio.done"
+	RealtimeEventResponseAudioTranscriptionDone     = "response.audio_transcript.done"
 )
 
 type RealtimeEvent struct {
@@ -31,10 +49,29 @@ type RealtimeEvent struct {
 	Response *RealtimeResponse  `json:"response,omitempty"`
 	Delta    string             `json:"delta,omitempty"`
 	Audio    string             `json:"audio,omitempty"`
+
+	// 服务端事件字段，桥接模式下由网关生成
+	PreviousItemId string           `json:"previous_item_id,omitempty"`
+	ResponseId     string           `json:"response_id,omitempty"`
+	ItemId         string           `json:"item_id,omitempty"`
+	OutputIndex    *int             `json:"output_index,omitempty"`
+	ContentIndex   *int             `json:"content_index,omitempty"`
+	Part           *RealtimeContent `json:"part,omitempty"`
+	Text           string           `json:"text,omitempty"`
+	Transcript     string           `json:"transcript,omitempty"`
+	CallId         string           `json:"call_id,omitempty"`
+	Name           string           `json:"name,omitempty"`
+	Arguments      string           `json:"arguments,omitempty"`
 }
 
 type RealtimeResponse struct {
-	Usage *RealtimeUsage `json:"usage"`
+	Id           string         `json:"id,omitempty"`
+	Object       string         `json:"object,omitempty"`
+	Status       string         `json:"status,omitempty"`
+	Modalities   []string       `json:"modalities,omitempty"`
+	Instructions string         `json:"instructions,omitempty"`
+	Output       []RealtimeItem `json:"output,omitempty"`
+	Usage        *RealtimeUsage `json:"usage"`
 }
 
 type RealtimeUsage struct {
@@ -46,6 +83,9 @@ type RealtimeUsage struct {
 }
 
 type RealtimeSession struct {
+	Id                      string                  `json:"id,omitempty"`
+	Object                  string                  `json:"object,omitempty"`
+	Model                   string                  `json:"model,omitempty"`
 	Modalities              []string                `json:"modalities"`
 	Instructions            string                  `json:"instructions"`
 	Voice                   string                  `json:"voice"`
@@ -79,6 +119,9 @@ type RealtimeItem struct {
 	Name      *string           `json:"name,omitempty"`
 	ToolCalls any               `json:"tool_calls,omitempty"`
 	CallId    string            `json:"call_id,omitempty"`
+	Object    string            `json:"object,omitempty"`
+	Arguments string            `json:"arguments,omitempty"`
+	Output    string            `json:"output,omitempty"`
 }
 type RealtimeContent struct {
 	Type       string `json:"type"`
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -15,6 +16,7 @@ import (
 	"one-api/setting/ratio_setting"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"github.com/gin-contrib/sessions"
@@ -39,6 +41,17 @@ func main() {
 		return
 	}
 
+	if *common.RotateSecrets {
+		report, err := model.RotateSecrets()
+		if err != nil {
+			common.FatalLog("failed to rotate secrets: " + err.Error())
+		}
+		common.SysLog(fmt.Sprintf("secrets re-encrypted with the active key: %d channels, %d users, %d options",
+			report.Channels, report.Users, report.Options))
+		_ = model.CloseDB()
+		return
+	}
+
 	common.SysLog("New API " + common.Version + " started")
 	if os.Getenv("GIN_MODE") != "debug" {
 		gin.SetMode(gin.ReleaseMode)
@@ -54,6 +67,13 @@ func main() {
 		}
 	}()
 
+	shutdownTracing, err := common.InitTracing()
+	if err != nil {
+		common.SysError("failed to initialize tracing: " + err.Error())
+	} else {
+		defer shutdownTracing(context.Background())
+	}
+
 	if common.RedisEnabled {
 		// for compatibility with old versions
 		common.MemoryCacheEnabled = true
@@ -78,6 +98,9 @@ func main() {
 		}()
 
 		go model.SyncChannelCache(common.SyncFrequency)
+	} else {
+		model.InitChannelCapabilityCache()
+		go model.SyncChannelCapabilityCache(common.SyncFrequency)
 	}
 
 	// 热更新配置
@@ -107,6 +130,23 @@ func main() {
 		gopool.Go(func() {
 			controller.UpdateTaskBulk()
 		})
+		gopool.Go(func() {
+			controller.UpdateBatchBulk()
+		})
+	}
+	if common.IsMasterNode {
+		go model.CleanExpiredTokenQuotaUsage()
+		go model.ProcessExpiredSubscriptions()
+		go model.GenerateMonthlyStatements()
+		if err := model.InitQuotaLedger(); err != nil {
+			common.SysError("failed to initialize quota ledger: " + err.Error())
+		}
+		if constant.QuotaReconcileInterval > 0 {
+			go model.ReconcileQuotaLedgerPeriodically(time.Duration(constant.QuotaReconcileInterval) * time.Minute)
+		}
+		if constant.ChatLogPurgeInterval > 0 {
+			go model.PurgeChatLogsPeriodically(time.Duration(constant.ChatLogPurgeInterval) * time.Minute)
+		}
 	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
@@ -136,6 +176,7 @@ func main() {
 	// This will cause SSE not to work!!!
 	//server.Use(gzip.Gzip(gzip.DefaultCompression))
 	server.Use(middleware.RequestId())
+	server.Use(middleware.Tracing())
 	middleware.SetUpLogger(server)
 	// Initialize session store
 	store := cookie.NewStore([]byte(common.SessionSecret))
@@ -173,6 +214,10 @@ func InitResources() error {
 
 	common.SetupLogger()
 
+	if err := common.CheckSecretEncryptionKeys(); err != nil {
+		return err
+	}
+
 	// Initialize model settings
 	ratio_setting.InitRatioSettings()
 
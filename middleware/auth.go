@@ -171,6 +171,11 @@ func WssAuth(c *gin.Context) {
 
 }
 
+// RelayTokenAuth 中继接口的令牌鉴权与限流，路由与批处理执行共用
+func RelayTokenAuth() []gin.HandlerFunc {
+	return []gin.HandlerFunc{TokenAuth(), TokenRequestRateLimit(), ModelRequestRateLimit()}
+}
+
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		// 先检测是否为ws
@@ -274,6 +279,11 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	c.Set("allow_ips", token.GetIpLimitsMap())
 	c.Set("token_group", token.Group)
+	c.Set("token_response_cache_ttl", token.ResponseCacheTTL)
+	c.Set("token_quota_budget", token.HasQuotaBudget())
+	c.Set("token_rpm_limit", token.RpmLimit)
+	c.Set("token_org_id", token.OrgId)
+	c.Set("token_guardrail_policy", token.GuardrailPolicy)
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -27,6 +27,8 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span, endSpan := common.StartSpan(c, "distribute")
+		defer endSpan()
 		allowIpsMap := common.GetContextKeyStringMap(c, constant.ContextKeyTokenAllowIps)
 		if len(allowIpsMap) != 0 {
 			clientIp := c.ClientIP()
@@ -100,13 +102,32 @@ func Distribute() func(c *gin.Context) {
 			}
 
 			if shouldSelectChannel {
+				setRequiredCapabilities(c)
 				var selectGroup string
 				channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
+				if err != nil {
+					// 请求模型无可用渠道时依次尝试备用模型
+					for _, fallback := range service.GetFallbackModels(c, modelRequest.Model) {
+						fallbackChannel, fallbackGroup, fallbackErr := model.CacheGetRandomSatisfiedChannel(c, userGroup, fallback, 0)
+						if fallbackErr == nil && fallbackChannel != nil {
+							service.SwitchFallbackModel(c, modelRequest.Model, fallback)
+							channel, selectGroup, err = fallbackChannel, fallbackGroup, nil
+							modelRequest.Model = fallback
+							break
+						}
+					}
+				}
 				if err != nil {
 					showGroup := userGroup
 					if userGroup == "auto" {
 						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
 					}
+					// 有渠道提供该模型，但均不支持请求用到的能力
+					if errors.Is(err, model.ErrChannelCapabilityUnsupported) {
+						capabilities := common.GetContextKeyStringSlice(c, constant.ContextKeyRequiredCapabilities)
+						abortWithOpenAiMessage(c, http.StatusBadRequest, fmt.Sprintf("当前分组 %s 下对于模型 %s 无支持 %s 的可用渠道", showGroup, modelRequest.Model, strings.Join(capabilities, ", ")))
+						return
+					}
 					message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", showGroup, modelRequest.Model)
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
 					if channel != nil {
@@ -124,7 +145,12 @@ func Distribute() func(c *gin.Context) {
 			}
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
-		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		if newAPIError := SetupContextForSelectedChannel(c, channel, modelRequest.Model); newAPIError != nil {
+			abortWithOpenAiMessage(c, newAPIError.StatusCode, newAPIError.Error())
+			return
+		}
+		span.SetAttributes(common.RelaySpanAttributes(c)...)
+		endSpan()
 		c.Next()
 	}
 }
@@ -250,6 +276,67 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	return &modelRequest, shouldSelectChannel, nil
 }
 
+// setRequiredCapabilities 记录对话请求用到的能力，选择渠道时排除不支持这些能力的渠道
+func setRequiredCapabilities(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions") && !strings.HasPrefix(c.Request.URL.Path, "/pg/chat/completions") {
+		return
+	}
+	var request dto.GeneralOpenAIRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return
+	}
+	capabilities := getRequestCapabilities(&request)
+	if len(capabilities) > 0 {
+		common.SetContextKey(c, constant.ContextKeyRequiredCapabilities, capabilities)
+	}
+}
+
+// getRequestCapabilities 根据请求内容检测需要渠道支持的能力
+func getRequestCapabilities(request *dto.GeneralOpenAIRequest) []string {
+	capabilities := make([]string, 0)
+	if request.Stream {
+		capabilities = append(capabilities, model.ChannelCapabilityStreaming)
+	}
+	if len(request.Tools) > 0 || (len(request.Functions) > 0 && string(request.Functions) != "null") {
+		capabilities = append(capabilities, model.ChannelCapabilityTools)
+	}
+	if request.ResponseFormat != nil {
+		switch request.ResponseFormat.Type {
+		case "json_object":
+			capabilities = append(capabilities, model.ChannelCapabilityJsonMode)
+		case "json_schema":
+			capabilities = append(capabilities, model.ChannelCapabilityJsonSchema)
+		}
+	}
+	hasImage, hasAudio := false, false
+	for _, message := range request.Messages {
+		if message.IsStringContent() {
+			continue
+		}
+		for _, content := range message.ParseContent() {
+			switch content.Type {
+			case dto.ContentTypeImageURL:
+				hasImage = true
+			case dto.ContentTypeInputAudio:
+				hasAudio = true
+			}
+		}
+	}
+	if hasImage {
+		capabilities = append(capabilities, model.ChannelCapabilityVision)
+	}
+	if !hasAudio && len(request.Modalities) > 0 {
+		var modalities []string
+		if err := common.Unmarshal(request.Modalities, &modalities); err == nil {
+			hasAudio = common.StringsContains(modalities, "audio")
+		}
+	}
+	if hasAudio || (len(request.Audio) > 0 && string(request.Audio) != "null") {
+		capabilities = append(capabilities, model.ChannelCapabilityAudio)
+	}
+	return capabilities
+}
+
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) *types.NewAPIError {
 	c.Set("original_model", modelName) // for retry
 	if channel == nil {
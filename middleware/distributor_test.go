@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"reflect"
+	"testing"
+)
+
+func TestGetRequestCapabilities(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "plain text",
+			body: `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`,
+			want: []string{},
+		},
+		{
+			name: "stream with tools",
+			body: `{"stream":true,"messages":[{"role":"user","content":"hi"}],"tools":[{"type":"function","function":{"name":"f"}}]}`,
+			want: []string{model.ChannelCapabilityStreaming, model.ChannelCapabilityTools},
+		},
+		{
+			name: "legacy functions",
+			body: `{"messages":[{"role":"user","content":"hi"}],"functions":[{"name":"f"}]}`,
+			want: []string{model.ChannelCapabilityTools},
+		},
+		{
+			name: "null functions",
+			body: `{"messages":[{"role":"user","content":"hi"}],"functions":null}`,
+			want: []string{},
+		},
+		{
+			name: "json mode",
+			body: `{"messages":[{"role":"user","content":"hi"}],"response_format":{"type":"json_object"}}`,
+			want: []string{model.ChannelCapabilityJsonMode},
+		},
+		{
+			name: "json schema",
+			body: `{"messages":[{"role":"user","content":"hi"}],"response_format":{"type":"json_schema","json_schema":{"name":"r","schema":{}}}}`,
+			want: []string{model.ChannelCapabilityJsonSchema},
+		},
+		{
+			name: "text response format",
+			body: `{"messages":[{"role":"user","content":"hi"}],"response_format":{"type":"text"}}`,
+			want: []string{},
+		},
+		{
+			name: "image content",
+			body: `{"messages":[{"role":"user","content":[{"type":"text","text":"what"},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`,
+			want: []string{model.ChannelCapabilityVision},
+		},
+		{
+			name: "input audio content",
+			body: `{"messages":[{"role":"user","content":[{"type":"input_audio","input_audio":{"data":"AAAA","format":"wav"}}]}]}`,
+			want: []string{model.ChannelCapabilityAudio},
+		},
+		{
+			name: "audio output modality",
+			body: `{"messages":[{"role":"user","content":"hi"}],"modalities":["text","audio"]}`,
+			want: []string{model.ChannelCapabilityAudio},
+		},
+		{
+			name: "text modality only",
+			body: `{"messages":[{"role":"user","content":"hi"}],"modalities":["text"]}`,
+			want: []string{},
+		},
+		{
+			name: "audio parameters",
+			body: `{"messages":[{"role":"user","content":"hi"}],"audio":{"voice":"alloy","format":"wav"}}`,
+			want: []string{model.ChannelCapabilityAudio},
+		},
+		{
+			name: "everything",
+			body: `{"stream":true,"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}],` +
+				`"tools":[{"type":"function","function":{"name":"f"}}],"response_format":{"type":"json_object"},"modalities":["audio"]}`,
+			want: []string{model.ChannelCapabilityStreaming, model.ChannelCapabilityTools, model.ChannelCapabilityJsonMode,
+				model.ChannelCapabilityVision, model.ChannelCapabilityAudio},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request dto.GeneralOpenAIRequest
+			if err := common.UnmarshalJsonStr(tt.body, &request); err != nil {
+				t.Fatal(err)
+			}
+			if got := getRequestCapabilities(&request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getRequestCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
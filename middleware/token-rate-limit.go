@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const TokenRequestRateLimitMark = "TRRL"
+
+var tokenRateLimiter common.InMemoryRateLimiter
+
+// 检查并记录令牌在一分钟内的请求数
+func checkTokenRedisRateLimit(key string, maxCount int) (bool, error) {
+	ctx := context.Background()
+	rdb := common.RDB
+	allowed, err := checkRedisRateLimit(ctx, rdb, key, maxCount, 60)
+	if err != nil || !allowed {
+		return allowed, err
+	}
+	rdb.LPush(ctx, key, time.Now().Format(timeFormat))
+	rdb.LTrim(ctx, key, 0, int64(maxCount-1))
+	rdb.Expire(ctx, key, time.Minute)
+	return true, nil
+}
+
+// TokenRequestRateLimit 令牌每分钟请求数限制中间件，需在 TokenAuth 之后使用
+func TokenRequestRateLimit() func(c *gin.Context) {
+	tokenRateLimiter.Init(time.Minute)
+	return func(c *gin.Context) {
+		rpmLimit := common.GetContextKeyInt(c, constant.ContextKeyTokenRpmLimit)
+		if rpmLimit <= 0 {
+			c.Next()
+			return
+		}
+		key := fmt.Sprintf("%s:%d", TokenRequestRateLimitMark, common.GetContextKeyInt(c, constant.ContextKeyTokenId))
+		allowed := true
+		if common.RedisEnabled {
+			var err error
+			allowed, err = checkTokenRedisRateLimit(key, rpmLimit)
+			if err != nil {
+				common.SysError("token rate limit check failed: " + err.Error())
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return
+			}
+		} else {
+			allowed = tokenRateLimiter.Request(key, rpmLimit, 60)
+		}
+		if !allowed {
+			c.Header("Retry-After", "60")
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("token rate limit exceeded: %d requests per minute", rpmLimit))
+			return
+		}
+		c.Next()
+	}
+}
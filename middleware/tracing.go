@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"one-api/common"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Tracing 为每个已注册路由的请求开启根 span，未启用追踪时 span 为空实现
+func Tracing() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+		span, end := common.StartServerSpan(c, c.Request.Method+" "+route,
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String("request_id", c.GetString(common.RequestIdKey)),
+		)
+		defer end()
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		if userId := c.GetInt("id"); userId != 0 {
+			span.SetAttributes(attribute.Int("user.id", userId))
+		}
+	}
+}
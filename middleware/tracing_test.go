@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector 本地 OTLP/HTTP 接收端，记录导出的 span
+type otlpCollector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (collector *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	request := &coltracepb.ExportTraceServiceRequest{}
+	if err != nil || r.URL.Path != "/v1/traces" || proto.Unmarshal(body, request) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	collector.mu.Lock()
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			collector.spans = append(collector.spans, scopeSpans.Spans...)
+		}
+	}
+	collector.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	data, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	_, _ = w.Write(data)
+}
+
+func spanAttributes(span *tracepb.Span) map[string]string {
+	attrs := make(map[string]string, len(span.Attributes))
+	for _, attr := range span.Attributes {
+		switch value := attr.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			attrs[attr.Key] = value.StringValue
+		case *commonpb.AnyValue_IntValue:
+			attrs[attr.Key] = strconv.FormatInt(value.IntValue, 10)
+		}
+	}
+	return attrs
+}
+
+func TestTracingExport(t *testing.T) {
+	collector := &otlpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+	t.Setenv("OTEL_SERVICE_NAME", "new-api-test")
+	previousEnabled, previousRatio := constant.TracingEnabled, constant.TracingSampleRatio
+	constant.TracingEnabled, constant.TracingSampleRatio = true, 1
+	t.Cleanup(func() {
+		constant.TracingEnabled, constant.TracingSampleRatio = previousEnabled, previousRatio
+	})
+	shutdown, err := common.InitTracing()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Tracing())
+	upstreamTraceparents := make(map[string]string)
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set("id", 7)
+		span, end := common.StartSpan(c, "adaptor.do_request")
+		header := http.Header{}
+		common.InjectTraceHeaders(c.Request.Context(), header)
+		upstreamTraceparents[c.GetHeader("X-Case")] = header.Get("traceparent")
+		status, _ := strconv.Atoi(c.Query("status"))
+		if status >= http.StatusInternalServerError {
+			common.SetSpanError(span, errors.New("upstream failed"))
+		}
+		end()
+		c.Status(status)
+	})
+
+	const parentTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentSpanId = "00f067aa0ba902b7"
+	tests := []struct {
+		name        string
+		status      int
+		traceparent string
+		wantError   bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "continues incoming trace", status: http.StatusOK, traceparent: "00-" + parentTraceId + "-" + parentSpanId + "-01"},
+		{name: "server error", status: http.StatusBadGateway, wantError: true},
+		{name: "client error", status: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/v1/chat/completions?status="+strconv.Itoa(tt.status), nil)
+		request.Header.Set("X-Case", tt.name)
+		if tt.traceparent != "" {
+			request.Header.Set("traceparent", tt.traceparent)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	// 关闭时刷新所有 span 到接收端
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	serverSpans := make(map[string]*tracepb.Span)
+	childSpans := make(map[string]*tracepb.Span)
+	for _, span := range collector.spans {
+		key := hex.EncodeToString(span.TraceId)
+		if span.Kind == tracepb.Span_SPAN_KIND_SERVER {
+			serverSpans[key] = span
+		} else {
+			childSpans[key] = span
+		}
+	}
+	if len(serverSpans) != len(tests) || len(childSpans) != len(tests) {
+		t.Fatalf("collector received %d server and %d child spans, want %d each", len(serverSpans), len(childSpans), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traceparent := upstreamTraceparents[tt.name]
+			if len(traceparent) != 55 {
+				t.Fatalf("upstream traceparent = %q", traceparent)
+			}
+			traceId := traceparent[3:35]
+			serverSpan, child := serverSpans[traceId], childSpans[traceId]
+			if serverSpan == nil || child == nil {
+				t.Fatalf("spans for trace %s not exported", traceId)
+			}
+			if tt.traceparent != "" && (traceId != parentTraceId || hex.EncodeToString(serverSpan.ParentSpanId) != parentSpanId) {
+				t.Errorf("server span trace/parent = %s/%x, want %s/%s", traceId, serverSpan.ParentSpanId, parentTraceId, parentSpanId)
+			}
+			if tt.traceparent == "" && len(serverSpan.ParentSpanId) != 0 {
+				t.Errorf("server span has unexpected parent %x", serverSpan.ParentSpanId)
+			}
+			if serverSpan.Name != "POST /v1/chat/completions" {
+				t.Errorf("server span name = %s", serverSpan.Name)
+			}
+			attrs := spanAttributes(serverSpan)
+			if attrs["http.route"] != "/v1/chat/completions" || attrs["http.response.status_code"] != strconv.Itoa(tt.status) || attrs["user.id"] != "7" {
+				t.Errorf("server span attributes = %v", attrs)
+			}
+			if isError := serverSpan.Status.GetCode() == tracepb.Status_STATUS_CODE_ERROR; isError != tt.wantError {
+				t.Errorf("server span error = %v, want %v", isError, tt.wantError)
+			}
+			if child.Name != "adaptor.do_request" || hex.EncodeToString(child.ParentSpanId) != hex.EncodeToString(serverSpan.SpanId) {
+				t.Errorf("child span %s parent = %x, want %x", child.Name, child.ParentSpanId, serverSpan.SpanId)
+			}
+			// 上游请求头携带的是子 span
+			if traceparent[36:52] != hex.EncodeToString(child.SpanId) {
+				t.Errorf("upstream traceparent span = %s, want %x", traceparent[36:52], child.SpanId)
+			}
+			if isError := child.Status.GetCode() == tracepb.Status_STATUS_CODE_ERROR; isError != tt.wantError {
+				t.Errorf("child span error = %v, want %v", isError, tt.wantError)
+			}
+		})
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"one-api/common"
+	"one-api/setting/operation_setting"
+	"sort"
 	"strings"
 	"sync"
 
@@ -57,78 +59,90 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
-
-	var priorities []int
-	err := DB.Model(&Ability{}).
-		Select("DISTINCT(priority)").
-		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
-		Order("priority DESC").              // 按优先级降序排序
-		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
+func abilityChannelIds(abilities []Ability) []int {
+	return lo.Uniq(lo.Map(abilities, func(ability_ Ability, _ int) int {
+		return ability_.ChannelId
+	}))
+}
 
+// getSatisfiedAbilities 未启用内存缓存时读取分组下模型的全部渠道，先排除不支持所需能力和熔断中的渠道，
+// 再按重试次数选择优先级，与内存缓存的筛选顺序一致，避免高优先级渠道全部熔断时低优先级渠道拿不到流量
+func getSatisfiedAbilities(group string, model string, retry int, capabilities []string) ([]Ability, error) {
+	var abilities []Ability
+	err := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
+		Order("weight DESC").Find(&abilities).Error
+	if err != nil || len(abilities) == 0 {
+		return nil, err
+	}
+	abilities, err = filterCapableAbilities(abilities, model, capabilities)
 	if err != nil {
-		// 处理错误
-		return 0, err
+		return nil, err
 	}
-
-	if len(priorities) == 0 {
-		// 如果没有查询到优先级，则返回错误
-		return 0, errors.New("数据库一致性被破坏")
+	// 排除熔断中的渠道，全部熔断时仍在原渠道中选择
+	available := filterAvailableChannels(abilityChannelIds(abilities))
+	// 排除密钥均在冷却或达到速率上限的多密钥渠道
+	available, err = filterKeyUsableChannelsFromDB(available)
+	if err != nil {
+		return nil, err
 	}
+	abilities = lo.Filter(abilities, func(ability_ Ability, _ int) bool {
+		return lo.Contains(available, ability_.ChannelId)
+	})
 
-	// 确定要使用的优先级
-	var priorityToUse int
+	priority := func(ability_ Ability) int64 {
+		if ability_.Priority == nil {
+			return 0
+		}
+		return *ability_.Priority
+	}
+	priorities := lo.Uniq(lo.Map(abilities, func(ability_ Ability, _ int) int64 {
+		return priority(ability_)
+	}))
+	sort.Slice(priorities, func(i, j int) bool {
+		return priorities[i] > priorities[j]
+	})
 	if retry >= len(priorities) {
-		// 如果重试次数大于优先级数，则使用最小的优先级
-		priorityToUse = priorities[len(priorities)-1]
-	} else {
-		priorityToUse = priorities[retry]
+		retry = len(priorities) - 1
 	}
-	return priorityToUse, nil
+	targetPriority := priorities[retry]
+	return lo.Filter(abilities, func(ability_ Ability, _ int) bool {
+		return priority(ability_) == targetPriority
+	}), nil
 }
 
-func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
-	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
-	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
-		if err != nil {
-			return nil, err
-		} else {
-			channelQuery = DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = ?", group, model, true, priority)
-		}
+// filterKeyUsableChannelsFromDB 未启用内存缓存时从数据库读取多密钥信息后排除密钥均不可用的渠道
+func filterKeyUsableChannelsFromDB(channelIds []int) ([]int, error) {
+	var channels []*Channel
+	if err := DB.Select("id", "channel_info").Where("id in (?)", channelIds).Find(&channels).Error; err != nil {
+		return nil, err
 	}
-
-	return channelQuery, nil
+	return filterKeyUsable(channelIds, lo.SliceToMap(channels, func(channel *Channel) (int, *Channel) {
+		return channel.Id, channel
+	})), nil
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
-	var abilities []Ability
-
-	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
-	if err != nil {
-		return nil, err
-	}
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
-	} else {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
-	}
+func GetRandomSatisfiedChannel(group string, model string, retry int, capabilities []string) (*Channel, error) {
+	abilities, err := getSatisfiedAbilities(group, model, retry, capabilities)
 	if err != nil {
 		return nil, err
 	}
 	channel := Channel{}
 	if len(abilities) > 0 {
 		// Randomly choose one
-		weightSum := uint(0)
-		for _, ability_ := range abilities {
-			weightSum += ability_.Weight + 10
+		weightSum := 0
+		weights := make([]int, len(abilities))
+		for i, ability_ := range abilities {
+			weights[i] = getHealthWeight(ability_.ChannelId, int(ability_.Weight)+10)
+			weightSum += weights[i]
+		}
+		// 其他路由策略需要渠道的响应时间、价格等信息
+		if operation_setting.GetGroupRoutingStrategy(group) != operation_setting.RoutingStrategyWeightedRandom {
+			return selectAbilityChannelByStrategy(group, model, abilities, weights)
 		}
 		// Randomly choose one
-		weight := common.GetRandomInt(int(weightSum))
-		for _, ability_ := range abilities {
-			weight -= int(ability_.Weight) + 10
+		weight := common.GetRandomInt(weightSum)
+		for i, ability_ := range abilities {
+			weight -= weights[i]
 			//log.Printf("weight: %d, ability weight: %d", weight, *ability_.Weight)
 			if weight <= 0 {
 				channel.Id = ability_.ChannelId
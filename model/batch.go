@@ -0,0 +1,248 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"one-api/dto"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+type Batch struct {
+	Id               string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(20);index"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	Errors           string `json:"errors" gorm:"type:text"`
+	Metadata         string `json:"metadata" gorm:"type:text"`
+	RequestTotal     int    `json:"request_total"`
+	RequestCompleted int    `json:"request_completed"`
+	RequestFailed    int    `json:"request_failed"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+
+	// 提交任务的客户端 IP，执行时按令牌的 IP 白名单检查
+	ClientIp string `json:"-" gorm:"type:varchar(64)"`
+
+	// 执行任务的进程及其最近一次心跳，用于判断执行中的任务是否因进程退出而中断
+	Owner       string `json:"-" gorm:"type:varchar(64)"`
+	HeartbeatAt int64  `json:"-" gorm:"bigint"`
+}
+
+func NewBatchId() string {
+	return "batch_" + common.GetRandomString(24)
+}
+
+func (batch *Batch) IsFinished() bool {
+	switch batch.Status {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (batch *Batch) SetErrors(errs []dto.BatchError) {
+	if len(errs) == 0 {
+		batch.Errors = ""
+		return
+	}
+	data, err := common.Marshal(dto.BatchErrors{Object: "list", Data: errs})
+	if err != nil {
+		common.SysError("failed to marshal batch errors: " + err.Error())
+		return
+	}
+	batch.Errors = string(data)
+}
+
+func (batch *Batch) SetMetadata(metadata map[string]string) {
+	if len(metadata) == 0 {
+		batch.Metadata = ""
+		return
+	}
+	data, err := common.Marshal(metadata)
+	if err != nil {
+		common.SysError("failed to marshal batch metadata: " + err.Error())
+		return
+	}
+	batch.Metadata = string(data)
+}
+
+func (batch *Batch) ToOpenAIBatch() dto.OpenAIBatch {
+	optionalTime := func(t int64) *int64 {
+		if t == 0 {
+			return nil
+		}
+		return &t
+	}
+	optionalString := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+	result := dto.OpenAIBatch{
+		Id:               batch.Id,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     optionalString(batch.OutputFileId),
+		ErrorFileId:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optionalTime(batch.InProgressAt),
+		ExpiresAt:        optionalTime(batch.ExpiresAt),
+		FinalizingAt:     optionalTime(batch.FinalizingAt),
+		CompletedAt:      optionalTime(batch.CompletedAt),
+		FailedAt:         optionalTime(batch.FailedAt),
+		ExpiredAt:        optionalTime(batch.ExpiredAt),
+		CancellingAt:     optionalTime(batch.CancellingAt),
+		CancelledAt:      optionalTime(batch.CancelledAt),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.RequestTotal,
+			Completed: batch.RequestCompleted,
+			Failed:    batch.RequestFailed,
+		},
+		Metadata: map[string]string{},
+	}
+	if batch.PromptTokens != 0 || batch.CompletionTokens != 0 {
+		result.Usage = &dto.BatchUsage{
+			InputTokens:  batch.PromptTokens,
+			OutputTokens: batch.CompletionTokens,
+			TotalTokens:  batch.PromptTokens + batch.CompletionTokens,
+		}
+	}
+	if batch.Errors != "" {
+		var errs dto.BatchErrors
+		if err := common.UnmarshalJsonStr(batch.Errors, &errs); err == nil {
+			result.Errors = &errs
+		}
+	}
+	if batch.Metadata != "" {
+		_ = common.UnmarshalJsonStr(batch.Metadata, &result.Metadata)
+	}
+	return result
+}
+
+func (batch *Batch) Insert() error {
+	if batch.Id == "" {
+		batch.Id = NewBatchId()
+	}
+	if batch.CreatedAt == 0 {
+		batch.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(batch).Error
+}
+
+func (batch *Batch) Update() error {
+	return DB.Save(batch).Error
+}
+
+// UpdateFromStatus 仅当数据库中的状态仍为 fromStatus 时才写入，返回是否更新成功
+func (batch *Batch) UpdateFromStatus(fromStatus string) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", batch.Id, fromStatus).Select("*").Omit("id").Updates(batch)
+	return result.RowsAffected > 0, result.Error
+}
+
+// UpdateProgress 仅更新执行进度，避免覆盖并发写入的状态字段（例如取消）
+func (batch *Batch) UpdateProgress() error {
+	batch.HeartbeatAt = common.GetTimestamp()
+	return DB.Model(batch).Select("request_completed", "request_failed", "prompt_tokens", "completion_tokens", "heartbeat_at").Updates(batch).Error
+}
+
+// UpdateBatchHeartbeat 执行进程定期刷新心跳，表明任务仍在执行
+func UpdateBatchHeartbeat(id string, owner string) error {
+	return DB.Model(&Batch{}).Where("id = ? and owner = ?", id, owner).Update("heartbeat_at", common.GetTimestamp()).Error
+}
+
+// InterruptBatch 执行进程已退出时将任务标记为失败。仅当任务仍在执行中且心跳与读取时一致才写入，
+// 避免覆盖刚刚完成或仍在执行的任务，返回是否更新成功
+func InterruptBatch(batch *Batch, errs []dto.BatchError) (bool, error) {
+	batch.Status = BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	batch.SetErrors(errs)
+	result := DB.Model(&Batch{}).
+		Where("id = ? and status in ? and heartbeat_at = ?", batch.Id, []string{BatchStatusInProgress, BatchStatusFinalizing}, batch.HeartbeatAt).
+		Updates(map[string]any{"status": batch.Status, "failed_at": batch.FailedAt, "errors": batch.Errors})
+	return result.RowsAffected > 0, result.Error
+}
+
+func GetUserBatchById(id string, userId int) (*Batch, error) {
+	if id == "" {
+		return nil, errors.New("batch id is empty")
+	}
+	var batch Batch
+	err := DB.Where("id = ? and user_id = ?", id, userId).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func GetBatchStatus(id string) (string, error) {
+	var batch Batch
+	err := DB.Select("status").Where("id = ?", id).First(&batch).Error
+	return batch.Status, err
+}
+
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	tx := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var cursor Batch
+		if err := DB.Select("id", "created_at").Where("id = ? and user_id = ?", after, userId).First(&cursor).Error; err == nil {
+			tx = tx.Where("(created_at < ? or (created_at = ? and id < ?))", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+		}
+	}
+	err := tx.Order("created_at desc, id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatches 获取需要执行器处理的批处理任务
+func GetUnfinishedBatches(limit int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status in ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).
+		Order("created_at asc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// CancelUserBatch 将批处理任务标记为取消中，由执行器完成最终取消
+func CancelUserBatch(id string, userId int) (*Batch, error) {
+	batch, err := GetUserBatchById(id, userId)
+	if err != nil {
+		return nil, err
+	}
+	if batch.IsFinished() || batch.Status == BatchStatusCancelling {
+		return batch, nil
+	}
+	now := common.GetTimestamp()
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", batch.Id, batch.Status).
+		Updates(map[string]any{"status": BatchStatusCancelling, "cancelling_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return GetUserBatchById(id, userId)
+}
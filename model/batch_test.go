@@ -0,0 +1,48 @@
+package model
+
+import (
+	"one-api/dto"
+	"testing"
+)
+
+func TestInterruptBatch(t *testing.T) {
+	setupTestDB(t, &Batch{})
+	tests := []struct {
+		name       string
+		status     string
+		heartbeat  int64
+		readBeat   int64
+		want       bool
+		wantStatus string
+	}{
+		{name: "in progress", status: BatchStatusInProgress, heartbeat: 100, readBeat: 100, want: true, wantStatus: BatchStatusFailed},
+		{name: "finalizing", status: BatchStatusFinalizing, heartbeat: 100, readBeat: 100, want: true, wantStatus: BatchStatusFailed},
+		{name: "completed after read", status: BatchStatusCompleted, heartbeat: 100, readBeat: 100, want: false, wantStatus: BatchStatusCompleted},
+		{name: "heartbeat after read", status: BatchStatusInProgress, heartbeat: 200, readBeat: 100, want: false, wantStatus: BatchStatusInProgress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := &Batch{Status: tt.status, HeartbeatAt: tt.heartbeat, Owner: "old"}
+			if err := stored.Insert(); err != nil {
+				t.Fatal(err)
+			}
+			read := *stored
+			read.Status = BatchStatusInProgress
+			read.HeartbeatAt = tt.readBeat
+			ok, err := InterruptBatch(&read, []dto.BatchError{{Code: "batch_interrupted"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Errorf("InterruptBatch() = %v, want %v", ok, tt.want)
+			}
+			status, err := GetBatchStatus(stored.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"one-api/common"
 	"one-api/constant"
 	"one-api/dto"
@@ -13,13 +14,14 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
 type Channel struct {
 	Id                 int     `json:"id"`
 	Type               int     `json:"type" gorm:"default:0"`
-	Key                string  `json:"key" gorm:"not null"`
+	Key                string  `json:"key" gorm:"not null;serializer:secret"`
 	OpenAIOrganization *string `json:"openai_organization"`
 	TestModel          *string `json:"test_model"`
 	Status             int     `json:"status" gorm:"default:1"`
@@ -46,6 +48,8 @@ type Channel struct {
 	ParamOverride     *string `json:"param_override" gorm:"type:text"`
 	// add after v0.8.5
 	ChannelInfo ChannelInfo `json:"channel_info" gorm:"type:json"`
+	// 密钥加密存储，按完整密钥搜索时匹配摘要
+	KeyDigest string `json:"-" gorm:"type:varchar(64);index"`
 }
 
 type ChannelInfo struct {
@@ -54,6 +58,11 @@ type ChannelInfo struct {
 	MultiKeyStatusList   map[int]int           `json:"multi_key_status_list"`   // key状态列表，key index -> status
 	MultiKeyPollingIndex int                   `json:"multi_key_polling_index"` // 多Key模式下轮询的key索引
 	MultiKeyMode         constant.MultiKeyMode `json:"multi_key_mode"`
+	// 单个密钥每分钟的请求数、token 数上限，0 表示不限制
+	MultiKeyRpmLimit int `json:"multi_key_rpm_limit,omitempty"`
+	MultiKeyTpmLimit int `json:"multi_key_tpm_limit,omitempty"`
+	// 密钥被上游限流且未返回 Retry-After 时的冷却秒数，0 表示使用默认值
+	MultiKeyCooldownSeconds int `json:"multi_key_cooldown_seconds,omitempty"`
 }
 
 // Value implements driver.Valuer interface
@@ -88,7 +97,17 @@ func (channel *Channel) getKeys() []string {
 	return keys
 }
 
+// GetNextEnabledKey 为转发请求选择密钥，并计入该密钥的请求数
 func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
+	return channel.getNextEnabledKey(true)
+}
+
+// PeekNextEnabledKey 选择一个可用密钥但不计入用量，用于内容审核等非转发请求
+func (channel *Channel) PeekNextEnabledKey() (string, int, *types.NewAPIError) {
+	return channel.getNextEnabledKey(false)
+}
+
+func (channel *Channel) getNextEnabledKey(record bool) (string, int, *types.NewAPIError) {
 	// If not in multi-key mode, return the original key string directly.
 	if !channel.ChannelInfo.IsMultiKey {
 		return channel.Key, 0, nil
@@ -125,11 +144,67 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		return keys[0], 0, nil
 	}
 
+	// 排除冷却中或达到 RPM/TPM 上限的密钥，全部不可用时返回限流错误
+	enabledIdx, keyRequests, keyTokens := filterUsableKeys(channel.Id, channel.ChannelInfo, enabledIdx)
+	rateLimitedErr := func() (string, int, *types.NewAPIError) {
+		return "", 0, types.NewErrorWithStatusCode(fmt.Errorf("all keys of channel #%d are cooling down or rate limited", channel.Id), types.ErrorCodeChannelKeysRateLimited, http.StatusTooManyRequests)
+	}
+	if len(enabledIdx) == 0 {
+		return rateLimitedErr()
+	}
+
+	// 排除熔断中的密钥，全部熔断时仍在可用的密钥中选择
+	availableIdx := make(map[int]bool, len(enabledIdx))
+	for _, idx := range enabledIdx {
+		if IsChannelKeyAvailable(channel.Id, idx) {
+			availableIdx[idx] = true
+		}
+	}
+	if len(availableIdx) > 0 && len(availableIdx) < len(enabledIdx) {
+		enabledIdx = lo.Filter(enabledIdx, func(idx int, _ int) bool {
+			return availableIdx[idx]
+		})
+	}
+	selectable := make(map[int]bool, len(enabledIdx))
+	for _, idx := range enabledIdx {
+		selectable[idx] = true
+	}
+	selectKey := func(idx int) (string, int, *types.NewAPIError) {
+		if !record {
+			return keys[idx], idx, nil
+		}
+		// 筛选后其它请求可能已用满该密钥的额度或占用了半开探测名额，从选中的密钥开始依次尝试
+		candidates := append([]int{idx}, lo.Without(enabledIdx, idx)...)
+		for _, candidate := range candidates {
+			if acquireChannelHealth(channel.Id, candidate, func() bool {
+				return tryRecordChannelKeyRequest(channel.Id, channel.ChannelInfo, candidate)
+			}) {
+				return keys[candidate], candidate, nil
+			}
+		}
+		// 密钥全部熔断时仍在额度未满的密钥中选择
+		for _, candidate := range candidates {
+			if tryRecordChannelKeyRequest(channel.Id, channel.ChannelInfo, candidate) {
+				return keys[candidate], candidate, nil
+			}
+		}
+		return rateLimitedErr()
+	}
+
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
 		// Randomly pick one enabled key
-		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
-		return keys[selectedIdx], selectedIdx, nil
+		return selectKey(enabledIdx[rand.Intn(len(enabledIdx))])
+	case constant.MultiKeyModeLeastUsed:
+		// 选择最近一分钟请求数最少的密钥，请求数相同时比较 token 数
+		selectedIdx := enabledIdx[0]
+		for _, idx := range enabledIdx[1:] {
+			if keyRequests[idx] < keyRequests[selectedIdx] ||
+				(keyRequests[idx] == keyRequests[selectedIdx] && keyTokens[idx] < keyTokens[selectedIdx]) {
+				selectedIdx = idx
+			}
+		}
+		return selectKey(selectedIdx)
 	case constant.MultiKeyModePolling:
 		// Use channel-specific lock to ensure thread-safe polling
 		lock := getChannelPollingLock(channel.Id)
@@ -158,17 +233,17 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if selectable[idx] {
 				// update polling index for next call (point to the next position)
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
-				return keys[idx], idx, nil
+				return selectKey(idx)
 			}
 		}
 		// Fallback – should not happen, but return first enabled key
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		return selectKey(enabledIdx[0])
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		return selectKey(enabledIdx[0])
 	}
 }
 
@@ -257,7 +332,7 @@ func GetChannelsByTag(tag string, idSort bool) ([]*Channel, error) {
 	if idSort {
 		order = "id desc"
 	}
-	err := DB.Where("tag = ?", tag).Order(order).Find(&channels).Error
+	err := DB.Where("tag = ?", tag).Order(order).Omit("key").Find(&channels).Error
 	return channels, err
 }
 
@@ -295,11 +370,11 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR key_digest = ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", secretDigest(keyword), keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR key_digest = ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", secretDigest(keyword), keyword, "%"+keyword+"%", "%"+model+"%")
 	}
 
 	// 执行查询
@@ -329,6 +404,9 @@ func GetChannelById(id int, selectAll bool) (*Channel, error) {
 
 func BatchInsertChannels(channels []Channel) error {
 	var err error
+	for i := range channels {
+		channels[i].KeyDigest = secretDigest(channels[i].Key)
+	}
 	err = DB.Create(&channels).Error
 	if err != nil {
 		return err
@@ -359,7 +437,7 @@ func BatchDeleteChannels(ids []int) error {
 	}
 	// 提交事务
 	tx.Commit()
-	return err
+	return DeleteChannelCapabilities(ids)
 }
 
 func (channel *Channel) GetPriority() int64 {
@@ -399,6 +477,7 @@ func (channel *Channel) GetStatusCodeMapping() string {
 
 func (channel *Channel) Insert() error {
 	var err error
+	channel.KeyDigest = secretDigest(channel.Key)
 	err = DB.Create(channel).Error
 	if err != nil {
 		return err
@@ -446,6 +525,7 @@ func (channel *Channel) Update() error {
 			}
 		}
 	}
+	channel.KeyDigest = secretDigest(channel.Key)
 	var err error
 	err = DB.Model(channel).Updates(channel).Error
 	if err != nil {
@@ -483,7 +563,10 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	return DeleteChannelCapabilities([]int{channel.Id})
 }
 
 var channelStatusLock sync.Mutex
@@ -752,11 +835,11 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR key_digest = ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", secretDigest(keyword), keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR key_digest = ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", secretDigest(keyword), keyword, "%"+keyword+"%", "%"+model+"%")
 	}
 
 	subQuery := baseQuery.Where(whereClause, args...).
@@ -783,6 +866,20 @@ func (channel *Channel) ValidateSettings() error {
 			return err
 		}
 	}
+	if channelParams.TestProfile != nil {
+		for _, capability := range channelParams.TestProfile.Capabilities {
+			if !common.StringsContains(ChannelProbeCapabilities, capability) {
+				return fmt.Errorf("unknown capability %s", capability)
+			}
+		}
+	}
+	for modelName, capabilities := range channelParams.Capabilities {
+		for _, capability := range capabilities {
+			if !common.StringsContains(ChannelCapabilities, capability) {
+				return fmt.Errorf("unknown capability %s for model %s", capability, modelName)
+			}
+		}
+	}
 	return nil
 }
 
@@ -3,8 +3,8 @@ package model
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/setting"
 	"sort"
 	"strings"
@@ -19,14 +19,23 @@ var channelsIDM map[int]*Channel                     // all channels include dis
 var channelSyncLock sync.RWMutex
 
 func InitChannelCache() {
+	// 未启用内存缓存时数据库路径同样使用轮询计数
+	resetRoundRobinCounters()
 	if !common.MemoryCacheEnabled {
 		return
 	}
 	newChannelId2channel := make(map[int]*Channel)
+	newChannelDeclaredCapabilities := make(map[int]map[string][]string)
 	var channels []*Channel
-	DB.Find(&channels)
+	if err := DB.Find(&channels).Error; err != nil {
+		// 密钥无法解密的渠道仍会返回，但 Key 为空
+		common.SysError("failed to load channels: " + err.Error())
+	}
 	for _, channel := range channels {
 		newChannelId2channel[channel.Id] = channel
+		if declared := channel.declaredCapabilities(); len(declared) > 0 {
+			newChannelDeclaredCapabilities[channel.Id] = declared
+		}
 	}
 	var abilities []*Ability
 	DB.Find(&abilities)
@@ -42,6 +51,9 @@ func InitChannelCache() {
 		if channel.Status != common.ChannelStatusEnabled {
 			continue // skip disabled channels
 		}
+		if channel.Key == "" && channel.KeyDigest != "" {
+			continue // skip channels whose key can not be decrypted
+		}
 		groups := strings.Split(channel.Group, ",")
 		for _, group := range groups {
 			models := strings.Split(channel.Models, ",")
@@ -67,7 +79,9 @@ func InitChannelCache() {
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
 	channelsIDM = newChannelId2channel
+	channelDeclaredCapabilities = newChannelDeclaredCapabilities
 	channelSyncLock.Unlock()
+	InitChannelCapabilityCache()
 	common.SysLog("channels synced from database")
 }
 
@@ -79,10 +93,28 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
+// 选中的半开渠道探测名额已被占用时重新选择的次数
+const channelAcquireAttempts = 3
+
 func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int) (*Channel, string, error) {
+	for attempt := 1; ; attempt++ {
+		channel, selectGroup, err := cacheGetRandomSatisfiedChannel(c, group, model, retry)
+		if err != nil {
+			return nil, selectGroup, err
+		}
+		// 筛选后其它请求可能已占用半开渠道的探测名额，重新选择时该渠道会被排除；
+		// 渠道全部熔断时仍使用选中的渠道
+		if AcquireChannelHealth(channel.Id, -1) || attempt >= channelAcquireAttempts {
+			return channel, selectGroup, nil
+		}
+	}
+}
+
+func cacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int) (*Channel, string, error) {
 	var channel *Channel
 	var err error
 	selectGroup := group
+	capabilities := common.GetContextKeyStringSlice(c, constant.ContextKeyRequiredCapabilities)
 	if group == "auto" {
 		if len(setting.AutoGroups) == 0 {
 			return nil, selectGroup, errors.New("auto groups is not enabled")
@@ -91,7 +123,11 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			if common.DebugEnabled {
 				println("autoGroup:", autoGroup)
 			}
-			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry)
+			var groupErr error
+			channel, groupErr = getRandomSatisfiedChannel(autoGroup, model, retry, capabilities)
+			if errors.Is(groupErr, ErrChannelCapabilityUnsupported) {
+				err = groupErr
+			}
 			if channel == nil {
 				continue
 			} else {
@@ -104,18 +140,22 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			}
 		}
 	} else {
-		channel, err = getRandomSatisfiedChannel(group, model, retry)
+		channel, err = getRandomSatisfiedChannel(group, model, retry, capabilities)
 		if err != nil {
 			return nil, group, err
 		}
 	}
 	if channel == nil {
+		// 自动分组中存在因能力不满足而被排除的渠道时，返回能力错误
+		if err != nil {
+			return nil, group, err
+		}
 		return nil, group, errors.New("channel not found")
 	}
 	return channel, selectGroup, nil
 }
 
-func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func getRandomSatisfiedChannel(group string, model string, retry int, capabilities []string) (*Channel, error) {
 	if strings.HasPrefix(model, "gpt-4-gizmo") {
 		model = "gpt-4-gizmo-*"
 	}
@@ -125,7 +165,7 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		return GetRandomSatisfiedChannel(group, model, retry, capabilities)
 	}
 
 	channelSyncLock.RLock()
@@ -136,6 +176,16 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New("channel not found")
 	}
 
+	// 排除熔断中的渠道
+	channels = filterAvailableChannels(channels)
+	// 排除密钥均在冷却或达到速率上限的多密钥渠道
+	channels = filterKeyUsableChannels(channels)
+	// 排除不支持请求所需能力的渠道
+	channels, err := filterCapableChannels(channels, model, capabilities)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(channels) == 1 {
 		if channel, ok := channelsIDM[channels[0]]; ok {
 			return channel, nil
@@ -176,20 +226,13 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	// 平滑系数
 	smoothingFactor := 10
-	// Calculate the total weight of all channels up to endIdx
-	totalWeight := 0
-	for _, channel := range targetChannels {
-		totalWeight += channel.GetWeight() + smoothingFactor
+	weights := make([]int, len(targetChannels))
+	for i, channel := range targetChannels {
+		weights[i] = getHealthWeight(channel.Id, channel.GetWeight()+smoothingFactor)
 	}
-	// Generate a random value in the range [0, totalWeight)
-	randomWeight := rand.Intn(totalWeight)
-
-	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight() + smoothingFactor
-		if randomWeight < 0 {
-			return channel, nil
-		}
+	// 按分组路由策略选择渠道
+	if channel := selectChannelByStrategy(group, model, targetChannels, weights); channel != nil {
+		return channel, nil
 	}
 	// return null if no channel is not found
 	return nil, errors.New("channel not found")
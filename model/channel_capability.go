@@ -0,0 +1,228 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"one-api/dto"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"gorm.io/gorm/clause"
+)
+
+// 渠道能力探测项
+const (
+	ChannelCapabilityStreaming  = "streaming"
+	ChannelCapabilityTools      = "tools"
+	ChannelCapabilityJsonMode   = "json_mode"
+	ChannelCapabilityJsonSchema = "json_schema"
+	ChannelCapabilityVision     = "vision"
+	ChannelCapabilityAudio      = "audio"
+	ChannelCapabilityEmbeddings = "embeddings"
+	ChannelCapabilityReasoning  = "reasoning"
+)
+
+// ChannelProbeCapabilities 可以通过探测获得的能力
+var ChannelProbeCapabilities = []string{
+	ChannelCapabilityStreaming,
+	ChannelCapabilityTools,
+	ChannelCapabilityJsonMode,
+	ChannelCapabilityJsonSchema,
+	ChannelCapabilityVision,
+	ChannelCapabilityEmbeddings,
+	ChannelCapabilityReasoning,
+}
+
+// ChannelCapabilities 渠道可以声明的能力，音频输入只能声明
+var ChannelCapabilities = []string{
+	ChannelCapabilityStreaming,
+	ChannelCapabilityTools,
+	ChannelCapabilityJsonMode,
+	ChannelCapabilityJsonSchema,
+	ChannelCapabilityVision,
+	ChannelCapabilityAudio,
+	ChannelCapabilityEmbeddings,
+	ChannelCapabilityReasoning,
+}
+
+// ErrChannelCapabilityUnsupported 候选渠道均不支持请求用到的能力
+var ErrChannelCapabilityUnsupported = errors.New("no channel supports the required capabilities")
+
+// ChannelCapability 渠道在某个模型上的能力探测结果
+type ChannelCapability struct {
+	Id         int    `json:"id"`
+	ChannelId  int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_model_capability"`
+	Model      string `json:"model" gorm:"type:varchar(255);uniqueIndex:idx_channel_model_capability"`
+	Capability string `json:"capability" gorm:"type:varchar(32);uniqueIndex:idx_channel_model_capability"`
+	Supported  bool   `json:"supported"`
+	// 探测详情，如向量维度或失败原因
+	Detail   string `json:"detail" gorm:"type:text"`
+	Latency  int    `json:"latency"` // in milliseconds
+	TestedAt int64  `json:"tested_at" gorm:"bigint"`
+}
+
+type channelCapabilityKey struct {
+	ChannelId  int
+	Model      string
+	Capability string
+}
+
+// 探测未通过的能力，选择渠道时使用
+var channelCapabilityLock sync.RWMutex
+var unsupportedChannelCapabilities = make(map[channelCapabilityKey]bool)
+
+// InitChannelCapabilityCache 从数据库加载探测未通过的能力
+func InitChannelCapabilityCache() {
+	var capabilities []*ChannelCapability
+	if err := DB.Where("supported = ?", false).Find(&capabilities).Error; err != nil {
+		common.SysError("failed to load channel capabilities: " + err.Error())
+		return
+	}
+	unsupported := make(map[channelCapabilityKey]bool, len(capabilities))
+	for _, capability := range capabilities {
+		unsupported[channelCapabilityKey{capability.ChannelId, capability.Model, capability.Capability}] = true
+	}
+	channelCapabilityLock.Lock()
+	unsupportedChannelCapabilities = unsupported
+	channelCapabilityLock.Unlock()
+}
+
+// SyncChannelCapabilityCache 未启用内存缓存时定时刷新探测结果，使其他节点的探测结果生效
+func SyncChannelCapabilityCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitChannelCapabilityCache()
+	}
+}
+
+// SaveChannelCapabilities 保存探测结果，覆盖同一渠道、模型、能力的旧结果
+func SaveChannelCapabilities(capabilities []*ChannelCapability) error {
+	if len(capabilities) == 0 {
+		return nil
+	}
+	err := DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel_id"}, {Name: "model"}, {Name: "capability"}},
+		DoUpdates: clause.AssignmentColumns([]string{"supported", "detail", "latency", "tested_at"}),
+	}).Create(capabilities).Error
+	if err != nil {
+		return err
+	}
+	channelCapabilityLock.Lock()
+	defer channelCapabilityLock.Unlock()
+	for _, capability := range capabilities {
+		key := channelCapabilityKey{capability.ChannelId, capability.Model, capability.Capability}
+		if capability.Supported {
+			delete(unsupportedChannelCapabilities, key)
+		} else {
+			unsupportedChannelCapabilities[key] = true
+		}
+	}
+	return nil
+}
+
+// GetChannelCapabilities 获取能力矩阵，channelId 为 0 时返回所有渠道
+func GetChannelCapabilities(channelId int, modelName string) ([]*ChannelCapability, error) {
+	var capabilities []*ChannelCapability
+	query := DB.Model(&ChannelCapability{})
+	if channelId != 0 {
+		query = query.Where("channel_id = ?", channelId)
+	}
+	if modelName != "" {
+		query = query.Where("model = ?", modelName)
+	}
+	err := query.Order("channel_id, model, capability").Find(&capabilities).Error
+	return capabilities, err
+}
+
+// DeleteChannelCapabilities 删除渠道的探测结果
+func DeleteChannelCapabilities(channelIds []int) error {
+	if err := DB.Where("channel_id in (?)", channelIds).Delete(&ChannelCapability{}).Error; err != nil {
+		return err
+	}
+	channelCapabilityLock.Lock()
+	defer channelCapabilityLock.Unlock()
+	for key := range unsupportedChannelCapabilities {
+		if lo.Contains(channelIds, key.ChannelId) {
+			delete(unsupportedChannelCapabilities, key)
+		}
+	}
+	return nil
+}
+
+// IsChannelCapabilitySupported 渠道在该模型上未探测或探测通过时视为支持
+func IsChannelCapabilitySupported(channelId int, modelName string, capabilities []string) bool {
+	channelCapabilityLock.RLock()
+	defer channelCapabilityLock.RUnlock()
+	for _, capability := range capabilities {
+		if unsupportedChannelCapabilities[channelCapabilityKey{channelId, modelName, capability}] {
+			return false
+		}
+	}
+	return true
+}
+
+// 渠道设置中声明的能力，随渠道缓存一起加载，避免选择渠道时重复解析设置
+var channelDeclaredCapabilities map[int]map[string][]string
+
+// declaredCapabilities 解析渠道设置中按模型声明的能力
+func (channel *Channel) declaredCapabilities() map[string][]string {
+	// 不使用 GetSetting，避免解析失败时保存只查询了部分字段的渠道
+	setting := dto.ChannelSettings{}
+	if channel.Setting != nil && *channel.Setting != "" {
+		_ = common.UnmarshalJsonStr(*channel.Setting, &setting)
+	}
+	return setting.Capabilities
+}
+
+// supportsCapabilities 渠道为模型声明了能力时以声明为准，否则排除探测未通过的能力
+func supportsCapabilities(channelId int, declared map[string][]string, modelName string, capabilities []string) bool {
+	if modelCapabilities, ok := (dto.ChannelSettings{Capabilities: declared}).GetModelCapabilities(modelName); ok {
+		for _, capability := range capabilities {
+			if !common.StringsContains(modelCapabilities, capability) {
+				return false
+			}
+		}
+		return true
+	}
+	return IsChannelCapabilitySupported(channelId, modelName, capabilities)
+}
+
+// filterCapableChannels 排除不支持所需能力的渠道，调用方需持有 channelSyncLock
+func filterCapableChannels(channelIds []int, modelName string, capabilities []string) ([]int, error) {
+	if len(capabilities) == 0 {
+		return channelIds, nil
+	}
+	capable := make([]int, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		if supportsCapabilities(channelId, channelDeclaredCapabilities[channelId], modelName, capabilities) {
+			capable = append(capable, channelId)
+		}
+	}
+	if len(capable) == 0 {
+		return nil, ErrChannelCapabilityUnsupported
+	}
+	return capable, nil
+}
+
+// filterCapableAbilities 未启用内存缓存时，从数据库读取渠道设置排除不支持所需能力的渠道
+func filterCapableAbilities(abilities []Ability, model string, capabilities []string) ([]Ability, error) {
+	if len(capabilities) == 0 {
+		return abilities, nil
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "setting").Where("id in (?)", abilityChannelIds(abilities)).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	capable := make(map[int]bool, len(channels))
+	for _, channel := range channels {
+		capable[channel.Id] = supportsCapabilities(channel.Id, channel.declaredCapabilities(), model, capabilities)
+	}
+	abilities = lo.Filter(abilities, func(ability_ Ability, _ int) bool {
+		return capable[ability_.ChannelId]
+	})
+	if len(abilities) == 0 {
+		return nil, ErrChannelCapabilityUnsupported
+	}
+	return abilities, nil
+}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"testing"
+)
+
+func TestSupportsCapabilities(t *testing.T) {
+	previous := unsupportedChannelCapabilities
+	unsupportedChannelCapabilities = map[channelCapabilityKey]bool{
+		{ChannelId: 1, Model: "gpt-4o", Capability: ChannelCapabilityVision}: true,
+	}
+	t.Cleanup(func() {
+		unsupportedChannelCapabilities = previous
+	})
+
+	tests := []struct {
+		name         string
+		channelId    int
+		declared     map[string][]string
+		model        string
+		capabilities []string
+		want         bool
+	}{
+		{name: "no probe result", channelId: 2, model: "gpt-4o", capabilities: []string{ChannelCapabilityVision}, want: true},
+		{name: "probe failed", channelId: 1, model: "gpt-4o", capabilities: []string{ChannelCapabilityVision}, want: false},
+		{name: "probe failed on other model", channelId: 1, model: "gpt-4o-mini", capabilities: []string{ChannelCapabilityVision}, want: true},
+		{
+			name:         "declared overrides probe",
+			channelId:    1,
+			declared:     map[string][]string{"gpt-4o": {ChannelCapabilityVision, ChannelCapabilityTools}},
+			model:        "gpt-4o",
+			capabilities: []string{ChannelCapabilityVision},
+			want:         true,
+		},
+		{
+			name:         "declared without capability",
+			channelId:    2,
+			declared:     map[string][]string{"gpt-4o": {ChannelCapabilityTools}},
+			model:        "gpt-4o",
+			capabilities: []string{ChannelCapabilityTools, ChannelCapabilityVision},
+			want:         false,
+		},
+		{
+			name:         "wildcard declaration",
+			channelId:    2,
+			declared:     map[string][]string{"*": {ChannelCapabilityStreaming}},
+			model:        "claude-3",
+			capabilities: []string{ChannelCapabilityStreaming},
+			want:         true,
+		},
+		{
+			name:         "model declaration takes precedence over wildcard",
+			channelId:    2,
+			declared:     map[string][]string{"*": {ChannelCapabilityTools}, "claude-3": {}},
+			model:        "claude-3",
+			capabilities: []string{ChannelCapabilityTools},
+			want:         false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := supportsCapabilities(tt.channelId, tt.declared, tt.model, tt.capabilities); got != tt.want {
+				t.Errorf("supportsCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRandomSatisfiedChannelFiltersCapabilitiesBeforePriority(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{})
+	toolSetting := `{"capabilities":{"*":["tools"]}}`
+	noToolSetting := `{"capabilities":{"*":["streaming"]}}`
+	channels := []*Channel{
+		{Id: 1, Name: "high", Key: "k1", Status: common.ChannelStatusEnabled, Models: "gpt-4o", Group: "default", Priority: common.GetPointer[int64](10), Setting: &noToolSetting},
+		{Id: 2, Name: "low", Key: "k2", Status: common.ChannelStatusEnabled, Models: "gpt-4o", Group: "default", Priority: common.GetPointer[int64](0), Setting: &toolSetting},
+		{Id: 3, Name: "lowest", Key: "k3", Status: common.ChannelStatusEnabled, Models: "gpt-4o", Group: "default", Priority: common.GetPointer[int64](-5), Setting: &toolSetting},
+	}
+	for _, channel := range channels {
+		if err := DB.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.AddAbilities(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		retry        int
+		capabilities []string
+		wantId       int
+		wantErr      error
+	}{
+		{name: "without capabilities uses highest priority", capabilities: nil, wantId: 1},
+		{name: "capable channel in lower priority", capabilities: []string{ChannelCapabilityTools}, wantId: 2},
+		{name: "retry moves to next capable priority", retry: 1, capabilities: []string{ChannelCapabilityTools}, wantId: 3},
+		{name: "retry past last priority", retry: 5, capabilities: []string{ChannelCapabilityTools}, wantId: 3},
+		{name: "no capable channel", capabilities: []string{ChannelCapabilityVision}, wantErr: ErrChannelCapabilityUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", tt.retry, tt.capabilities)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if channel.Id != tt.wantId {
+				t.Errorf("channel = %d, want %d", channel.Id, tt.wantId)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package model
+
+import (
+	"one-api/setting/operation_setting"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	CircuitStateClosed   = "closed"
+	CircuitStateOpen     = "open"
+	CircuitStateHalfOpen = "half_open"
+)
+
+// 滚动窗口划分的桶数
+const channelHealthBucketCount = 10
+
+// channelHealthKey 渠道级统计的 KeyIndex 为 -1，多密钥渠道的密钥级统计为密钥下标
+type channelHealthKey struct {
+	ChannelId int
+	KeyIndex  int
+}
+
+type channelHealthBucket struct {
+	start     int64
+	requests  int
+	failures  int
+	slow      int
+	latencies int // 参与延迟统计的请求数（流式请求不参与）
+	latencyMs int64
+}
+
+type channelHealth struct {
+	buckets             [channelHealthBucketCount]channelHealthBucket
+	state               string
+	consecutiveFailures int
+	halfOpenSuccesses   int
+	openedAt            time.Time
+	openUntil           time.Time
+	probing             bool
+	probeStartedAt      time.Time
+	openCount           int
+	lastError           string
+	lastErrorAt         int64
+}
+
+type ChannelHealthInfo struct {
+	ChannelId           int     `json:"channel_id"`
+	KeyIndex            int     `json:"key_index"`
+	State               string  `json:"state"`
+	Requests            int     `json:"requests"`
+	Failures            int     `json:"failures"`
+	SlowRequests        int     `json:"slow_requests"`
+	ErrorRate           float64 `json:"error_rate"`
+	AvgLatencyMs        int64   `json:"avg_latency_ms"`
+	HealthScore         int     `json:"health_score"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	OpenCount           int     `json:"open_count"`
+	OpenedAt            int64   `json:"opened_at,omitempty"`
+	OpenUntil           int64   `json:"open_until,omitempty"`
+	LastError           string  `json:"last_error,omitempty"`
+	LastErrorAt         int64   `json:"last_error_at,omitempty"`
+}
+
+var channelHealthLock sync.Mutex
+var channelHealthMap = make(map[channelHealthKey]*channelHealth)
+
+func channelHealthBucketSeconds() int64 {
+	seconds := int64(operation_setting.GetChannelHealthSetting().WindowSeconds) / channelHealthBucketCount
+	if seconds <= 0 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// stats 汇总窗口内的统计数据
+func (h *channelHealth) stats(now time.Time) (requests, failures, slow int, avgLatencyMs int64) {
+	bucketSeconds := channelHealthBucketSeconds()
+	windowStart := now.Unix() - bucketSeconds*channelHealthBucketCount
+	latencies := 0
+	var latencyMs int64
+	for _, bucket := range h.buckets {
+		if bucket.start <= windowStart {
+			continue
+		}
+		requests += bucket.requests
+		failures += bucket.failures
+		slow += bucket.slow
+		latencies += bucket.latencies
+		latencyMs += bucket.latencyMs
+	}
+	if latencies > 0 {
+		avgLatencyMs = latencyMs / int64(latencies)
+	}
+	return
+}
+
+func (h *channelHealth) addSample(now time.Time, failed bool, slow bool, latency time.Duration) {
+	bucketSeconds := channelHealthBucketSeconds()
+	start := now.Unix() / bucketSeconds * bucketSeconds
+	bucket := &h.buckets[(start/bucketSeconds)%channelHealthBucketCount]
+	if bucket.start != start {
+		*bucket = channelHealthBucket{start: start}
+	}
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slow++
+	}
+	if latency > 0 {
+		bucket.latencies++
+		bucket.latencyMs += latency.Milliseconds()
+	}
+}
+
+func (h *channelHealth) open(now time.Time) {
+	setting := operation_setting.GetChannelHealthSetting()
+	h.state = CircuitStateOpen
+	h.openedAt = now
+	h.openUntil = now.Add(time.Duration(setting.OpenSeconds) * time.Second)
+	h.probing = false
+	h.halfOpenSuccesses = 0
+	h.openCount++
+}
+
+func (h *channelHealth) close() {
+	h.state = CircuitStateClosed
+	h.buckets = [channelHealthBucketCount]channelHealthBucket{}
+	h.consecutiveFailures = 0
+	h.halfOpenSuccesses = 0
+	h.probing = false
+}
+
+func (h *channelHealth) record(now time.Time, failed bool, slow bool, latency time.Duration) {
+	setting := operation_setting.GetChannelHealthSetting()
+	h.addSample(now, failed, slow, latency)
+	bad := failed || slow
+	if !setting.CircuitBreakerEnabled {
+		if bad {
+			h.consecutiveFailures++
+		} else {
+			h.consecutiveFailures = 0
+		}
+		return
+	}
+	switch h.state {
+	case CircuitStateHalfOpen:
+		h.probing = false
+		if bad {
+			h.open(now)
+			return
+		}
+		h.halfOpenSuccesses++
+		if h.halfOpenSuccesses >= setting.HalfOpenSuccesses {
+			h.close()
+		}
+	case CircuitStateOpen:
+		// 熔断前发出的请求返回，仅计入统计
+	default:
+		if bad {
+			h.consecutiveFailures++
+		} else {
+			h.consecutiveFailures = 0
+		}
+		if setting.ConsecutiveFailures > 0 && h.consecutiveFailures >= setting.ConsecutiveFailures {
+			h.open(now)
+			return
+		}
+		requests, failures, slowRequests, _ := h.stats(now)
+		if requests > 0 && requests >= setting.MinRequests &&
+			float64(failures+slowRequests)/float64(requests) >= setting.ErrorRateThreshold {
+			h.open(now)
+		}
+	}
+}
+
+// available 渠道是否可以参与分配，熔断到期或半开且没有进行中的探测时允许
+func (h *channelHealth) available(now time.Time) bool {
+	switch h.state {
+	case CircuitStateOpen:
+		return !now.Before(h.openUntil)
+	case CircuitStateHalfOpen:
+		probeTimeout := time.Duration(operation_setting.GetChannelHealthSetting().OpenSeconds) * time.Second
+		return !h.probing || now.Sub(h.probeStartedAt) > probeTimeout
+	}
+	return true
+}
+
+// acquire 选中渠道时调用，熔断到期后转为半开并放行一个探测请求
+func (h *channelHealth) acquire(now time.Time) {
+	if h.state == CircuitStateOpen && !now.Before(h.openUntil) {
+		h.state = CircuitStateHalfOpen
+		h.halfOpenSuccesses = 0
+	}
+	if h.state == CircuitStateHalfOpen {
+		h.probing = true
+		h.probeStartedAt = now
+	}
+}
+
+func (h *channelHealth) score(now time.Time) int {
+	switch h.state {
+	case CircuitStateOpen:
+		return 0
+	}
+	requests, failures, slow, avgLatencyMs := h.stats(now)
+	if requests == 0 {
+		return 100
+	}
+	score := 100 * (1 - float64(failures+slow)/float64(requests))
+	slowRequestMs := int64(operation_setting.GetChannelHealthSetting().SlowRequestMs)
+	if slowRequestMs > 0 && avgLatencyMs > slowRequestMs {
+		score = score * float64(slowRequestMs) / float64(avgLatencyMs)
+	}
+	if score < 0 {
+		score = 0
+	}
+	return int(score)
+}
+
+func getChannelHealth(key channelHealthKey) *channelHealth {
+	h, ok := channelHealthMap[key]
+	if !ok {
+		h = &channelHealth{state: CircuitStateClosed}
+		channelHealthMap[key] = h
+	}
+	return h
+}
+
+// RecordChannelHealth 记录一次请求结果，keyIndex 小于 0 时仅记录渠道级统计
+func RecordChannelHealth(channelId int, keyIndex int, failed bool, latency time.Duration, errMsg string) {
+	setting := operation_setting.GetChannelHealthSetting()
+	slow := setting.SlowRequestMs > 0 && latency > time.Duration(setting.SlowRequestMs)*time.Millisecond
+	now := time.Now()
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	keys := []channelHealthKey{{ChannelId: channelId, KeyIndex: -1}}
+	if keyIndex >= 0 {
+		keys = append(keys, channelHealthKey{ChannelId: channelId, KeyIndex: keyIndex})
+	}
+	for _, key := range keys {
+		h := getChannelHealth(key)
+		h.record(now, failed, slow, latency)
+		if failed {
+			h.lastError = errMsg
+			h.lastErrorAt = now.Unix()
+		}
+	}
+}
+
+// IsChannelAvailable 判断渠道熔断器是否允许分配请求
+func IsChannelAvailable(channelId int) bool {
+	return IsChannelKeyAvailable(channelId, -1)
+}
+
+func IsChannelKeyAvailable(channelId int, keyIndex int) bool {
+	if !operation_setting.GetChannelHealthSetting().CircuitBreakerEnabled {
+		return true
+	}
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	h, ok := channelHealthMap[channelHealthKey{ChannelId: channelId, KeyIndex: keyIndex}]
+	if !ok {
+		return true
+	}
+	return h.available(time.Now())
+}
+
+// AcquireChannelHealth 在同一次加锁中检查熔断器并占用半开状态的探测名额，
+// 避免多个请求同时通过检查后都成为探测请求，返回 false 表示渠道或密钥当前不可用
+func AcquireChannelHealth(channelId int, keyIndex int) bool {
+	return acquireChannelHealth(channelId, keyIndex, nil)
+}
+
+// acquireChannelHealth 熔断器允许时调用 reserve 占用其它额度，reserve 返回 false 时不占用探测名额
+func acquireChannelHealth(channelId int, keyIndex int, reserve func() bool) bool {
+	if !operation_setting.GetChannelHealthSetting().CircuitBreakerEnabled {
+		return reserve == nil || reserve()
+	}
+	now := time.Now()
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	h, ok := channelHealthMap[channelHealthKey{ChannelId: channelId, KeyIndex: keyIndex}]
+	if ok && !h.available(now) {
+		return false
+	}
+	if reserve != nil && !reserve() {
+		return false
+	}
+	if ok {
+		h.acquire(now)
+	}
+	return true
+}
+
+// ReleaseChannelHealth 选中渠道后没有请求上游时调用，归还渠道及密钥的半开探测名额
+func ReleaseChannelHealth(channelId int, keyIndex int) {
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	keys := []channelHealthKey{{ChannelId: channelId, KeyIndex: -1}}
+	if keyIndex >= 0 {
+		keys = append(keys, channelHealthKey{ChannelId: channelId, KeyIndex: keyIndex})
+	}
+	for _, key := range keys {
+		if h, ok := channelHealthMap[key]; ok && h.state == CircuitStateHalfOpen {
+			h.probing = false
+		}
+	}
+}
+
+// GetChannelHealthScore 获取渠道健康分（0-100），没有统计数据时为 100
+func GetChannelHealthScore(channelId int) int {
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	h, ok := channelHealthMap[channelHealthKey{ChannelId: channelId, KeyIndex: -1}]
+	if !ok {
+		return 100
+	}
+	return h.score(time.Now())
+}
+
+// getHealthWeight 按健康分缩放渠道权重，未启用时原样返回
+func getHealthWeight(channelId int, weight int) int {
+	if !operation_setting.GetChannelHealthSetting().HealthWeightEnabled {
+		return weight
+	}
+	score := GetChannelHealthScore(channelId)
+	// 保留最低 10% 的权重，避免渠道完全拿不到流量而无法恢复
+	if score < 10 {
+		score = 10
+	}
+	return weight * score / 100
+}
+
+// ResetChannelHealth 清除渠道及其密钥的熔断状态和统计数据
+func ResetChannelHealth(channelId int) {
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	for key := range channelHealthMap {
+		if key.ChannelId == channelId {
+			delete(channelHealthMap, key)
+		}
+	}
+}
+
+func GetChannelHealthInfos(channelId int) []ChannelHealthInfo {
+	now := time.Now()
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	infos := make([]ChannelHealthInfo, 0, len(channelHealthMap))
+	for key, h := range channelHealthMap {
+		if channelId != 0 && key.ChannelId != channelId {
+			continue
+		}
+		// 熔断到期但还没有探测请求时按半开展示
+		state := h.state
+		if state == CircuitStateOpen && !now.Before(h.openUntil) {
+			state = CircuitStateHalfOpen
+		}
+		requests, failures, slow, avgLatencyMs := h.stats(now)
+		info := ChannelHealthInfo{
+			ChannelId:           key.ChannelId,
+			KeyIndex:            key.KeyIndex,
+			State:               state,
+			Requests:            requests,
+			Failures:            failures,
+			SlowRequests:        slow,
+			AvgLatencyMs:        avgLatencyMs,
+			HealthScore:         h.score(now),
+			ConsecutiveFailures: h.consecutiveFailures,
+			OpenCount:           h.openCount,
+			LastError:           h.lastError,
+			LastErrorAt:         h.lastErrorAt,
+		}
+		if requests > 0 {
+			info.ErrorRate = float64(failures) / float64(requests)
+		}
+		if h.state != CircuitStateClosed {
+			info.OpenedAt = h.openedAt.Unix()
+			info.OpenUntil = h.openUntil.Unix()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ChannelId != infos[j].ChannelId {
+			return infos[i].ChannelId < infos[j].ChannelId
+		}
+		return infos[i].KeyIndex < infos[j].KeyIndex
+	})
+	return infos
+}
+
+// filterAvailableChannels 排除熔断中的渠道，全部熔断时返回原列表
+func filterAvailableChannels(channelIds []int) []int {
+	if !operation_setting.GetChannelHealthSetting().CircuitBreakerEnabled {
+		return channelIds
+	}
+	available := make([]int, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		if IsChannelAvailable(channelId) {
+			available = append(available, channelId)
+		}
+	}
+	if len(available) == 0 {
+		return channelIds
+	}
+	return available
+}
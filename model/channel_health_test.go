@@ -0,0 +1,217 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// enableCircuitBreaker 开启熔断器，连续失败一次即熔断，测试结束后恢复配置和渠道状态
+func enableCircuitBreaker(t *testing.T, channelIds ...int) {
+	t.Helper()
+	setting := operation_setting.GetChannelHealthSetting()
+	previous := *setting
+	setting.CircuitBreakerEnabled = true
+	setting.ConsecutiveFailures = 1
+	setting.MinRequests = 100
+	setting.OpenSeconds = 30
+	setting.HalfOpenSuccesses = 2
+	setting.SlowRequestMs = 0
+	setting.HealthWeightEnabled = false
+	for _, id := range channelIds {
+		ResetChannelHealth(id)
+	}
+	t.Cleanup(func() {
+		*setting = previous
+		for _, id := range channelIds {
+			ResetChannelHealth(id)
+		}
+	})
+}
+
+func openChannelCircuit(channelId int) {
+	RecordChannelHealth(channelId, -1, true, 0, "upstream error")
+}
+
+// expireChannelCircuit 使熔断到期，下一次选中时进入半开状态
+func expireChannelCircuit(channelId int) {
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	channelHealthMap[channelHealthKey{ChannelId: channelId, KeyIndex: -1}].openUntil = time.Now().Add(-time.Second)
+}
+
+func channelCircuitState(channelId int) string {
+	channelHealthLock.Lock()
+	defer channelHealthLock.Unlock()
+	if h, ok := channelHealthMap[channelHealthKey{ChannelId: channelId, KeyIndex: -1}]; ok {
+		return h.state
+	}
+	return CircuitStateClosed
+}
+
+func TestChannelCircuitTransitions(t *testing.T) {
+	const channelId = 9101
+	enableCircuitBreaker(t, channelId)
+
+	if !IsChannelAvailable(channelId) || channelCircuitState(channelId) != CircuitStateClosed {
+		t.Fatal("channel without samples should be closed and available")
+	}
+	openChannelCircuit(channelId)
+	if channelCircuitState(channelId) != CircuitStateOpen {
+		t.Fatalf("state = %s, want open", channelCircuitState(channelId))
+	}
+	if IsChannelAvailable(channelId) || AcquireChannelHealth(channelId, -1) {
+		t.Fatal("open channel should not be available before open seconds elapse")
+	}
+
+	expireChannelCircuit(channelId)
+	if !IsChannelAvailable(channelId) {
+		t.Fatal("expired open channel should be available for a probe")
+	}
+	if !AcquireChannelHealth(channelId, -1) {
+		t.Fatal("first acquire after expiry should get the probe")
+	}
+	if channelCircuitState(channelId) != CircuitStateHalfOpen {
+		t.Fatalf("state = %s, want half_open", channelCircuitState(channelId))
+	}
+	if AcquireChannelHealth(channelId, -1) {
+		t.Fatal("second acquire should fail while the probe is in flight")
+	}
+
+	// 探测失败重新熔断
+	openChannelCircuit(channelId)
+	if channelCircuitState(channelId) != CircuitStateOpen {
+		t.Fatalf("state = %s, want open after failed probe", channelCircuitState(channelId))
+	}
+
+	// 连续探测成功后恢复
+	expireChannelCircuit(channelId)
+	for i := 0; i < operation_setting.GetChannelHealthSetting().HalfOpenSuccesses; i++ {
+		if !AcquireChannelHealth(channelId, -1) {
+			t.Fatalf("probe %d should be acquired", i)
+		}
+		RecordChannelHealth(channelId, -1, false, 0, "")
+	}
+	if channelCircuitState(channelId) != CircuitStateClosed {
+		t.Fatalf("state = %s, want closed after successful probes", channelCircuitState(channelId))
+	}
+}
+
+func TestAcquireChannelHealthSingleProbe(t *testing.T) {
+	const channelId = 9102
+	enableCircuitBreaker(t, channelId)
+	openChannelCircuit(channelId)
+	expireChannelCircuit(channelId)
+
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if AcquireChannelHealth(channelId, -1) {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired.Load() != 1 {
+		t.Fatalf("acquired = %d, want exactly one probe", acquired.Load())
+	}
+}
+
+func TestAcquireChannelHealthReserve(t *testing.T) {
+	const channelId = 9103
+	enableCircuitBreaker(t, channelId)
+	openChannelCircuit(channelId)
+	expireChannelCircuit(channelId)
+
+	if acquireChannelHealth(channelId, -1, func() bool { return false }) {
+		t.Fatal("acquire should fail when reserve fails")
+	}
+	if channelCircuitState(channelId) != CircuitStateOpen {
+		t.Fatal("failed reserve should not take the probe")
+	}
+	if !acquireChannelHealth(channelId, -1, func() bool { return true }) {
+		t.Fatal("acquire should succeed when reserve succeeds")
+	}
+}
+
+func createTestChannel(t *testing.T, id int, priority int64) {
+	t.Helper()
+	weight := uint(0)
+	channel := &Channel{Id: id, Name: "test", Key: "key", Status: common.ChannelStatusEnabled,
+		Models: "gpt-test", Group: "default", Priority: &priority, Weight: &weight}
+	if err := DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetRandomSatisfiedChannelSkipsOpenTier(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{})
+	enableCircuitBreaker(t, 1, 2, 3)
+	createTestChannel(t, 1, 10)
+	createTestChannel(t, 2, 10)
+	createTestChannel(t, 3, 0)
+
+	selected := func(retry int) map[int]bool {
+		ids := make(map[int]bool)
+		for i := 0; i < 50; i++ {
+			channel, err := GetRandomSatisfiedChannel("default", "gpt-test", retry, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids[channel.Id] = true
+		}
+		return ids
+	}
+
+	if ids := selected(0); ids[3] || len(ids) == 0 {
+		t.Fatalf("healthy top tier should be used, got %v", ids)
+	}
+
+	openChannelCircuit(1)
+	if ids := selected(0); !ids[2] || len(ids) != 1 {
+		t.Fatalf("open channel 1 should be skipped, got %v", ids)
+	}
+
+	// 高优先级渠道全部熔断时使用低优先级渠道
+	openChannelCircuit(2)
+	if ids := selected(0); !ids[3] || len(ids) != 1 {
+		t.Fatalf("tripped top tier should fall through to channel 3, got %v", ids)
+	}
+
+	// 全部熔断时仍按优先级在原渠道中选择
+	openChannelCircuit(3)
+	if ids := selected(0); ids[3] || len(ids) == 0 {
+		t.Fatalf("all open should still use the top tier, got %v", ids)
+	}
+	if ids := selected(1); !ids[3] || len(ids) != 1 {
+		t.Fatalf("retry 1 should use the lower tier, got %v", ids)
+	}
+}
+
+func TestReleaseChannelHealth(t *testing.T) {
+	const channelId = 9104
+	enableCircuitBreaker(t, channelId)
+	openChannelCircuit(channelId)
+	expireChannelCircuit(channelId)
+
+	if !AcquireChannelHealth(channelId, -1) {
+		t.Fatal("first acquire should get the probe")
+	}
+	// 没有请求上游时归还探测名额，下一个请求可以继续探测
+	ReleaseChannelHealth(channelId, -1)
+	if channelCircuitState(channelId) != CircuitStateHalfOpen {
+		t.Fatalf("state = %s, want half_open", channelCircuitState(channelId))
+	}
+	if !AcquireChannelHealth(channelId, -1) {
+		t.Fatal("acquire after release should get the probe")
+	}
+}
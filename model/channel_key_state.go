@@ -0,0 +1,275 @@
+package model
+
+import (
+	"one-api/common"
+	"sync"
+	"time"
+)
+
+// 密钥用量按分钟滚动统计，划分为 6 个 10 秒的桶
+const (
+	channelKeyBucketCount   = 6
+	channelKeyBucketSeconds = 10
+)
+
+// 上游限流且未指定 Retry-After 时的默认冷却时间
+const defaultChannelKeyCooldown = 60 * time.Second
+
+// 多密钥渠道中密钥的状态
+const (
+	ChannelKeyStatusEnabled     = "enabled"
+	ChannelKeyStatusDisabled    = "disabled"
+	ChannelKeyStatusCooling     = "cooling"
+	ChannelKeyStatusRateLimited = "rate_limited"
+)
+
+type channelKeyBucket struct {
+	start    int64
+	requests int
+	tokens   int
+}
+
+type channelKeyState struct {
+	buckets       [channelKeyBucketCount]channelKeyBucket
+	totalRequests int64
+	totalTokens   int64
+	lastUsedAt    int64
+	coolingUntil  time.Time
+	cooldownCount int
+	lastError     string
+	lastErrorAt   int64
+}
+
+type ChannelKeyStatus struct {
+	KeyIndex int    `json:"key_index"`
+	Status   string `json:"status"`
+	// 最近一分钟的请求数与 token 数
+	Rpm int `json:"rpm"`
+	Tpm int `json:"tpm"`
+	// 本进程启动以来的累计用量
+	TotalRequests int64  `json:"total_requests"`
+	TotalTokens   int64  `json:"total_tokens"`
+	LastUsedAt    int64  `json:"last_used_at,omitempty"`
+	CoolingUntil  int64  `json:"cooling_until,omitempty"`
+	CooldownCount int    `json:"cooldown_count"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorAt   int64  `json:"last_error_at,omitempty"`
+}
+
+// 与渠道健康统计一样保存在内存中，多节点部署时各节点分别统计
+var channelKeyStateLock sync.Mutex
+var channelKeyStateMap = make(map[channelHealthKey]*channelKeyState)
+
+func getChannelKeyState(channelId int, keyIndex int) *channelKeyState {
+	key := channelHealthKey{ChannelId: channelId, KeyIndex: keyIndex}
+	s, ok := channelKeyStateMap[key]
+	if !ok {
+		s = &channelKeyState{}
+		channelKeyStateMap[key] = s
+	}
+	return s
+}
+
+// window 汇总最近一分钟的用量
+func (s *channelKeyState) window(now time.Time) (requests, tokens int) {
+	windowStart := now.Unix() - channelKeyBucketSeconds*channelKeyBucketCount
+	for _, bucket := range s.buckets {
+		if bucket.start <= windowStart {
+			continue
+		}
+		requests += bucket.requests
+		tokens += bucket.tokens
+	}
+	return
+}
+
+func (s *channelKeyState) add(now time.Time, requests int, tokens int) {
+	start := now.Unix() / channelKeyBucketSeconds * channelKeyBucketSeconds
+	bucket := &s.buckets[(start/channelKeyBucketSeconds)%channelKeyBucketCount]
+	if bucket.start != start {
+		*bucket = channelKeyBucket{start: start}
+	}
+	bucket.requests += requests
+	bucket.tokens += tokens
+	s.totalRequests += int64(requests)
+	s.totalTokens += int64(tokens)
+}
+
+// status 冷却优先于速率上限，二者都不满足时密钥可用
+func (s *channelKeyState) status(now time.Time, info ChannelInfo) string {
+	if now.Before(s.coolingUntil) {
+		return ChannelKeyStatusCooling
+	}
+	requests, tokens := s.window(now)
+	if info.MultiKeyRpmLimit > 0 && requests >= info.MultiKeyRpmLimit {
+		return ChannelKeyStatusRateLimited
+	}
+	if info.MultiKeyTpmLimit > 0 && tokens >= info.MultiKeyTpmLimit {
+		return ChannelKeyStatusRateLimited
+	}
+	return ChannelKeyStatusEnabled
+}
+
+// getMultiKeyStatus 获取密钥的启用状态，状态列表中没有记录时视为启用
+func (info ChannelInfo) getMultiKeyStatus(keyIndex int) int {
+	if status, ok := info.MultiKeyStatusList[keyIndex]; ok {
+		return status
+	}
+	return common.ChannelStatusEnabled
+}
+
+// filterUsableKeys 排除冷却中或达到 RPM/TPM 上限的密钥，并返回各密钥最近一分钟的用量
+func filterUsableKeys(channelId int, info ChannelInfo, keyIndexes []int) (usable []int, requests map[int]int, tokens map[int]int) {
+	now := time.Now()
+	usable = make([]int, 0, len(keyIndexes))
+	requests = make(map[int]int, len(keyIndexes))
+	tokens = make(map[int]int, len(keyIndexes))
+	channelKeyStateLock.Lock()
+	defer channelKeyStateLock.Unlock()
+	for _, idx := range keyIndexes {
+		s, ok := channelKeyStateMap[channelHealthKey{ChannelId: channelId, KeyIndex: idx}]
+		if !ok {
+			usable = append(usable, idx)
+			continue
+		}
+		requests[idx], tokens[idx] = s.window(now)
+		if s.status(now, info) == ChannelKeyStatusEnabled {
+			usable = append(usable, idx)
+		}
+	}
+	return
+}
+
+// hasUsableKey 多密钥渠道是否还有已启用且未冷却、未达到速率上限的密钥
+func (channel *Channel) hasUsableKey() bool {
+	info := channel.ChannelInfo
+	keyIndexes := make([]int, 0, info.MultiKeySize)
+	for i := 0; i < info.MultiKeySize; i++ {
+		if info.getMultiKeyStatus(i) == common.ChannelStatusEnabled {
+			keyIndexes = append(keyIndexes, i)
+		}
+	}
+	// 没有已启用的密钥时交给原有逻辑处理
+	if len(keyIndexes) == 0 {
+		return true
+	}
+	usable, _, _ := filterUsableKeys(channel.Id, info, keyIndexes)
+	return len(usable) > 0
+}
+
+// filterKeyUsableChannels 排除所有密钥均不可用的多密钥渠道，全部排除时返回原列表，调用方需持有 channelSyncLock
+func filterKeyUsableChannels(channelIds []int) []int {
+	return filterKeyUsable(channelIds, channelsIDM)
+}
+
+// filterKeyUsable 按渠道信息排除所有密钥均不可用的多密钥渠道，全部排除时返回原列表
+func filterKeyUsable(channelIds []int, channels map[int]*Channel) []int {
+	usable := make([]int, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		channel, ok := channels[channelId]
+		if !ok || !channel.ChannelInfo.IsMultiKey || channel.hasUsableKey() {
+			usable = append(usable, channelId)
+		}
+	}
+	if len(usable) == 0 {
+		return channelIds
+	}
+	return usable
+}
+
+// tryRecordChannelKeyRequest 密钥仍未冷却且未达到速率上限时计入一次请求，检查与计数在同一次加锁内完成
+func tryRecordChannelKeyRequest(channelId int, info ChannelInfo, keyIndex int) bool {
+	now := time.Now()
+	channelKeyStateLock.Lock()
+	defer channelKeyStateLock.Unlock()
+	s := getChannelKeyState(channelId, keyIndex)
+	if s.status(now, info) != ChannelKeyStatusEnabled {
+		return false
+	}
+	s.add(now, 1, 0)
+	s.lastUsedAt = now.Unix()
+	return true
+}
+
+// RecordChannelKeyTokens 请求完成后计入密钥消耗的 token 数
+func RecordChannelKeyTokens(channelId int, keyIndex int, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	channelKeyStateLock.Lock()
+	defer channelKeyStateLock.Unlock()
+	getChannelKeyState(channelId, keyIndex).add(time.Now(), 0, tokens)
+}
+
+// RecordChannelKeyError 记录密钥最近一次错误
+func RecordChannelKeyError(channelId int, keyIndex int, errMsg string) {
+	channelKeyStateLock.Lock()
+	defer channelKeyStateLock.Unlock()
+	s := getChannelKeyState(channelId, keyIndex)
+	s.lastError = errMsg
+	s.lastErrorAt = common.GetTimestamp()
+}
+
+// CooldownChannelKey 密钥被上游限流后暂停使用，到期后自动恢复；duration 为 0 时使用渠道设置或默认值
+func CooldownChannelKey(channelId int, keyIndex int, duration time.Duration, info ChannelInfo) time.Duration {
+	if duration <= 0 {
+		duration = time.Duration(info.MultiKeyCooldownSeconds) * time.Second
+	}
+	if duration <= 0 {
+		duration = defaultChannelKeyCooldown
+	}
+	channelKeyStateLock.Lock()
+	defer channelKeyStateLock.Unlock()
+	s := getChannelKeyState(channelId, keyIndex)
+	s.coolingUntil = time.Now().Add(duration)
+	s.cooldownCount++
+	return duration
+}
+
+// ResetChannelKeyStates 清除渠道所有密钥的冷却状态和用量统计
+func ResetChannelKeyStates(channelId int) {
+	channelKeyStateLock.Lock()
+	defer channelKeyStateLock.Unlock()
+	for key := range channelKeyStateMap {
+		if key.ChannelId == channelId {
+			delete(channelKeyStateMap, key)
+		}
+	}
+}
+
+// GetChannelKeyStatuses 获取多密钥渠道中每个密钥的状态与用量
+func GetChannelKeyStatuses(channel *Channel) []ChannelKeyStatus {
+	info := channel.ChannelInfo
+	size := info.MultiKeySize
+	if size <= 0 {
+		size = len(channel.getKeys())
+	}
+	now := time.Now()
+	channelKeyStateLock.Lock()
+	defer channelKeyStateLock.Unlock()
+	statuses := make([]ChannelKeyStatus, 0, size)
+	for i := 0; i < size; i++ {
+		status := ChannelKeyStatus{
+			KeyIndex: i,
+			Status:   ChannelKeyStatusEnabled,
+		}
+		if s, ok := channelKeyStateMap[channelHealthKey{ChannelId: channel.Id, KeyIndex: i}]; ok {
+			status.Status = s.status(now, info)
+			status.Rpm, status.Tpm = s.window(now)
+			status.TotalRequests = s.totalRequests
+			status.TotalTokens = s.totalTokens
+			status.LastUsedAt = s.lastUsedAt
+			status.CooldownCount = s.cooldownCount
+			status.LastError = s.lastError
+			status.LastErrorAt = s.lastErrorAt
+			if now.Before(s.coolingUntil) {
+				status.CoolingUntil = s.coolingUntil.Unix()
+			}
+		}
+		if info.getMultiKeyStatus(i) != common.ChannelStatusEnabled {
+			status.Status = ChannelKeyStatusDisabled
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,157 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/constant"
+	"one-api/types"
+	"sync"
+	"testing"
+)
+
+func newMultiKeyChannel(t *testing.T, id int, info ChannelInfo) *Channel {
+	t.Helper()
+	info.IsMultiKey = true
+	info.MultiKeySize = 2
+	info.MultiKeyMode = constant.MultiKeyModeRandom
+	ResetChannelKeyStates(id)
+	t.Cleanup(func() {
+		ResetChannelKeyStates(id)
+	})
+	return &Channel{Id: id, Key: "key-0\nkey-1", ChannelInfo: info}
+}
+
+func TestGetNextEnabledKeySkipsUnusableKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    ChannelInfo
+		prepare func(channel *Channel)
+		wantKey string
+		wantErr bool
+	}{
+		{
+			name:    "disabled key",
+			info:    ChannelInfo{MultiKeyStatusList: map[int]int{0: common.ChannelStatusAutoDisabled}},
+			wantKey: "key-1",
+		},
+		{
+			name: "cooling key",
+			prepare: func(channel *Channel) {
+				CooldownChannelKey(channel.Id, 1, 0, channel.ChannelInfo)
+			},
+			wantKey: "key-0",
+		},
+		{
+			name: "rpm limited key",
+			info: ChannelInfo{MultiKeyRpmLimit: 1},
+			prepare: func(channel *Channel) {
+				tryRecordChannelKeyRequest(channel.Id, channel.ChannelInfo, 0)
+			},
+			wantKey: "key-1",
+		},
+		{
+			name: "all keys cooling",
+			prepare: func(channel *Channel) {
+				CooldownChannelKey(channel.Id, 0, 0, channel.ChannelInfo)
+				CooldownChannelKey(channel.Id, 1, 0, channel.ChannelInfo)
+			},
+			wantErr: true,
+		},
+		{
+			name: "cooling and disabled",
+			info: ChannelInfo{MultiKeyStatusList: map[int]int{1: common.ChannelStatusManuallyDisabled}},
+			prepare: func(channel *Channel) {
+				CooldownChannelKey(channel.Id, 0, 0, channel.ChannelInfo)
+			},
+			wantErr: true,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel := newMultiKeyChannel(t, 1000+i, tt.info)
+			if tt.prepare != nil {
+				tt.prepare(channel)
+			}
+			// 随机选择，多次选取都不能落到不可用的密钥上；限流用例每次选取都会计数，只选一次
+			attempts := 10
+			if tt.info.MultiKeyRpmLimit > 0 {
+				attempts = 1
+			}
+			for n := 0; n < attempts; n++ {
+				key, _, apiErr := channel.GetNextEnabledKey()
+				if tt.wantErr {
+					if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeChannelKeysRateLimited {
+						t.Fatalf("GetNextEnabledKey() = %q, %v, want rate limited error", key, apiErr)
+					}
+					continue
+				}
+				if apiErr != nil || key != tt.wantKey {
+					t.Fatalf("GetNextEnabledKey() = %q, %v, want %q", key, apiErr, tt.wantKey)
+				}
+			}
+		})
+	}
+}
+
+func TestGetNextEnabledKeyRpmLimitConcurrent(t *testing.T) {
+	channel := newMultiKeyChannel(t, 1100, ChannelInfo{MultiKeyRpmLimit: 5})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	selected := make(map[int]int)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, index, apiErr := channel.GetNextEnabledKey()
+			if apiErr != nil {
+				return
+			}
+			mu.Lock()
+			selected[index]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	// 检查与计数原子完成，并发请求不能超过每个密钥的上限
+	if selected[0] != 5 || selected[1] != 5 {
+		t.Errorf("selected = %v, want 5 requests per key", selected)
+	}
+}
+
+func TestPeekNextEnabledKeyDoesNotRecord(t *testing.T) {
+	channel := newMultiKeyChannel(t, 1200, ChannelInfo{MultiKeyRpmLimit: 1})
+	for i := 0; i < 5; i++ {
+		if _, _, apiErr := channel.PeekNextEnabledKey(); apiErr != nil {
+			t.Fatalf("PeekNextEnabledKey() error = %v", apiErr)
+		}
+	}
+	for _, status := range GetChannelKeyStatuses(channel) {
+		if status.Status != ChannelKeyStatusEnabled || status.TotalRequests != 0 {
+			t.Errorf("key %d status = %s, %d requests, want unused", status.KeyIndex, status.Status, status.TotalRequests)
+		}
+	}
+}
+
+func TestGetRandomSatisfiedChannelSkipsCoolingMultiKeyChannel(t *testing.T) {
+	setupTestDB(t, &Channel{}, &Ability{})
+	channel := newMultiKeyChannel(t, 1300, ChannelInfo{MultiKeyCooldownSeconds: 60})
+	priority, weight := int64(10), uint(0)
+	channel.Name, channel.Status, channel.Models, channel.Group = "multi", common.ChannelStatusEnabled, "gpt-test", "default"
+	channel.Priority, channel.Weight = &priority, &weight
+	if err := DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(); err != nil {
+		t.Fatal(err)
+	}
+	createTestChannel(t, 1301, 0)
+
+	if selected, err := GetRandomSatisfiedChannel("default", "gpt-test", 0, nil); err != nil || selected.Id != channel.Id {
+		t.Fatalf("selected = %v, %v, want multi-key channel", selected, err)
+	}
+	CooldownChannelKey(channel.Id, 0, 0, channel.ChannelInfo)
+	CooldownChannelKey(channel.Id, 1, 0, channel.ChannelInfo)
+	// 数据库路径同样排除密钥全部冷却的多密钥渠道
+	if selected, err := GetRandomSatisfiedChannel("default", "gpt-test", 0, nil); err != nil || selected.Id != 1301 {
+		t.Fatalf("selected = %v, %v, want channel 1301", selected, err)
+	}
+}
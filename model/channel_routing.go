@@ -0,0 +1,178 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"one-api/dto"
+	"one-api/setting/operation_setting"
+	"one-api/setting/ratio_setting"
+	"sort"
+	"sync"
+)
+
+var roundRobinCounters = make(map[string]uint64)
+var roundRobinLock sync.Mutex
+
+// resetRoundRobinCounters 渠道重新加载后清空轮询计数，避免已删除的分组、模型和优先级的计数一直累积
+func resetRoundRobinCounters() {
+	roundRobinLock.Lock()
+	defer roundRobinLock.Unlock()
+	roundRobinCounters = make(map[string]uint64)
+}
+
+// selectChannelByStrategy 按分组配置的路由策略，在同一优先级的渠道中选出一个，weights 与 channels 一一对应
+func selectChannelByStrategy(group string, model string, channels []*Channel, weights []int) *Channel {
+	if len(channels) == 0 {
+		return nil
+	}
+	if len(channels) == 1 {
+		return channels[0]
+	}
+	switch operation_setting.GetGroupRoutingStrategy(group) {
+	case operation_setting.RoutingStrategyLeastLatency:
+		return selectLeastLatencyChannel(channels, weights)
+	case operation_setting.RoutingStrategyLeastCost:
+		return selectLeastCostChannel(model, channels, weights)
+	case operation_setting.RoutingStrategyRoundRobin:
+		return selectRoundRobinChannel(group, model, channels)
+	default:
+		return selectWeightedRandomChannel(channels, weights)
+	}
+}
+
+func selectWeightedRandomChannel(channels []*Channel, weights []int) *Channel {
+	totalWeight := 0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	if totalWeight <= 0 {
+		return channels[rand.Intn(len(channels))]
+	}
+	// Generate a random value in the range [0, totalWeight)
+	randomWeight := rand.Intn(totalWeight)
+	// Find a channel based on its weight
+	for i, channel := range channels {
+		randomWeight -= weights[i]
+		if randomWeight < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+// selectMinScoreChannel 选出分值最低的渠道，分值相同时按权重随机，分值小于 0 表示未知，仅在全部未知时参与随机
+func selectMinScoreChannel(channels []*Channel, weights []int, score func(channel *Channel) float64) *Channel {
+	minScore := math.MaxFloat64
+	var candidates []*Channel
+	var candidateWeights []int
+	for i, channel := range channels {
+		s := score(channel)
+		if s < 0 {
+			continue
+		}
+		if s < minScore {
+			minScore = s
+			candidates = candidates[:0]
+			candidateWeights = candidateWeights[:0]
+		}
+		if s == minScore {
+			candidates = append(candidates, channel)
+			candidateWeights = append(candidateWeights, weights[i])
+		}
+	}
+	if len(candidates) == 0 {
+		return selectWeightedRandomChannel(channels, weights)
+	}
+	return selectWeightedRandomChannel(candidates, candidateWeights)
+}
+
+// selectLeastLatencyChannel 选择最近一次测试响应时间最短的渠道，未测试过的渠道不参与比较
+func selectLeastLatencyChannel(channels []*Channel, weights []int) *Channel {
+	return selectMinScoreChannel(channels, weights, func(channel *Channel) float64 {
+		if channel.ResponseTime <= 0 {
+			return -1
+		}
+		return float64(channel.ResponseTime)
+	})
+}
+
+func selectLeastCostChannel(model string, channels []*Channel, weights []int) *Channel {
+	return selectMinScoreChannel(channels, weights, func(channel *Channel) float64 {
+		return GetChannelEffectiveCost(channel, model)
+	})
+}
+
+func selectRoundRobinChannel(group string, model string, channels []*Channel) *Channel {
+	sorted := make([]*Channel, len(channels))
+	copy(sorted, channels)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Id < sorted[j].Id
+	})
+	key := fmt.Sprintf("%s:%s:%d", group, model, sorted[0].GetPriority())
+	roundRobinLock.Lock()
+	counter := roundRobinCounters[key]
+	roundRobinCounters[key] = counter + 1
+	roundRobinLock.Unlock()
+	return sorted[counter%uint64(len(sorted))]
+}
+
+// selectAbilityChannelByStrategy 数据库路径下按路由策略选择渠道
+func selectAbilityChannelByStrategy(group string, model string, abilities []Ability, weights []int) (*Channel, error) {
+	ids := make([]int, 0, len(abilities))
+	for _, ability := range abilities {
+		ids = append(ids, ability.ChannelId)
+	}
+	channels, err := GetChannelsByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	channelMap := make(map[int]*Channel, len(channels))
+	for _, channel := range channels {
+		channelMap[channel.Id] = channel
+	}
+	targetChannels := make([]*Channel, 0, len(abilities))
+	targetWeights := make([]int, 0, len(abilities))
+	for i, ability := range abilities {
+		if channel, ok := channelMap[ability.ChannelId]; ok {
+			targetChannels = append(targetChannels, channel)
+			targetWeights = append(targetWeights, weights[i])
+		}
+	}
+	channel := selectChannelByStrategy(group, model, targetChannels, targetWeights)
+	if channel == nil {
+		return nil, errors.New("channel not found")
+	}
+	return channel, nil
+}
+
+// GetChannelEffectiveCost 计算渠道处理该模型的实际成本：映射后模型的价格（或倍率）乘以渠道成本倍率，无法计算时返回 -1
+func GetChannelEffectiveCost(channel *Channel, model string) float64 {
+	upstreamModel := model
+	if mapping := channel.GetModelMapping(); mapping != "" && mapping != "{}" {
+		modelMap := make(map[string]string)
+		if err := json.Unmarshal([]byte(mapping), &modelMap); err == nil && modelMap[model] != "" {
+			upstreamModel = modelMap[model]
+		}
+	}
+	cost := -1.0
+	if price, ok := ratio_setting.GetModelPrice(upstreamModel, false); ok {
+		cost = price
+	} else if modelRatio, ok, _ := ratio_setting.GetModelRatio(upstreamModel); ok {
+		// 按输入输出各占一半估算
+		cost = modelRatio * (1 + ratio_setting.GetCompletionRatio(upstreamModel)) / 2
+	}
+	if cost < 0 {
+		return -1
+	}
+	// 这里不使用 GetSetting，避免解析失败时在选择渠道的过程中写库
+	if channel.Setting != nil && *channel.Setting != "" {
+		var setting dto.ChannelSettings
+		if err := json.Unmarshal([]byte(*channel.Setting), &setting); err == nil && setting.CostRatio > 0 {
+			cost *= setting.CostRatio
+		}
+	}
+	return cost
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"fmt"
+	"one-api/setting/ratio_setting"
+	"testing"
+)
+
+func routingTestChannel(id int, responseTime int, costRatio float64) *Channel {
+	setting := ""
+	if costRatio != 0 {
+		setting = fmt.Sprintf(`{"cost_ratio":%g}`, costRatio)
+	}
+	return &Channel{Id: id, ResponseTime: responseTime, Setting: &setting}
+}
+
+// selectedChannelIds 多次选择后返回被选中过的渠道
+func selectedChannelIds(selectChannel func() *Channel) map[int]bool {
+	ids := make(map[int]bool)
+	for i := 0; i < 200; i++ {
+		ids[selectChannel().Id] = true
+	}
+	return ids
+}
+
+func equalIds(got map[int]bool, want []int) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for _, id := range want {
+		if !got[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSelectLeastLatencyChannel(t *testing.T) {
+	tests := []struct {
+		name          string
+		responseTimes []int
+		want          []int
+	}{
+		{"distinct", []int{300, 100, 200}, []int{2}},
+		{"equal", []int{100, 100, 200}, []int{1, 2}},
+		{"untested skipped", []int{0, 200, 150}, []int{3}},
+		{"all untested", []int{0, 0, 0}, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels := make([]*Channel, len(tt.responseTimes))
+			weights := make([]int, len(tt.responseTimes))
+			for i, responseTime := range tt.responseTimes {
+				channels[i] = routingTestChannel(i+1, responseTime, 0)
+				weights[i] = 10
+			}
+			got := selectedChannelIds(func() *Channel { return selectLeastLatencyChannel(channels, weights) })
+			if !equalIds(got, tt.want) {
+				t.Fatalf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectLeastCostChannel(t *testing.T) {
+	previous := ratio_setting.ModelPrice2JSONString()
+	if err := ratio_setting.UpdateModelPriceByJSONString(`{"routing-test":2}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ratio_setting.UpdateModelPriceByJSONString(previous) })
+
+	tests := []struct {
+		name       string
+		model      string
+		costRatios []float64
+		want       []int
+	}{
+		{"distinct", "routing-test", []float64{1.5, 0.5, 1}, []int{2}},
+		{"equal", "routing-test", []float64{0.5, 0.5, 1}, []int{1, 2}},
+		// 成本倍率为 0 表示未配置，按原价计算
+		{"zero ratio is full price", "routing-test", []float64{0, 1, 2}, []int{1, 2}},
+		{"zero ratio cheaper", "routing-test", []float64{0, 1.5, 2}, []int{1}},
+		// 无法计算成本时在全部渠道中随机
+		{"unknown model", "routing-test-unknown", []float64{0.5, 1, 2}, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels := make([]*Channel, len(tt.costRatios))
+			weights := make([]int, len(tt.costRatios))
+			for i, costRatio := range tt.costRatios {
+				channels[i] = routingTestChannel(i+1, 0, costRatio)
+				weights[i] = 10
+			}
+			got := selectedChannelIds(func() *Channel { return selectLeastCostChannel(tt.model, channels, weights) })
+			if !equalIds(got, tt.want) {
+				t.Fatalf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectRoundRobinChannel(t *testing.T) {
+	resetRoundRobinCounters()
+	t.Cleanup(resetRoundRobinCounters)
+
+	tests := []struct {
+		name  string
+		group string
+		ids   []int
+		want  []int
+	}{
+		{"cycles by id", "default", []int{3, 1, 2}, []int{1, 2, 3, 1, 2, 3, 1}},
+		{"two channels", "vip", []int{5, 4}, []int{4, 5, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels := make([]*Channel, len(tt.ids))
+			for i, id := range tt.ids {
+				channels[i] = routingTestChannel(id, 0, 0)
+			}
+			for i, want := range tt.want {
+				if got := selectRoundRobinChannel(tt.group, "gpt-test", channels).Id; got != want {
+					t.Fatalf("pick %d = %d, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInitChannelCacheResetsRoundRobinCounters(t *testing.T) {
+	resetRoundRobinCounters()
+	t.Cleanup(resetRoundRobinCounters)
+	channels := []*Channel{routingTestChannel(1, 0, 0), routingTestChannel(2, 0, 0)}
+
+	if got := selectRoundRobinChannel("default", "gpt-test", channels).Id; got != 1 {
+		t.Fatalf("first pick = %d, want 1", got)
+	}
+	InitChannelCache()
+	roundRobinLock.Lock()
+	counters := len(roundRobinCounters)
+	roundRobinLock.Unlock()
+	if counters != 0 {
+		t.Fatalf("counters = %d after reload, want 0", counters)
+	}
+	if got := selectRoundRobinChannel("default", "gpt-test", channels).Id; got != 1 {
+		t.Fatalf("pick after reload = %d, want 1", got)
+	}
+}
@@ -2,12 +2,15 @@ package model
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -24,6 +27,7 @@ type ChatLog struct {
 	ModelName   string `json:"model_name" gorm:"index"`
 	ChannelID   int    `json:"channel_id"`
 	ChannelName string `json:"channel_name"`
+	Group       string `json:"group" gorm:"index"`
 
 	// Request information
 	RequestType   string `json:"request_type"`                        // chat, completion, embedding, etc.
@@ -61,6 +65,9 @@ type ChatLog struct {
 	UserIP    string `json:"user_ip"`
 	UserAgent string `json:"user_agent"`
 	Other     string `json:"other" gorm:"type:text"` // Additional metadata as JSON
+
+	// Retention
+	ContentPurgedAt int64 `json:"content_purged_at" gorm:"type:bigint;default:0"` // When content was cleared by retention policy
 }
 
 // ChatLogParams contains parameters for creating a chat log entry
@@ -72,6 +79,7 @@ type ChatLogParams struct {
 	ModelName      string
 	ChannelID      int
 	ChannelName    string
+	Group          string
 	RequestType    string
 	RequestData    interface{} // Could be GeneralOpenAIRequest, etc.
 	ResponseData   interface{} // Could be OpenAITextResponse, etc.
@@ -83,29 +91,154 @@ type ChatLogParams struct {
 	UserIP         string
 	UserAgent      string
 	Other          map[string]interface{}
+	// 写入前对内容脱敏，为 nil 时原样保存
+	RedactContent func(string) string
+	// 不保存对话内容，仅记录元数据
+	SkipContent bool
+}
+
+// 加密内容的前缀，用于区分未加密的历史记录
+const chatLogEncryptedPrefix = "enc:v1:"
+
+var (
+	chatLogKeyLock   sync.Mutex
+	chatLogKeyLoaded bool
+	chatLogKeySource string
+	chatLogKey       []byte
+	chatLogHashKey   []byte
+)
+
+// loadChatLogKey 解析 CHAT_LOG_ENCRYPTION_KEY，配置变化时重新解析，
+// 同时派生用于 prompt_hash 的 HMAC 密钥，避免加密与摘要共用同一密钥
+func loadChatLogKey() ([]byte, []byte) {
+	chatLogKeyLock.Lock()
+	defer chatLogKeyLock.Unlock()
+	if chatLogKeyLoaded && chatLogKeySource == constant.ChatLogEncryptionKey {
+		return chatLogKey, chatLogHashKey
+	}
+	chatLogKeyLoaded = true
+	chatLogKeySource = constant.ChatLogEncryptionKey
+	chatLogKey, chatLogHashKey = nil, nil
+	if chatLogKeySource == "" {
+		return nil, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(chatLogKeySource); err == nil && len(key) == 32 {
+		chatLogKey = key
+	} else {
+		chatLogKey = common.DeriveAesKey(chatLogKeySource)
+	}
+	chatLogHashKey = common.HmacSha256Raw([]byte("chat-log-prompt-hash"), chatLogKey)
+	return chatLogKey, chatLogHashKey
+}
+
+// getChatLogKey 获取对话内容加密密钥，32 字节的 base64 密钥直接使用，其余情况派生，未配置时返回 nil
+func getChatLogKey() []byte {
+	key, _ := loadChatLogKey()
+	return key
+}
+
+func encryptChatLogContent(content string) (string, error) {
+	key := getChatLogKey()
+	if key == nil || content == "" {
+		return content, nil
+	}
+	data, err := common.AesGcmEncrypt(key, []byte(content))
+	if err != nil {
+		return "", err
+	}
+	return chatLogEncryptedPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decryptChatLogContent 解密对话内容，未加密的内容原样返回
+func decryptChatLogContent(content string) (string, error) {
+	if !strings.HasPrefix(content, chatLogEncryptedPrefix) {
+		return content, nil
+	}
+	key := getChatLogKey()
+	if key == nil {
+		return "", fmt.Errorf("chat log content is encrypted but CHAT_LOG_ENCRYPTION_KEY is not set")
+	}
+	data, err := base64.StdEncoding.DecodeString(content[len(chatLogEncryptedPrefix):])
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := common.AesGcmDecrypt(key, data)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (log *ChatLog) encryptContent() error {
+	for _, field := range []*string{&log.PromptContent, &log.SystemPrompt, &log.ResponseContent} {
+		encrypted, err := encryptChatLogContent(*field)
+		if err != nil {
+			return err
+		}
+		*field = encrypted
+	}
+	return nil
+}
+
+// DecryptChatLogs 就地解密对话内容，无法解密的字段保留密文
+func DecryptChatLogs(logs []ChatLog) {
+	for i := range logs {
+		for _, field := range []*string{&logs[i].PromptContent, &logs[i].SystemPrompt, &logs[i].ResponseContent} {
+			plaintext, err := decryptChatLogContent(*field)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to decrypt chat log %d: %s", logs[i].ID, err.Error()))
+				continue
+			}
+			*field = plaintext
+		}
+	}
 }
 
 // CreateChatLog creates a new chat log entry with deduplication and conversation tracking
 func CreateChatLog(c *gin.Context, params ChatLogParams) error {
-	// Extract prompt content and generate hash
+	// Extract prompt and response content
 	promptContent, systemPrompt, messageCount := extractPromptInfo(params.RequestData)
-	promptHash := generatePromptHash(promptContent)
-
-	// Generate or extract conversation ID
-	conversationID := generateConversationID(c, params.RequestData)
-
-	// Extract response content
 	responseContent, responseChoices, finishReason := extractResponseInfo(params.ResponseData)
 
 	// Calculate conversation metrics
 	conversationLength := len(promptContent) + len(responseContent)
 	isMultiround := messageCount > 1
 
+	// With CHAT_LOG_ENCRYPTION_KEY the original prompt is hashed with a keyed HMAC so that redaction
+	// does not merge different prompts; without it only the redacted prompt is hashed, since a plain
+	// hash of short prompts could be brute forced back to the redacted PII.
+	// Skip deduplication when content must not be recorded or there is no prompt
+	originalPrompt := promptContent
+
+	// Redact or drop content before it is persisted
+	if params.SkipContent {
+		promptContent, systemPrompt, responseContent = "", "", ""
+	} else if params.RedactContent != nil {
+		promptContent = params.RedactContent(promptContent)
+		systemPrompt = params.RedactContent(systemPrompt)
+		responseContent = params.RedactContent(responseContent)
+	}
+
+	promptHash := ""
+	if !params.SkipContent && originalPrompt != "" {
+		if _, hashKey := loadChatLogKey(); hashKey != nil {
+			promptHash = generatePromptHash(originalPrompt, hashKey)
+		} else {
+			promptHash = generatePromptHash(promptContent, nil)
+		}
+	}
+
+	// Generate or extract conversation ID
+	conversationID := generateConversationID(c, params.RequestData)
+
 	now := common.GetTimestamp()
 
 	// Check for existing entry with same prompt hash for deduplication
 	var existingLog ChatLog
-	err := DB.Where("prompt_hash = ? AND user_id = ?", promptHash, params.UserID).First(&existingLog).Error
+	err := gorm.ErrRecordNotFound
+	if promptHash != "" {
+		err = DB.Where("prompt_hash = ? AND user_id = ?", promptHash, params.UserID).First(&existingLog).Error
+	}
 
 	if err == nil {
 		// Update duplicate count and timestamp for existing prompt
@@ -132,6 +265,7 @@ func CreateChatLog(c *gin.Context, params ChatLogParams) error {
 		ModelName:          params.ModelName,
 		ChannelID:          params.ChannelID,
 		ChannelName:        params.ChannelName,
+		Group:              params.Group,
 		RequestType:        params.RequestType,
 		PromptHash:         promptHash,
 		PromptContent:      promptContent,
@@ -159,6 +293,11 @@ func CreateChatLog(c *gin.Context, params ChatLogParams) error {
 		Other:              common.MapToJsonStr(params.Other),
 	}
 
+	if err = chatLog.encryptContent(); err != nil {
+		common.LogError(c, fmt.Sprintf("Failed to encrypt chat log content: %v", err))
+		return err
+	}
+
 	err = DB.Create(chatLog).Error
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("Failed to create chat log: %v", err))
@@ -171,8 +310,8 @@ func CreateChatLog(c *gin.Context, params ChatLogParams) error {
 	return nil
 }
 
-// generatePromptHash creates a hash of the prompt content for deduplication
-func generatePromptHash(promptContent string) string {
+// generatePromptHash creates a hash of the prompt content for deduplication, keyed with an HMAC when key is set
+func generatePromptHash(promptContent string, key []byte) string {
 	// Normalize the prompt by removing extra whitespace and converting to lowercase
 	normalized := strings.ToLower(strings.TrimSpace(promptContent))
 	normalized = strings.ReplaceAll(normalized, "\n", " ")
@@ -182,6 +321,9 @@ func generatePromptHash(promptContent string) string {
 		normalized = strings.ReplaceAll(normalized, "  ", " ")
 	}
 
+	if key != nil {
+		return common.GenerateHMACWithKey(key, normalized)
+	}
 	hash := sha256.Sum256([]byte(normalized))
 	return hex.EncodeToString(hash[:])
 }
@@ -422,6 +564,7 @@ func GetChatLogsByUser(userID int, limit, offset int) ([]ChatLog, int64, error)
 		Limit(limit).Offset(offset).
 		Find(&logs).Error
 
+	DecryptChatLogs(logs)
 	return logs, total, err
 }
 
@@ -434,6 +577,7 @@ func GetDuplicatePrompts(userID int, limit, offset int) ([]ChatLog, error) {
 		Limit(limit).Offset(offset).
 		Find(&logs).Error
 
+	DecryptChatLogs(logs)
 	return logs, err
 }
 
@@ -446,5 +590,6 @@ func GetLongestChats(userID int, limit, offset int) ([]ChatLog, error) {
 		Limit(limit).Offset(offset).
 		Find(&logs).Error
 
+	DecryptChatLogs(logs)
 	return logs, err
 }
@@ -0,0 +1,269 @@
+package model
+
+import (
+	"one-api/common"
+	"strings"
+
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const (
+	// 内容加密时搜索需在内存中解密匹配，最多扫描的最近记录数
+	chatLogSearchScanLimit = 10000
+	// 按会话 ID 查询时每批的数量
+	chatLogConversationBatchSize = 500
+)
+
+// ChatLogTurnDiff 本轮提示相对上一轮的变化，按行比较
+type ChatLogTurnDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type ChatLogTurn struct {
+	ID               int64           `json:"id"`
+	CreatedAt        int64           `json:"created_at"`
+	ModelName        string          `json:"model_name"`
+	SystemPrompt     string          `json:"system_prompt"`
+	PromptContent    string          `json:"prompt_content"`
+	ResponseContent  string          `json:"response_content"`
+	FinishReason     string          `json:"finish_reason"`
+	MessageCount     int             `json:"message_count"`
+	PromptTokens     int             `json:"prompt_tokens"`
+	CompletionTokens int             `json:"completion_tokens"`
+	TotalTokens      int             `json:"total_tokens"`
+	Quota            int             `json:"quota"`
+	Diff             ChatLogTurnDiff `json:"diff"`
+}
+
+type ChatLogConversation struct {
+	ConversationID   string        `json:"conversation_id"`
+	FirstAt          int64         `json:"first_at"`
+	LastAt           int64         `json:"last_at"`
+	TurnCount        int           `json:"turn_count"`
+	Models           []string      `json:"models"`
+	PromptTokens     int           `json:"prompt_tokens"`
+	CompletionTokens int           `json:"completion_tokens"`
+	TotalTokens      int           `json:"total_tokens"`
+	Quota            int           `json:"quota"`
+	Turns            []ChatLogTurn `json:"turns"`
+}
+
+// diffPromptLines 比较两轮提示，跳过相同的开头部分，其余视为删除与新增
+func diffPromptLines(previous, current string) ChatLogTurnDiff {
+	var prevLines, curLines []string
+	if previous != "" {
+		prevLines = strings.Split(previous, "\n")
+	}
+	if current != "" {
+		curLines = strings.Split(current, "\n")
+	}
+	same := 0
+	for same < len(prevLines) && same < len(curLines) && prevLines[same] == curLines[same] {
+		same++
+	}
+	diff := ChatLogTurnDiff{
+		Added:   curLines[same:],
+		Removed: prevLines[same:],
+	}
+	if diff.Added == nil {
+		diff.Added = []string{}
+	}
+	if diff.Removed == nil {
+		diff.Removed = []string{}
+	}
+	return diff
+}
+
+// searchEncryptedConversationIds 解密最近的记录并在内存中匹配关键词，按会话最近一条记录从新到旧返回会话 ID，
+// 最多返回 chatLogSearchScanLimit 条记录所属的会话
+func searchEncryptedConversationIds(userId int, keyword string) ([]string, error) {
+	keyword = strings.ToLower(keyword)
+	// 扫描按 id 倒序，会话首次出现的顺序即最近活动的顺序
+	order := make([]string, 0)
+	matched := make(map[string]bool)
+	batchSize := 500
+	for offset := 0; offset < chatLogSearchScanLimit; offset += batchSize {
+		var logs []ChatLog
+		err := DB.Select("id, conversation_id, prompt_content, system_prompt, response_content").
+			Where("user_id = ?", userId).
+			Order("id DESC").Limit(batchSize).Offset(offset).
+			Find(&logs).Error
+		if err != nil {
+			return nil, err
+		}
+		DecryptChatLogs(logs)
+		for _, log := range logs {
+			found, seen := matched[log.ConversationID]
+			if !seen {
+				order = append(order, log.ConversationID)
+			}
+			if found {
+				continue
+			}
+			matched[log.ConversationID] = strings.Contains(strings.ToLower(log.PromptContent), keyword) ||
+				strings.Contains(strings.ToLower(log.SystemPrompt), keyword) ||
+				strings.Contains(strings.ToLower(log.ResponseContent), keyword)
+		}
+		if len(logs) < batchSize {
+			break
+		}
+	}
+	ids := make([]string, 0)
+	for _, id := range order {
+		if matched[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// filterConversationIdsByModel 保留使用过指定模型的会话，分批查询避免 IN 条件超出占位符数量限制
+func filterConversationIdsByModel(userId int, ids []string, modelName string) ([]string, error) {
+	used := make(map[string]bool, len(ids))
+	for _, chunk := range lo.Chunk(ids, chatLogConversationBatchSize) {
+		var found []string
+		err := DB.Model(&ChatLog{}).Where("user_id = ? AND model_name = ? AND conversation_id IN ?", userId, modelName, chunk).
+			Distinct().Pluck("conversation_id", &found).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range found {
+			used[id] = true
+		}
+	}
+	filtered := make([]string, 0, len(used))
+	for _, id := range ids {
+		if used[id] {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered, nil
+}
+
+// escapeLikePattern 转义 LIKE 通配符，配合 ESCAPE '!' 使用
+func escapeLikePattern(keyword string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(keyword)
+}
+
+// GetChatLogConversations 按会话聚合用户的对话日志，keyword 不为空时只返回提示或回复中包含关键词的会话
+func GetChatLogConversations(userId int, keyword string, modelName string, limit, offset int) ([]ChatLogConversation, int64, error) {
+	conversations := make([]ChatLogConversation, 0)
+	if keyword != "" && getChatLogKey() != nil {
+		// 内容已加密，匹配的会话在内存中分页，不将全部会话 ID 作为查询条件
+		ids, err := searchEncryptedConversationIds(userId, keyword)
+		if err != nil {
+			return nil, 0, err
+		}
+		if modelName != "" {
+			ids, err = filterConversationIdsByModel(userId, ids, modelName)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		total := int64(len(ids))
+		if offset >= len(ids) {
+			return conversations, total, nil
+		}
+		conversations, err = loadChatLogConversations(userId, ids[offset:min(offset+limit, len(ids))])
+		return conversations, total, err
+	}
+
+	tx := DB.Model(&ChatLog{}).Where("user_id = ?", userId)
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if keyword != "" {
+		pattern := "%" + escapeLikePattern(keyword) + "%"
+		tx = tx.Where("conversation_id IN (?)", DB.Model(&ChatLog{}).Select("conversation_id").
+			Where("user_id = ? AND (prompt_content LIKE ? ESCAPE '!' OR system_prompt LIKE ? ESCAPE '!' OR response_content LIKE ? ESCAPE '!')",
+				userId, pattern, pattern, pattern))
+	}
+
+	var total int64
+	if err := tx.Session(&gorm.Session{}).Distinct("conversation_id").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var rows []struct {
+		ConversationId string
+		LastAt         int64
+	}
+	err := tx.Session(&gorm.Session{}).Select("conversation_id, MAX(created_at) AS last_at").
+		Group("conversation_id").
+		Order("last_at DESC").
+		Limit(limit).Offset(offset).
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return conversations, total, err
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ConversationId)
+	}
+	conversations, err = loadChatLogConversations(userId, ids)
+	return conversations, total, err
+}
+
+// loadChatLogConversations 加载指定会话的全部记录并按轮次组织，返回顺序与 ids 一致
+func loadChatLogConversations(userId int, ids []string) ([]ChatLogConversation, error) {
+	conversations := make([]ChatLogConversation, 0, len(ids))
+	var logs []ChatLog
+	err := DB.Where("user_id = ? AND conversation_id IN ?", userId, ids).
+		Order("created_at ASC, id ASC").
+		Find(&logs).Error
+	if err != nil {
+		return nil, err
+	}
+	DecryptChatLogs(logs)
+
+	byId := make(map[string]*ChatLogConversation, len(ids))
+	lastPrompt := make(map[string]string, len(ids))
+	for _, id := range ids {
+		byId[id] = &ChatLogConversation{
+			ConversationID: id,
+			Models:         []string{},
+			Turns:          []ChatLogTurn{},
+		}
+	}
+	for _, log := range logs {
+		conversation := byId[log.ConversationID]
+		if conversation == nil {
+			continue
+		}
+		if conversation.TurnCount == 0 {
+			conversation.FirstAt = log.CreatedAt
+		}
+		conversation.LastAt = log.CreatedAt
+		conversation.TurnCount++
+		conversation.PromptTokens += log.PromptTokens
+		conversation.CompletionTokens += log.CompletionTokens
+		conversation.TotalTokens += log.TotalTokens
+		conversation.Quota += log.Quota
+		if log.ModelName != "" && !common.StringsContains(conversation.Models, log.ModelName) {
+			conversation.Models = append(conversation.Models, log.ModelName)
+		}
+		conversation.Turns = append(conversation.Turns, ChatLogTurn{
+			ID:               log.ID,
+			CreatedAt:        log.CreatedAt,
+			ModelName:        log.ModelName,
+			SystemPrompt:     log.SystemPrompt,
+			PromptContent:    log.PromptContent,
+			ResponseContent:  log.ResponseContent,
+			FinishReason:     log.FinishReason,
+			MessageCount:     log.MessageCount,
+			PromptTokens:     log.PromptTokens,
+			CompletionTokens: log.CompletionTokens,
+			TotalTokens:      log.TotalTokens,
+			Quota:            log.Quota,
+			Diff:             diffPromptLines(lastPrompt[log.ConversationID], log.PromptContent),
+		})
+		lastPrompt[log.ConversationID] = log.PromptContent
+	}
+	for _, id := range ids {
+		conversations = append(conversations, *byId[id])
+	}
+	return conversations, nil
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"one-api/constant"
+	"reflect"
+	"testing"
+)
+
+func TestDiffPromptLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		previous    string
+		current     string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{name: "first turn", current: "hello\nworld", wantAdded: []string{"hello", "world"}, wantRemoved: []string{}},
+		{name: "appended lines", previous: "hello", current: "hello\nanswer\nnext", wantAdded: []string{"answer", "next"}, wantRemoved: []string{}},
+		{name: "unchanged", previous: "hello\nworld", current: "hello\nworld", wantAdded: []string{}, wantRemoved: []string{}},
+		{name: "edited middle line", previous: "a\nb\nc", current: "a\nx\nc", wantAdded: []string{"x", "c"}, wantRemoved: []string{"b", "c"}},
+		{name: "history truncated", previous: "a\nb", current: "b\nc", wantAdded: []string{"b", "c"}, wantRemoved: []string{"a", "b"}},
+		{name: "cleared", previous: "a", current: "", wantAdded: []string{}, wantRemoved: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffPromptLines(tt.previous, tt.current)
+			if !reflect.DeepEqual(diff.Added, tt.wantAdded) || !reflect.DeepEqual(diff.Removed, tt.wantRemoved) {
+				t.Errorf("diffPromptLines() = %+v, want added %v, removed %v", diff, tt.wantAdded, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+// setupChatLogConversations 写入两名用户的三个会话，key 不为空时加密内容
+func setupChatLogConversations(t *testing.T, key string) {
+	t.Helper()
+	setupTestDB(t, &ChatLog{})
+	previousKey := constant.ChatLogEncryptionKey
+	constant.ChatLogEncryptionKey = key
+	t.Cleanup(func() {
+		constant.ChatLogEncryptionKey = previousKey
+	})
+	logs := []ChatLog{
+		{UserID: 1, ConversationID: "conv-a", CreatedAt: 100, ModelName: "gpt-4o", PromptContent: "plan a trip", ResponseContent: "sure", PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, Quota: 20},
+		{UserID: 1, ConversationID: "conv-b", CreatedAt: 150, ModelName: "claude-3", PromptContent: "discount is 50% off", ResponseContent: "ok"},
+		{UserID: 1, ConversationID: "conv-a", CreatedAt: 200, ModelName: "gpt-4o-mini", PromptContent: "plan a trip\nto paris", ResponseContent: "day one", PromptTokens: 20, CompletionTokens: 8, TotalTokens: 28, Quota: 30},
+		{UserID: 1, ConversationID: "conv-c", CreatedAt: 300, ModelName: "gpt-4o", PromptContent: "rename my_file", ResponseContent: "done"},
+		{UserID: 2, ConversationID: "conv-d", CreatedAt: 400, ModelName: "gpt-4o", PromptContent: "plan a trip", ResponseContent: "other user"},
+	}
+	for i := range logs {
+		if err := logs[i].encryptContent(); err != nil {
+			t.Fatal(err)
+		}
+		if err := DB.Create(&logs[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func conversationIds(conversations []ChatLogConversation) []string {
+	ids := make([]string, 0, len(conversations))
+	for _, conversation := range conversations {
+		ids = append(ids, conversation.ConversationID)
+	}
+	return ids
+}
+
+func TestGetChatLogConversationsThreading(t *testing.T) {
+	setupChatLogConversations(t, "")
+	conversations, total, err := GetChatLogConversations(1, "", "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按最近活动时间倒序
+	if total != 3 || !reflect.DeepEqual(conversationIds(conversations), []string{"conv-c", "conv-a", "conv-b"}) {
+		t.Fatalf("conversations = %v, total = %d", conversationIds(conversations), total)
+	}
+	conversation := conversations[1]
+	if conversation.TurnCount != 2 || conversation.FirstAt != 100 || conversation.LastAt != 200 {
+		t.Errorf("conversation = %+v, want 2 turns from 100 to 200", conversation)
+	}
+	if conversation.TotalTokens != 43 || conversation.Quota != 50 || !reflect.DeepEqual(conversation.Models, []string{"gpt-4o", "gpt-4o-mini"}) {
+		t.Errorf("totals = %d tokens, %d quota, models %v", conversation.TotalTokens, conversation.Quota, conversation.Models)
+	}
+	if diff := conversation.Turns[1].Diff; !reflect.DeepEqual(diff.Added, []string{"to paris"}) || len(diff.Removed) != 0 {
+		t.Errorf("second turn diff = %+v, want only the appended line", diff)
+	}
+
+	conversations, total, err = GetChatLogConversations(1, "", "", 1, 1)
+	if err != nil || total != 3 || !reflect.DeepEqual(conversationIds(conversations), []string{"conv-a"}) {
+		t.Errorf("second page = %v, total = %d, err = %v", conversationIds(conversations), total, err)
+	}
+}
+
+func TestGetChatLogConversationsSearch(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyword   string
+		modelName string
+		limit     int
+		offset    int
+		wantIds   []string
+		wantTotal int64
+	}{
+		{name: "keyword", keyword: "trip", limit: 10, wantIds: []string{"conv-a"}, wantTotal: 1},
+		{name: "keyword in response", keyword: "DONE", limit: 10, wantIds: []string{"conv-c"}, wantTotal: 1},
+		{name: "percent is literal", keyword: "50%", limit: 10, wantIds: []string{"conv-b"}, wantTotal: 1},
+		{name: "underscore is literal", keyword: "y_f", limit: 10, wantIds: []string{"conv-c"}, wantTotal: 1},
+		{name: "wildcard only", keyword: "%", limit: 10, wantIds: []string{"conv-b"}, wantTotal: 1},
+		{name: "model filter", keyword: "o", modelName: "gpt-4o", limit: 10, wantIds: []string{"conv-c", "conv-a"}, wantTotal: 2},
+		{name: "paged", keyword: "o", limit: 1, offset: 1, wantIds: []string{"conv-a"}, wantTotal: 3},
+		{name: "offset past end", keyword: "o", limit: 10, offset: 5, wantIds: []string{}, wantTotal: 3},
+		{name: "no match", keyword: "missing", limit: 10, wantIds: []string{}, wantTotal: 0},
+	}
+	for _, key := range []string{"", "chat-log-key"} {
+		for _, tt := range tests {
+			t.Run(tt.name+" encrypted="+key, func(t *testing.T) {
+				setupChatLogConversations(t, key)
+				conversations, total, err := GetChatLogConversations(1, tt.keyword, tt.modelName, tt.limit, tt.offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if total != tt.wantTotal || !reflect.DeepEqual(conversationIds(conversations), tt.wantIds) {
+					t.Errorf("GetChatLogConversations() = %v, total %d, want %v, total %d", conversationIds(conversations), total, tt.wantIds, tt.wantTotal)
+				}
+				if len(conversations) > 0 && conversations[0].Turns[0].PromptContent == "" {
+					t.Errorf("turns were not decrypted")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 每批清理之间的间隔，避免长时间占用数据库锁
+const chatLogPurgeBatchInterval = 100 * time.Millisecond
+
+// ChatLogRetentionReport 单个保留策略作用范围的清理结果
+type ChatLogRetentionReport struct {
+	// default、group:<分组> 或 user:<用户ID>
+	Scope        string `json:"scope"`
+	ContentDays  int    `json:"content_days"`
+	MetadataDays int    `json:"metadata_days"`
+	// 清空内容的记录数
+	ContentPurged int64 `json:"content_purged"`
+	// 整条删除的记录数
+	MetadataPurged int64 `json:"metadata_purged"`
+}
+
+type chatLogRetentionScope struct {
+	name      string
+	retention operation_setting.ChatLogRetention
+	apply     func(tx *gorm.DB) *gorm.DB
+}
+
+// getChatLogRetentionScopes 将保留策略展开为互不重叠的作用范围
+func getChatLogRetentionScopes() []chatLogRetentionScope {
+	chatLogSetting := operation_setting.GetChatLogSetting()
+	userIds := make([]int, 0, len(chatLogSetting.UserRetention))
+	for userId := range chatLogSetting.UserRetention {
+		userIds = append(userIds, userId)
+	}
+	sort.Ints(userIds)
+	groups := make([]string, 0, len(chatLogSetting.GroupRetention))
+	for group := range chatLogSetting.GroupRetention {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	excludeUsers := func(tx *gorm.DB) *gorm.DB {
+		if len(userIds) > 0 {
+			tx = tx.Where("user_id NOT IN ?", userIds)
+		}
+		return tx
+	}
+
+	scopes := make([]chatLogRetentionScope, 0, len(userIds)+len(groups)+1)
+	for _, userId := range userIds {
+		userId := userId
+		scopes = append(scopes, chatLogRetentionScope{
+			name:      "user:" + strconv.Itoa(userId),
+			retention: chatLogSetting.UserRetention[userId],
+			apply: func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("user_id = ?", userId)
+			},
+		})
+	}
+	for _, group := range groups {
+		group := group
+		scopes = append(scopes, chatLogRetentionScope{
+			name:      "group:" + group,
+			retention: chatLogSetting.GroupRetention[group],
+			apply: func(tx *gorm.DB) *gorm.DB {
+				return excludeUsers(tx.Where(commonGroupCol+" = ?", group))
+			},
+		})
+	}
+	scopes = append(scopes, chatLogRetentionScope{
+		name: "default",
+		retention: operation_setting.ChatLogRetention{
+			ContentDays:  chatLogSetting.ContentRetentionDays,
+			MetadataDays: chatLogSetting.MetadataRetentionDays,
+		},
+		apply: func(tx *gorm.DB) *gorm.DB {
+			if len(groups) > 0 {
+				// NOT IN 不匹配 NULL，未记录分组的日志也属于默认范围
+				tx = tx.Where("("+commonGroupCol+" IS NULL OR "+commonGroupCol+" NOT IN ?)", groups)
+			}
+			return excludeUsers(tx)
+		},
+	})
+	return scopes
+}
+
+func retentionCutoff(now int64, days int) int64 {
+	return now - int64(days)*24*60*60
+}
+
+// purgeChatLogBatches 按批次取出 ID 后处理，兼容不支持 UPDATE/DELETE ... LIMIT 的数据库
+func purgeChatLogBatches(ctx context.Context, query func() *gorm.DB, batchSize int, handle func(ids []int64) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		var ids []int64
+		if err := query().Order("id").Limit(batchSize).Pluck("id", &ids).Error; err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		affected, err := handle(ids)
+		if err != nil {
+			return total, err
+		}
+		total += affected
+		if len(ids) < batchSize {
+			return total, nil
+		}
+		time.Sleep(chatLogPurgeBatchInterval)
+	}
+}
+
+// PurgeChatLogs 按保留策略清理对话日志，dryRun 为 true 时只统计将被清理的记录数
+func PurgeChatLogs(ctx context.Context, dryRun bool) ([]ChatLogRetentionReport, error) {
+	batchSize := operation_setting.GetChatLogSetting().RetentionBatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	now := common.GetTimestamp()
+	reports := make([]ChatLogRetentionReport, 0)
+	for _, scope := range getChatLogRetentionScopes() {
+		scope := scope
+		report := ChatLogRetentionReport{
+			Scope:        scope.name,
+			ContentDays:  scope.retention.ContentDays,
+			MetadataDays: scope.retention.MetadataDays,
+		}
+
+		if scope.retention.MetadataDays > 0 {
+			cutoff := retentionCutoff(now, scope.retention.MetadataDays)
+			query := func() *gorm.DB {
+				return scope.apply(DB.Model(&ChatLog{})).Where("created_at < ?", cutoff)
+			}
+			if dryRun {
+				if err := query().Count(&report.MetadataPurged).Error; err != nil {
+					return reports, err
+				}
+			} else {
+				purged, err := purgeChatLogBatches(ctx, query, batchSize, func(ids []int64) (int64, error) {
+					result := DB.Where("id IN ?", ids).Delete(&ChatLog{})
+					return result.RowsAffected, result.Error
+				})
+				report.MetadataPurged = purged
+				if err != nil {
+					return append(reports, report), err
+				}
+			}
+		}
+
+		if scope.retention.ContentDays > 0 {
+			cutoff := retentionCutoff(now, scope.retention.ContentDays)
+			query := func() *gorm.DB {
+				tx := scope.apply(DB.Model(&ChatLog{})).Where("created_at < ? AND (content_purged_at = 0 OR content_purged_at IS NULL)", cutoff)
+				if dryRun && scope.retention.MetadataDays > 0 {
+					// 将被整条删除的记录不重复统计
+					tx = tx.Where("created_at >= ?", retentionCutoff(now, scope.retention.MetadataDays))
+				}
+				return tx
+			}
+			if dryRun {
+				if err := query().Count(&report.ContentPurged).Error; err != nil {
+					return reports, err
+				}
+			} else {
+				purged, err := purgeChatLogBatches(ctx, query, batchSize, func(ids []int64) (int64, error) {
+					result := DB.Model(&ChatLog{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+						"prompt_content":    "",
+						"system_prompt":     "",
+						"response_content":  "",
+						"content_purged_at": now,
+					})
+					return result.RowsAffected, result.Error
+				})
+				report.ContentPurged = purged
+				if err != nil {
+					return append(reports, report), err
+				}
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// PurgeChatLogsPeriodically 定期按保留策略清理对话日志
+func PurgeChatLogsPeriodically(interval time.Duration) {
+	for {
+		reports, err := PurgeChatLogs(context.Background(), false)
+		if err != nil {
+			common.SysError("failed to purge chat logs: " + err.Error())
+		}
+		for _, report := range reports {
+			if report.ContentPurged > 0 || report.MetadataPurged > 0 {
+				common.SysLog(fmt.Sprintf("chat log retention %s: cleared content of %d logs, deleted %d logs",
+					report.Scope, report.ContentPurged, report.MetadataPurged))
+			}
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"context"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"testing"
+)
+
+func TestPurgeChatLogs(t *testing.T) {
+	setupTestDB(t, &ChatLog{})
+	chatLogSetting := operation_setting.GetChatLogSetting()
+	previous := *chatLogSetting
+	chatLogSetting.ContentRetentionDays = 7
+	chatLogSetting.MetadataRetentionDays = 30
+	chatLogSetting.GroupRetention = map[string]operation_setting.ChatLogRetention{"vip": {ContentDays: 60}}
+	chatLogSetting.UserRetention = map[int]operation_setting.ChatLogRetention{9: {ContentDays: 1, MetadataDays: 2}}
+	t.Cleanup(func() {
+		*chatLogSetting = previous
+	})
+
+	now := common.GetTimestamp()
+	day := int64(24 * 60 * 60)
+	logs := []*ChatLog{
+		{ID: 1, UserID: 1, Group: "default", PromptContent: "p", CreatedAt: now - 10*day},
+		{ID: 2, UserID: 1, Group: "default", PromptContent: "p", CreatedAt: now - 3*day},
+		{ID: 3, UserID: 1, Group: "default", PromptContent: "p", CreatedAt: now - 40*day},
+		{ID: 4, UserID: 1, Group: "vip", PromptContent: "p", CreatedAt: now - 10*day},
+		{ID: 5, UserID: 9, Group: "vip", PromptContent: "p", CreatedAt: now - 3*day},
+		{ID: 6, UserID: 1, PromptContent: "p", CreatedAt: now - 10*day},
+		{ID: 7, UserID: 1, Group: "default", PromptContent: "p", CreatedAt: now - 10*day},
+	}
+	for _, log := range logs {
+		if err := DB.Create(log).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 旧版本写入的日志分组和清理时间可能为 NULL
+	DB.Exec("UPDATE chat_logs SET "+commonGroupCol+" = NULL WHERE id = ?", 6)
+	DB.Exec("UPDATE chat_logs SET content_purged_at = NULL WHERE id = ?", 7)
+
+	reports, err := PurgeChatLogs(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3", len(reports))
+	}
+
+	tests := []struct {
+		id      int
+		deleted bool
+		purged  bool
+	}{
+		{id: 1, purged: true},
+		{id: 2},
+		{id: 3, deleted: true},
+		{id: 4},
+		{id: 5, deleted: true},
+		{id: 6, purged: true},
+		{id: 7, purged: true},
+	}
+	for _, tt := range tests {
+		var log ChatLog
+		err := DB.Where("id = ?", tt.id).Limit(1).Find(&log).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted := log.ID == 0; deleted != tt.deleted {
+			t.Errorf("log %d deleted = %v, want %v", tt.id, deleted, tt.deleted)
+			continue
+		}
+		if tt.deleted {
+			continue
+		}
+		if purged := log.PromptContent == ""; purged != tt.purged {
+			t.Errorf("log %d content purged = %v, want %v", tt.id, purged, tt.purged)
+		}
+	}
+}
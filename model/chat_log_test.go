@@ -0,0 +1,87 @@
+package model
+
+import (
+	"net/http/httptest"
+	"one-api/constant"
+	"one-api/dto"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func chatLogRequest(prompt string) any {
+	if prompt == "" {
+		return nil
+	}
+	return &dto.GeneralOpenAIRequest{Messages: []dto.Message{{Role: "user", Content: prompt}}}
+}
+
+func TestCreateChatLogDeduplication(t *testing.T) {
+	setupTestDB(t, &ChatLog{})
+	// 等待 CreateChatLog 启动的会话统计协程结束，避免其在 DB 恢复后访问
+	t.Cleanup(func() {
+		time.Sleep(100 * time.Millisecond)
+	})
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	redactDigits := func(s string) string {
+		return regexp.MustCompile(`\d`).ReplaceAllString(s, "*")
+	}
+
+	tests := []struct {
+		name          string
+		userId        int
+		prompts       []string
+		skipContent   bool
+		redact        func(string) string
+		encryptionKey string
+		wantDuplicate int
+	}{
+		{name: "same prompt", userId: 1, prompts: []string{"hello world", "Hello  World"}, wantDuplicate: 2},
+		{name: "different prompts", userId: 2, prompts: []string{"hello", "goodbye"}, wantDuplicate: 1},
+		{name: "skip content", userId: 3, prompts: []string{"hello", "goodbye"}, skipContent: true, wantDuplicate: 1},
+		{name: "skip content same prompt", userId: 4, prompts: []string{"hello", "hello"}, skipContent: true, wantDuplicate: 1},
+		{name: "redacted prompts differ", userId: 5, prompts: []string{"card 1234", "card 5678"}, redact: redactDigits, encryptionKey: "chat-log-key", wantDuplicate: 1},
+		{name: "redacted prompts equal", userId: 6, prompts: []string{"card 1234", "card 1234"}, redact: redactDigits, encryptionKey: "chat-log-key", wantDuplicate: 2},
+		{name: "redacted prompts without key", userId: 7, prompts: []string{"card 1234", "card 5678"}, redact: redactDigits, wantDuplicate: 2},
+		{name: "empty prompt", userId: 8, prompts: []string{"", ""}, wantDuplicate: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousKey := constant.ChatLogEncryptionKey
+			constant.ChatLogEncryptionKey = tt.encryptionKey
+			defer func() { constant.ChatLogEncryptionKey = previousKey }()
+			for _, prompt := range tt.prompts {
+				err := CreateChatLog(c, ChatLogParams{
+					UserID:        tt.userId,
+					RequestData:   chatLogRequest(prompt),
+					SkipContent:   tt.skipContent,
+					RedactContent: tt.redact,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			var logs []ChatLog
+			if err := DB.Where("user_id = ?", tt.userId).Order("id").Find(&logs).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(logs) != len(tt.prompts) {
+				t.Fatalf("got %d logs, want %d", len(logs), len(tt.prompts))
+			}
+			if logs[0].DuplicateCount != tt.wantDuplicate {
+				t.Errorf("duplicate_count = %d, want %d", logs[0].DuplicateCount, tt.wantDuplicate)
+			}
+			if tt.skipContent && logs[0].PromptHash != "" {
+				t.Errorf("prompt_hash = %q, want empty when content is skipped", logs[0].PromptHash)
+			}
+			// 未加密的原始 prompt 摘要可被暴力破解，不能保存
+			if tt.redact != nil && tt.prompts[0] != "" && logs[0].PromptHash == generatePromptHash(tt.prompts[0], nil) {
+				t.Errorf("prompt_hash is an unkeyed hash of the unredacted prompt")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"one-api/dto"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+// File 通过 /v1/files 上传的文件，内容直接保存在数据库中
+type File struct {
+	Id        string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Filename  string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+	Content   []byte `json:"-"`
+}
+
+func NewFileId() string {
+	return "file-" + common.GetRandomString(24)
+}
+
+func (file *File) ToOpenAIFile() dto.OpenAIFile {
+	return dto.OpenAIFile{
+		Id:        file.Id,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    "processed",
+	}
+}
+
+func (file *File) Insert() error {
+	if file.Id == "" {
+		file.Id = NewFileId()
+	}
+	if file.CreatedAt == 0 {
+		file.CreatedAt = common.GetTimestamp()
+	}
+	file.Bytes = len(file.Content)
+	return DB.Create(file).Error
+}
+
+// GetUserFileById 获取文件元信息，不包含文件内容
+func GetUserFileById(id string, userId int) (*File, error) {
+	if id == "" {
+		return nil, errors.New("file id is empty")
+	}
+	var file File
+	err := DB.Omit("content").Where("id = ? and user_id = ?", id, userId).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func GetUserFileContent(id string, userId int) (*File, error) {
+	if id == "" {
+		return nil, errors.New("file id is empty")
+	}
+	var file File
+	err := DB.Where("id = ? and user_id = ?", id, userId).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func GetUserFiles(userId int, purpose string, order string, after string, limit int) ([]*File, error) {
+	var files []*File
+	tx := DB.Omit("content").Where("user_id = ?", userId)
+	if purpose != "" {
+		tx = tx.Where("purpose = ?", purpose)
+	}
+	if after != "" {
+		var cursor File
+		if err := DB.Select("id", "created_at").Where("id = ? and user_id = ?", after, userId).First(&cursor).Error; err == nil {
+			if order == "asc" {
+				tx = tx.Where("(created_at > ? or (created_at = ? and id > ?))", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+			} else {
+				tx = tx.Where("(created_at < ? or (created_at = ? and id < ?))", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+			}
+		}
+	}
+	if order == "asc" {
+		tx = tx.Order("created_at asc, id asc")
+	} else {
+		tx = tx.Order("created_at desc, id desc")
+	}
+	err := tx.Limit(limit).Find(&files).Error
+	return files, err
+}
+
+func DeleteUserFile(id string, userId int) error {
+	result := DB.Where("id = ? and user_id = ?", id, userId).Delete(&File{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("file not found")
+	}
+	return nil
+}
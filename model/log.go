@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"one-api/common"
+	"one-api/constant"
 	"os"
 	"strings"
 	"time"
@@ -34,6 +35,7 @@ type Log struct {
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	Other            string `json:"other"`
+	OrgId            int    `json:"org_id" gorm:"index;default:0"`
 }
 
 const (
@@ -126,6 +128,7 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 			return ""
 		}(),
 		Other: otherStr,
+		OrgId: c.GetInt("token_org_id"),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -151,6 +154,8 @@ type RecordConsumeLogParams struct {
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
 	common.LogInfo(c, fmt.Sprintf("record consume log: userId=%d, params=%s", userId, common.GetJsonString(params)))
+	// 供指标统计读取本次请求的最终用量
+	common.SetContextKey(c, constant.ContextKeyConsumeLogParams, params)
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -186,6 +191,7 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			return ""
 		}(),
 		Other: otherStr,
+		OrgId: c.GetInt("token_org_id"),
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -303,6 +309,64 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 	return logs, total, err
 }
 
+// GetOrganizationLogs 获取组织令牌产生的日志
+func GetOrganizationLogs(orgId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int) (logs []*Log, total int64, err error) {
+	tx := LOG_DB.Where("logs.org_id = ?", orgId)
+	if logType != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", logType)
+	}
+	if modelName != "" {
+		tx = tx.Where("logs.model_name like ?", modelName)
+	}
+	if username != "" {
+		tx = tx.Where("logs.username = ?", username)
+	}
+	if tokenName != "" {
+		tx = tx.Where("logs.token_name = ?", tokenName)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", endTimestamp)
+	}
+	err = tx.Model(&Log{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("logs.id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	formatUserLogs(logs)
+	return logs, total, err
+}
+
+type OrganizationUsage struct {
+	UserId           int    `json:"user_id"`
+	Username         string `json:"username"`
+	Quota            int    `json:"quota"`
+	Count            int    `json:"count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// GetOrganizationUsage 按成员汇总组织令牌的消费
+func GetOrganizationUsage(orgId int, startTimestamp int64, endTimestamp int64) (usages []*OrganizationUsage, err error) {
+	tx := LOG_DB.Table("logs").
+		Select("user_id, username, sum(quota) quota, count(*) count, sum(prompt_tokens) prompt_tokens, sum(completion_tokens) completion_tokens").
+		Where("org_id = ? and type = ?", orgId, LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Group("user_id, username").Order("quota desc").Scan(&usages).Error
+	return usages, err
+}
+
 func SearchAllLogs(keyword string) (logs []*Log, err error) {
 	err = LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(common.MaxRecentItems).Find(&logs).Error
 	return logs, err
@@ -82,7 +82,11 @@ func createRootAccountIfNeed() error {
 			AccessToken: nil,
 			Quota:       100000000,
 		}
-		DB.Create(&rootUser)
+		if err := DB.Create(&rootUser).Error; err != nil {
+			return err
+		}
+		RecordQuotaLedger(LedgerSource{Reason: LedgerReasonRegister, UserId: rootUser.Id},
+			FundingLegs(LedgerAccountUser, rootUser.Id, rootUser.Quota)...)
 	}
 	return nil
 }
@@ -252,6 +256,16 @@ func migrateDB() error {
 		&Task{},
 		&Setup{},
 		&ChatLog{}, // Add the new ChatLog model
+		&File{},
+		&Batch{},
+		&TokenQuotaUsage{},
+		&Organization{},
+		&OrganizationMember{},
+		&SubscriptionPlan{},
+		&UserSubscription{},
+		&QuotaLedgerEntry{},
+		&UsageStatement{},
+		&ChannelCapability{},
 	)
 	if err != nil {
 		return err
@@ -279,6 +293,16 @@ func migrateDBFast() error {
 		{&Task{}, "Task"},
 		{&Setup{}, "Setup"},
 		{&ChatLog{}, "ChatLog"}, // Add the new ChatLog model
+		{&File{}, "File"},
+		{&Batch{}, "Batch"},
+		{&TokenQuotaUsage{}, "TokenQuotaUsage"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&UserSubscription{}, "UserSubscription"},
+		{&QuotaLedgerEntry{}, "QuotaLedgerEntry"},
+		{&UsageStatement{}, "UsageStatement"},
+		{&ChannelCapability{}, "ChannelCapability"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
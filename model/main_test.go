@@ -0,0 +1,30 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB 使用内存 SQLite 替换 DB 并关闭 Redis 缓存，测试结束后恢复
+func setupTestDB(t *testing.T, models ...any) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	previousDB, previousLogDB, previousRedisEnabled := DB, LOG_DB, common.RedisEnabled
+	DB, LOG_DB, common.RedisEnabled = db, db, false
+	initCol()
+	t.Cleanup(func() {
+		DB, LOG_DB, common.RedisEnabled = previousDB, previousLogDB, previousRedisEnabled
+		sqlDB, _ := db.DB()
+		_ = sqlDB.Close()
+	})
+}
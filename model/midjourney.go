@@ -4,6 +4,7 @@ type Midjourney struct {
 	Id          int    `json:"id"`
 	Code        int    `json:"code"`
 	UserId      int    `json:"user_id" gorm:"index"`
+	OrgId       int    `json:"org_id" gorm:"default:0"`
 	Action      string `json:"action" gorm:"type:varchar(40);index"`
 	MjId        string `json:"mj_id" gorm:"index"`
 	Prompt      string `json:"prompt"`
@@ -23,6 +24,11 @@ type Midjourney struct {
 	Quota       int    `json:"quota"`
 	Buttons     string `json:"buttons"`
 	Properties  string `json:"properties"`
+
+	// 订阅额度承担的部分、所属订阅及扣费时所处的订阅周期，失败退款时原路退回
+	SubscriptionId          int   `json:"subscription_id" gorm:"default:0"`
+	SubscriptionPeriodStart int64 `json:"subscription_period_start" gorm:"type:bigint;default:0"`
+	SubscriptionQuota       int   `json:"subscription_quota" gorm:"default:0"`
 }
 
 // TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
@@ -157,6 +163,28 @@ func (midjourney *Midjourney) Update() error {
 	return err
 }
 
+// PayerCharge 返回任务扣费的组成
+func (midjourney *Midjourney) PayerCharge() PayerCharge {
+	return PayerCharge{
+		SubscriptionId:          midjourney.SubscriptionId,
+		SubscriptionPeriodStart: midjourney.SubscriptionPeriodStart,
+		SubscriptionQuota:       midjourney.SubscriptionQuota,
+		Quota:                   midjourney.Quota - midjourney.SubscriptionQuota,
+	}
+}
+
+// SavePayerCharge 记录任务扣费中订阅额度承担的部分
+func (midjourney *Midjourney) SavePayerCharge(charge PayerCharge) error {
+	midjourney.SubscriptionId = charge.SubscriptionId
+	midjourney.SubscriptionPeriodStart = charge.SubscriptionPeriodStart
+	midjourney.SubscriptionQuota = charge.SubscriptionQuota
+	return DB.Model(&Midjourney{}).Where("id = ?", midjourney.Id).Updates(map[string]any{
+		"subscription_id":           charge.SubscriptionId,
+		"subscription_period_start": charge.SubscriptionPeriodStart,
+		"subscription_quota":        charge.SubscriptionQuota,
+	}).Error
+}
+
 func MjBulkUpdate(mjIds []string, params map[string]any) error {
 	return DB.Model(&Midjourney{}).
 		Where("mj_id in (?)", mjIds).
@@ -148,6 +148,15 @@ func InitOptionMap() {
 func loadOptionsFromDatabase() {
 	options, _ := AllOption()
 	for _, option := range options {
+		// 早期版本可能加密过不在 secretOptions 中的配置项，同样需要解密
+		if IsSecretOption(option.Key) || common.IsEncryptedSecret(option.Value) {
+			value, err := common.DecryptSecret(option.Value)
+			if err != nil {
+				common.SysError("failed to decrypt option " + option.Key + ": " + err.Error())
+				continue
+			}
+			option.Value = value
+		}
 		err := updateOptionMap(option.Key, option.Value)
 		if err != nil {
 			common.SysError("failed to update option map: " + err.Error())
@@ -164,13 +173,20 @@ func SyncOptions(frequency int) {
 }
 
 func UpdateOption(key string, value string) error {
+	storedValue := value
+	if IsSecretOption(key) {
+		var err error
+		if storedValue, err = common.EncryptSecret(value); err != nil {
+			return err
+		}
+	}
 	// Save to database first
 	option := Option{
 		Key: key,
 	}
 	// https://gorm.io/docs/update.html#Save-All-Fields
 	DB.FirstOrCreate(&option, Option{Key: key})
-	option.Value = value
+	option.Value = storedValue
 	// Save is a combination function.
 	// If save value does not contain primary key, it will execute Create,
 	// otherwise it will execute Update (with all fields).
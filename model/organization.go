@@ -0,0 +1,515 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleAdmin  = "admin"
+	OrganizationRoleMember = "member"
+)
+
+const (
+	OrganizationStatusEnabled  = 1
+	OrganizationStatusDisabled = 2
+)
+
+// 组织层级的最大深度，防止数据异常时无限向上查找
+const organizationMaxDepth = 8
+
+// Organization 组织/团队，成员的组织令牌从组织的共享额度池扣费
+type Organization struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name" gorm:"type:varchar(64);index"`
+	ParentId    int            `json:"parent_id" gorm:"index;default:0"` // 上级组织，0 表示顶级组织
+	OwnerId     int            `json:"owner_id" gorm:"index"`
+	Status      int            `json:"status" gorm:"default:1"`
+	Quota       int            `json:"quota" gorm:"default:0"` // 共享额度池剩余额度
+	UsedQuota   int            `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	Role        string         `json:"role,omitempty" gorm:"-"` // 当前用户在组织中的角色
+}
+
+type OrganizationMember struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"uniqueIndex:idx_org_member"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_org_member;index"`
+	Role        string `json:"role" gorm:"type:varchar(16)"`
+	QuotaLimit  int    `json:"quota_limit" gorm:"default:0"` // 成员可使用的组织额度上限，0 表示不限制
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Username    string `json:"username" gorm:"->;-:migration"`
+}
+
+func IsValidOrganizationRole(role string) bool {
+	switch role {
+	case OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleMember:
+		return true
+	}
+	return false
+}
+
+// CreateOrganization 创建组织，创建者成为所有者
+func CreateOrganization(org *Organization) error {
+	org.CreatedTime = common.GetTimestamp()
+	org.Status = OrganizationStatusEnabled
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:       org.Id,
+			UserId:      org.OwnerId,
+			Role:        OrganizationRoleOwner,
+			CreatedTime: org.CreatedTime,
+		}).Error
+	})
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	org := Organization{}
+	err := DB.First(&org, "id = ?", id).Error
+	return &org, err
+}
+
+func GetAllOrganizations(startIdx int, num int) (orgs []*Organization, total int64, err error) {
+	err = DB.Model(&Organization{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, total, err
+}
+
+// GetUserOrganizations 获取用户直接加入的组织
+func GetUserOrganizations(userId int) ([]*Organization, error) {
+	var members []*OrganizationMember
+	if err := DB.Where("user_id = ?", userId).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[int]string, len(members))
+	orgIds := make([]int, 0, len(members))
+	for _, member := range members {
+		roles[member.OrgId] = member.Role
+		orgIds = append(orgIds, member.OrgId)
+	}
+	var orgs []*Organization
+	if len(orgIds) == 0 {
+		return orgs, nil
+	}
+	if err := DB.Where("id in ?", orgIds).Order("id desc").Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		org.Role = roles[org.Id]
+	}
+	return orgs, nil
+}
+
+func GetChildOrganizations(parentId int) (orgs []*Organization, err error) {
+	err = DB.Where("parent_id = ?", parentId).Order("id desc").Find(&orgs).Error
+	return orgs, err
+}
+
+func (org *Organization) Update() error {
+	return DB.Model(org).Select("name", "status").Updates(org).Error
+}
+
+// GetOrganizationMember 获取用户在组织中的成员记录，不是成员时返回 nil
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Where("org_id = ? and user_id = ?", orgId, userId).Limit(1).Find(&members).Error
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+	return members[0], nil
+}
+
+func GetOrganizationMembers(orgId int) (members []*OrganizationMember, err error) {
+	err = DB.Table("organization_members").
+		Select("organization_members.*, users.username").
+		Joins("left join users on users.id = organization_members.user_id").
+		Where("organization_members.org_id = ?", orgId).
+		Order("organization_members.id asc").
+		Find(&members).Error
+	return members, err
+}
+
+// GetOrganizationRole 获取用户在组织中的有效角色，上级组织的所有者和管理员视为下级组织的管理员，不是成员时返回空字符串
+func GetOrganizationRole(orgId int, userId int) (string, error) {
+	member, err := GetOrganizationMember(orgId, userId)
+	if err != nil {
+		return "", err
+	}
+	if member != nil {
+		return member.Role, nil
+	}
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return "", err
+	}
+	parentId := org.ParentId
+	for depth := 0; parentId != 0 && depth < organizationMaxDepth; depth++ {
+		member, err = GetOrganizationMember(parentId, userId)
+		if err != nil {
+			return "", err
+		}
+		if member != nil && member.Role != OrganizationRoleMember {
+			return OrganizationRoleAdmin, nil
+		}
+		parent, err := GetOrganizationById(parentId)
+		if err != nil {
+			return "", err
+		}
+		parentId = parent.ParentId
+	}
+	return "", nil
+}
+
+func AddOrganizationMember(member *OrganizationMember) error {
+	existing, err := GetOrganizationMember(member.OrgId, member.UserId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("该用户已是组织成员")
+	}
+	member.CreatedTime = common.GetTimestamp()
+	return DB.Create(member).Error
+}
+
+func (member *OrganizationMember) Update() error {
+	return DB.Model(member).Select("role", "quota_limit").Updates(member).Error
+}
+
+// RemoveOrganizationMember 移除成员，成员名下的组织令牌转移给组织所有者，令牌继续可用
+func RemoveOrganizationMember(orgId int, userId int) error {
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return err
+	}
+	if org.OwnerId == userId {
+		return errors.New("不能移除组织所有者")
+	}
+	var keys []string
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Token{}).Where("org_id = ? and user_id = ?", orgId, userId).Pluck("key", &keys).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Token{}).Where("org_id = ? and user_id = ?", orgId, userId).Update("user_id", org.OwnerId).Error; err != nil {
+			return err
+		}
+		return tx.Where("org_id = ? and user_id = ?", orgId, userId).Delete(&OrganizationMember{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateTokensCache(keys)
+	return nil
+}
+
+// LeaveAllOrganizations 用户被删除时退出所有组织，所有者被删除时由最早加入的管理员（或成员）接任
+func LeaveAllOrganizations(userId int) error {
+	var members []*OrganizationMember
+	if err := DB.Where("user_id = ?", userId).Find(&members).Error; err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member.Role == OrganizationRoleOwner {
+			transferred, err := transferOrganizationOwnerOnLeave(member.OrgId, userId)
+			if err != nil {
+				return err
+			}
+			if !transferred {
+				continue
+			}
+		}
+		if err := RemoveOrganizationMember(member.OrgId, userId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func transferOrganizationOwnerOnLeave(orgId int, ownerId int) (bool, error) {
+	var successors []*OrganizationMember
+	err := DB.Where("org_id = ? and user_id <> ?", orgId, ownerId).
+		Order(fmt.Sprintf("case when role = '%s' then 0 else 1 end, id asc", OrganizationRoleAdmin)).
+		Limit(1).Find(&successors).Error
+	if err != nil {
+		return false, err
+	}
+	if len(successors) == 0 {
+		// 没有其他成员，禁用组织，由系统管理员处理
+		return false, DB.Model(&Organization{}).Where("id = ?", orgId).Update("status", OrganizationStatusDisabled).Error
+	}
+	return true, TransferOrganizationOwner(orgId, successors[0].UserId)
+}
+
+// TransferOrganizationOwner 转移组织所有权，原所有者降为管理员
+func TransferOrganizationOwner(orgId int, newOwnerId int) error {
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return err
+	}
+	member, err := GetOrganizationMember(orgId, newOwnerId)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return errors.New("新所有者必须是组织成员")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OrganizationMember{}).Where("org_id = ? and user_id = ?", orgId, org.OwnerId).
+			Update("role", OrganizationRoleAdmin).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&OrganizationMember{}).Where("org_id = ? and user_id = ?", orgId, newOwnerId).
+			Update("role", OrganizationRoleOwner).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Organization{}).Where("id = ?", orgId).Update("owner_id", newOwnerId).Error
+	})
+}
+
+// DeleteOrganization 删除组织，禁用组织令牌，剩余额度退回上级组织或所有者
+func DeleteOrganization(orgId int) error {
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return err
+	}
+	var childCount int64
+	if err := DB.Model(&Organization{}).Where("parent_id = ?", orgId).Count(&childCount).Error; err != nil {
+		return err
+	}
+	if childCount > 0 {
+		return errors.New("请先删除下级组织")
+	}
+	source := LedgerSource{
+		Reason:     LedgerReasonOrgDelete,
+		SourceType: LedgerSourceOrganization,
+		SourceId:   fmt.Sprintf("%d", org.Id),
+		UserId:     org.OwnerId,
+	}
+	var keys []string
+	quota := 0
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		current := Organization{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, "id = ?", orgId).Error; err != nil {
+			return err
+		}
+		quota = current.Quota
+		if err := tx.Model(&Token{}).Where("org_id = ?", orgId).Pluck("key", &keys).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Token{}).Where("org_id = ?", orgId).Update("status", common.TokenStatusDisabled).Error; err != nil {
+			return err
+		}
+		// 剩余额度退回上级组织，顶级组织退回所有者
+		refundLeg := LedgerLeg{AccountType: LedgerAccountOrganization, AccountId: org.ParentId, Amount: quota}
+		if org.ParentId == 0 {
+			refundLeg = LedgerLeg{AccountType: LedgerAccountUser, AccountId: org.OwnerId, Amount: quota}
+		}
+		if quota > 0 {
+			var err error
+			if org.ParentId != 0 {
+				err = tx.Model(&Organization{}).Where("id = ?", org.ParentId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+			} else {
+				err = tx.Model(&User{}).Where("id = ?", org.OwnerId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("org_id = ?", orgId).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(org).Error; err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, source,
+			LedgerLeg{AccountType: LedgerAccountOrganization, AccountId: org.Id, Amount: -quota},
+			refundLeg,
+		)
+	})
+	if err != nil {
+		return err
+	}
+	invalidateTokensCache(keys)
+	if quota > 0 && org.ParentId == 0 {
+		gopool.Go(func() {
+			if err := cacheIncrUserQuota(org.OwnerId, int64(quota)); err != nil {
+				common.SysError("failed to increase user quota cache: " + err.Error())
+			}
+		})
+	}
+	return nil
+}
+
+// DepositOrganizationQuota 成员将个人额度转入组织额度池
+func DepositOrganizationQuota(orgId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// 以余额充足为条件扣减，避免并发转入导致个人额度透支
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", userId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("个人额度不足")
+		}
+		result = tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", gorm.Expr("quota + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("组织不存在")
+		}
+		return RecordQuotaLedgerTx(tx, LedgerSource{
+			Reason:     LedgerReasonOrgDeposit,
+			SourceType: LedgerSourceOrganization,
+			SourceId:   fmt.Sprintf("%d", orgId),
+			UserId:     userId,
+		},
+			LedgerLeg{AccountType: LedgerAccountUser, AccountId: userId, Amount: -quota},
+			LedgerLeg{AccountType: LedgerAccountOrganization, AccountId: orgId, Amount: quota},
+		)
+	})
+	if err != nil {
+		return err
+	}
+	gopool.Go(func() {
+		if err := cacheDecrUserQuota(userId, int64(quota)); err != nil {
+			common.SysError("failed to decrease user quota cache: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// AllocateOrganizationQuota 上级组织向下级组织划拨额度，quota 为负数时表示收回
+func AllocateOrganizationQuota(parentId int, childId int, quota int) error {
+	if quota == 0 {
+		return nil
+	}
+	child, err := GetOrganizationById(childId)
+	if err != nil {
+		return err
+	}
+	if child.ParentId != parentId {
+		return errors.New("只能向直属下级组织划拨额度")
+	}
+	from, to, amount := parentId, childId, quota
+	if quota < 0 {
+		from, to, amount = childId, parentId, -quota
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Organization{}).Where("id = ? and quota >= ?", from, amount).
+			Update("quota", gorm.Expr("quota - ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("组织额度不足")
+		}
+		if err := tx.Model(&Organization{}).Where("id = ?", to).Update("quota", gorm.Expr("quota + ?", amount)).Error; err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, LedgerSource{
+			Reason:     LedgerReasonOrgAllocate,
+			SourceType: LedgerSourceOrganization,
+			SourceId:   fmt.Sprintf("%d", parentId),
+			UserId:     child.OwnerId,
+		},
+			LedgerLeg{AccountType: LedgerAccountOrganization, AccountId: from, Amount: -amount},
+			LedgerLeg{AccountType: LedgerAccountOrganization, AccountId: to, Amount: amount},
+		)
+	})
+}
+
+// SetOrganizationQuota 系统管理员直接设置组织额度
+func SetOrganizationQuota(orgId int, quota int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		org := Organization{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&org, "id = ?", orgId).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", quota).Error; err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, LedgerSource{
+			Reason:     LedgerReasonAdminAdjust,
+			SourceType: LedgerSourceOrganization,
+			SourceId:   fmt.Sprintf("%d", orgId),
+			UserId:     org.OwnerId,
+		}, FundingLegs(LedgerAccountOrganization, orgId, quota-org.Quota)...)
+	})
+}
+
+// GetOrganizationAvailableQuota 获取成员可使用的组织额度，受组织剩余额度和成员额度上限共同约束
+func GetOrganizationAvailableQuota(orgId int, userId int) (int, error) {
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return 0, err
+	}
+	if org.Status != OrganizationStatusEnabled {
+		return 0, errors.New("organization is disabled")
+	}
+	member, err := GetOrganizationMember(orgId, userId)
+	if err != nil {
+		return 0, err
+	}
+	if member == nil {
+		return 0, errors.New("user is not a member of the organization")
+	}
+	quota := org.Quota
+	if member.QuotaLimit > 0 && member.QuotaLimit-member.UsedQuota < quota {
+		quota = member.QuotaLimit - member.UsedQuota
+	}
+	return quota, nil
+}
+
+// updateOrganizationConsumedQuota 在事务中从组织额度池扣减额度并计入成员用量，quota 为负数时表示退还
+func updateOrganizationConsumedQuota(tx *gorm.DB, orgId int, userId int, quota int) error {
+	err := tx.Model(&Organization{}).Where("id = ?", orgId).Updates(map[string]interface{}{
+		"quota":      gorm.Expr("quota - ?", quota),
+		"used_quota": gorm.Expr("used_quota + ?", quota),
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&OrganizationMember{}).Where("org_id = ? and user_id = ?", orgId, userId).
+		Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+}
+
+func GetOrganizationTokens(orgId int, startIdx int, num int) (tokens []*Token, total int64, err error) {
+	err = DB.Model(&Token{}).Where("org_id = ?", orgId).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Where("org_id = ?", orgId).Order("id desc").Limit(num).Offset(startIdx).Find(&tokens).Error
+	return tokens, total, err
+}
+
+func invalidateTokensCache(keys []string) {
+	if !common.RedisEnabled {
+		return
+	}
+	for _, key := range keys {
+		if err := cacheDeleteToken(key); err != nil {
+			common.SysError("failed to delete token cache: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package model
+
+import "testing"
+
+func TestDeleteOrganizationRefundsQuota(t *testing.T) {
+	setupTestDB(t, &User{}, &Organization{}, &OrganizationMember{}, &Token{}, &QuotaLedgerEntry{})
+	seed := []any{
+		&User{Id: 1, Username: "owner", AffCode: "o1", Quota: 100},
+		&Organization{Id: 1, Name: "root", OwnerId: 1, Quota: 300, Status: OrganizationStatusEnabled},
+		&Organization{Id: 2, Name: "team", OwnerId: 1, ParentId: 1, Quota: 50, Status: OrganizationStatusEnabled},
+		&OrganizationMember{OrgId: 2, UserId: 1, Role: OrganizationRoleOwner},
+		&Token{Id: 1, UserId: 1, OrgId: 2, Key: "team-key", Status: 1},
+	}
+	for _, record := range seed {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DeleteOrganization(1); err == nil {
+		t.Fatal("deleting an organization with children succeeded")
+	}
+
+	if err := DeleteOrganization(2); err != nil {
+		t.Fatal(err)
+	}
+	root, err := GetOrganizationById(1)
+	if err != nil || root.Quota != 350 {
+		t.Errorf("root quota after deleting child = %d, %v, want 350", root.Quota, err)
+	}
+	var token Token
+	if err := DB.First(&token, 1).Error; err != nil || token.Status == 1 {
+		t.Errorf("organization token status = %d, %v, want disabled", token.Status, err)
+	}
+
+	if err := DeleteOrganization(1); err != nil {
+		t.Fatal(err)
+	}
+	user, err := GetUserById(1, false)
+	if err != nil || user.Quota != 450 {
+		t.Errorf("owner quota after deleting root = %d, %v, want 450", user.Quota, err)
+	}
+	var entries []QuotaLedgerEntry
+	if err := DB.Where("reason = ?", LedgerReasonOrgDelete).Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("org delete ledger entries = %d, want 4", len(entries))
+	}
+	last := entries[3]
+	if last.AccountType != LedgerAccountUser || last.AccountId != 1 || last.Amount != 350 || last.Balance != 450 {
+		t.Errorf("owner refund entry = %+v", last)
+	}
+}
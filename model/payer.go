@@ -0,0 +1,178 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// GetPayerQuota 获取本次请求付费方的剩余额度，组织令牌使用组织额度池，否则使用用户订阅额度与充值额度之和
+func GetPayerQuota(userId int, orgId int) (int, error) {
+	if orgId == 0 {
+		quota, err := GetUserQuota(userId, false)
+		if err != nil {
+			return 0, err
+		}
+		subscriptionQuota, err := GetUserSubscriptionQuota(userId)
+		if err != nil {
+			return 0, err
+		}
+		return quota + subscriptionQuota, nil
+	}
+	return GetOrganizationAvailableQuota(orgId, userId)
+}
+
+// PayerCharge 付费方扣费的组成，退款时按组成原路退回
+type PayerCharge struct {
+	SubscriptionId int
+	// 扣费时订阅所处周期的开始时间，订阅进入新周期后不再退回
+	SubscriptionPeriodStart int64
+	// 订阅额度承担的部分
+	SubscriptionQuota int
+	// 充值额度或组织额度承担的部分
+	Quota int
+}
+
+func (charge PayerCharge) Total() int {
+	return charge.SubscriptionQuota + charge.Quota
+}
+
+// Add 累加一次扣费
+func (charge *PayerCharge) Add(other PayerCharge) {
+	if other.SubscriptionId != 0 {
+		charge.SubscriptionId = other.SubscriptionId
+		charge.SubscriptionPeriodStart = other.SubscriptionPeriodStart
+	}
+	charge.SubscriptionQuota += other.SubscriptionQuota
+	charge.Quota += other.Quota
+}
+
+// Sub 扣除一次退款
+func (charge *PayerCharge) Sub(refund PayerCharge) {
+	charge.SubscriptionQuota -= refund.SubscriptionQuota
+	charge.Quota -= refund.Quota
+}
+
+// Refund 计算退还 quota 时各部分的金额。扣费时先用订阅额度，因此退款时先退充值额度，
+// 使保留的扣费与直接按实际用量扣费时的组成一致；超出已记录扣费的部分退回充值额度
+func (charge PayerCharge) Refund(quota int) PayerCharge {
+	refund := PayerCharge{SubscriptionId: charge.SubscriptionId, SubscriptionPeriodStart: charge.SubscriptionPeriodStart}
+	refund.Quota = max(min(quota, charge.Quota), 0)
+	refund.SubscriptionQuota = max(min(quota-refund.Quota, charge.SubscriptionQuota), 0)
+	refund.Quota = quota - refund.SubscriptionQuota
+	return refund
+}
+
+// DecreasePayerQuota 扣除付费方额度，个人额度优先从订阅额度扣除，不足部分扣除充值额度，返回扣费的组成。
+// 额度变动与账本在同一事务中写入
+func DecreasePayerQuota(userId int, orgId int, quota int, source LedgerSource) (PayerCharge, error) {
+	if quota < 0 {
+		return PayerCharge{}, errors.New("quota 不能为负数！")
+	}
+	var sub *UserSubscription
+	if orgId == 0 {
+		// 在事务外获取订阅，周期结束时会先续期
+		var err error
+		sub, err = getUserSubscriptionCache(userId)
+		if err != nil {
+			return PayerCharge{}, err
+		}
+	}
+	charge := PayerCharge{Quota: quota}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if orgId != 0 {
+			if err := updateOrganizationConsumedQuota(tx, orgId, userId, quota); err != nil {
+				return err
+			}
+			return recordPayerLedger(tx, userId, orgId, charge, source)
+		}
+		covered, err := consumeSubscriptionQuota(tx, sub, quota)
+		if err != nil {
+			return err
+		}
+		if covered > 0 {
+			charge = PayerCharge{SubscriptionId: sub.Id, SubscriptionPeriodStart: sub.CurrentPeriodStart, SubscriptionQuota: covered, Quota: quota - covered}
+		}
+		if err := updateUserQuotaTx(tx, userId, -charge.Quota); err != nil {
+			return err
+		}
+		return recordPayerLedger(tx, userId, orgId, charge, source)
+	})
+	if err != nil {
+		return PayerCharge{}, err
+	}
+	if orgId == 0 {
+		applyUserQuotaDelta(userId, -charge.Quota)
+		if charge.SubscriptionQuota > 0 {
+			cacheIncrUserSubscriptionUsedQuota(userId, charge.SubscriptionQuota)
+		}
+	}
+	return charge, nil
+}
+
+// IncreasePayerQuota 按 refund 的组成退还付费方额度，订阅承担的部分退回原订阅，
+// 订阅已失效或进入新周期时该部分随订阅额度作废。额度变动与账本在同一事务中写入
+func IncreasePayerQuota(userId int, orgId int, refund PayerCharge, source LedgerSource) error {
+	if refund.Quota < 0 || refund.SubscriptionQuota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	if orgId != 0 {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			if err := updateOrganizationConsumedQuota(tx, orgId, userId, -refund.Total()); err != nil {
+				return err
+			}
+			return recordPayerLedger(tx, userId, orgId, PayerCharge{Quota: -refund.Total()}, source)
+		})
+	}
+	refunded := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if refund.SubscriptionQuota > 0 {
+			var err error
+			refunded, err = refundSubscriptionQuota(tx, refund.SubscriptionId, refund.SubscriptionPeriodStart, refund.SubscriptionQuota)
+			if err != nil {
+				return err
+			}
+		}
+		if err := updateUserQuotaTx(tx, userId, refund.Quota); err != nil {
+			return err
+		}
+		return recordPayerLedger(tx, userId, orgId, PayerCharge{
+			SubscriptionId:    refund.SubscriptionId,
+			SubscriptionQuota: -refunded,
+			Quota:             -refund.Quota,
+		}, source)
+	})
+	if err != nil {
+		return err
+	}
+	applyUserQuotaDelta(userId, refund.Quota)
+	if refunded > 0 {
+		cacheIncrUserSubscriptionUsedQuota(userId, -refunded)
+	}
+	if refunded < refund.SubscriptionQuota {
+		common.SysLog(fmt.Sprintf("subscription %d of user %d can not take back refund %d, forfeited %d",
+			refund.SubscriptionId, userId, refund.SubscriptionQuota, refund.SubscriptionQuota-refunded))
+	}
+	return nil
+}
+
+// recordPayerLedger 在事务中记录付费方的消耗，charge 各部分为负数时表示退还
+func recordPayerLedger(tx *gorm.DB, userId int, orgId int, charge PayerCharge, source LedgerSource) error {
+	source.UserId = userId
+	legs := []LedgerLeg{{AccountType: LedgerAccountConsumption, AccountId: userId, Amount: charge.Total()}}
+	if source.Reason == LedgerReasonTaskRefund {
+		// 异步任务失败的退款是补偿，不冲减用户的已用额度
+		legs[0] = LedgerLeg{AccountType: LedgerAccountFunding, Amount: charge.Total()}
+	}
+	if orgId != 0 {
+		legs = append(legs, LedgerLeg{AccountType: LedgerAccountOrganization, AccountId: orgId, Amount: -charge.Quota})
+	} else {
+		legs = append(legs, LedgerLeg{AccountType: LedgerAccountUser, AccountId: userId, Amount: -charge.Quota})
+	}
+	if charge.SubscriptionQuota != 0 {
+		legs = append(legs, LedgerLeg{AccountType: LedgerAccountSubscription, AccountId: charge.SubscriptionId, Amount: -charge.SubscriptionQuota})
+	}
+	return RecordQuotaLedgerTx(tx, source, legs...)
+}
@@ -0,0 +1,75 @@
+package model
+
+import "testing"
+
+func TestPayerChargeRefund(t *testing.T) {
+	tests := []struct {
+		name   string
+		charge PayerCharge
+		quota  int
+		want   PayerCharge
+	}{
+		{
+			name:   "wallet part refunded first",
+			charge: PayerCharge{SubscriptionId: 3, SubscriptionQuota: 100, Quota: 50},
+			quota:  30,
+			want:   PayerCharge{SubscriptionId: 3, SubscriptionQuota: 0, Quota: 30},
+		},
+		{
+			name:   "spills over into subscription",
+			charge: PayerCharge{SubscriptionId: 3, SubscriptionQuota: 100, Quota: 50},
+			quota:  120,
+			want:   PayerCharge{SubscriptionId: 3, SubscriptionQuota: 70, Quota: 50},
+		},
+		{
+			name:   "subscription only",
+			charge: PayerCharge{SubscriptionId: 3, SubscriptionQuota: 100},
+			quota:  100,
+			want:   PayerCharge{SubscriptionId: 3, SubscriptionQuota: 100, Quota: 0},
+		},
+		{
+			name:   "more than charged goes to wallet",
+			charge: PayerCharge{SubscriptionId: 3, SubscriptionQuota: 10, Quota: 5},
+			quota:  40,
+			want:   PayerCharge{SubscriptionId: 3, SubscriptionQuota: 10, Quota: 30},
+		},
+		{
+			name:   "no recorded charge",
+			charge: PayerCharge{},
+			quota:  20,
+			want:   PayerCharge{Quota: 20},
+		},
+		{
+			name:   "zero",
+			charge: PayerCharge{SubscriptionId: 3, SubscriptionQuota: 10, Quota: 5},
+			quota:  0,
+			want:   PayerCharge{SubscriptionId: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.charge.Refund(tt.quota)
+			if got != tt.want {
+				t.Errorf("Refund(%d) = %+v, want %+v", tt.quota, got, tt.want)
+			}
+			if got.Total() != tt.quota {
+				t.Errorf("Refund(%d) total = %d", tt.quota, got.Total())
+			}
+		})
+	}
+}
+
+func TestPayerChargeAddSub(t *testing.T) {
+	var charge PayerCharge
+	charge.Add(PayerCharge{SubscriptionId: 2, SubscriptionQuota: 80, Quota: 20})
+	charge.Add(PayerCharge{Quota: 10})
+	want := PayerCharge{SubscriptionId: 2, SubscriptionQuota: 80, Quota: 30}
+	if charge != want {
+		t.Fatalf("after Add = %+v, want %+v", charge, want)
+	}
+	charge.Sub(charge.Refund(50))
+	want = PayerCharge{SubscriptionId: 2, SubscriptionQuota: 60, Quota: 0}
+	if charge != want {
+		t.Fatalf("after Sub = %+v, want %+v", charge, want)
+	}
+}
@@ -0,0 +1,385 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 账本账户类型，user/token/org/subscription 对应实际的额度余额，其余为系统对手账户，不校验余额
+const (
+	LedgerAccountUser           = "user"
+	LedgerAccountToken          = "token"
+	LedgerAccountOrganization   = "org"
+	LedgerAccountSubscription   = "subscription"
+	LedgerAccountFunding        = "funding"         // 额度来源：充值、兑换码、赠送、管理员调整、订阅发放等
+	LedgerAccountConsumption    = "consumption"     // 额度去向：模型调用等消耗，按用户 id 记账
+	LedgerAccountTokenAllowance = "token_allowance" // 令牌额度的对手账户，令牌额度是用户额度之上的限额而非独立资金
+)
+
+// 账务原因代码
+const (
+	LedgerReasonOpeningBalance     = "opening_balance"
+	LedgerReasonConsume            = "consume"
+	LedgerReasonTaskRefund         = "task_refund"
+	LedgerReasonTopUp              = "topup"
+	LedgerReasonRedemption         = "redemption"
+	LedgerReasonAffTransfer        = "aff_transfer"
+	LedgerReasonRegister           = "register"
+	LedgerReasonInvite             = "invite"
+	LedgerReasonAdminAdjust        = "admin_adjust"
+	LedgerReasonTokenAllowance     = "token_allowance"
+	LedgerReasonOrgDeposit         = "org_deposit"
+	LedgerReasonOrgAllocate        = "org_allocate"
+	LedgerReasonOrgDelete          = "org_delete"
+	LedgerReasonSubscriptionGrant  = "subscription_grant"
+	LedgerReasonSubscriptionExpire = "subscription_expire"
+)
+
+// 来源类型
+const (
+	LedgerSourceRequest      = "request"
+	LedgerSourceTradeNo      = "trade_no"
+	LedgerSourceRedemption   = "redemption"
+	LedgerSourceTask         = "task"
+	LedgerSourceAdmin        = "admin"
+	LedgerSourceOrganization = "org"
+	LedgerSourceSubscription = "subscription"
+)
+
+// QuotaLedgerEntry 额度账本分录，只追加不修改。每次额度变动写入一笔交易，同一交易（TransactionId）的分录金额之和为 0
+type QuotaLedgerEntry struct {
+	Id            int64  `json:"id"`
+	TransactionId string `json:"transaction_id" gorm:"type:varchar(64);index"`
+	AccountType   string `json:"account_type" gorm:"type:varchar(32);index:idx_ledger_account"`
+	AccountId     int    `json:"account_id" gorm:"index:idx_ledger_account"`
+	Amount        int    `json:"amount"`  // 正数为入账，负数为出账
+	Balance       int    `json:"balance"` // 变动后的账户余额，系统账户为 0；开启批量更新时为写入时已落库的余额
+	UserId        int    `json:"user_id" gorm:"index"`
+	Reason        string `json:"reason" gorm:"type:varchar(32);index"`
+	SourceType    string `json:"source_type" gorm:"type:varchar(32)"`
+	SourceId      string `json:"source_id" gorm:"type:varchar(128);index"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// LedgerSource 额度变动的原因和来源，UserId 为发起变动的用户
+type LedgerSource struct {
+	Reason     string
+	SourceType string
+	SourceId   string
+	UserId     int
+}
+
+// TaskRefundLedgerSource 异步任务失败退还额度的账务来源
+func TaskRefundLedgerSource(userId int, taskId string) LedgerSource {
+	return LedgerSource{
+		Reason:     LedgerReasonTaskRefund,
+		SourceType: LedgerSourceTask,
+		SourceId:   taskId,
+		UserId:     userId,
+	}
+}
+
+type LedgerLeg struct {
+	AccountType string
+	AccountId   int
+	Amount      int
+}
+
+// FundingLegs 返回从系统来源账户向 account 转入 amount 的两条分录，amount 为负数时表示转出
+func FundingLegs(accountType string, accountId int, amount int) []LedgerLeg {
+	return []LedgerLeg{
+		{AccountType: LedgerAccountFunding, Amount: -amount},
+		{AccountType: accountType, AccountId: accountId, Amount: amount},
+	}
+}
+
+func ledgerAccountBalance(tx *gorm.DB, accountType string, accountId int) (int, error) {
+	var balance int
+	var err error
+	switch accountType {
+	case LedgerAccountUser:
+		err = tx.Model(&User{}).Where("id = ?", accountId).Select("quota").Find(&balance).Error
+	case LedgerAccountToken:
+		err = tx.Model(&Token{}).Where("id = ?", accountId).Select("remain_quota").Find(&balance).Error
+	case LedgerAccountOrganization:
+		err = tx.Model(&Organization{}).Where("id = ?", accountId).Select("quota").Find(&balance).Error
+	case LedgerAccountSubscription:
+		// 失效订阅的剩余额度已作废，余额为 0
+		err = tx.Model(&UserSubscription{}).Where("id = ? and status <> ?", accountId, SubscriptionStatusExpired).
+			Select("granted_quota - used_quota").Find(&balance).Error
+	}
+	return balance, err
+}
+
+// RecordQuotaLedgerTx 在事务中写入一笔平衡的账务交易，应在额度更新之后调用以记录变动后的余额
+func RecordQuotaLedgerTx(tx *gorm.DB, source LedgerSource, legs ...LedgerLeg) error {
+	sum := 0
+	entries := make([]QuotaLedgerEntry, 0, len(legs))
+	transactionId := common.GetUUID()
+	now := common.GetTimestamp()
+	for _, leg := range legs {
+		if leg.Amount == 0 {
+			continue
+		}
+		sum += leg.Amount
+		balance, err := ledgerAccountBalance(tx, leg.AccountType, leg.AccountId)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, QuotaLedgerEntry{
+			TransactionId: transactionId,
+			AccountType:   leg.AccountType,
+			AccountId:     leg.AccountId,
+			Amount:        leg.Amount,
+			Balance:       balance,
+			UserId:        source.UserId,
+			Reason:        source.Reason,
+			SourceType:    source.SourceType,
+			SourceId:      source.SourceId,
+			CreatedAt:     now,
+		})
+	}
+	if sum != 0 {
+		return fmt.Errorf("unbalanced ledger transaction %s: %+v", source.Reason, legs)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Create(&entries).Error
+}
+
+// RecordQuotaLedger 写入一笔账务交易，额度已经更新成功，失败时只记录错误日志，由对账任务发现差异
+func RecordQuotaLedger(source LedgerSource, legs ...LedgerLeg) {
+	if err := RecordQuotaLedgerTx(DB, source, legs...); err != nil {
+		common.SysError("failed to record quota ledger: " + err.Error())
+	}
+}
+
+type LedgerQuery struct {
+	AccountType string
+	AccountId   int
+	UserId      int
+	Reason      string
+	SourceId    string
+	StartTime   int64
+	EndTime     int64
+}
+
+func GetQuotaLedgerEntries(query LedgerQuery, startIdx int, num int) (entries []*QuotaLedgerEntry, total int64, err error) {
+	tx := DB.Model(&QuotaLedgerEntry{})
+	if query.AccountType != "" {
+		tx = tx.Where("account_type = ?", query.AccountType)
+		if query.AccountId != 0 {
+			tx = tx.Where("account_id = ?", query.AccountId)
+		}
+	}
+	if query.UserId != 0 {
+		tx = tx.Where("user_id = ?", query.UserId)
+	}
+	if query.Reason != "" {
+		tx = tx.Where("reason = ?", query.Reason)
+	}
+	if query.SourceId != "" {
+		tx = tx.Where("source_id = ?", query.SourceId)
+	}
+	if query.StartTime != 0 {
+		tx = tx.Where("created_at >= ?", query.StartTime)
+	}
+	if query.EndTime != 0 {
+		tx = tx.Where("created_at <= ?", query.EndTime)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&entries).Error
+	return entries, total, err
+}
+
+// InitQuotaLedger 账本为空时（首次启用）为现有账户写入期初余额，使后续对账有统一的起点
+func InitQuotaLedger() error {
+	var count int64
+	if err := DB.Model(&QuotaLedgerEntry{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	source := LedgerSource{Reason: LedgerReasonOpeningBalance}
+	var users []*User
+	err := DB.Select("id", "quota", "used_quota").FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+		for _, user := range users {
+			source.UserId = user.Id
+			legs := FundingLegs(LedgerAccountUser, user.Id, user.Quota)
+			// 历史消耗计入消耗账户的期初余额
+			legs = append(legs, FundingLegs(LedgerAccountConsumption, user.Id, user.UsedQuota)...)
+			if err := RecordQuotaLedgerTx(DB, source, legs...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	var tokens []*Token
+	err = DB.Select("id", "user_id", "remain_quota").FindInBatches(&tokens, 500, func(tx *gorm.DB, batch int) error {
+		for _, token := range tokens {
+			source.UserId = token.UserId
+			if err := RecordQuotaLedgerTx(DB, source, tokenAllowanceLegs(token.Id, token.RemainQuota)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	var orgs []*Organization
+	if err := DB.Select("id", "owner_id", "quota").Find(&orgs).Error; err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		source.UserId = org.OwnerId
+		if err := RecordQuotaLedgerTx(DB, source, FundingLegs(LedgerAccountOrganization, org.Id, org.Quota)...); err != nil {
+			return err
+		}
+	}
+	var subs []*UserSubscription
+	if err := DB.Where("status <> ?", SubscriptionStatusExpired).Find(&subs).Error; err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		source.UserId = sub.UserId
+		if err := RecordQuotaLedgerTx(DB, source, FundingLegs(LedgerAccountSubscription, sub.Id, sub.GrantedQuota-sub.UsedQuota)...); err != nil {
+			return err
+		}
+	}
+	common.SysLog("quota ledger initialized with opening balances")
+	return nil
+}
+
+func tokenAllowanceLegs(tokenId int, amount int) []LedgerLeg {
+	return []LedgerLeg{
+		{AccountType: LedgerAccountTokenAllowance, Amount: -amount},
+		{AccountType: LedgerAccountToken, AccountId: tokenId, Amount: amount},
+	}
+}
+
+// LedgerDrift 账本余额与实际余额不一致的账户
+type LedgerDrift struct {
+	AccountType   string `json:"account_type"`
+	AccountId     int    `json:"account_id"`
+	Field         string `json:"field"`
+	LedgerBalance int    `json:"ledger_balance"`
+	ActualBalance int    `json:"actual_balance"`
+	Drift         int    `json:"drift"`
+}
+
+type LedgerReconciliation struct {
+	CheckedAt       int64         `json:"checked_at"`
+	CheckedAccounts int           `json:"checked_accounts"`
+	Unbalanced      []string      `json:"unbalanced_transactions"`
+	Drifts          []LedgerDrift `json:"drifts"`
+}
+
+type ledgerSum struct {
+	AccountId int
+	Total     int
+}
+
+func sumLedger(accountType string) (map[int]int, error) {
+	var sums []ledgerSum
+	err := DB.Model(&QuotaLedgerEntry{}).Select("account_id, sum(amount) as total").
+		Where("account_type = ?", accountType).Group("account_id").Scan(&sums).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]int, len(sums))
+	for _, sum := range sums {
+		result[sum.AccountId] = sum.Total
+	}
+	return result, nil
+}
+
+type actualBalance struct {
+	Id      int
+	Balance int
+}
+
+// ReconcileQuotaLedger 对比账本汇总与 User.Quota、Token.RemainQuota、组织和订阅的实际余额，返回存在差异的账户。
+// 文本请求不计入 User.UsedQuota，因此消耗账户不与已用额度对账。开启批量更新时额度延迟落库，可能出现暂时性的差异
+func ReconcileQuotaLedger() (*LedgerReconciliation, error) {
+	report := &LedgerReconciliation{
+		CheckedAt:  common.GetTimestamp(),
+		Unbalanced: make([]string, 0),
+		Drifts:     make([]LedgerDrift, 0),
+	}
+
+	if err := DB.Model(&QuotaLedgerEntry{}).Select("transaction_id").Group("transaction_id").
+		Having("sum(amount) <> 0").Limit(100).Pluck("transaction_id", &report.Unbalanced).Error; err != nil {
+		return nil, err
+	}
+
+	checks := []struct {
+		accountType string
+		field       string
+		query       *gorm.DB
+	}{
+		{LedgerAccountUser, "quota", DB.Model(&User{}).Select("id, quota as balance")},
+		{LedgerAccountToken, "remain_quota", DB.Model(&Token{}).Select("id, remain_quota as balance")},
+		{LedgerAccountOrganization, "quota", DB.Model(&Organization{}).Select("id, quota as balance")},
+		{LedgerAccountSubscription, "remain_quota", DB.Model(&UserSubscription{}).
+			Where("status <> ?", SubscriptionStatusExpired).Select("id, granted_quota - used_quota as balance")},
+	}
+	for _, check := range checks {
+		sums, err := sumLedger(check.accountType)
+		if err != nil {
+			return nil, err
+		}
+		var balances []actualBalance
+		if err := check.query.Scan(&balances).Error; err != nil {
+			return nil, err
+		}
+		for _, balance := range balances {
+			report.CheckedAccounts++
+			ledgerBalance := sums[balance.Id]
+			if ledgerBalance == balance.Balance {
+				continue
+			}
+			report.Drifts = append(report.Drifts, LedgerDrift{
+				AccountType:   check.accountType,
+				AccountId:     balance.Id,
+				Field:         check.field,
+				LedgerBalance: ledgerBalance,
+				ActualBalance: balance.Balance,
+				Drift:         balance.Balance - ledgerBalance,
+			})
+		}
+	}
+	return report, nil
+}
+
+// ReconcileQuotaLedgerPeriodically 定期对账，存在差异时记录系统日志
+func ReconcileQuotaLedgerPeriodically(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		report, err := ReconcileQuotaLedger()
+		if err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+			continue
+		}
+		if len(report.Drifts) == 0 && len(report.Unbalanced) == 0 {
+			common.SysLog(fmt.Sprintf("quota ledger reconciled, %d accounts checked, no drift", report.CheckedAccounts))
+			continue
+		}
+		common.SysError(fmt.Sprintf("quota ledger drift detected: %d accounts drifted, %d unbalanced transactions",
+			len(report.Drifts), len(report.Unbalanced)))
+		for _, drift := range report.Drifts {
+			common.SysError(fmt.Sprintf("quota ledger drift: %s %d %s ledger=%d actual=%d drift=%d", drift.AccountType,
+				drift.AccountId, drift.Field, drift.LedgerBalance, drift.ActualBalance, drift.Drift))
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package model
+
+import (
+	"one-api/common"
+	"reflect"
+	"testing"
+)
+
+func TestRecordQuotaLedgerTx(t *testing.T) {
+	setupTestDB(t, &QuotaLedgerEntry{}, &User{})
+	if err := DB.Create(&User{Id: 1, Username: "ledger", Quota: 70}).Error; err != nil {
+		t.Fatal(err)
+	}
+	source := LedgerSource{Reason: LedgerReasonConsume, SourceType: LedgerSourceRequest, SourceId: "req-1", UserId: 1}
+	tests := []struct {
+		name         string
+		legs         []LedgerLeg
+		wantErr      bool
+		wantAmounts  []int
+		wantBalances []int
+	}{
+		{
+			name:         "funding",
+			legs:         FundingLegs(LedgerAccountUser, 1, 100),
+			wantAmounts:  []int{-100, 100},
+			wantBalances: []int{0, 70},
+		},
+		{
+			name:         "consume",
+			legs:         []LedgerLeg{{AccountType: LedgerAccountUser, AccountId: 1, Amount: -30}, {AccountType: LedgerAccountConsumption, AccountId: 1, Amount: 30}},
+			wantAmounts:  []int{-30, 30},
+			wantBalances: []int{70, 0},
+		},
+		{
+			name:         "zero legs skipped",
+			legs:         []LedgerLeg{{AccountType: LedgerAccountUser, AccountId: 1}, {AccountType: LedgerAccountFunding}},
+			wantAmounts:  []int{},
+			wantBalances: []int{},
+		},
+		{
+			name:    "unbalanced",
+			legs:    []LedgerLeg{{AccountType: LedgerAccountUser, AccountId: 1, Amount: 10}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB.Where("1 = 1").Delete(&QuotaLedgerEntry{})
+			err := RecordQuotaLedgerTx(DB, source, tt.legs...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RecordQuotaLedgerTx() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var entries []QuotaLedgerEntry
+			if err := DB.Order("id").Find(&entries).Error; err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if len(entries) != 0 {
+					t.Errorf("unbalanced transaction wrote %d entries", len(entries))
+				}
+				return
+			}
+			amounts := make([]int, 0, len(entries))
+			balances := make([]int, 0, len(entries))
+			for _, entry := range entries {
+				amounts = append(amounts, entry.Amount)
+				balances = append(balances, entry.Balance)
+				if entry.TransactionId != entries[0].TransactionId {
+					t.Errorf("entries belong to different transactions")
+				}
+				if entry.Reason != source.Reason || entry.SourceId != source.SourceId || entry.UserId != source.UserId {
+					t.Errorf("entry source = %+v, want %+v", entry, source)
+				}
+			}
+			if !reflect.DeepEqual(amounts, tt.wantAmounts) {
+				t.Errorf("amounts = %v, want %v", amounts, tt.wantAmounts)
+			}
+			if !reflect.DeepEqual(balances, tt.wantBalances) {
+				t.Errorf("balances = %v, want %v", balances, tt.wantBalances)
+			}
+		})
+	}
+}
+
+func TestReconcileQuotaLedger(t *testing.T) {
+	tests := []struct {
+		name string
+		// 在写入期初余额之后对账户做的变动
+		change     func() error
+		wantDrifts []LedgerDrift
+	}{
+		{
+			name:       "opening balances",
+			change:     func() error { return nil },
+			wantDrifts: []LedgerDrift{},
+		},
+		{
+			name: "consume recorded in ledger",
+			change: func() error {
+				if err := DB.Model(&User{}).Where("id = ?", 1).Updates(map[string]any{"quota": 900, "used_quota": 150}).Error; err != nil {
+					return err
+				}
+				return RecordQuotaLedgerTx(DB, LedgerSource{Reason: LedgerReasonConsume, UserId: 1},
+					LedgerLeg{AccountType: LedgerAccountUser, AccountId: 1, Amount: -100},
+					LedgerLeg{AccountType: LedgerAccountConsumption, AccountId: 1, Amount: 100})
+			},
+			wantDrifts: []LedgerDrift{},
+		},
+		{
+			name: "quota changed without ledger",
+			change: func() error {
+				return DB.Model(&User{}).Where("id = ?", 2).Updates(map[string]any{"quota": 250, "used_quota": 30}).Error
+			},
+			wantDrifts: []LedgerDrift{
+				{AccountType: LedgerAccountUser, AccountId: 2, Field: "quota", LedgerBalance: 200, ActualBalance: 250, Drift: 50},
+			},
+		},
+		{
+			name: "token and subscription changed without ledger",
+			change: func() error {
+				if err := DB.Model(&Token{}).Where("id = ?", 1).Update("remain_quota", 40).Error; err != nil {
+					return err
+				}
+				return DB.Model(&UserSubscription{}).Where("id = ?", 1).Update("used_quota", 600).Error
+			},
+			wantDrifts: []LedgerDrift{
+				{AccountType: LedgerAccountToken, AccountId: 1, Field: "remain_quota", LedgerBalance: 100, ActualBalance: 40, Drift: -60},
+				{AccountType: LedgerAccountSubscription, AccountId: 1, Field: "remain_quota", LedgerBalance: 500, ActualBalance: 400, Drift: -100},
+			},
+		},
+		{
+			name: "expired subscription is not checked",
+			change: func() error {
+				return DB.Model(&UserSubscription{}).Where("id = ?", 2).Update("used_quota", 0).Error
+			},
+			wantDrifts: []LedgerDrift{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t, &QuotaLedgerEntry{}, &User{}, &Token{}, &Organization{}, &UserSubscription{})
+			seed := []any{
+				&User{Id: 1, Username: "u1", AffCode: "a1", Quota: 1000, UsedQuota: 50},
+				&User{Id: 2, Username: "u2", AffCode: "a2", Quota: 200},
+				&Token{Id: 1, UserId: 1, Key: "k1", RemainQuota: 100},
+				&Organization{Id: 1, OwnerId: 1, Quota: 300},
+				&UserSubscription{Id: 1, UserId: 1, Status: SubscriptionStatusActive, GrantedQuota: 1000, UsedQuota: 500},
+				&UserSubscription{Id: 2, UserId: 2, Status: SubscriptionStatusExpired, GrantedQuota: 1000, UsedQuota: 1000},
+			}
+			for _, record := range seed {
+				if err := DB.Create(record).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := InitQuotaLedger(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.change(); err != nil {
+				t.Fatal(err)
+			}
+			report, err := ReconcileQuotaLedger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Unbalanced) != 0 {
+				t.Errorf("unbalanced transactions: %v", report.Unbalanced)
+			}
+			if report.CheckedAccounts != 5 {
+				t.Errorf("checked %d accounts, want 5", report.CheckedAccounts)
+			}
+			if !reflect.DeepEqual(report.Drifts, tt.wantDrifts) {
+				t.Errorf("drifts = %+v, want %+v", report.Drifts, tt.wantDrifts)
+			}
+		})
+	}
+}
+
+func TestUserInsertRecordsLedger(t *testing.T) {
+	setupTestDB(t, &QuotaLedgerEntry{}, &User{}, &Log{})
+	previousNewUser, previousInvitee := common.QuotaForNewUser, common.QuotaForInvitee
+	common.QuotaForNewUser, common.QuotaForInvitee = 100, 50
+	t.Cleanup(func() {
+		common.QuotaForNewUser, common.QuotaForInvitee = previousNewUser, previousInvitee
+	})
+	if err := DB.Create(&User{Id: 1, Username: "inviter", AffCode: "inv1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Username: "invitee", Password: "password123"}
+	if err := user.Insert(1); err != nil {
+		t.Fatal(err)
+	}
+	if user.Quota != 150 {
+		t.Errorf("quota = %d, want 150", user.Quota)
+	}
+	var entries []QuotaLedgerEntry
+	if err := DB.Where("account_type = ?", LedgerAccountUser).Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	var reasons []string
+	var balances []int
+	for _, entry := range entries {
+		reasons = append(reasons, entry.Reason)
+		balances = append(balances, entry.Balance)
+	}
+	if !reflect.DeepEqual(reasons, []string{LedgerReasonRegister, LedgerReasonInvite}) || !reflect.DeepEqual(balances, []int{100, 150}) {
+		t.Errorf("ledger reasons = %v, balances = %v", reasons, balances)
+	}
+}
@@ -143,7 +143,15 @@ func Redeem(key string, userId int) (quota int, err error) {
 		redemption.Status = common.RedemptionCodeStatusUsed
 		redemption.UsedUserId = userId
 		err = tx.Save(redemption).Error
-		return err
+		if err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, LedgerSource{
+			Reason:     LedgerReasonRedemption,
+			SourceType: LedgerSourceRedemption,
+			SourceId:   strconv.Itoa(redemption.Id),
+			UserId:     userId,
+		}, FundingLegs(LedgerAccountUser, userId, redemption.Quota)...)
 	})
 	if err != nil {
 		return 0, errors.New("兑换失败，" + err.Error())
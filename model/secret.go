@@ -0,0 +1,219 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("secret", secretSerializer{})
+}
+
+// secretSerializer 写入数据库时加密、读取时解密，用于 gorm:"serializer:secret" 标记的字段
+type secretSerializer struct{}
+
+func (secretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	}
+	plaintext, err := common.DecryptSecret(value)
+	if err != nil {
+		// 不能把密文当作明文使用，字段保持为空并返回错误
+		return fmt.Errorf("failed to decrypt %s.%s: %w", field.Schema.Table, field.DBName, err)
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+func (secretSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, _ := fieldValue.(string)
+	return common.EncryptSecret(value)
+}
+
+// secretDigest 密钥的 SHA-256 摘要，加密后仍可按完整密钥精确查找
+func secretDigest(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// secretOptions 需要加密存储且不在选项接口中返回的配置项
+var secretOptions = map[string]bool{
+	"SMTPToken":           true,
+	"WorkerValidKey":      true,
+	"EpayKey":             true,
+	"StripeApiSecret":     true,
+	"StripeWebhookSecret": true,
+	"GitHubClientSecret":  true,
+	"LinuxDOClientSecret": true,
+	"WeChatServerToken":   true,
+	"TelegramBotToken":    true,
+	"TurnstileSecretKey":  true,
+	"oidc.client_secret":  true,
+}
+
+// IsSecretOption 是否为需要加密存储且不在选项接口中返回的配置项
+func IsSecretOption(key string) bool {
+	return secretOptions[key]
+}
+
+func encryptUserSetting(setting dto.UserSetting) (dto.UserSetting, error) {
+	secret, err := common.EncryptSecret(setting.WebhookSecret)
+	if err != nil {
+		return setting, err
+	}
+	setting.WebhookSecret = secret
+	return setting, nil
+}
+
+func decryptUserSetting(setting dto.UserSetting) dto.UserSetting {
+	secret, err := common.DecryptSecret(setting.WebhookSecret)
+	if err != nil {
+		common.SysError("failed to decrypt webhook secret: " + err.Error())
+		return setting
+	}
+	setting.WebhookSecret = secret
+	return setting
+}
+
+// SecretRotationReport 重新加密的记录数
+type SecretRotationReport struct {
+	Channels int `json:"channels"`
+	Users    int `json:"users"`
+	Options  int `json:"options"`
+}
+
+// RotateSecrets 将明文或使用旧主密钥加密的渠道密钥、webhook 密钥和敏感配置改为由当前主密钥加密
+func RotateSecrets() (SecretRotationReport, error) {
+	report := SecretRotationReport{}
+	if !common.IsSecretEncryptionEnabled() {
+		return report, fmt.Errorf("SECRET_ENCRYPTION_KEYS is not set")
+	}
+
+	batchSize := 100
+	// 读取原始值，避免经过解密
+	var lastId int
+	for {
+		var channels []struct {
+			Id        int
+			Key       string
+			KeyDigest string
+		}
+		err := DB.Model(&Channel{}).Select("id", "key", "key_digest").Where("id > ?", lastId).
+			Order("id").Limit(batchSize).Find(&channels).Error
+		if err != nil {
+			return report, err
+		}
+		for _, channel := range channels {
+			lastId = channel.Id
+			key, changed, err := common.RewrapSecret(channel.Key)
+			if err != nil {
+				return report, fmt.Errorf("channel #%d: %w", channel.Id, err)
+			}
+			updates := map[string]interface{}{}
+			if changed {
+				updates["key"] = key
+			}
+			if channel.KeyDigest == "" && channel.Key != "" {
+				plaintext, err := common.DecryptSecret(key)
+				if err != nil {
+					return report, fmt.Errorf("channel #%d: %w", channel.Id, err)
+				}
+				updates["key_digest"] = secretDigest(plaintext)
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if err := DB.Table("channels").Where("id = ?", channel.Id).Updates(updates).Error; err != nil {
+				return report, err
+			}
+			if changed {
+				report.Channels++
+			}
+		}
+		if len(channels) < batchSize {
+			break
+		}
+	}
+
+	lastId = 0
+	for {
+		var users []struct {
+			Id      int
+			Setting string
+		}
+		err := DB.Unscoped().Model(&User{}).Select("id", "setting").Where("id > ? AND setting LIKE ?", lastId, "%webhook_secret%").
+			Order("id").Limit(batchSize).Find(&users).Error
+		if err != nil {
+			return report, err
+		}
+		for _, user := range users {
+			lastId = user.Id
+			var setting dto.UserSetting
+			if err := common.Unmarshal([]byte(user.Setting), &setting); err != nil {
+				common.SysError(fmt.Sprintf("user #%d: failed to unmarshal setting: %s", user.Id, err.Error()))
+				continue
+			}
+			secret, changed, err := common.RewrapSecret(setting.WebhookSecret)
+			if err != nil {
+				return report, fmt.Errorf("user #%d: %w", user.Id, err)
+			}
+			if !changed {
+				continue
+			}
+			setting.WebhookSecret = secret
+			settingBytes, err := common.Marshal(setting)
+			if err != nil {
+				return report, err
+			}
+			if err := DB.Table("users").Where("id = ?", user.Id).Update("setting", string(settingBytes)).Error; err != nil {
+				return report, err
+			}
+			report.Users++
+		}
+		if len(users) < batchSize {
+			break
+		}
+	}
+
+	options, err := AllOption()
+	if err != nil {
+		return report, err
+	}
+	for _, option := range options {
+		var value string
+		var changed bool
+		if IsSecretOption(option.Key) {
+			value, changed, err = common.RewrapSecret(option.Value)
+		} else if common.IsEncryptedSecret(option.Value) {
+			// 不需要保密的配置项恢复为明文
+			value, err = common.DecryptSecret(option.Value)
+			changed = err == nil
+		} else {
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("option %s: %w", option.Key, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := DB.Model(&Option{}).Where(commonKeyCol+" = ?", option.Key).Update("value", value).Error; err != nil {
+			return report, err
+		}
+		report.Options++
+	}
+	return report, nil
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/constant"
+	"testing"
+)
+
+func setSecretEncryptionKeys(t *testing.T, keys string) {
+	t.Helper()
+	previous := constant.SecretEncryptionKeys
+	constant.SecretEncryptionKeys = keys
+	t.Cleanup(func() {
+		constant.SecretEncryptionKeys = previous
+	})
+}
+
+func rawChannelKey(t *testing.T, id int) string {
+	t.Helper()
+	var key string
+	if err := DB.Table("channels").Where("id = ?", id).Select("key").Scan(&key).Error; err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestRotateSecrets(t *testing.T) {
+	setupTestDB(t, &Channel{}, &User{}, &Option{})
+	setSecretEncryptionKeys(t, "")
+	// 启用加密前写入的明文数据，以及早期版本加密过的公开配置
+	if err := DB.Create(&Channel{Id: 1, Name: "legacy", Key: "sk-legacy"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&Option{Key: "SMTPToken", Value: "smtp-password"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	constant.SecretEncryptionKeys = "k1:first-master-key"
+	siteKey, _ := common.EncryptSecret("site-key")
+	if err := DB.Create(&Option{Key: "TurnstileSiteKey", Value: siteKey}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RotateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Channels != 1 || report.Options != 2 {
+		t.Errorf("RotateSecrets() = %+v, want 1 channel and 2 options", report)
+	}
+	if key := rawChannelKey(t, 1); !common.IsEncryptedSecret(key) {
+		t.Errorf("stored channel key = %q, want it encrypted", key)
+	}
+	channel, err := GetChannelById(1, true)
+	if err != nil || channel.Key != "sk-legacy" || channel.KeyDigest != secretDigest("sk-legacy") {
+		t.Errorf("GetChannelById() = %q, %q, %v", channel.Key, channel.KeyDigest, err)
+	}
+	options := map[string]string{}
+	all, _ := AllOption()
+	for _, option := range all {
+		options[option.Key] = option.Value
+	}
+	if !common.IsEncryptedSecret(options["SMTPToken"]) {
+		t.Errorf("SMTPToken = %q, want it encrypted", options["SMTPToken"])
+	}
+	if options["TurnstileSiteKey"] != "site-key" {
+		t.Errorf("TurnstileSiteKey = %q, want it restored to plaintext", options["TurnstileSiteKey"])
+	}
+
+	// 更换主密钥后重新加密，旧主密钥移除后仍可解密
+	constant.SecretEncryptionKeys = "k2:second-master-key,k1:first-master-key"
+	report, err = RotateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Channels != 1 || report.Options != 1 {
+		t.Errorf("RotateSecrets() after key change = %+v, want 1 channel and 1 option", report)
+	}
+	constant.SecretEncryptionKeys = "k2:second-master-key"
+	channel, err = GetChannelById(1, true)
+	if err != nil || channel.Key != "sk-legacy" {
+		t.Errorf("GetChannelById() after rotation = %q, %v", channel.Key, err)
+	}
+}
+
+func TestSecretSerializerUnknownKey(t *testing.T) {
+	setupTestDB(t, &Channel{})
+	setSecretEncryptionKeys(t, "k1:first-master-key")
+	if err := DB.Create(&Channel{Id: 1, Name: "encrypted", Key: "sk-secret", KeyDigest: secretDigest("sk-secret")}).Error; err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := rawChannelKey(t, 1)
+
+	constant.SecretEncryptionKeys = "k2:second-master-key"
+	channel := &Channel{}
+	err := DB.First(channel, "id = ?", 1).Error
+	if err == nil {
+		t.Fatal("loading a channel encrypted with an unknown key id succeeded")
+	}
+	if channel.Key == ciphertext || channel.Key != "" {
+		t.Errorf("Key = %q, want it empty instead of the ciphertext", channel.Key)
+	}
+}
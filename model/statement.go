@@ -0,0 +1,289 @@
+package model
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const statementPeriodLayout = "2006-01"
+
+// UsageStatement 用户月度用量账单，按模型、令牌、分组及计费倍率汇总消费日志
+type UsageStatement struct {
+	Id               int              `json:"id"`
+	UserId           int              `json:"user_id" gorm:"uniqueIndex:idx_statement_user_period"`
+	Username         string           `json:"username" gorm:"type:varchar(64)"`
+	Period           string           `json:"period" gorm:"type:varchar(7);uniqueIndex:idx_statement_user_period;index"` // 账单月份，如 2025-01
+	StartTime        int64            `json:"start_time" gorm:"bigint"`
+	EndTime          int64            `json:"end_time" gorm:"bigint"`
+	Requests         int              `json:"requests"`
+	PromptTokens     int              `json:"prompt_tokens"`
+	CompletionTokens int              `json:"completion_tokens"`
+	Quota            int              `json:"quota"`
+	Items            string           `json:"-" gorm:"type:text"`
+	GeneratedTime    int64            `json:"generated_time" gorm:"bigint"`
+	ItemList         []*StatementItem `json:"items,omitempty" gorm:"-"`
+}
+
+// StatementItem 账单明细，同一模型在账单周期内计费倍率变化时分为多行
+type StatementItem struct {
+	ModelName        string  `json:"model_name"`
+	TokenName        string  `json:"token_name"`
+	Group            string  `json:"group"`
+	ModelRatio       float64 `json:"model_ratio"`
+	CompletionRatio  float64 `json:"completion_ratio"`
+	GroupRatio       float64 `json:"group_ratio"`
+	ModelPrice       float64 `json:"model_price"` // 按次计费的价格，按倍率计费时为 -1
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Quota            int     `json:"quota"`
+}
+
+// ParseStatementPeriod 解析账单月份，返回该月的起止时间戳（服务器本地时区）
+func ParseStatementPeriod(period string) (int64, int64, error) {
+	start, err := time.ParseInLocation(statementPeriodLayout, period, time.Local)
+	if err != nil {
+		return 0, 0, errors.New("账单月份格式错误，应为 YYYY-MM")
+	}
+	return start.Unix(), start.AddDate(0, 1, 0).Unix(), nil
+}
+
+func getFloat(m map[string]interface{}, key string) float64 {
+	if v, ok := m[key].(float64); ok {
+		return v
+	}
+	return 0
+}
+
+// BuildUsageStatement 根据消费日志汇总用户指定月份的账单，不写入数据库
+func BuildUsageStatement(userId int, period string) (*UsageStatement, error) {
+	startTime, endTime, err := ParseStatementPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	statement := &UsageStatement{
+		UserId:        userId,
+		Period:        period,
+		StartTime:     startTime,
+		EndTime:       endTime,
+		GeneratedTime: common.GetTimestamp(),
+	}
+	statement.Username, _ = GetUsernameById(userId, false)
+
+	items := make(map[string]*StatementItem)
+	var logs []*Log
+	err = LOG_DB.Model(&Log{}).
+		Select("id, model_name, token_name, "+logGroupCol+", quota, prompt_tokens, completion_tokens, other").
+		Where("user_id = ? and type = ? and created_at >= ? and created_at < ?", userId, LogTypeConsume, startTime, endTime).
+		FindInBatches(&logs, 1000, func(tx *gorm.DB, batch int) error {
+			for _, log := range logs {
+				other, _ := common.StrToMap(log.Other)
+				item := &StatementItem{
+					ModelName:       log.ModelName,
+					TokenName:       log.TokenName,
+					Group:           log.Group,
+					ModelRatio:      getFloat(other, "model_ratio"),
+					CompletionRatio: getFloat(other, "completion_ratio"),
+					GroupRatio:      getFloat(other, "group_ratio"),
+					ModelPrice:      getFloat(other, "model_price"),
+				}
+				key := fmt.Sprintf("%s|%s|%s|%v|%v|%v|%v", item.ModelName, item.TokenName, item.Group,
+					item.ModelRatio, item.CompletionRatio, item.GroupRatio, item.ModelPrice)
+				if existing, ok := items[key]; ok {
+					item = existing
+				} else {
+					items[key] = item
+				}
+				item.Requests++
+				item.PromptTokens += log.PromptTokens
+				item.CompletionTokens += log.CompletionTokens
+				item.Quota += log.Quota
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	statement.ItemList = make([]*StatementItem, 0, len(items))
+	for _, item := range items {
+		statement.ItemList = append(statement.ItemList, item)
+		statement.Requests += item.Requests
+		statement.PromptTokens += item.PromptTokens
+		statement.CompletionTokens += item.CompletionTokens
+		statement.Quota += item.Quota
+	}
+	sort.Slice(statement.ItemList, func(i, j int) bool {
+		a, b := statement.ItemList[i], statement.ItemList[j]
+		if a.ModelName != b.ModelName {
+			return a.ModelName < b.ModelName
+		}
+		if a.TokenName != b.TokenName {
+			return a.TokenName < b.TokenName
+		}
+		return a.Group < b.Group
+	})
+	itemsJson, err := common.Marshal(statement.ItemList)
+	if err != nil {
+		return nil, err
+	}
+	statement.Items = string(itemsJson)
+	return statement, nil
+}
+
+// GenerateUsageStatement 生成并保存用户指定月份的账单，已存在时覆盖
+func GenerateUsageStatement(userId int, period string) (*UsageStatement, error) {
+	statement, err := BuildUsageStatement(userId, period)
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "period"}},
+		DoUpdates: clause.AssignmentColumns([]string{"username", "start_time", "end_time", "requests",
+			"prompt_tokens", "completion_tokens", "quota", "items", "generated_time"}),
+	}).Create(statement).Error
+	if err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// GenerateUsageStatements 为指定月份有消费记录的用户生成账单，userId 不为 0 时只生成该用户的账单，skipExisting 为 true 时跳过已生成的账单
+func GenerateUsageStatements(period string, userId int, skipExisting bool) (int, error) {
+	startTime, endTime, err := ParseStatementPeriod(period)
+	if err != nil {
+		return 0, err
+	}
+	var userIds []int
+	if userId != 0 {
+		userIds = []int{userId}
+	} else {
+		err = LOG_DB.Model(&Log{}).Where("type = ? and created_at >= ? and created_at < ?", LogTypeConsume, startTime, endTime).
+			Distinct("user_id").Pluck("user_id", &userIds).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	existing := make(map[int]bool)
+	if skipExisting {
+		var generated []int
+		if err := DB.Model(&UsageStatement{}).Where("period = ?", period).Pluck("user_id", &generated).Error; err != nil {
+			return 0, err
+		}
+		for _, id := range generated {
+			existing[id] = true
+		}
+	}
+	count := 0
+	for _, id := range userIds {
+		if existing[id] {
+			continue
+		}
+		if _, err := GenerateUsageStatement(id, period); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (statement *UsageStatement) LoadItems() error {
+	statement.ItemList = make([]*StatementItem, 0)
+	if statement.Items == "" {
+		return nil
+	}
+	return common.UnmarshalJsonStr(statement.Items, &statement.ItemList)
+}
+
+func GetUsageStatements(userId int, period string, startIdx int, num int) (statements []*UsageStatement, total int64, err error) {
+	tx := DB.Model(&UsageStatement{}).Omit("items")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if period != "" {
+		tx = tx.Where("period = ?", period)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("period desc, id desc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, total, err
+}
+
+func GetUsageStatementById(id int) (*UsageStatement, error) {
+	statement := UsageStatement{}
+	if err := DB.First(&statement, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &statement, statement.LoadItems()
+}
+
+// GetUserUsageStatement 获取用户指定月份的账单，当月账单实时生成，历史月份未生成时生成并保存
+func GetUserUsageStatement(userId int, period string) (*UsageStatement, error) {
+	if period >= time.Now().Format(statementPeriodLayout) {
+		return BuildUsageStatement(userId, period)
+	}
+	statement := UsageStatement{}
+	err := DB.Where("user_id = ? and period = ?", userId, period).First(&statement).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return GenerateUsageStatement(userId, period)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &statement, statement.LoadItems()
+}
+
+// CSV 导出账单明细，末行为合计，金额按额度换算为美元
+func (statement *UsageStatement) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	formatAmount := func(quota int) string {
+		return strconv.FormatFloat(float64(quota)/common.QuotaPerUnit, 'f', 6, 64)
+	}
+	_ = writer.Write([]string{"period", "model", "token", "group", "model_ratio", "completion_ratio", "group_ratio",
+		"model_price", "requests", "prompt_tokens", "completion_tokens", "quota", "amount_usd"})
+	for _, item := range statement.ItemList {
+		_ = writer.Write([]string{statement.Period, item.ModelName, item.TokenName, item.Group,
+			formatFloat(item.ModelRatio), formatFloat(item.CompletionRatio), formatFloat(item.GroupRatio), formatFloat(item.ModelPrice),
+			strconv.Itoa(item.Requests), strconv.Itoa(item.PromptTokens), strconv.Itoa(item.CompletionTokens),
+			strconv.Itoa(item.Quota), formatAmount(item.Quota)})
+	}
+	_ = writer.Write([]string{statement.Period, "TOTAL", "", "", "", "", "", "",
+		strconv.Itoa(statement.Requests), strconv.Itoa(statement.PromptTokens), strconv.Itoa(statement.CompletionTokens),
+		strconv.Itoa(statement.Quota), formatAmount(statement.Quota)})
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// GenerateMonthlyStatements 每月初为上月有消费的用户生成账单
+func GenerateMonthlyStatements() {
+	generatedPeriod := ""
+	for {
+		period := time.Now().AddDate(0, 0, -time.Now().Day()).Format(statementPeriodLayout)
+		if period != generatedPeriod {
+			count, err := GenerateUsageStatements(period, 0, true)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to generate usage statements for %s: %s", period, err.Error()))
+			} else {
+				generatedPeriod = period
+				if count > 0 {
+					common.SysLog(fmt.Sprintf("generated %d usage statements for %s", count, period))
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatementPeriod(t *testing.T) {
+	tests := []struct {
+		period  string
+		start   time.Time
+		end     time.Time
+		wantErr bool
+	}{
+		{period: "2025-01", start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local), end: time.Date(2025, 2, 1, 0, 0, 0, 0, time.Local)},
+		{period: "2024-12", start: time.Date(2024, 12, 1, 0, 0, 0, 0, time.Local), end: time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+		{period: "2025-1", wantErr: true},
+		{period: "2025-13", wantErr: true},
+		{period: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			start, end, err := ParseStatementPeriod(tt.period)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStatementPeriod() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (start != tt.start.Unix() || end != tt.end.Unix()) {
+				t.Errorf("ParseStatementPeriod() = %d, %d, want %d, %d", start, end, tt.start.Unix(), tt.end.Unix())
+			}
+		})
+	}
+}
+
+func TestBuildUsageStatement(t *testing.T) {
+	setupTestDB(t, &User{}, &Log{})
+	if err := DB.Create(&User{Id: 1, Username: "alice", AffCode: "a1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	inPeriod := time.Date(2025, 3, 15, 12, 0, 0, 0, time.Local).Unix()
+	ratio := `{"model_ratio":2.5,"completion_ratio":4,"group_ratio":1,"model_price":-1}`
+	logs := []*Log{
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "gpt-4o", TokenName: "a", Group: "default", Quota: 100, PromptTokens: 10, CompletionTokens: 5, Other: ratio},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: inPeriod + 60, ModelName: "gpt-4o", TokenName: "a", Group: "default", Quota: 200, PromptTokens: 20, CompletionTokens: 10, Other: ratio},
+		// 倍率调整后单独成行
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "gpt-4o", TokenName: "a", Group: "default", Quota: 50, PromptTokens: 5, CompletionTokens: 1,
+			Other: `{"model_ratio":1.25,"completion_ratio":4,"group_ratio":1,"model_price":-1}`},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "dall-e-3", TokenName: "b", Group: "vip", Quota: 20000,
+			Other: `{"model_ratio":0,"completion_ratio":0,"group_ratio":0.8,"model_price":0.04}`},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "legacy", TokenName: "b", Group: "default", Quota: 7},
+		// 以下日志不计入账单
+		{UserId: 1, Type: LogTypeTopup, CreatedAt: inPeriod, Quota: 500000},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: time.Date(2025, 4, 1, 0, 0, 0, 0, time.Local).Unix(), ModelName: "gpt-4o", Quota: 1},
+		{UserId: 1, Type: LogTypeConsume, CreatedAt: time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local).Unix() - 1, ModelName: "gpt-4o", Quota: 1},
+		{UserId: 2, Type: LogTypeConsume, CreatedAt: inPeriod, ModelName: "gpt-4o", Quota: 1},
+	}
+	if err := LOG_DB.Create(logs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := BuildUsageStatement(1, "2025-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.Username != "alice" {
+		t.Errorf("username = %s, want alice", statement.Username)
+	}
+	if statement.Requests != 5 || statement.PromptTokens != 35 || statement.CompletionTokens != 16 || statement.Quota != 20357 {
+		t.Errorf("totals = %d requests, %d/%d tokens, %d quota, want 5, 35/16, 20357",
+			statement.Requests, statement.PromptTokens, statement.CompletionTokens, statement.Quota)
+	}
+	wantItems := []StatementItem{
+		{ModelName: "dall-e-3", TokenName: "b", Group: "vip", GroupRatio: 0.8, ModelPrice: 0.04, Requests: 1, Quota: 20000},
+		{ModelName: "gpt-4o", TokenName: "a", Group: "default", ModelRatio: 2.5, CompletionRatio: 4, GroupRatio: 1, ModelPrice: -1,
+			Requests: 2, PromptTokens: 30, CompletionTokens: 15, Quota: 300},
+		{ModelName: "gpt-4o", TokenName: "a", Group: "default", ModelRatio: 1.25, CompletionRatio: 4, GroupRatio: 1, ModelPrice: -1,
+			Requests: 1, PromptTokens: 5, CompletionTokens: 1, Quota: 50},
+		{ModelName: "legacy", TokenName: "b", Group: "default", Requests: 1, Quota: 7},
+	}
+	items := make([]StatementItem, 0, len(statement.ItemList))
+	for _, item := range statement.ItemList {
+		items = append(items, *item)
+	}
+	// 同一模型、令牌和分组的多行之间顺序不固定
+	if len(items) == len(wantItems) && items[1].ModelRatio != wantItems[1].ModelRatio {
+		items[1], items[2] = items[2], items[1]
+	}
+	if !reflect.DeepEqual(items, wantItems) {
+		t.Errorf("items = %+v, want %+v", items, wantItems)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(mustStatementCSV(t, statement))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(wantItems)+2 {
+		t.Fatalf("got %d csv rows, want %d", len(records), len(wantItems)+2)
+	}
+	total := records[len(records)-1]
+	wantTotal := []string{"2025-03", "TOTAL", "", "", "", "", "", "", "5", "35", "16", "20357", "0.040714"}
+	if !reflect.DeepEqual(total, wantTotal) {
+		t.Errorf("total row = %v, want %v", total, wantTotal)
+	}
+}
+
+func mustStatementCSV(t *testing.T, statement *UsageStatement) []byte {
+	t.Helper()
+	data, err := statement.CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,549 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SubscriptionPlanStatusEnabled  = 1
+	SubscriptionPlanStatusDisabled = 2
+)
+
+// 用户订阅状态
+const (
+	SubscriptionStatusActive  = "active"
+	SubscriptionStatusPastDue = "past_due" // Stripe 扣款失败，当前周期额度仍可用但不再续期
+	SubscriptionStatusExpired = "expired"
+)
+
+// Stripe 订阅在周期结束后等待续期通知的最长时间，超过后视为已失效
+const stripeSubscriptionGracePeriod = 3 * 24 * time.Hour
+
+// SubscriptionPlan 订阅套餐，每个周期（按月）向订阅用户发放一次额度，周期结束时未用完的额度作废
+type SubscriptionPlan struct {
+	Id            int     `json:"id"`
+	Name          string  `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description   string  `json:"description"`
+	MonthlyQuota  int     `json:"monthly_quota"`
+	Group         string  `json:"group" gorm:"type:varchar(64);default:''"` // 订阅期间用户升级到的分组，为空时不变
+	Price         float64 `json:"price"`
+	StripePriceId string  `json:"stripe_price_id" gorm:"type:varchar(128)"`
+	Status        int     `json:"status" gorm:"default:1"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime   int64   `json:"updated_time" gorm:"bigint"`
+}
+
+// UserSubscription 用户订阅，每个用户同时只有一个有效订阅，订阅额度与充值额度分开记录并优先扣除
+type UserSubscription struct {
+	Id                   int               `json:"id"`
+	UserId               int               `json:"user_id" gorm:"index"`
+	PlanId               int               `json:"plan_id" gorm:"index"`
+	Status               string            `json:"status" gorm:"type:varchar(16);index"`
+	StartTime            int64             `json:"start_time" gorm:"bigint"`
+	EndTime              int64             `json:"end_time" gorm:"bigint"` // 管理员指定的订阅截止时间，0 表示持续按月续期
+	CurrentPeriodStart   int64             `json:"current_period_start" gorm:"bigint"`
+	CurrentPeriodEnd     int64             `json:"current_period_end" gorm:"bigint"`
+	GrantedQuota         int               `json:"granted_quota"`
+	UsedQuota            int               `json:"used_quota"`
+	CancelAtPeriodEnd    bool              `json:"cancel_at_period_end"`
+	PreviousGroup        string            `json:"previous_group" gorm:"type:varchar(64);default:''"`
+	StripeSubscriptionId string            `json:"stripe_subscription_id" gorm:"type:varchar(128);index"`
+	CreatedTime          int64             `json:"created_time" gorm:"bigint"`
+	UpdatedTime          int64             `json:"updated_time" gorm:"bigint"`
+	Plan                 *SubscriptionPlan `json:"plan,omitempty" gorm:"-"`
+}
+
+func (sub *UserSubscription) RemainQuota() int {
+	if sub.Status == SubscriptionStatusExpired || sub.CurrentPeriodEnd <= common.GetTimestamp() {
+		return 0
+	}
+	if sub.UsedQuota >= sub.GrantedQuota {
+		return 0
+	}
+	return sub.GrantedQuota - sub.UsedQuota
+}
+
+func GetAllSubscriptionPlans(enabledOnly bool) (plans []*SubscriptionPlan, err error) {
+	query := DB.Order("id asc")
+	if enabledOnly {
+		query = query.Where("status = ?", SubscriptionPlanStatusEnabled)
+	}
+	err = query.Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	plan := &SubscriptionPlan{}
+	err := DB.First(plan, "id = ?", id).Error
+	return plan, err
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	plan.UpdatedTime = plan.CreatedTime
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	plan.UpdatedTime = common.GetTimestamp()
+	return DB.Model(plan).Select("name", "description", "monthly_quota", "group", "price", "stripe_price_id", "status", "updated_time").Updates(plan).Error
+}
+
+// DeleteSubscriptionPlan 删除套餐，仍有用户订阅时只能禁用
+func DeleteSubscriptionPlan(id int) error {
+	var count int64
+	err := DB.Model(&UserSubscription{}).Where("plan_id = ? and status <> ?", id, SubscriptionStatusExpired).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("仍有用户订阅该套餐，请先禁用套餐")
+	}
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+func GetAllUserSubscriptions(userId int, status string, startIdx int, num int) (subs []*UserSubscription, total int64, err error) {
+	query := DB.Model(&UserSubscription{})
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err = query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&subs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	fillSubscriptionPlans(subs)
+	return subs, total, nil
+}
+
+func fillSubscriptionPlans(subs []*UserSubscription) {
+	plans := make(map[int]*SubscriptionPlan)
+	for _, sub := range subs {
+		plan, ok := plans[sub.PlanId]
+		if !ok {
+			plan, _ = GetSubscriptionPlanById(sub.PlanId)
+			plans[sub.PlanId] = plan
+		}
+		sub.Plan = plan
+	}
+}
+
+func GetUserSubscriptionById(id int) (*UserSubscription, error) {
+	sub := &UserSubscription{}
+	err := DB.First(sub, "id = ?", id).Error
+	return sub, err
+}
+
+func getStripeSubscription(stripeSubscriptionId string) (*UserSubscription, error) {
+	sub := &UserSubscription{}
+	err := DB.Where("stripe_subscription_id = ?", stripeSubscriptionId).Order("id desc").First(sub).Error
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// GetUserSubscription 获取用户当前未失效的订阅，管理员分配的订阅在周期结束时自动续期并重新发放额度，没有订阅时返回 nil
+func GetUserSubscription(userId int) (sub *UserSubscription, err error) {
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			cached := sub
+			gopool.Go(func() {
+				if err := cacheSetUserSubscription(userId, cached); err != nil {
+					common.SysError("failed to update user subscription cache: " + err.Error())
+				}
+			})
+		}
+	}()
+	sub = &UserSubscription{}
+	err = DB.Where("user_id = ? and status <> ?", userId, SubscriptionStatusExpired).Order("id desc").Limit(1).Find(sub).Error
+	if err != nil {
+		return nil, err
+	}
+	if sub.Id == 0 {
+		return nil, nil
+	}
+	if sub.CurrentPeriodEnd <= common.GetTimestamp() {
+		if err := processSubscriptionPeriodEnd(sub); err != nil {
+			return nil, err
+		}
+		if sub.Status == SubscriptionStatusExpired {
+			return nil, nil
+		}
+	}
+	return sub, nil
+}
+
+// nextSubscriptionPeriod 返回包含 now 的按月周期，从 start 开始逐月顺延
+func nextSubscriptionPeriod(start int64, now int64) (int64, int64) {
+	periodStart := time.Unix(start, 0)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	for periodEnd.Unix() <= now {
+		periodStart = periodEnd
+		periodEnd = periodStart.AddDate(0, 1, 0)
+	}
+	return periodStart.Unix(), periodEnd.Unix()
+}
+
+// processSubscriptionPeriodEnd 处理周期已结束的订阅：管理员分配的订阅续期，到期或已取消的订阅失效，
+// Stripe 订阅由 webhook 续期，超过宽限期仍未续期时失效
+func processSubscriptionPeriodEnd(sub *UserSubscription) error {
+	now := common.GetTimestamp()
+	if sub.StripeSubscriptionId != "" {
+		if now < sub.CurrentPeriodEnd+int64(stripeSubscriptionGracePeriod.Seconds()) {
+			return nil
+		}
+		return expireUserSubscription(sub)
+	}
+	if sub.CancelAtPeriodEnd || sub.Status != SubscriptionStatusActive || (sub.EndTime != 0 && sub.CurrentPeriodEnd >= sub.EndTime) {
+		return expireUserSubscription(sub)
+	}
+	periodStart, periodEnd := nextSubscriptionPeriod(sub.CurrentPeriodEnd, now)
+	if sub.EndTime != 0 && periodEnd > sub.EndTime {
+		periodEnd = sub.EndTime
+	}
+	return renewUserSubscription(sub, periodStart, periodEnd)
+}
+
+// renewUserSubscription 开始新的订阅周期并按套餐重新发放额度，上个周期未用完的额度作废
+func renewUserSubscription(sub *UserSubscription, periodStart int64, periodEnd int64) error {
+	plan, err := GetSubscriptionPlanById(sub.PlanId)
+	if err != nil {
+		return err
+	}
+	renewed := true
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		current := UserSubscription{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, "id = ?", sub.Id).Error; err != nil {
+			return err
+		}
+		// 以原周期结束时间为条件，避免并发请求重复续期
+		if current.CurrentPeriodEnd != sub.CurrentPeriodEnd {
+			renewed = false
+			return nil
+		}
+		// 不支持行锁的数据库上同样以原周期结束时间为条件更新，只有一个请求能完成续期
+		result := tx.Model(&UserSubscription{}).Where("id = ? and current_period_end = ?", sub.Id, sub.CurrentPeriodEnd).
+			Updates(map[string]interface{}{
+				"used_quota":         current.GrantedQuota,
+				"current_period_end": periodEnd,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			renewed = false
+			return nil
+		}
+		// 周期已结束时 RemainQuota 为 0，按账本余额作废上个周期未用完的额度
+		if err := RecordQuotaLedgerTx(tx, subscriptionLedgerSource(sub, LedgerReasonSubscriptionExpire),
+			FundingLegs(LedgerAccountSubscription, sub.Id, -(current.GrantedQuota-current.UsedQuota))...); err != nil {
+			return err
+		}
+		err := tx.Model(&UserSubscription{}).Where("id = ?", sub.Id).Updates(map[string]interface{}{
+			"status":               SubscriptionStatusActive,
+			"current_period_start": periodStart,
+			"granted_quota":        plan.MonthlyQuota,
+			"used_quota":           0,
+			"updated_time":         common.GetTimestamp(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, subscriptionLedgerSource(sub, LedgerReasonSubscriptionGrant),
+			FundingLegs(LedgerAccountSubscription, sub.Id, plan.MonthlyQuota)...)
+	})
+	if err != nil {
+		return err
+	}
+	invalidateUserSubscriptionCache(sub.UserId)
+	if !renewed {
+		return DB.First(sub, "id = ?", sub.Id).Error
+	}
+	sub.Status = SubscriptionStatusActive
+	sub.CurrentPeriodStart = periodStart
+	sub.CurrentPeriodEnd = periodEnd
+	sub.GrantedQuota = plan.MonthlyQuota
+	sub.UsedQuota = 0
+	recordSubscriptionGrantLog(sub, plan)
+	return nil
+}
+
+func subscriptionLedgerSource(sub *UserSubscription, reason string) LedgerSource {
+	return LedgerSource{
+		Reason:     reason,
+		SourceType: LedgerSourceSubscription,
+		SourceId:   strconv.Itoa(sub.Id),
+		UserId:     sub.UserId,
+	}
+}
+
+func recordSubscriptionGrantLog(sub *UserSubscription, plan *SubscriptionPlan) {
+	RecordLog(sub.UserId, LogTypeTopup, fmt.Sprintf("订阅套餐 %s 发放本周期额度 %s，有效期至 %s", plan.Name,
+		common.LogQuota(sub.GrantedQuota), time.Unix(sub.CurrentPeriodEnd, 0).Format("2006-01-02 15:04:05")))
+}
+
+// CreateUserSubscription 为用户开通订阅并发放首个周期的额度，用户已有订阅时先使其失效，
+// 套餐指定了分组时将用户升级到该分组，订阅失效后恢复原分组
+func CreateUserSubscription(sub *UserSubscription) error {
+	plan, err := GetSubscriptionPlanById(sub.PlanId)
+	if err != nil {
+		return errors.New("订阅套餐不存在")
+	}
+	existing, err := GetUserSubscription(sub.UserId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if err := expireUserSubscription(existing); err != nil {
+			return err
+		}
+	}
+	user, err := GetUserById(sub.UserId, false)
+	if err != nil {
+		return err
+	}
+
+	now := common.GetTimestamp()
+	if sub.StartTime == 0 {
+		sub.StartTime = now
+	}
+	if sub.CurrentPeriodStart == 0 {
+		sub.CurrentPeriodStart = sub.StartTime
+	}
+	if sub.CurrentPeriodEnd == 0 {
+		sub.CurrentPeriodEnd = time.Unix(sub.CurrentPeriodStart, 0).AddDate(0, 1, 0).Unix()
+		if sub.EndTime != 0 && sub.CurrentPeriodEnd > sub.EndTime {
+			sub.CurrentPeriodEnd = sub.EndTime
+		}
+	}
+	if sub.Status == "" {
+		sub.Status = SubscriptionStatusActive
+	}
+	sub.GrantedQuota = plan.MonthlyQuota
+	sub.UsedQuota = 0
+	sub.PreviousGroup = user.Group
+	sub.CreatedTime = now
+	sub.UpdatedTime = now
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sub).Error; err != nil {
+			return err
+		}
+		if err := RecordQuotaLedgerTx(tx, subscriptionLedgerSource(sub, LedgerReasonSubscriptionGrant),
+			FundingLegs(LedgerAccountSubscription, sub.Id, sub.GrantedQuota)...); err != nil {
+			return err
+		}
+		if plan.Group != "" && plan.Group != user.Group {
+			return tx.Model(&User{}).Where("id = ?", sub.UserId).Update("group", plan.Group).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	invalidateUserSubscriptionCache(sub.UserId)
+	if plan.Group != "" && plan.Group != user.Group {
+		if err := updateUserGroupCache(sub.UserId, plan.Group); err != nil {
+			common.SysError("failed to update user group cache: " + err.Error())
+		}
+	}
+	sub.Plan = plan
+	RecordLog(sub.UserId, LogTypeManage, fmt.Sprintf("开通订阅套餐 %s", plan.Name))
+	recordSubscriptionGrantLog(sub, plan)
+	return nil
+}
+
+// expireUserSubscription 使订阅失效，作废剩余额度，用户仍在套餐分组时恢复订阅前的分组
+func expireUserSubscription(sub *UserSubscription) error {
+	plan, err := GetSubscriptionPlanById(sub.PlanId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	restoreGroup := plan != nil && plan.Group != "" && sub.PreviousGroup != "" && plan.Group != sub.PreviousGroup
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		current := UserSubscription{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, "id = ?", sub.Id).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&UserSubscription{}).Where("id = ? and status <> ?", sub.Id, SubscriptionStatusExpired).Updates(map[string]interface{}{
+			"status":       SubscriptionStatusExpired,
+			"updated_time": common.GetTimestamp(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			restoreGroup = false
+			return nil
+		}
+		// 失效订阅的余额视为 0，剩余额度作废
+		err := RecordQuotaLedgerTx(tx, subscriptionLedgerSource(sub, LedgerReasonSubscriptionExpire),
+			FundingLegs(LedgerAccountSubscription, sub.Id, -(current.GrantedQuota-current.UsedQuota))...)
+		if err != nil || !restoreGroup {
+			return err
+		}
+		// 管理员在订阅期间手动调整过分组时不再恢复
+		return tx.Model(&User{}).Where("id = ? and "+commonGroupCol+" = ?", sub.UserId, plan.Group).Update("group", sub.PreviousGroup).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateUserSubscriptionCache(sub.UserId)
+	sub.Status = SubscriptionStatusExpired
+	if restoreGroup {
+		if err := invalidateUserCache(sub.UserId); err != nil {
+			common.SysError("failed to invalidate user cache: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// CancelUserSubscription 取消订阅，immediately 为 false 时当前周期结束后失效
+func CancelUserSubscription(sub *UserSubscription, immediately bool) error {
+	if immediately {
+		return expireUserSubscription(sub)
+	}
+	sub.CancelAtPeriodEnd = true
+	err := DB.Model(sub).Updates(map[string]interface{}{
+		"cancel_at_period_end": true,
+		"updated_time":         common.GetTimestamp(),
+	}).Error
+	invalidateUserSubscriptionCache(sub.UserId)
+	return err
+}
+
+// SyncStripeSubscription 根据 Stripe 订阅状态创建、续期或更新本地订阅
+func SyncStripeSubscription(userId int, planId int, customerId string, stripeSubscriptionId string, status string, periodStart int64, periodEnd int64, cancelAtPeriodEnd bool) error {
+	sub, err := getStripeSubscription(stripeSubscriptionId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if sub == nil || sub.Status == SubscriptionStatusExpired {
+		if status != SubscriptionStatusActive {
+			return nil
+		}
+		if customerId != "" {
+			err = DB.Model(&User{}).Where("id = ? and (stripe_customer = '' or stripe_customer is null)", userId).
+				Update("stripe_customer", customerId).Error
+			if err != nil {
+				return err
+			}
+		}
+		return CreateUserSubscription(&UserSubscription{
+			UserId:               userId,
+			PlanId:               planId,
+			StripeSubscriptionId: stripeSubscriptionId,
+			CurrentPeriodStart:   periodStart,
+			CurrentPeriodEnd:     periodEnd,
+			CancelAtPeriodEnd:    cancelAtPeriodEnd,
+		})
+	}
+	if status == SubscriptionStatusExpired {
+		return expireUserSubscription(sub)
+	}
+	if status == SubscriptionStatusActive && periodStart > sub.CurrentPeriodStart {
+		if err := renewUserSubscription(sub, periodStart, periodEnd); err != nil {
+			return err
+		}
+	}
+	err = DB.Model(&UserSubscription{}).Where("id = ?", sub.Id).Updates(map[string]interface{}{
+		"status":               status,
+		"cancel_at_period_end": cancelAtPeriodEnd,
+		"updated_time":         common.GetTimestamp(),
+	}).Error
+	invalidateUserSubscriptionCache(sub.UserId)
+	return err
+}
+
+// ProcessExpiredSubscriptions 定期处理周期已结束的订阅，使不活跃用户的订阅也能按时续期或失效并恢复分组
+func ProcessExpiredSubscriptions() {
+	for {
+		var subs []*UserSubscription
+		err := DB.Where("status <> ? and current_period_end <= ?", SubscriptionStatusExpired, common.GetTimestamp()).Find(&subs).Error
+		if err != nil {
+			common.SysError("failed to query expired subscriptions: " + err.Error())
+		}
+		for _, sub := range subs {
+			if err := processSubscriptionPeriodEnd(sub); err != nil {
+				common.SysError(fmt.Sprintf("failed to process subscription %d: %s", sub.Id, err.Error()))
+			}
+		}
+		time.Sleep(10 * time.Minute)
+	}
+}
+
+// consumeSubscriptionQuota 在事务中从订阅 sub 扣除最多 quota，返回实际扣除的额度
+func consumeSubscriptionQuota(tx *gorm.DB, sub *UserSubscription, quota int) (int, error) {
+	if sub == nil {
+		return 0, nil
+	}
+	take := min(sub.RemainQuota(), quota)
+	if take <= 0 {
+		return 0, nil
+	}
+	result := tx.Model(&UserSubscription{}).Where("id = ? and status <> ? and current_period_start = ? and used_quota + ? <= granted_quota", sub.Id, SubscriptionStatusExpired, sub.CurrentPeriodStart, take).
+		Update("used_quota", gorm.Expr("used_quota + ?", take))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 并发扣除导致订阅额度不足，剩余部分由充值额度承担，缓存的用量已过时
+		invalidateUserSubscriptionCache(sub.UserId)
+		return 0, nil
+	}
+	return take, nil
+}
+
+// refundSubscriptionQuota 在事务中将最多 quota 退回指定订阅的 periodStart 周期，订阅已失效或已进入新周期时不退回，
+// 返回实际退回的额度
+func refundSubscriptionQuota(tx *gorm.DB, subId int, periodStart int64, quota int) (int, error) {
+	sub := &UserSubscription{}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(sub, "id = ?", subId).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	// 新周期开始时上个周期未用完的额度已作废，退款不能计入新周期
+	if sub.Status == SubscriptionStatusExpired || sub.CurrentPeriodStart != periodStart {
+		return 0, nil
+	}
+	refund := min(sub.UsedQuota, quota)
+	if refund <= 0 {
+		return 0, nil
+	}
+	result := tx.Model(&UserSubscription{}).Where("id = ? and status <> ? and current_period_start = ? and used_quota >= ?", sub.Id, SubscriptionStatusExpired, periodStart, refund).
+		Update("used_quota", gorm.Expr("used_quota - ?", refund))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, nil
+	}
+	return refund, nil
+}
+
+// GetUserSubscriptionQuota 获取用户当前订阅周期的剩余额度
+func GetUserSubscriptionQuota(userId int) (int, error) {
+	sub, err := getUserSubscriptionCache(userId)
+	if err != nil || sub == nil {
+		return 0, err
+	}
+	return sub.RemainQuota(), nil
+}
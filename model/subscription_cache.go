@@ -0,0 +1,69 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// 用户订阅缓存，Id 为 0 表示用户没有未失效的订阅
+func getUserSubscriptionCacheKey(userId int) string {
+	return fmt.Sprintf("user_subscription:%d", userId)
+}
+
+func cacheSetUserSubscription(userId int, sub *UserSubscription) error {
+	if !common.RedisEnabled {
+		return nil
+	}
+	cached := UserSubscription{UserId: userId}
+	if sub != nil {
+		cached = *sub
+		cached.Plan = nil
+	}
+	return common.RedisHSetObj(getUserSubscriptionCacheKey(userId), &cached, time.Duration(common.RedisKeyCacheSeconds())*time.Second)
+}
+
+func cacheGetUserSubscription(userId int) (*UserSubscription, error) {
+	if !common.RedisEnabled {
+		return nil, fmt.Errorf("redis is not enabled")
+	}
+	var sub UserSubscription
+	if err := common.RedisHGetObj(getUserSubscriptionCacheKey(userId), &sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func invalidateUserSubscriptionCache(userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RedisDelKey(getUserSubscriptionCacheKey(userId)); err != nil {
+		common.SysError("failed to invalidate user subscription cache: " + err.Error())
+	}
+}
+
+func cacheIncrUserSubscriptionUsedQuota(userId int, delta int) {
+	if !common.RedisEnabled {
+		return
+	}
+	gopool.Go(func() {
+		if err := common.RedisHIncrBy(getUserSubscriptionCacheKey(userId), "UsedQuota", int64(delta)); err != nil {
+			common.SysError("failed to update user subscription cache: " + err.Error())
+		}
+	})
+}
+
+// getUserSubscriptionCache 获取用户当前未失效的订阅，优先读取缓存，周期已结束或缓存不可用时从数据库获取并处理续期
+func getUserSubscriptionCache(userId int) (*UserSubscription, error) {
+	sub, err := cacheGetUserSubscription(userId)
+	if err == nil && (sub.Id == 0 || sub.CurrentPeriodEnd > common.GetTimestamp()) {
+		if sub.Id == 0 {
+			return nil, nil
+		}
+		return sub, nil
+	}
+	return GetUserSubscription(userId)
+}
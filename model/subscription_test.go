@@ -0,0 +1,114 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+)
+
+// setupSubscriptionTest 创建一个周期已结束的管理员分配订阅，已用额度为 used
+func setupSubscriptionTest(t *testing.T, used int) *UserSubscription {
+	t.Helper()
+	setupTestDB(t, &User{}, &SubscriptionPlan{}, &UserSubscription{}, &QuotaLedgerEntry{}, &Log{})
+	if err := DB.Create(&User{Id: 1, Username: "subscriber", Quota: 1000}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&SubscriptionPlan{Id: 1, Name: "monthly", MonthlyQuota: 500, Status: SubscriptionPlanStatusEnabled}).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := common.GetTimestamp()
+	sub := &UserSubscription{
+		Id:                 1,
+		UserId:             1,
+		PlanId:             1,
+		Status:             SubscriptionStatusActive,
+		StartTime:          now - 40*86400,
+		CurrentPeriodStart: now - 40*86400,
+		CurrentPeriodEnd:   now - 10*86400,
+		GrantedQuota:       500,
+		UsedQuota:          used,
+	}
+	if err := DB.Create(sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func countLedgerTransactions(t *testing.T, reason string) int64 {
+	t.Helper()
+	var count int64
+	if err := DB.Model(&QuotaLedgerEntry{}).Where("reason = ?", reason).Distinct("transaction_id").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestRenewUserSubscriptionOnce(t *testing.T) {
+	for _, used := range []int{200, 500} {
+		setupSubscriptionTest(t, used)
+		// 两个请求读到同一个已结束的周期
+		first, _ := GetUserSubscriptionById(1)
+		second, _ := GetUserSubscriptionById(1)
+		if err := processSubscriptionPeriodEnd(first); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecreasePayerQuota(1, 0, 100, LedgerSource{Reason: LedgerReasonConsume, SourceType: LedgerSourceRequest, SourceId: "req-1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := processSubscriptionPeriodEnd(second); err != nil {
+			t.Fatal(err)
+		}
+		sub, _ := GetUserSubscriptionById(1)
+		if sub.UsedQuota != 100 {
+			t.Errorf("used %d: used_quota after concurrent renewal = %d, want 100", used, sub.UsedQuota)
+		}
+		if second.CurrentPeriodEnd != first.CurrentPeriodEnd {
+			t.Errorf("used %d: stale renewal did not reload the subscription", used)
+		}
+		if got := countLedgerTransactions(t, LedgerReasonSubscriptionGrant); got != 1 {
+			t.Errorf("used %d: grant ledger transactions = %d, want 1", used, got)
+		}
+	}
+}
+
+func TestIncreasePayerQuotaAfterRenewal(t *testing.T) {
+	setupSubscriptionTest(t, 0)
+	if err := DB.Model(&UserSubscription{}).Where("id = 1").Update("current_period_end", common.GetTimestamp()+86400).Error; err != nil {
+		t.Fatal(err)
+	}
+	source := LedgerSource{Reason: LedgerReasonConsume, SourceType: LedgerSourceRequest, SourceId: "req-1"}
+	charge, err := DecreasePayerQuota(1, 0, 300, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charge.SubscriptionQuota != 300 || charge.SubscriptionPeriodStart == 0 {
+		t.Fatalf("charge = %+v, want 300 from the current period", charge)
+	}
+
+	// 同一周期内退款退回订阅
+	if err := IncreasePayerQuota(1, 0, charge.Refund(100), source); err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := GetUserSubscriptionById(1)
+	if sub.UsedQuota != 200 {
+		t.Errorf("used_quota after same-period refund = %d, want 200", sub.UsedQuota)
+	}
+
+	// 续期后上个周期的退款作废，不计入新周期
+	if err := DB.Model(&UserSubscription{}).Where("id = 1").Updates(map[string]any{
+		"current_period_start": charge.SubscriptionPeriodStart + 86400,
+		"used_quota":           50,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := IncreasePayerQuota(1, 0, charge.Refund(100), source); err != nil {
+		t.Fatal(err)
+	}
+	sub, _ = GetUserSubscriptionById(1)
+	if sub.UsedQuota != 50 {
+		t.Errorf("used_quota after refund from previous period = %d, want 50", sub.UsedQuota)
+	}
+	user, _ := GetUserById(1, false)
+	if user.Quota != 1000 {
+		t.Errorf("wallet quota = %d, want 1000", user.Quota)
+	}
+}
@@ -27,6 +27,7 @@ type Task struct {
 	TaskID     string                `json:"task_id" gorm:"type:varchar(50);index"`  // 第三方id，不一定有/ song id\ Task id
 	Platform   constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
 	UserId     int                   `json:"user_id" gorm:"index"`
+	OrgId      int                   `json:"org_id" gorm:"default:0"` // 组织令牌提交的任务，失败时退还到组织额度池
 	ChannelId  int                   `json:"channel_id" gorm:"index"`
 	Quota      int                   `json:"quota"`
 	Action     string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
@@ -39,6 +40,11 @@ type Task struct {
 	Properties Properties            `json:"properties" gorm:"type:json"`
 
 	Data json.RawMessage `json:"data" gorm:"type:json"`
+
+	// 订阅额度承担的部分、所属订阅及扣费时所处的订阅周期，失败退款时原路退回
+	SubscriptionId          int   `json:"subscription_id" gorm:"default:0"`
+	SubscriptionPeriodStart int64 `json:"subscription_period_start" gorm:"type:bigint;default:0"`
+	SubscriptionQuota       int   `json:"subscription_quota" gorm:"default:0"`
 }
 
 func (t *Task) SetData(data any) {
@@ -80,6 +86,7 @@ type SyncTaskQueryParams struct {
 func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.TaskRelayInfo) *Task {
 	t := &Task{
 		UserId:     relayInfo.UserId,
+		OrgId:      relayInfo.OrgId,
 		SubmitTime: time.Now().Unix(),
 		Status:     TaskStatusNotStart,
 		Progress:   "0%",
@@ -234,6 +241,28 @@ func (Task *Task) Insert() error {
 	return err
 }
 
+// PayerCharge 返回任务扣费的组成
+func (t *Task) PayerCharge() PayerCharge {
+	return PayerCharge{
+		SubscriptionId:          t.SubscriptionId,
+		SubscriptionPeriodStart: t.SubscriptionPeriodStart,
+		SubscriptionQuota:       t.SubscriptionQuota,
+		Quota:                   t.Quota - t.SubscriptionQuota,
+	}
+}
+
+// SavePayerCharge 记录任务扣费中订阅额度承担的部分
+func (t *Task) SavePayerCharge(charge PayerCharge) error {
+	t.SubscriptionId = charge.SubscriptionId
+	t.SubscriptionPeriodStart = charge.SubscriptionPeriodStart
+	t.SubscriptionQuota = charge.SubscriptionQuota
+	return DB.Model(&Task{}).Where("id = ?", t.ID).Updates(map[string]any{
+		"subscription_id":           charge.SubscriptionId,
+		"subscription_period_start": charge.SubscriptionPeriodStart,
+		"subscription_quota":        charge.SubscriptionQuota,
+	}).Error
+}
+
 func (Task *Task) Update() error {
 	var err error
 	err = DB.Save(Task).Error
@@ -8,6 +8,7 @@ import (
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Token struct {
@@ -26,6 +27,13 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
+	ResponseCacheTTL   int            `json:"response_cache_ttl" gorm:"default:0"`  // 响应缓存时间（秒），0 跟随分组配置，-1 不缓存
+	DailyQuotaLimit    int            `json:"daily_quota_limit" gorm:"default:0"`   // 每日额度上限，0 表示不限制
+	WeeklyQuotaLimit   int            `json:"weekly_quota_limit" gorm:"default:0"`  // 每周额度上限，0 表示不限制
+	MonthlyQuotaLimit  int            `json:"monthly_quota_limit" gorm:"default:0"` // 每月额度上限，0 表示不限制
+	RpmLimit           int            `json:"rpm_limit" gorm:"default:0"`           // 每分钟请求数上限，0 表示不限制
+	OrgId              int            `json:"org_id" gorm:"index;default:0"`        // 所属组织，组织令牌从组织额度池扣费
+	GuardrailPolicy    string         `json:"guardrail_policy" gorm:"default:''"`   // 护栏策略，为空跟随分组配置，none 不检查
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -166,9 +174,20 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 }
 
 func (token *Token) Insert() error {
-	var err error
-	err = DB.Create(token).Error
-	return err
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(token).Error; err != nil {
+			return err
+		}
+		return recordTokenAllowanceLedger(tx, token, token.RemainQuota)
+	})
+}
+
+// recordTokenAllowanceLedger 在事务中记录令牌额度的设置，delta 为令牌剩余额度的变化量
+func recordTokenAllowanceLedger(tx *gorm.DB, token *Token, delta int) error {
+	return RecordQuotaLedgerTx(tx, LedgerSource{
+		Reason: LedgerReasonTokenAllowance,
+		UserId: token.UserId,
+	}, tokenAllowanceLegs(token.Id, delta)...)
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
@@ -183,9 +202,21 @@ func (token *Token) Update() (err error) {
 			})
 		}
 	}()
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
-	return err
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var oldRemainQuota int
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&Token{}).Where("id = ?", token.Id).
+			Select("remain_quota").Find(&oldRemainQuota).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
+			"model_limits_enabled", "model_limits", "allow_ips", "group", "response_cache_ttl",
+			"daily_quota_limit", "weekly_quota_limit", "monthly_quota_limit", "rpm_limit", "guardrail_policy").Updates(token).Error
+		if err != nil {
+			return err
+		}
+		return recordTokenAllowanceLedger(tx, token, token.RemainQuota-oldRemainQuota)
+	})
 }
 
 func (token *Token) SelectUpdate() (err error) {
@@ -261,7 +292,7 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, key string, quota int) (err error) {
+func IncreaseTokenQuota(id int, key string, quota int, source LedgerSource) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -275,13 +306,19 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 	}
 	if common.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
+		RecordQuotaLedger(source, tokenAllowanceLegs(id, quota)...)
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := increaseTokenQuota(tx, id, quota); err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, source, tokenAllowanceLegs(id, quota)...)
+	})
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
+func increaseTokenQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&Token{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
 			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
 			"used_quota":    gorm.Expr("used_quota - ?", quota),
@@ -291,7 +328,7 @@ func increaseTokenQuota(id int, quota int) (err error) {
 	return err
 }
 
-func DecreaseTokenQuota(id int, key string, quota int) (err error) {
+func DecreaseTokenQuota(id int, key string, quota int, source LedgerSource) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -305,13 +342,19 @@ func DecreaseTokenQuota(id int, key string, quota int) (err error) {
 	}
 	if common.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
+		RecordQuotaLedger(source, tokenAllowanceLegs(id, -quota)...)
 		return nil
 	}
-	return decreaseTokenQuota(id, quota)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := decreaseTokenQuota(tx, id, quota); err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, source, tokenAllowanceLegs(id, -quota)...)
+	})
 }
 
-func decreaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
+func decreaseTokenQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&Token{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
 			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
 			"used_quota":    gorm.Expr("used_quota + ?", quota),
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 令牌额度预算的统计周期
+const (
+	TokenBudgetPeriodDay   = "day"
+	TokenBudgetPeriodWeek  = "week"
+	TokenBudgetPeriodMonth = "month"
+)
+
+var TokenBudgetPeriods = []string{TokenBudgetPeriodDay, TokenBudgetPeriodWeek, TokenBudgetPeriodMonth}
+
+// TokenQuotaUsage 令牌在某个统计周期内的已用额度，周期切换后自动使用新的记录
+type TokenQuotaUsage struct {
+	Id          int    `json:"id"`
+	TokenId     int    `json:"token_id" gorm:"uniqueIndex:idx_token_period"`
+	Period      string `json:"period" gorm:"type:varchar(16);uniqueIndex:idx_token_period"`
+	PeriodStart int64  `json:"period_start" gorm:"bigint;uniqueIndex:idx_token_period"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+}
+
+// GetTokenBudgetPeriodStart 返回统计周期的起始时间，按服务器本地时区计算，周从周一开始
+func GetTokenBudgetPeriodStart(period string, now time.Time) int64 {
+	year, month, day := now.Date()
+	switch period {
+	case TokenBudgetPeriodWeek:
+		offset := (int(now.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, now.Location()).Unix()
+	case TokenBudgetPeriodMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, now.Location()).Unix()
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, now.Location()).Unix()
+	}
+}
+
+// GetTokenBudgetPeriodEnd 返回统计周期的结束时间，即下一个周期的起始时间
+func GetTokenBudgetPeriodEnd(period string, now time.Time) int64 {
+	start := time.Unix(GetTokenBudgetPeriodStart(period, now), 0).In(now.Location())
+	switch period {
+	case TokenBudgetPeriodWeek:
+		return start.AddDate(0, 0, 7).Unix()
+	case TokenBudgetPeriodMonth:
+		return start.AddDate(0, 1, 0).Unix()
+	default:
+		return start.AddDate(0, 0, 1).Unix()
+	}
+}
+
+func (token *Token) GetQuotaBudgetLimit(period string) int {
+	switch period {
+	case TokenBudgetPeriodDay:
+		return token.DailyQuotaLimit
+	case TokenBudgetPeriodWeek:
+		return token.WeeklyQuotaLimit
+	case TokenBudgetPeriodMonth:
+		return token.MonthlyQuotaLimit
+	}
+	return 0
+}
+
+// HasQuotaBudget 是否设置了任一周期的额度上限
+func (token *Token) HasQuotaBudget() bool {
+	return token.DailyQuotaLimit > 0 || token.WeeklyQuotaLimit > 0 || token.MonthlyQuotaLimit > 0
+}
+
+// GetTokenQuotaUsage 获取令牌当前各统计周期的已用额度
+func GetTokenQuotaUsage(tokenId int) (map[string]int, error) {
+	return getTokenQuotaUsage(tokenId, time.Now())
+}
+
+func getTokenQuotaUsage(tokenId int, now time.Time) (map[string]int, error) {
+	query := DB.Model(&TokenQuotaUsage{}).Where("token_id = ?", tokenId)
+	conditions := DB.Where("1 = 0")
+	for _, period := range TokenBudgetPeriods {
+		conditions = conditions.Or("period = ? AND period_start = ?", period, GetTokenBudgetPeriodStart(period, now))
+	}
+	var usages []TokenQuotaUsage
+	if err := query.Where(conditions).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(TokenBudgetPeriods))
+	for _, period := range TokenBudgetPeriods {
+		result[period] = 0
+	}
+	for _, usage := range usages {
+		result[usage.Period] = usage.UsedQuota
+	}
+	return result, nil
+}
+
+// IncreaseTokenQuotaUsage 累加令牌当前各统计周期的已用额度，quota 为负数时表示退还
+func IncreaseTokenQuotaUsage(tokenId int, quota int) error {
+	return increaseTokenQuotaUsage(tokenId, quota, time.Now())
+}
+
+func increaseTokenQuotaUsage(tokenId int, quota int, now time.Time) error {
+	if quota == 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, period := range TokenBudgetPeriods {
+			usage := TokenQuotaUsage{
+				TokenId:     tokenId,
+				Period:      period,
+				PeriodStart: GetTokenBudgetPeriodStart(period, now),
+				UsedQuota:   quota,
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "token_id"}, {Name: "period"}, {Name: "period_start"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"used_quota": gorm.Expr("used_quota + ?", quota),
+				}),
+			}).Create(&usage).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CleanExpiredTokenQuotaUsage 定期清理已结束周期的统计记录
+func CleanExpiredTokenQuotaUsage() {
+	for {
+		// 当前只会用到本月及本周的记录，保留两个月足够
+		before := time.Now().AddDate(0, -2, 0).Unix()
+		result := DB.Where("period_start < ?", before).Delete(&TokenQuotaUsage{})
+		if result.Error != nil {
+			common.SysError("failed to clean token quota usage: " + result.Error.Error())
+		} else if result.RowsAffected > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired token quota usage records", result.RowsAffected))
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
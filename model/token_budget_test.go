@@ -0,0 +1,71 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBudgetPeriodBounds(t *testing.T) {
+	// 2026-03-31 是周二
+	now := time.Date(2026, 3, 31, 15, 30, 0, 0, time.Local)
+	tests := []struct {
+		period    string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{TokenBudgetPeriodDay, time.Date(2026, 3, 31, 0, 0, 0, 0, time.Local), time.Date(2026, 4, 1, 0, 0, 0, 0, time.Local)},
+		{TokenBudgetPeriodWeek, time.Date(2026, 3, 30, 0, 0, 0, 0, time.Local), time.Date(2026, 4, 6, 0, 0, 0, 0, time.Local)},
+		{TokenBudgetPeriodMonth, time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 4, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			if got := GetTokenBudgetPeriodStart(tt.period, now); got != tt.wantStart.Unix() {
+				t.Errorf("start = %v, want %v", time.Unix(got, 0), tt.wantStart)
+			}
+			if got := GetTokenBudgetPeriodEnd(tt.period, now); got != tt.wantEnd.Unix() {
+				t.Errorf("end = %v, want %v", time.Unix(got, 0), tt.wantEnd)
+			}
+		})
+	}
+	// 周日属于周一开始的那一周
+	sunday := time.Date(2026, 4, 5, 23, 0, 0, 0, time.Local)
+	if got := GetTokenBudgetPeriodStart(TokenBudgetPeriodWeek, sunday); got != time.Date(2026, 3, 30, 0, 0, 0, 0, time.Local).Unix() {
+		t.Errorf("sunday week start = %v", time.Unix(got, 0))
+	}
+}
+
+func TestTokenQuotaUsageRollover(t *testing.T) {
+	setupTestDB(t, &TokenQuotaUsage{})
+	const tokenId = 1
+	now := time.Date(2026, 3, 31, 15, 0, 0, 0, time.Local)
+	if err := increaseTokenQuotaUsage(tokenId, 100, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := increaseTokenQuotaUsage(tokenId, -30, now); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want map[string]int
+	}{
+		{"same day", now.Add(time.Hour), map[string]int{TokenBudgetPeriodDay: 70, TokenBudgetPeriodWeek: 70, TokenBudgetPeriodMonth: 70}},
+		// 新的一天、新的一月，仍在同一周
+		{"next day and month", now.Add(12 * time.Hour), map[string]int{TokenBudgetPeriodDay: 0, TokenBudgetPeriodWeek: 70, TokenBudgetPeriodMonth: 0}},
+		{"next week", now.AddDate(0, 0, 7), map[string]int{TokenBudgetPeriodDay: 0, TokenBudgetPeriodWeek: 0, TokenBudgetPeriodMonth: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage, err := getTokenQuotaUsage(tokenId, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for period, want := range tt.want {
+				if usage[period] != want {
+					t.Errorf("%s usage = %d, want %d", period, usage[period], want)
+				}
+			}
+		})
+	}
+}
@@ -87,7 +87,12 @@ func Recharge(referenceId string, customerId string) (err error) {
 			return err
 		}
 
-		return nil
+		return RecordQuotaLedgerTx(tx, LedgerSource{
+			Reason:     LedgerReasonTopUp,
+			SourceType: LedgerSourceTradeNo,
+			SourceId:   topUp.TradeNo,
+			UserId:     topUp.UserId,
+		}, FundingLegs(LedgerAccountUser, topUp.UserId, int(quota))...)
 	})
 
 	if err != nil {
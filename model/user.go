@@ -11,6 +11,7 @@ import (
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // User if you add sensitive fields, don't forget to clean them in setupLogin function.
@@ -78,10 +79,15 @@ func (user *User) GetSetting() dto.UserSetting {
 			common.SysError("failed to unmarshal setting: " + err.Error())
 		}
 	}
-	return setting
+	return decryptUserSetting(setting)
 }
 
 func (user *User) SetSetting(setting dto.UserSetting) {
+	setting, err := encryptUserSetting(setting)
+	if err != nil {
+		common.SysError("failed to encrypt setting: " + err.Error())
+		return
+	}
 	settingBytes, err := json.Marshal(setting)
 	if err != nil {
 		common.SysError("failed to marshal setting: " + err.Error())
@@ -304,6 +310,14 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 		return err
 	}
 
+	err = RecordQuotaLedgerTx(tx, LedgerSource{
+		Reason: LedgerReasonAffTransfer,
+		UserId: user.Id,
+	}, FundingLegs(LedgerAccountUser, user.Id, quota)...)
+	if err != nil {
+		return err
+	}
+
 	// 提交事务
 	return tx.Commit().Error
 }
@@ -319,17 +333,38 @@ func (user *User) Insert(inviterId int) error {
 	user.Quota = common.QuotaForNewUser
 	//user.SetAccessToken(common.GetUUID())
 	user.AffCode = common.GetRandomString(4)
-	result := DB.Create(user)
-	if result.Error != nil {
-		return result.Error
+	inviteeQuota := 0
+	if inviterId != 0 {
+		inviteeQuota = common.QuotaForInvitee
+	}
+	// 用户与赠送额度的账本在同一事务中写入
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if err := RecordQuotaLedgerTx(tx, LedgerSource{Reason: LedgerReasonRegister, UserId: user.Id},
+			FundingLegs(LedgerAccountUser, user.Id, common.QuotaForNewUser)...); err != nil {
+			return err
+		}
+		if inviteeQuota <= 0 {
+			return nil
+		}
+		if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("quota", gorm.Expr("quota + ?", inviteeQuota)).Error; err != nil {
+			return err
+		}
+		user.Quota += inviteeQuota
+		return RecordQuotaLedgerTx(tx, LedgerSource{Reason: LedgerReasonInvite, SourceId: strconv.Itoa(inviterId), UserId: user.Id},
+			FundingLegs(LedgerAccountUser, user.Id, inviteeQuota)...)
+	})
+	if err != nil {
+		return err
 	}
 	if common.QuotaForNewUser > 0 {
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(common.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
-		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true)
-			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(common.QuotaForInvitee)))
+		if inviteeQuota > 0 {
+			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(inviteeQuota)))
 		}
 		if common.QuotaForInviter > 0 {
 			//_ = IncreaseUserQuota(inviterId, common.QuotaForInviter)
@@ -379,8 +414,21 @@ func (user *User) Edit(updatePassword bool) error {
 		updates["password"] = newUser.Password
 	}
 
-	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(updates).Error; err != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, user.Id).Error; err != nil {
+			return err
+		}
+		oldQuota := user.Quota
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return RecordQuotaLedgerTx(tx, LedgerSource{
+			Reason:     LedgerReasonAdminAdjust,
+			SourceType: LedgerSourceAdmin,
+			UserId:     user.Id,
+		}, FundingLegs(LedgerAccountUser, user.Id, newUser.Quota-oldQuota)...)
+	})
+	if err != nil {
 		return err
 	}
 
@@ -395,6 +443,10 @@ func (user *User) Delete() error {
 	if err := DB.Delete(user).Error; err != nil {
 		return err
 	}
+	// 退出所有组织，组织令牌转移给组织所有者
+	if err := LeaveAllOrganizations(user.Id); err != nil {
+		common.SysError(fmt.Sprintf("failed to leave organizations for user %d: %s", user.Id, err.Error()))
+	}
 
 	// 清除缓存
 	return invalidateUserCache(user.Id)
@@ -443,6 +495,14 @@ func (user *User) FillUserByEmail() error {
 	return nil
 }
 
+func (user *User) FillUserByUsername() error {
+	if user.Username == "" {
+		return errors.New("username 为空！")
+	}
+	DB.Where(User{Username: user.Username}).First(user)
+	return nil
+}
+
 func (user *User) FillUserByGitHubId() error {
 	if user.GitHubId == "" {
 		return errors.New("GitHub id 为空！")
@@ -712,6 +772,30 @@ func decreaseUserQuota(id int, quota int) (err error) {
 	return err
 }
 
+// updateUserQuotaTx 在事务中变更用户额度，开启批量更新时跳过，由 applyUserQuotaDelta 在事务提交后加入批量更新
+func updateUserQuotaTx(tx *gorm.DB, id int, delta int) error {
+	if delta == 0 || common.BatchUpdateEnabled {
+		return nil
+	}
+	return tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", delta)).Error
+}
+
+// applyUserQuotaDelta 在 updateUserQuotaTx 的事务提交后更新缓存，开启批量更新时加入批量更新
+func applyUserQuotaDelta(id int, delta int) {
+	if delta == 0 {
+		return
+	}
+	gopool.Go(func() {
+		err := cacheIncrUserQuota(id, int64(delta))
+		if err != nil {
+			common.SysError("failed to update user quota cache: " + err.Error())
+		}
+	})
+	if common.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeUserQuota, id, delta)
+	}
+}
+
 func DeltaUpdateUserQuota(id int, delta int) (err error) {
 	if delta == 0 {
 		return nil
@@ -40,7 +40,7 @@ func (user *UserBase) GetSetting() dto.UserSetting {
 			common.SysError("failed to unmarshal setting: " + err.Error())
 		}
 	}
-	return setting
+	return decryptUserSetting(setting)
 }
 
 // getUserCacheKey returns the key for user cache
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	common2 "one-api/common"
+	constant2 "one-api/constant"
 	"one-api/relay/common"
 	"one-api/relay/constant"
 	"one-api/relay/helper"
@@ -34,6 +35,15 @@ func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Hea
 	}
 }
 
+// UpstreamRequestContext 返回上游请求使用的上下文。客户端断开时不中断上游，由重试、计费逻辑照常处理；
+// 实时桥接通过 ContextKeyUpstreamCancellable 开启，使 response.cancel 可以中断进行中的上游请求
+func UpstreamRequestContext(c *gin.Context) context.Context {
+	if common2.GetContextKeyBool(c, constant2.ContextKeyUpstreamCancellable) {
+		return c.Request.Context()
+	}
+	return context.WithoutCancel(c.Request.Context())
+}
+
 func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
 	fullRequestURL, err := a.GetRequestURL(info)
 	if err != nil {
@@ -42,7 +52,7 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if common2.DebugEnabled {
 		println("fullRequestURL:", fullRequestURL)
 	}
-	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
+	req, err := http.NewRequestWithContext(UpstreamRequestContext(c), c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("new request failed: %w", err)
 	}
@@ -50,6 +60,7 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	common.ApplyParamOverrideHeaders(req.Header, info)
 	resp, err := doRequest(c, req, info)
 	if err != nil {
 		return nil, fmt.Errorf("do request failed: %w", err)
@@ -65,7 +76,7 @@ func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBod
 	if common2.DebugEnabled {
 		println("fullRequestURL:", fullRequestURL)
 	}
-	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
+	req, err := http.NewRequestWithContext(UpstreamRequestContext(c), c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("new request failed: %w", err)
 	}
@@ -76,6 +87,7 @@ func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBod
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	common.ApplyParamOverrideHeaders(req.Header, info)
 	resp, err := doRequest(c, req, info)
 	if err != nil {
 		return nil, fmt.Errorf("do request failed: %w", err)
@@ -93,7 +105,9 @@ func DoWssRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	common.ApplyParamOverrideHeaders(targetHeader, info)
 	targetHeader.Set("Content-Type", c.Request.Header.Get("Content-Type"))
+	common2.InjectTraceHeaders(c.Request.Context(), targetHeader)
 	targetConn, _, err := websocket.DefaultDialer.Dial(fullRequestURL, targetHeader)
 	if err != nil {
 		return nil, fmt.Errorf("dial failed to %s: %w", fullRequestURL, err)
@@ -207,6 +221,8 @@ func DoRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	return doRequest(c, req, info)
 }
 func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
+	// 向上游传递 W3C traceparent
+	common2.InjectTraceHeaders(c.Request.Context(), req.Header)
 	var client *http.Client
 	var err error
 	if info.ChannelSetting.Proxy != "" {
@@ -238,7 +254,9 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	upstreamStart := time.Now()
 	resp, err := client.Do(req)
+	common2.SetContextKey(c, constant2.ContextKeyUpstreamLatency, time.Since(upstreamStart))
 
 	if err != nil {
 		return nil, err
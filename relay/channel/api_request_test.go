@@ -0,0 +1,39 @@
+package channel
+
+import (
+	"context"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpstreamRequestContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		cancellable bool
+		wantErr     bool
+	}{
+		// 客户端断开不能让上游请求失败，否则会被当作渠道错误重试并计入健康统计
+		{name: "client disconnect does not cancel upstream", cancellable: false, wantErr: false},
+		{name: "realtime bridge cancels upstream", cancellable: true, wantErr: true},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx, cancel := context.WithCancel(context.Background())
+			c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil).WithContext(ctx)
+			if tt.cancellable {
+				common.SetContextKey(c, constant.ContextKeyUpstreamCancellable, true)
+			}
+			upstreamCtx := UpstreamRequestContext(c)
+			cancel()
+			if (upstreamCtx.Err() != nil) != tt.wantErr {
+				t.Errorf("upstream context err = %v, want cancelled %v", upstreamCtx.Err(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -224,6 +224,7 @@ func checkIfChatComplete(a *Adaptor, c *gin.Context, info *relaycommon.RelayInfo
 	if err != nil {
 		return err, false
 	}
+	relaycommon.ApplyParamOverrideHeaders(req.Header, info)
 
 	resp, err := doRequest(req, info) // 调用 doRequest
 	if err != nil {
@@ -269,6 +270,7 @@ func getChatDetail(a *Adaptor, c *gin.Context, info *relaycommon.RelayInfo) (*ht
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	relaycommon.ApplyParamOverrideHeaders(req.Header, info)
 	resp, err := doRequest(req, info)
 	if err != nil {
 		return nil, fmt.Errorf("do request failed: %w", err)
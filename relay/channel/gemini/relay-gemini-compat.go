@@ -0,0 +1,539 @@
+package gemini
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeminiToOpenAIRequest 将 Gemini generateContent 请求转换为 OpenAI chat completions 请求，
+// 使非 Gemini 渠道也能通过 /v1beta/models/*:generateContent 访问
+func GeminiToOpenAIRequest(req *GeminiChatRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	openAIRequest := &dto.GeneralOpenAIRequest{
+		Model:  info.UpstreamModelName,
+		Stream: info.IsStream,
+	}
+
+	config := req.GenerationConfig
+	openAIRequest.Temperature = config.Temperature
+	openAIRequest.TopP = config.TopP
+	openAIRequest.TopK = int(config.TopK)
+	openAIRequest.MaxTokens = config.MaxOutputTokens
+	openAIRequest.Seed = float64(config.Seed)
+	if config.CandidateCount > 1 {
+		openAIRequest.N = config.CandidateCount
+	}
+	if len(config.StopSequences) > 0 {
+		openAIRequest.Stop = config.StopSequences
+	}
+	if config.ResponseMimeType == "application/json" {
+		if config.ResponseSchema != nil {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &dto.FormatJsonSchema{
+					Name:   "response",
+					Schema: config.ResponseSchema,
+				},
+			}
+		} else {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		}
+	}
+
+	for _, tool := range req.Tools {
+		if tool.FunctionDeclarations == nil {
+			continue
+		}
+		declarations, err := common.Any2Type[[]dto.FunctionRequest](tool.FunctionDeclarations)
+		if err != nil {
+			return nil, fmt.Errorf("invalid functionDeclarations: %w", err)
+		}
+		for _, declaration := range declarations {
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+				Type:     "function",
+				Function: declaration,
+			})
+		}
+	}
+	if len(openAIRequest.Tools) > 0 && req.ToolConfig != nil && req.ToolConfig.FunctionCallingConfig != nil {
+		openAIRequest.ToolChoice = geminiToolConfigToOpenAI(req.ToolConfig.FunctionCallingConfig)
+	}
+
+	if req.SystemInstructions != nil {
+		var texts []string
+		for _, part := range req.SystemInstructions.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			systemMessage := dto.Message{Role: "system"}
+			systemMessage.SetStringContent(strings.Join(texts, "\n"))
+			openAIRequest.Messages = append(openAIRequest.Messages, systemMessage)
+		}
+	}
+
+	// Gemini 的 functionCall/functionResponse 没有 id，按函数名依次配对
+	pendingCallIds := make(map[string][]string)
+	for _, content := range req.Contents {
+		var mediaContents []dto.MediaContent
+		var toolCalls []dto.ToolCallRequest
+		var toolMessages []dto.Message
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				id := "call_" + common.GetUUID()
+				pendingCallIds[part.FunctionCall.FunctionName] = append(pendingCallIds[part.FunctionCall.FunctionName], id)
+				arguments, _ := common.Marshal(part.FunctionCall.Arguments)
+				toolCalls = append(toolCalls, dto.ToolCallRequest{
+					ID:   id,
+					Type: "function",
+					Function: dto.FunctionRequest{
+						Name:      part.FunctionCall.FunctionName,
+						Arguments: string(arguments),
+					},
+				})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				id := "call_" + common.GetUUID()
+				if ids := pendingCallIds[name]; len(ids) > 0 {
+					id = ids[0]
+					pendingCallIds[name] = ids[1:]
+				}
+				response, _ := common.Marshal(part.FunctionResponse.Response)
+				toolMessage := dto.Message{
+					Role:       "tool",
+					ToolCallId: id,
+				}
+				toolMessage.SetStringContent(string(response))
+				toolMessages = append(toolMessages, toolMessage)
+			case part.Thought:
+				// 历史中的思考内容不回传给上游
+			case part.InlineData != nil:
+				mediaContents = append(mediaContents, inlineDataToMediaContent(part.InlineData))
+			case part.FileData != nil:
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type:     dto.ContentTypeImageURL,
+					ImageUrl: &dto.MessageImageUrl{Url: part.FileData.FileUri, Detail: "auto"},
+				})
+			case part.Text != "":
+				mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeText, Text: part.Text})
+			}
+		}
+
+		// 工具结果必须紧跟在对应的 tool_calls 之后
+		openAIRequest.Messages = append(openAIRequest.Messages, toolMessages...)
+
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		if len(mediaContents) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		message := dto.Message{Role: role}
+		if len(mediaContents) == 1 && mediaContents[0].Type == dto.ContentTypeText {
+			message.SetStringContent(mediaContents[0].Text)
+		} else if len(mediaContents) > 0 {
+			message.SetMediaContent(mediaContents)
+		} else {
+			message.SetStringContent("")
+		}
+		if len(toolCalls) > 0 {
+			message.SetToolCalls(toolCalls)
+		}
+		openAIRequest.Messages = append(openAIRequest.Messages, message)
+	}
+	return openAIRequest, nil
+}
+
+func inlineDataToMediaContent(data *GeminiInlineData) dto.MediaContent {
+	if strings.HasPrefix(data.MimeType, "audio/") {
+		return dto.MediaContent{
+			Type: dto.ContentTypeInputAudio,
+			InputAudio: &dto.MessageInputAudio{
+				Data:   data.Data,
+				Format: strings.TrimPrefix(data.MimeType, "audio/"),
+			},
+		}
+	}
+	if strings.HasPrefix(data.MimeType, "image/") {
+		return dto.MediaContent{
+			Type: dto.ContentTypeImageURL,
+			ImageUrl: &dto.MessageImageUrl{
+				Url:    fmt.Sprintf("data:%s;base64,%s", data.MimeType, data.Data),
+				Detail: "auto",
+			},
+		}
+	}
+	return dto.MediaContent{
+		Type: dto.ContentTypeFile,
+		File: &dto.MessageFile{
+			FileData: fmt.Sprintf("data:%s;base64,%s", data.MimeType, data.Data),
+		},
+	}
+}
+
+// geminiToolConfigToOpenAI 将 Gemini 的 functionCallingConfig 转换为 OpenAI 的 tool_choice
+func geminiToolConfigToOpenAI(config *GeminiFunctionCallingConfig) any {
+	switch strings.ToUpper(config.Mode) {
+	case "NONE":
+		return "none"
+	case "ANY":
+		if len(config.AllowedFunctionNames) == 1 {
+			return map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name": config.AllowedFunctionNames[0],
+				},
+			}
+		}
+		return "required"
+	case "AUTO":
+		return "auto"
+	}
+	return nil
+}
+
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case constant.FinishReasonLength:
+		return "MAX_TOKENS"
+	case constant.FinishReasonContentFilter:
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func geminiUsageFromOpenAI(usage *dto.Usage) GeminiUsageMetadata {
+	return GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens - usage.CompletionTokenDetails.ReasoningTokens,
+		ThoughtsTokenCount:   usage.CompletionTokenDetails.ReasoningTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+	}
+}
+
+// markdownImageRegex 匹配 Gemini 渠道转换为 markdown 的内联图片，以便还原为 inlineData
+var markdownImageRegex = regexp.MustCompile(`!\[[^\]]*\]\(data:([^;)]+);base64,([^)]+)\)`)
+
+// textToGeminiParts 将文本转换为 Gemini parts，其中的 base64 markdown 图片还原为 inlineData
+func textToGeminiParts(text string) []GeminiPart {
+	var parts []GeminiPart
+	last := 0
+	for _, match := range markdownImageRegex.FindAllStringSubmatchIndex(text, -1) {
+		if before := text[last:match[0]]; strings.TrimSpace(before) != "" {
+			parts = append(parts, GeminiPart{Text: before})
+		}
+		parts = append(parts, GeminiPart{InlineData: &GeminiInlineData{
+			MimeType: text[match[2]:match[3]],
+			Data:     text[match[4]:match[5]],
+		}})
+		last = match[1]
+	}
+	if last == 0 {
+		if text == "" {
+			return nil
+		}
+		return []GeminiPart{{Text: text}}
+	}
+	if rest := text[last:]; strings.TrimSpace(rest) != "" {
+		parts = append(parts, GeminiPart{Text: rest})
+	}
+	return parts
+}
+
+// messageContentToGeminiParts 转换 OpenAI 响应消息内容，支持字符串和包含图片的数组
+func messageContentToGeminiParts(message *dto.Message) []GeminiPart {
+	if message.IsStringContent() {
+		return textToGeminiParts(message.StringContent())
+	}
+	var parts []GeminiPart
+	for _, item := range message.ParseContent() {
+		switch item.Type {
+		case dto.ContentTypeText:
+			parts = append(parts, textToGeminiParts(item.Text)...)
+		case dto.ContentTypeImageURL:
+			image := item.GetImageMedia()
+			if image == nil {
+				continue
+			}
+			if strings.HasPrefix(image.Url, "data:") {
+				parts = append(parts, textToGeminiParts("![image]("+image.Url+")")...)
+			} else {
+				parts = append(parts, GeminiPart{FileData: &GeminiFileData{MimeType: image.MimeType, FileUri: image.Url}})
+			}
+		}
+	}
+	return parts
+}
+
+func toolCallToGeminiPart(name string, arguments string) GeminiPart {
+	var args map[string]any
+	if arguments != "" {
+		if err := common.UnmarshalJsonStr(arguments, &args); err != nil {
+			args = map[string]any{"arguments": arguments}
+		}
+	}
+	if args == nil {
+		args = map[string]any{}
+	}
+	return GeminiPart{FunctionCall: &FunctionCall{FunctionName: name, Arguments: args}}
+}
+
+// ResponseOpenAI2Gemini 将 OpenAI 非流式响应转换为 Gemini generateContent 响应
+func ResponseOpenAI2Gemini(response *dto.OpenAITextResponse, usage *dto.Usage) *GeminiChatResponse {
+	geminiResponse := &GeminiChatResponse{
+		Candidates:    make([]GeminiChatCandidate, 0, len(response.Choices)),
+		UsageMetadata: geminiUsageFromOpenAI(usage),
+	}
+	for _, choice := range response.Choices {
+		var parts []GeminiPart
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		parts = append(parts, messageContentToGeminiParts(&choice.Message)...)
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			parts = append(parts, toolCallToGeminiPart(toolCall.Function.Name, toolCall.Function.Arguments))
+		}
+		if parts == nil {
+			parts = make([]GeminiPart, 0)
+		}
+		finishReason := finishReasonOpenAI2Gemini(choice.FinishReason)
+		geminiResponse.Candidates = append(geminiResponse.Candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: &finishReason,
+			Index:        int64(choice.Index),
+		})
+	}
+	return geminiResponse
+}
+
+type geminiPendingToolCall struct {
+	name      string
+	arguments strings.Builder
+}
+
+// GeminiResponseWriter 将适配器输出的 OpenAI 格式响应（含流式）实时转换为 Gemini 格式，
+// 流式的函数调用参数会累积到结束时一次性输出
+type GeminiResponseWriter struct {
+	gin.ResponseWriter
+	c    *gin.Context
+	info *relaycommon.RelayInfo
+
+	isStream bool
+	decided  bool
+	buf      helper.SSELineBuffer
+
+	// 流式转换状态，按 choice index 记录
+	toolCalls     map[int]map[int]*geminiPendingToolCall
+	finishReasons map[int]string
+}
+
+func NewGeminiResponseWriter(c *gin.Context, info *relaycommon.RelayInfo) *GeminiResponseWriter {
+	writer := &GeminiResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		info:           info,
+		toolCalls:      make(map[int]map[int]*geminiPendingToolCall),
+		finishReasons:  make(map[int]string),
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *GeminiResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.isStream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func (w *GeminiResponseWriter) Write(data []byte) (int, error) {
+	w.decide()
+	w.buf.Write(data)
+	if w.isStream {
+		w.buf.ProcessData(w.processData)
+	}
+	return len(data), nil
+}
+
+func (w *GeminiResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 非流式响应需要在转换完成后一次性写出，此时不向下游刷新
+func (w *GeminiResponseWriter) Flush() {
+	w.decide()
+	if w.isStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *GeminiResponseWriter) processData(data string) {
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+		common.LogError(w.c, "error unmarshalling stream response for gemini conversion: "+err.Error())
+		return
+	}
+	w.handleChunk(&chunk)
+}
+
+func (w *GeminiResponseWriter) send(resp *GeminiChatResponse) {
+	jsonData, err := common.Marshal(resp)
+	if err != nil {
+		common.SysError("error marshalling gemini stream response: " + err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("data: %s\r\n\r\n", jsonData))
+	w.ResponseWriter.Flush()
+}
+
+func (w *GeminiResponseWriter) handleChunk(chunk *dto.ChatCompletionsStreamResponse) {
+	candidates := make([]GeminiChatCandidate, 0, len(chunk.Choices))
+	for _, choice := range chunk.Choices {
+		var parts []GeminiPart
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		parts = append(parts, textToGeminiParts(choice.Delta.GetContentString())...)
+		for i, toolCall := range choice.Delta.ToolCalls {
+			toolIndex := i
+			if toolCall.Index != nil {
+				toolIndex = *toolCall.Index
+			}
+			calls, ok := w.toolCalls[choice.Index]
+			if !ok {
+				calls = make(map[int]*geminiPendingToolCall)
+				w.toolCalls[choice.Index] = calls
+			}
+			call, ok := calls[toolIndex]
+			if !ok {
+				call = &geminiPendingToolCall{}
+				calls[toolIndex] = call
+			}
+			if toolCall.Function.Name != "" {
+				call.name = toolCall.Function.Name
+			}
+			call.arguments.WriteString(toolCall.Function.Arguments)
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.finishReasons[choice.Index] = *choice.FinishReason
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		candidates = append(candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{Role: "model", Parts: parts},
+			Index:   int64(choice.Index),
+		})
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	w.send(&GeminiChatResponse{
+		Candidates: candidates,
+		UsageMetadata: GeminiUsageMetadata{
+			PromptTokenCount: w.info.PromptTokens,
+		},
+	})
+}
+
+// Finish 写出包含函数调用、结束原因和用量的最后一个数据块（流式）或转换后的完整响应（非流式），并恢复原始 Writer
+func (w *GeminiResponseWriter) Finish(usage *dto.Usage) {
+	defer w.Restore()
+	if usage == nil {
+		usage = &dto.Usage{PromptTokens: w.info.PromptTokens}
+	}
+	w.decide()
+	if w.isStream {
+		w.buf.FlushData(w.processData)
+		w.send(w.finalChunk(usage))
+		return
+	}
+
+	var openAIResponse dto.OpenAITextResponse
+	if err := common.Unmarshal(w.buf.Bytes(), &openAIResponse); err != nil {
+		common.LogError(w.c, "error unmarshalling response for gemini conversion: "+err.Error())
+		w.writeBody(w.buf.Bytes())
+		return
+	}
+	body, err := common.Marshal(ResponseOpenAI2Gemini(&openAIResponse, usage))
+	if err != nil {
+		common.LogError(w.c, "error marshalling gemini response: "+err.Error())
+		w.writeBody(w.buf.Bytes())
+		return
+	}
+	w.writeBody(body)
+}
+
+func (w *GeminiResponseWriter) finalChunk(usage *dto.Usage) *GeminiChatResponse {
+	indexes := make(map[int]struct{})
+	for index := range w.finishReasons {
+		indexes[index] = struct{}{}
+	}
+	for index := range w.toolCalls {
+		indexes[index] = struct{}{}
+	}
+	if len(indexes) == 0 {
+		indexes[0] = struct{}{}
+	}
+	sortedIndexes := make([]int, 0, len(indexes))
+	for index := range indexes {
+		sortedIndexes = append(sortedIndexes, index)
+	}
+	sort.Ints(sortedIndexes)
+
+	candidates := make([]GeminiChatCandidate, 0, len(sortedIndexes))
+	for _, index := range sortedIndexes {
+		parts := make([]GeminiPart, 0)
+		calls := w.toolCalls[index]
+		toolIndexes := make([]int, 0, len(calls))
+		for toolIndex := range calls {
+			toolIndexes = append(toolIndexes, toolIndex)
+		}
+		sort.Ints(toolIndexes)
+		for _, toolIndex := range toolIndexes {
+			call := calls[toolIndex]
+			parts = append(parts, toolCallToGeminiPart(call.name, call.arguments.String()))
+		}
+		finishReason := finishReasonOpenAI2Gemini(w.finishReasons[index])
+		candidates = append(candidates, GeminiChatCandidate{
+			Content:      GeminiChatContent{Role: "model", Parts: parts},
+			FinishReason: &finishReason,
+			Index:        int64(index),
+		})
+	}
+	return &GeminiChatResponse{
+		Candidates:    candidates,
+		UsageMetadata: geminiUsageFromOpenAI(usage),
+	}
+}
+
+func (w *GeminiResponseWriter) writeBody(body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// Restore 恢复原始 Writer，转换失败时由调用方按原逻辑返回错误
+func (w *GeminiResponseWriter) Restore() {
+	w.c.Writer = w.ResponseWriter
+}
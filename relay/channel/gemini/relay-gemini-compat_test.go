@@ -0,0 +1,232 @@
+package gemini
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGeminiToOpenAIRequest(t *testing.T) {
+	body := `{
+		"systemInstruction": {"parts": [{"text": "be brief"}, {"text": "answer in english"}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "weather in paris?"}, {"inlineData": {"mimeType": "image/png", "data": "aGVsbG8="}}]},
+			{"role": "model", "parts": [{"text": "thinking", "thought": true}, {"functionCall": {"name": "get_weather", "args": {"city": "paris"}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"name": "get_weather", "response": {"temp": 20}}}, {"text": "and tomorrow?"}]}
+		],
+		"tools": [{"functionDeclarations": [{"name": "get_weather", "parameters": {"type": "object"}}]}],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY", "allowedFunctionNames": ["get_weather"]}},
+		"generationConfig": {"maxOutputTokens": 100, "stopSequences": ["END"], "responseMimeType": "application/json"}
+	}`
+	var req GeminiChatRequest
+	if err := common.UnmarshalJsonStr(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	openAIRequest, err := GeminiToOpenAIRequest(&req, &relaycommon.RelayInfo{UpstreamModelName: "gpt-4o", IsStream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if openAIRequest.Model != "gpt-4o" || !openAIRequest.Stream || openAIRequest.MaxTokens != 100 {
+		t.Errorf("model = %s, stream = %v, max_tokens = %d", openAIRequest.Model, openAIRequest.Stream, openAIRequest.MaxTokens)
+	}
+	if openAIRequest.ResponseFormat == nil || openAIRequest.ResponseFormat.Type != "json_object" {
+		t.Errorf("response_format = %+v, want json_object", openAIRequest.ResponseFormat)
+	}
+	if len(openAIRequest.Tools) != 1 || openAIRequest.Tools[0].Function.Name != "get_weather" {
+		t.Fatalf("tools = %+v", openAIRequest.Tools)
+	}
+	if choice, ok := openAIRequest.ToolChoice.(map[string]any); !ok || choice["type"] != "function" {
+		t.Errorf("tool_choice = %v, want the single allowed function", openAIRequest.ToolChoice)
+	}
+
+	messages := openAIRequest.Messages
+	roles := make([]string, 0, len(messages))
+	for _, message := range messages {
+		roles = append(roles, message.Role)
+	}
+	// 工具结果紧跟在 tool_calls 之后，同一轮的文本作为新的 user 消息
+	if strings.Join(roles, ",") != "system,user,assistant,tool,user" {
+		t.Fatalf("roles = %v", roles)
+	}
+	if messages[0].StringContent() != "be brief\nanswer in english" {
+		t.Errorf("system = %q", messages[0].StringContent())
+	}
+	userContent := messages[1].ParseContent()
+	if len(userContent) != 2 || userContent[1].Type != dto.ContentTypeImageURL || userContent[1].GetImageMedia().Url != "data:image/png;base64,aGVsbG8=" {
+		t.Errorf("user content = %+v", userContent)
+	}
+	toolCalls := messages[2].ParseToolCalls()
+	if len(toolCalls) != 1 || toolCalls[0].Function.Arguments != `{"city":"paris"}` {
+		t.Fatalf("tool calls = %+v", toolCalls)
+	}
+	// 历史中的思考内容不回传
+	if messages[2].StringContent() != "" {
+		t.Errorf("assistant content = %q, want thought dropped", messages[2].StringContent())
+	}
+	if messages[3].ToolCallId != toolCalls[0].ID || messages[3].StringContent() != `{"temp":20}` {
+		t.Errorf("tool message = %+v, want result for %s", messages[3], toolCalls[0].ID)
+	}
+	if messages[4].StringContent() != "and tomorrow?" {
+		t.Errorf("last user message = %q", messages[4].StringContent())
+	}
+}
+
+func TestGeminiToolConfigToOpenAI(t *testing.T) {
+	tests := []struct {
+		config GeminiFunctionCallingConfig
+		want   any
+	}{
+		{config: GeminiFunctionCallingConfig{Mode: "NONE"}, want: "none"},
+		{config: GeminiFunctionCallingConfig{Mode: "auto"}, want: "auto"},
+		{config: GeminiFunctionCallingConfig{Mode: "ANY"}, want: "required"},
+		{config: GeminiFunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{"a", "b"}}, want: "required"},
+		{config: GeminiFunctionCallingConfig{Mode: "MODE_UNSPECIFIED"}, want: nil},
+	}
+	for _, tt := range tests {
+		if got := geminiToolConfigToOpenAI(&tt.config); got != tt.want {
+			t.Errorf("geminiToolConfigToOpenAI(%+v) = %v, want %v", tt.config, got, tt.want)
+		}
+	}
+}
+
+func TestResponseOpenAI2Gemini(t *testing.T) {
+	body := `{
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"reasoning_content": "let me check",
+				"content": "here ![chart](data:image/png;base64,aGVsbG8=) done",
+				"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"paris\"}"}}]
+			},
+			"finish_reason": "length"
+		}]
+	}`
+	var response dto.OpenAITextResponse
+	if err := common.UnmarshalJsonStr(body, &response); err != nil {
+		t.Fatal(err)
+	}
+	usage := &dto.Usage{PromptTokens: 10, CompletionTokens: 8}
+	usage.CompletionTokenDetails.ReasoningTokens = 3
+	geminiResponse := ResponseOpenAI2Gemini(&response, usage)
+
+	if len(geminiResponse.Candidates) != 1 {
+		t.Fatalf("candidates = %+v", geminiResponse.Candidates)
+	}
+	candidate := geminiResponse.Candidates[0]
+	if candidate.FinishReason == nil || *candidate.FinishReason != "MAX_TOKENS" {
+		t.Errorf("finish reason = %v, want MAX_TOKENS", candidate.FinishReason)
+	}
+	parts := candidate.Content.Parts
+	if len(parts) != 5 {
+		t.Fatalf("parts = %+v", parts)
+	}
+	if !parts[0].Thought || parts[0].Text != "let me check" {
+		t.Errorf("thought part = %+v", parts[0])
+	}
+	if parts[1].Text != "here " || parts[2].InlineData == nil || parts[2].InlineData.MimeType != "image/png" || parts[3].Text != " done" {
+		t.Errorf("content parts = %+v", parts[1:4])
+	}
+	if parts[4].FunctionCall == nil || parts[4].FunctionCall.FunctionName != "get_weather" {
+		t.Errorf("function call part = %+v", parts[4])
+	}
+	metadata := geminiResponse.UsageMetadata
+	if metadata.PromptTokenCount != 10 || metadata.CandidatesTokenCount != 5 || metadata.ThoughtsTokenCount != 3 || metadata.TotalTokenCount != 18 {
+		t.Errorf("usage metadata = %+v", metadata)
+	}
+}
+
+func newGeminiWriterTest(contentType string) (*GeminiResponseWriter, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/v1beta/models/gpt-4o:generateContent", nil)
+	c.Header("Content-Type", contentType)
+	return NewGeminiResponseWriter(c, &relaycommon.RelayInfo{PromptTokens: 10}), recorder
+}
+
+func readGeminiStreamEvents(t *testing.T, body string) []GeminiChatResponse {
+	t.Helper()
+	var events []GeminiChatResponse
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event GeminiChatResponse
+		if err := common.UnmarshalJsonStr(strings.TrimPrefix(line, "data: "), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestGeminiResponseWriterStream(t *testing.T) {
+	writer, recorder := newGeminiWriterTest("text/event-stream")
+	chunks := []string{
+		`data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}` + "\n\n",
+		`data: {"choices":[{"index":0,"delta":{"content":"lo"}}]}` + "\n\n",
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"get_weather","arguments":"{\"ci"}}]}}]}` + "\n\n",
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"paris\"}"}}]},"finish_reason":"tool_calls"}]}` + "\n\n",
+		"data: [DONE]\n\n",
+	}
+	// 数据块可能在任意位置被拆开写入
+	stream := strings.Join(chunks, "")
+	for len(stream) > 0 {
+		n := min(7, len(stream))
+		if _, err := writer.WriteString(stream[:n]); err != nil {
+			t.Fatal(err)
+		}
+		stream = stream[n:]
+	}
+	writer.Finish(&dto.Usage{PromptTokens: 10, CompletionTokens: 4})
+
+	events := readGeminiStreamEvents(t, recorder.Body.String())
+	if len(events) != 3 {
+		t.Fatalf("got %d events: %s", len(events), recorder.Body.String())
+	}
+	if events[0].Candidates[0].Content.Parts[0].Text != "Hel" || events[1].Candidates[0].Content.Parts[0].Text != "lo" {
+		t.Errorf("text events = %+v, %+v", events[0], events[1])
+	}
+	final := events[2]
+	parts := final.Candidates[0].Content.Parts
+	if len(parts) != 1 || parts[0].FunctionCall == nil || parts[0].FunctionCall.FunctionName != "get_weather" {
+		t.Fatalf("final parts = %+v", parts)
+	}
+	if args, _ := parts[0].FunctionCall.Arguments.(map[string]any); args["city"] != "paris" {
+		t.Errorf("function call args = %v, want accumulated arguments", parts[0].FunctionCall.Arguments)
+	}
+	if final.Candidates[0].FinishReason == nil || *final.Candidates[0].FinishReason != "STOP" {
+		t.Errorf("finish reason = %v, want STOP", final.Candidates[0].FinishReason)
+	}
+	if final.UsageMetadata.TotalTokenCount != 14 {
+		t.Errorf("usage metadata = %+v", final.UsageMetadata)
+	}
+}
+
+func TestGeminiResponseWriterNonStream(t *testing.T) {
+	writer, recorder := newGeminiWriterTest("application/json")
+	if _, err := writer.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"content_filter"}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	// 非流式响应在转换完成前不能写出
+	if recorder.Body.Len() != 0 {
+		t.Fatalf("body written before Finish: %s", recorder.Body.String())
+	}
+	writer.Finish(&dto.Usage{PromptTokens: 10, CompletionTokens: 1})
+
+	var response GeminiChatResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	candidate := response.Candidates[0]
+	if candidate.Content.Parts[0].Text != "hi" || candidate.FinishReason == nil || *candidate.FinishReason != "SAFETY" {
+		t.Errorf("response = %s", recorder.Body.String())
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"io"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
+	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
 	"one-api/setting/model_setting"
@@ -53,7 +56,9 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError)
 	}
 
+	_, endSpan := common.StartSpan(c, "count_prompt_tokens")
 	promptTokens, err := getClaudePromptTokens(textRequest, relayInfo)
+	endSpan()
 	// count messages token error 计算promptTokens错误
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -109,22 +114,33 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		relayInfo.UpstreamModelName = textRequest.Model
 	}
 
-	convertedRequest, err := adaptor.ConvertClaudeRequest(c, relayInfo, textRequest)
+	// 非 Claude 原生渠道先统一转换为 OpenAI 格式，再交给适配器转换为上游格式，响应再转换回 Claude 格式
+	compatMode := !isClaudeNativeChannel(relayInfo)
+	var convertedRequest any
+	if compatMode {
+		convertedRequest, err = convertClaudeRequestViaOpenAI(c, adaptor, relayInfo, textRequest)
+	} else {
+		convertedRequest, err = adaptor.ConvertClaudeRequest(c, relayInfo, textRequest)
+	}
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 	}
 	jsonData, err := common.Marshal(convertedRequest)
-	if common.DebugEnabled {
-		println("requestBody: ", string(jsonData))
-	}
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 	}
+	jsonData, err = relaycommon.ApplyParamOverride(jsonData, relayInfo)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
+	}
+	if common.DebugEnabled {
+		println("requestBody: ", string(jsonData))
+	}
 	requestBody = bytes.NewBuffer(jsonData)
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	var httpResp *http.Response
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -140,13 +156,35 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	var claudeWriter *service.ClaudeResponseWriter
+	var guardrailWriter *service.GuardrailResponseWriter
+	if compatMode {
+		claudeWriter = service.NewClaudeResponseWriter(c, relayInfo)
+		// 输出护栏处理转换前的 OpenAI 格式响应
+		guardrailWriter = service.NewGuardrailResponseWriter(c, service.GuardrailFormatOpenAI)
+	} else {
+		guardrailWriter = service.NewGuardrailResponseWriter(c, service.GuardrailFormatClaude)
+	}
+	usage, newAPIError := doAdaptorResponse(c, adaptor, httpResp, relayInfo)
+	if guardrailWriter != nil {
+		guardrailWriter.Finish()
+	}
 	//log.Printf("usage: %v", usage)
+	if claudeWriter != nil {
+		if newAPIError != nil {
+			claudeWriter.Restore()
+		} else {
+			claudeWriter.Finish(usage.(*dto.Usage))
+		}
+	}
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
 		return newAPIError
 	}
+	if newAPIError = service.CheckFallbackRefusal(c, relayInfo.OriginModelName); newAPIError != nil {
+		return newAPIError
+	}
 	service.PostClaudeConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 
 	// Add detailed chat logging for Claude requests
@@ -154,6 +192,37 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	return nil
 }
 
+// isClaudeNativeChannel 判断渠道是否原生支持 Claude Messages 请求
+func isClaudeNativeChannel(info *relaycommon.RelayInfo) bool {
+	switch info.ApiType {
+	case constant.APITypeAnthropic, constant.APITypeAws:
+		return true
+	case constant.APITypeVertexAi:
+		return strings.HasPrefix(info.UpstreamModelName, "claude")
+	}
+	return false
+}
+
+// convertClaudeRequestViaOpenAI 将 Claude 请求转换为 OpenAI 格式，并按 chat completions 请求交给适配器处理
+func convertClaudeRequestViaOpenAI(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	openAIRequest, err := service.ClaudeToOpenAIRequest(*request, info)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsStream && info.SupportStreamOptions {
+		openAIRequest.StreamOptions = &dto.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+	// 保留携带 usage 的最后一个数据块，其中可能包含 finish_reason
+	info.ShouldIncludeUsage = true
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	adaptor.Init(info)
+	return adaptor.ConvertOpenAIRequest(c, info, openAIRequest)
+}
+
 func getClaudePromptTokens(textRequest *dto.ClaudeRequest, info *relaycommon.RelayInfo) (int, error) {
 	var promptTokens int
 	var err error
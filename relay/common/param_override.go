@@ -0,0 +1,377 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 参数覆盖规则支持的操作
+const (
+	ParamOverrideOpSet          = "set"
+	ParamOverrideOpDelete       = "delete"
+	ParamOverrideOpRename       = "rename"
+	ParamOverrideOpAppend       = "append"
+	ParamOverrideOpSetHeader    = "set_header"
+	ParamOverrideOpRemoveHeader = "remove_header"
+)
+
+// ParamOverrideCondition 规则生效条件，未设置的字段不参与判断
+type ParamOverrideCondition struct {
+	// 模型名称，以 * 结尾时按前缀匹配，原始模型名与映射后的模型名任一匹配即可
+	Models []string `json:"models,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Stream *bool    `json:"stream,omitempty"`
+}
+
+// ParamOverrideRule 单条覆盖规则，path 与 to 为以 . 分隔的 JSON 路径，数字表示数组下标，例如 messages.0.content
+type ParamOverrideRule struct {
+	Op string `json:"op"`
+	// 请求体操作的目标路径
+	Path string `json:"path,omitempty"`
+	// rename 的目标路径
+	To string `json:"to,omitempty"`
+	// 请求头名称
+	Name  string                  `json:"name,omitempty"`
+	Value interface{}             `json:"value"`
+	When  *ParamOverrideCondition `json:"when,omitempty"`
+
+	path []string
+	to   []string
+}
+
+// ParamOverride 渠道参数覆盖规则，按顺序依次应用
+type ParamOverride struct {
+	Rules []ParamOverrideRule `json:"rules"`
+}
+
+func parseParamOverridePath(path string) ([]string, error) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return segments, nil
+}
+
+func (rule *ParamOverrideRule) compile() error {
+	var err error
+	switch rule.Op {
+	case ParamOverrideOpSet, ParamOverrideOpDelete, ParamOverrideOpAppend:
+		if rule.Op == ParamOverrideOpAppend && rule.Value == nil {
+			return errors.New("append requires value")
+		}
+		rule.path, err = parseParamOverridePath(rule.Path)
+		return err
+	case ParamOverrideOpRename:
+		if rule.path, err = parseParamOverridePath(rule.Path); err != nil {
+			return err
+		}
+		rule.to, err = parseParamOverridePath(rule.To)
+		return err
+	case ParamOverrideOpSetHeader:
+		if _, ok := rule.Value.(string); !ok {
+			return errors.New("set_header requires a string value")
+		}
+		fallthrough
+	case ParamOverrideOpRemoveHeader:
+		if strings.TrimSpace(rule.Name) == "" {
+			return fmt.Errorf("%s requires name", rule.Op)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown op %q", rule.Op)
+	}
+}
+
+func (rule *ParamOverrideRule) isHeaderRule() bool {
+	return rule.Op == ParamOverrideOpSetHeader || rule.Op == ParamOverrideOpRemoveHeader
+}
+
+// 已记录过的无效配置，保存前已校验，只有旧版本遗留的配置会出现，同一错误每个渠道只记录一次
+var invalidParamOverrideLogged sync.Map
+
+// logInvalidParamOverride 记录渠道无效的参数覆盖配置，该配置会被忽略
+func logInvalidParamOverride(channelId int, err error) {
+	key := fmt.Sprintf("%d:%s", channelId, err.Error())
+	if _, logged := invalidParamOverrideLogged.LoadOrStore(key, struct{}{}); logged {
+		return
+	}
+	common.SysError(fmt.Sprintf("channel #%d: invalid param override, ignored until it is fixed: %s", channelId, err.Error()))
+}
+
+// NewParamOverride 解析渠道的 param_override 配置，格式为 {"rules": [...]}；
+// 旧版的扁平对象视为对顶层字段的 set 规则，字段名不按路径拆分
+func NewParamOverride(raw map[string]interface{}) (*ParamOverride, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	rules, ok := raw["rules"].([]interface{})
+	if !ok {
+		keys := make([]string, 0, len(raw))
+		for key := range raw {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		override := &ParamOverride{Rules: make([]ParamOverrideRule, 0, len(keys))}
+		for _, key := range keys {
+			override.Rules = append(override.Rules, ParamOverrideRule{
+				Op:    ParamOverrideOpSet,
+				Path:  key,
+				Value: raw[key],
+				path:  []string{key},
+			})
+		}
+		return override, nil
+	}
+	if len(raw) > 1 {
+		return nil, errors.New("rules cannot be combined with other fields")
+	}
+	rulesBytes, err := common.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+	override := &ParamOverride{}
+	if err := common.Unmarshal(rulesBytes, &override.Rules); err != nil {
+		return nil, err
+	}
+	for i := range override.Rules {
+		if err := override.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+	}
+	return override, nil
+}
+
+func matchParamOverrideModel(pattern string, modelName string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(modelName, prefix)
+	}
+	return pattern == modelName
+}
+
+func (cond *ParamOverrideCondition) match(info *RelayInfo) bool {
+	if cond == nil {
+		return true
+	}
+	if len(cond.Models) > 0 {
+		matched := false
+		for _, pattern := range cond.Models {
+			if matchParamOverrideModel(pattern, info.OriginModelName) || matchParamOverrideModel(pattern, info.UpstreamModelName) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(cond.Groups) > 0 && !common.StringsContains(cond.Groups, info.UsingGroup) {
+		return false
+	}
+	if cond.Stream != nil && *cond.Stream != info.IsStream {
+		return false
+	}
+	return true
+}
+
+// MatchedRules 返回对本次请求生效的规则序号（从 0 开始）
+func (o *ParamOverride) MatchedRules(info *RelayInfo) []int {
+	matched := make([]int, 0)
+	if o == nil {
+		return matched
+	}
+	for i := range o.Rules {
+		if o.Rules[i].When.match(info) {
+			matched = append(matched, i)
+		}
+	}
+	return matched
+}
+
+func getParamOverridePath(node interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			value, ok := n[segment]
+			if !ok {
+				return nil, false
+			}
+			node = value
+		case []interface{}:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(n) {
+				return nil, false
+			}
+			node = n[idx]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setParamOverridePath 设置路径上的值，缺失的中间对象会被创建，返回更新后的节点
+func setParamOverridePath(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch n := node.(type) {
+	case nil:
+		child, err := setParamOverridePath(nil, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{path[0]: child}, nil
+	case map[string]interface{}:
+		child, err := setParamOverridePath(n[path[0]], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []interface{}:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx >= len(n) {
+			return nil, fmt.Errorf("array index %q out of range", path[0])
+		}
+		child, err := setParamOverridePath(n[idx], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	default:
+		return nil, fmt.Errorf("cannot set %q on a non-object value", path[0])
+	}
+}
+
+// deleteParamOverridePath 删除路径上的值，路径不存在时不做处理，返回更新后的节点
+func deleteParamOverridePath(node interface{}, path []string) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(n, path[0])
+		} else if child, ok := n[path[0]]; ok {
+			n[path[0]] = deleteParamOverridePath(child, path[1:])
+		}
+		return n
+	case []interface{}:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx >= len(n) {
+			return n
+		}
+		if len(path) == 1 {
+			return append(n[:idx], n[idx+1:]...)
+		}
+		n[idx] = deleteParamOverridePath(n[idx], path[1:])
+		return n
+	default:
+		return node
+	}
+}
+
+func appendParamOverrideValue(target interface{}, value interface{}) (interface{}, error) {
+	switch t := target.(type) {
+	case []interface{}:
+		if values, ok := value.([]interface{}); ok {
+			return append(t, values...), nil
+		}
+		return append(t, value), nil
+	case string:
+		if s, ok := value.(string); ok {
+			return t + s, nil
+		}
+	}
+	return nil, errors.New("append target must be an array or string")
+}
+
+func (rule *ParamOverrideRule) applyBody(body interface{}) (interface{}, error) {
+	switch rule.Op {
+	case ParamOverrideOpSet:
+		return setParamOverridePath(body, rule.path, rule.Value)
+	case ParamOverrideOpDelete:
+		return deleteParamOverridePath(body, rule.path), nil
+	case ParamOverrideOpRename:
+		value, ok := getParamOverridePath(body, rule.path)
+		if !ok {
+			return body, nil
+		}
+		body = deleteParamOverridePath(body, rule.path)
+		return setParamOverridePath(body, rule.to, value)
+	case ParamOverrideOpAppend:
+		target, ok := getParamOverridePath(body, rule.path)
+		if !ok {
+			value := rule.Value
+			if _, isArray := value.([]interface{}); !isArray {
+				value = []interface{}{value}
+			}
+			return setParamOverridePath(body, rule.path, value)
+		}
+		value, err := appendParamOverrideValue(target, rule.Value)
+		if err != nil {
+			return nil, err
+		}
+		return setParamOverridePath(body, rule.path, value)
+	}
+	return body, nil
+}
+
+// ApplyParamOverride 对转换后的上游请求体应用渠道参数覆盖规则
+func ApplyParamOverride(jsonData []byte, info *RelayInfo) ([]byte, error) {
+	if info.ParamOverride == nil {
+		return jsonData, nil
+	}
+	var body interface{}
+	applied := false
+	for _, i := range info.ParamOverride.MatchedRules(info) {
+		rule := &info.ParamOverride.Rules[i]
+		if rule.isHeaderRule() {
+			continue
+		}
+		if !applied {
+			// 保留数字原样，避免大整数丢失精度
+			decoder := json.NewDecoder(bytes.NewReader(jsonData))
+			decoder.UseNumber()
+			if err := decoder.Decode(&body); err != nil {
+				return nil, err
+			}
+			if _, ok := body.(map[string]interface{}); !ok {
+				return nil, errors.New("request body is not a json object")
+			}
+			applied = true
+		}
+		var err error
+		if body, err = rule.applyBody(body); err != nil {
+			return nil, fmt.Errorf("param override rule #%d: %w", i+1, err)
+		}
+	}
+	if !applied {
+		return jsonData, nil
+	}
+	return common.Marshal(body)
+}
+
+// ApplyParamOverrideHeaders 对上游请求头应用渠道参数覆盖规则，在适配器设置请求头之后调用
+func ApplyParamOverrideHeaders(header http.Header, info *RelayInfo) {
+	if info.ParamOverride == nil {
+		return
+	}
+	for _, i := range info.ParamOverride.MatchedRules(info) {
+		rule := &info.ParamOverride.Rules[i]
+		switch rule.Op {
+		case ParamOverrideOpSetHeader:
+			header.Set(rule.Name, rule.Value.(string))
+		case ParamOverrideOpRemoveHeader:
+			header.Del(rule.Name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func mustParamOverride(t *testing.T, raw string) *ParamOverride {
+	t.Helper()
+	var config map[string]interface{}
+	if err := common.UnmarshalJsonStr(raw, &config); err != nil {
+		t.Fatal(err)
+	}
+	override, err := NewParamOverride(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return override
+}
+
+func TestNewParamOverride(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		wantRules int
+		wantErr   bool
+	}{
+		{name: "empty", raw: `{}`},
+		{name: "legacy flat object", raw: `{"temperature":0.2,"top_p":1}`, wantRules: 2},
+		{name: "rules", raw: `{"rules":[{"op":"set","path":"a.b","value":1},{"op":"remove_header","name":"X-A"}]}`, wantRules: 2},
+		{name: "rules with other fields", raw: `{"rules":[],"temperature":1}`, wantErr: true},
+		{name: "unknown op", raw: `{"rules":[{"op":"replace","path":"a"}]}`, wantErr: true},
+		{name: "empty path segment", raw: `{"rules":[{"op":"set","path":"a..b","value":1}]}`, wantErr: true},
+		{name: "rename without target", raw: `{"rules":[{"op":"rename","path":"a"}]}`, wantErr: true},
+		{name: "append without value", raw: `{"rules":[{"op":"append","path":"stop"}]}`, wantErr: true},
+		{name: "set_header with non-string value", raw: `{"rules":[{"op":"set_header","name":"X-A","value":1}]}`, wantErr: true},
+		{name: "remove_header without name", raw: `{"rules":[{"op":"remove_header"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config map[string]interface{}
+			if err := common.UnmarshalJsonStr(tt.raw, &config); err != nil {
+				t.Fatal(err)
+			}
+			override, err := NewParamOverride(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewParamOverride() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			rules := 0
+			if override != nil {
+				rules = len(override.Rules)
+			}
+			if rules != tt.wantRules {
+				t.Errorf("got %d rules, want %d", rules, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestApplyParamOverride(t *testing.T) {
+	info := &RelayInfo{OriginModelName: "gpt-4o", UpstreamModelName: "gpt-4o-2024-08-06", UsingGroup: "vip", IsStream: true}
+	tests := []struct {
+		name     string
+		override string
+		body     string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "legacy keys are not split",
+			override: `{"a.b":1}`,
+			body:     `{"model":"gpt-4o"}`,
+			want:     `{"a.b":1,"model":"gpt-4o"}`,
+		},
+		{
+			name:     "set nested path creates objects",
+			override: `{"rules":[{"op":"set","path":"extra_body.thinking.type","value":"enabled"}]}`,
+			body:     `{"model":"gpt-4o"}`,
+			want:     `{"extra_body":{"thinking":{"type":"enabled"}},"model":"gpt-4o"}`,
+		},
+		{
+			name:     "set array element",
+			override: `{"rules":[{"op":"set","path":"messages.0.role","value":"developer"}]}`,
+			body:     `{"messages":[{"role":"system","content":"hi"}]}`,
+			want:     `{"messages":[{"content":"hi","role":"developer"}]}`,
+		},
+		{
+			name:     "set array index out of range",
+			override: `{"rules":[{"op":"set","path":"messages.3.role","value":"user"}]}`,
+			body:     `{"messages":[]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "set through scalar",
+			override: `{"rules":[{"op":"set","path":"model.name","value":"x"}]}`,
+			body:     `{"model":"gpt-4o"}`,
+			wantErr:  true,
+		},
+		{
+			name:     "delete field and array element",
+			override: `{"rules":[{"op":"delete","path":"temperature"},{"op":"delete","path":"stop.0"},{"op":"delete","path":"missing.path"}]}`,
+			body:     `{"temperature":1,"stop":["a","b"]}`,
+			want:     `{"stop":["b"]}`,
+		},
+		{
+			name:     "rename",
+			override: `{"rules":[{"op":"rename","path":"max_tokens","to":"max_completion_tokens"},{"op":"rename","path":"missing","to":"other"}]}`,
+			body:     `{"max_tokens":100}`,
+			want:     `{"max_completion_tokens":100}`,
+		},
+		{
+			name:     "append to array, string and missing path",
+			override: `{"rules":[{"op":"append","path":"stop","value":["c"]},{"op":"append","path":"user","value":"-suffix"},{"op":"append","path":"tags","value":"x"}]}`,
+			body:     `{"stop":["a"],"user":"u1"}`,
+			want:     `{"stop":["a","c"],"tags":["x"],"user":"u1-suffix"}`,
+		},
+		{
+			name:     "append to number",
+			override: `{"rules":[{"op":"append","path":"n","value":1}]}`,
+			body:     `{"n":1}`,
+			wantErr:  true,
+		},
+		{
+			name:     "large integers keep precision",
+			override: `{"rules":[{"op":"set","path":"temperature","value":0}]}`,
+			body:     `{"seed":12345678901234567890,"temperature":1}`,
+			want:     `{"seed":12345678901234567890,"temperature":0}`,
+		},
+		{
+			name: "conditions",
+			override: `{"rules":[
+				{"op":"set","path":"a","value":1,"when":{"models":["gpt-4o-2024*"]}},
+				{"op":"set","path":"b","value":1,"when":{"models":["claude-*"]}},
+				{"op":"set","path":"c","value":1,"when":{"groups":["vip"],"stream":true}},
+				{"op":"set","path":"d","value":1,"when":{"groups":["default"]}},
+				{"op":"set","path":"e","value":1,"when":{"stream":false}}
+			]}`,
+			body: `{}`,
+			want: `{"a":1,"c":1}`,
+		},
+		{
+			name:     "only header rules leave body untouched",
+			override: `{"rules":[{"op":"set_header","name":"X-A","value":"1"}]}`,
+			body:     `{"b": 1,  "a": 2}`,
+			want:     `{"b": 1,  "a": 2}`,
+		},
+		{
+			name:     "body is not an object",
+			override: `{"rules":[{"op":"set","path":"a","value":1}]}`,
+			body:     `[1]`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relayInfo := *info
+			relayInfo.ParamOverride = mustParamOverride(t, tt.override)
+			got, err := ApplyParamOverride([]byte(tt.body), &relayInfo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyParamOverride() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("ApplyParamOverride() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyParamOverrideHeaders(t *testing.T) {
+	override := mustParamOverride(t, `{"rules":[
+		{"op":"set_header","name":"X-Team","value":"search"},
+		{"op":"remove_header","name":"OpenAI-Organization"},
+		{"op":"set_header","name":"X-Stream","value":"1","when":{"stream":true}}
+	]}`)
+	tests := []struct {
+		name     string
+		isStream bool
+		want     http.Header
+	}{
+		{name: "non stream", want: http.Header{"Authorization": {"Bearer k"}, "X-Team": {"search"}}},
+		{name: "stream", isStream: true, want: http.Header{"Authorization": {"Bearer k"}, "X-Team": {"search"}, "X-Stream": {"1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Authorization", "Bearer k")
+			header.Set("OpenAI-Organization", "org-1")
+			ApplyParamOverrideHeaders(header, &RelayInfo{IsStream: tt.isStream, ParamOverride: override})
+			if !reflect.DeepEqual(header, tt.want) {
+				t.Errorf("header = %v, want %v", header, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenRelayInfoInvalidParamOverride(t *testing.T) {
+	var logs bytes.Buffer
+	previousWriter := gin.DefaultErrorWriter
+	gin.DefaultErrorWriter = &logs
+	defer func() { gin.DefaultErrorWriter = previousWriter }()
+
+	gin.SetMode(gin.TestMode)
+	newContext := func(channelId int, rules string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		var config map[string]interface{}
+		if err := common.UnmarshalJsonStr(rules, &config); err != nil {
+			t.Fatal(err)
+		}
+		common.SetContextKey(c, constant.ContextKeyChannelId, channelId)
+		common.SetContextKey(c, constant.ContextKeyChannelParamOverride, config)
+		return c
+	}
+	invalid := `{"rules":[{"op":"unknown","path":"a"}]}`
+	for i := 0; i < 3; i++ {
+		if info := GenRelayInfo(newContext(901, invalid)); info.ParamOverride != nil {
+			t.Fatalf("invalid param override was applied: %+v", info.ParamOverride)
+		}
+	}
+	// 同一渠道的同一错误只记录一次，其它渠道或新的错误仍会记录
+	GenRelayInfo(newContext(902, invalid))
+	GenRelayInfo(newContext(901, `{"rules":[{"op":"set"}]}`))
+	if count := strings.Count(logs.String(), "invalid param override"); count != 3 {
+		t.Errorf("logged %d times, want 3:\n%s", count, logs.String())
+	}
+}
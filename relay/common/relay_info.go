@@ -68,6 +68,9 @@ type RelayInfo struct {
 	UsingGroup        string // 使用的分组
 	UserGroup         string // 用户所在分组
 	TokenUnlimited    bool
+	TokenQuotaBudget  bool // 令牌设置了周期额度上限，需要统计周期用量
+	OrgId             int  // 组织令牌所属组织，额度从组织额度池扣减
+	RequestId         string
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	isFirstResponse   bool
@@ -98,13 +101,22 @@ type RelayInfo struct {
 	AudioUsage           bool
 	ReasoningEffort      string
 	ChannelSetting       dto.ChannelSettings
-	ParamOverride        map[string]interface{}
+	ParamOverride        *ParamOverride
 	UserSetting          dto.UserSetting
 	UserEmail            string
 	UserQuota            int
 	RelayFormat          string
 	SendResponseCount    int
 	ChannelCreateTime    int64
+	ResponseCacheHit     bool // 命中响应缓存，按折扣计费
+	ClientDisconnected   bool // 流式响应过程中客户端已断开
+
+	// 本次请求已扣额度中订阅额度承担的部分与所属订阅、其余额度承担的部分，退款时原路退回
+	ChargedSubscriptionId          int
+	ChargedSubscriptionPeriodStart int64
+	ChargedSubscriptionQuota       int
+	ChargedQuota                   int
+
 	ThinkingContentInfo
 	*ClaudeConvertInfo
 	*RerankerInfo
@@ -215,7 +227,10 @@ func GenRelayInfoImage(c *gin.Context) *RelayInfo {
 func GenRelayInfo(c *gin.Context) *RelayInfo {
 	channelType := common.GetContextKeyInt(c, constant.ContextKeyChannelType)
 	channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId)
-	paramOverride := common.GetContextKeyStringMap(c, constant.ContextKeyChannelParamOverride)
+	paramOverride, err := NewParamOverride(common.GetContextKeyStringMap(c, constant.ContextKeyChannelParamOverride))
+	if err != nil {
+		logInvalidParamOverride(channelId, err)
+	}
 
 	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
 	tokenKey := common.GetContextKeyString(c, constant.ContextKeyTokenKey)
@@ -241,6 +256,9 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		UsingGroup:        common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
 		UserGroup:         common.GetContextKeyString(c, constant.ContextKeyUserGroup),
 		TokenUnlimited:    tokenUnlimited,
+		TokenQuotaBudget:  common.GetContextKeyBool(c, constant.ContextKeyTokenQuotaBudget),
+		OrgId:             common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId),
+		RequestId:         c.GetString(common.RequestIdKey),
 		StartTime:         startTime,
 		FirstResponseTime: startTime.Add(-time.Second),
 		OriginModelName:   common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
@@ -87,9 +87,13 @@ func EmbeddingHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 	}
+	jsonData, err = relaycommon.ApplyParamOverride(jsonData, relayInfo)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
+	}
 	requestBody := bytes.NewBuffer(jsonData)
 	statusCodeMappingStr := c.GetString("status_code_mapping")
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -105,7 +109,7 @@ func EmbeddingHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newAPIError := doAdaptorResponse(c, adaptor, httpResp, relayInfo)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
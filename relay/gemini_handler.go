@@ -5,14 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
+	"one-api/relay/channel"
 	"one-api/relay/channel/gemini"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
-	"one-api/setting"
 	"one-api/setting/model_setting"
 	"one-api/types"
 	"strings"
@@ -44,21 +47,20 @@ func checkGeminiStreamMode(c *gin.Context, relayInfo *relaycommon.RelayInfo) {
 	// }
 }
 
-func checkGeminiInputSensitive(textRequest *gemini.GeminiChatRequest) ([]string, error) {
-	var inputTexts []string
-	for _, content := range textRequest.Contents {
-		for _, part := range content.Parts {
-			if part.Text != "" {
-				inputTexts = append(inputTexts, part.Text)
+func checkGeminiInputGuardrails(c *gin.Context, textRequest *gemini.GeminiChatRequest) *types.NewAPIError {
+	var inputTexts []*string
+	for i := range textRequest.Contents {
+		parts := textRequest.Contents[i].Parts
+		for j := range parts {
+			if parts[j].Text != "" {
+				inputTexts = append(inputTexts, &parts[j].Text)
 			}
 		}
 	}
 	if len(inputTexts) == 0 {
-		return nil, nil
+		return nil
 	}
-
-	sensitiveWords, err := service.CheckSensitiveInput(inputTexts)
-	return sensitiveWords, err
+	return service.CheckPromptGuardrails(c, inputTexts...)
 }
 
 func getGeminiInputTokens(req *gemini.GeminiChatRequest, info *relaycommon.RelayInfo) int {
@@ -105,7 +107,113 @@ func trimModelThinking(modelName string) string {
 	return modelName
 }
 
+// geminiCountTokens 处理 countTokens 请求，原生 Gemini 渠道转发上游，其它渠道本地估算，均不计费
+func geminiCountTokens(c *gin.Context) *types.NewAPIError {
+	var request struct {
+		gemini.GeminiChatRequest
+		GenerateContentRequest *gemini.GeminiChatRequest `json:"generateContentRequest,omitempty"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return types.NewError(err, types.ErrorCodeInvalidRequest)
+	}
+	req := &request.GeminiChatRequest
+	if request.GenerateContentRequest != nil {
+		req = request.GenerateContentRequest
+	}
+
+	relayInfo := relaycommon.GenRelayInfoGemini(c)
+	if err := helper.ModelMappedHelper(c, relayInfo, req); err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError)
+	}
+	if isGeminiNativeChannel(relayInfo) {
+		forwarded, newAPIError := forwardGeminiCountTokens(c, relayInfo, req, request.GenerateContentRequest != nil)
+		if forwarded || newAPIError != nil {
+			return newAPIError
+		}
+	}
+	totalTokens := getGeminiInputTokens(req, relayInfo)
+	c.JSON(http.StatusOK, gin.H{
+		"totalTokens": totalTokens,
+	})
+	return nil
+}
+
+var errGeminiCountTokensUnsupported = errors.New("model does not support countTokens")
+
+// geminiCountTokensAdaptor 将适配器生成的 generateContent 地址替换为 countTokens
+type geminiCountTokensAdaptor struct {
+	channel.Adaptor
+}
+
+func (a *geminiCountTokensAdaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	requestURL, err := a.Adaptor.GetRequestURL(info)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(requestURL, ":generateContent") {
+		return "", errGeminiCountTokensUnsupported
+	}
+	return strings.TrimSuffix(requestURL, ":generateContent") + ":countTokens", nil
+}
+
+// forwardGeminiCountTokens 将 countTokens 请求转发到原生 Gemini 渠道并原样返回响应，
+// 模型不支持 countTokens（如 embedding、imagen）时返回 false，由调用方本地估算
+func forwardGeminiCountTokens(c *gin.Context, info *relaycommon.RelayInfo, req *gemini.GeminiChatRequest, wrapped bool) (bool, *types.NewAPIError) {
+	info.IsStream = false
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return false, types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType)
+	}
+	adaptor.Init(info)
+	countAdaptor := &geminiCountTokensAdaptor{Adaptor: adaptor}
+	if _, err := countAdaptor.GetRequestURL(info); err != nil {
+		if errors.Is(err, errGeminiCountTokensUnsupported) {
+			return false, nil
+		}
+		return true, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+
+	// Gemini API 只在 generateContentRequest 中接受系统指令与工具，且需要填写映射后的模型；Vertex AI 直接接受这些字段
+	var body any = req
+	if info.ApiType == constant.APITypeGemini {
+		if wrapped {
+			body = map[string]any{
+				"generateContentRequest": struct {
+					Model string `json:"model"`
+					*gemini.GeminiChatRequest
+				}{Model: "models/" + info.UpstreamModelName, GeminiChatRequest: req},
+			}
+		} else {
+			body = map[string]any{"contents": req.Contents}
+		}
+	}
+	requestBody, err := common.Marshal(body)
+	if err != nil {
+		return true, types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+	resp, err := channel.DoApiRequest(countAdaptor, c, info, bytes.NewReader(requestBody))
+	if err != nil {
+		return true, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	if resp.StatusCode != http.StatusOK {
+		newAPIError := service.RelayErrorHandler(resp, false)
+		service.ResetStatusCode(newAPIError, c.GetString("status_code_mapping"))
+		return true, newAPIError
+	}
+	defer common.CloseResponseBodyGracefully(resp)
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, types.NewError(err, types.ErrorCodeReadResponseBodyFailed)
+	}
+	common.IOCopyBytesGracefully(c, resp, responseBody)
+	return true, nil
+}
+
 func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
+	if strings.HasSuffix(c.Request.URL.Path, ":countTokens") {
+		return geminiCountTokens(c)
+	}
+
 	req, err := getAndValidateGeminiRequest(c)
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("getAndValidateGeminiRequest error: %s", err.Error()))
@@ -117,12 +225,8 @@ func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	// 检查 Gemini 流式模式
 	checkGeminiStreamMode(c, relayInfo)
 
-	if setting.ShouldCheckPromptSensitive() {
-		sensitiveWords, err := checkGeminiInputSensitive(req)
-		if err != nil {
-			common.LogWarn(c, fmt.Sprintf("user sensitive words detected: %s", strings.Join(sensitiveWords, ", ")))
-			return types.NewError(err, types.ErrorCodeSensitiveWordsDetected)
-		}
+	if newAPIError := checkGeminiInputGuardrails(c, req); newAPIError != nil {
+		return newAPIError
 	}
 
 	// model mapped 模型映射
@@ -135,7 +239,9 @@ func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		promptTokens := value.(int)
 		relayInfo.SetPromptTokens(promptTokens)
 	} else {
+		_, endSpan := common.StartSpan(c, "count_prompt_tokens")
 		promptTokens := getGeminiInputTokens(req, relayInfo)
+		endSpan()
 		c.Set("prompt_tokens", promptTokens)
 	}
 
@@ -194,16 +300,34 @@ func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	requestBody, err := json.Marshal(req)
+	// 非 Gemini 原生渠道先转换为 OpenAI 格式，再交给适配器转换为上游格式，响应再转换回 Gemini 格式
+	compatMode := !isGeminiNativeChannel(relayInfo)
+	var requestBody []byte
+	if compatMode {
+		convertedRequest, err := convertGeminiRequestViaOpenAI(c, adaptor, relayInfo, req)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		}
+		requestBody, err = common.Marshal(convertedRequest)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		}
+	} else {
+		requestBody, err = json.Marshal(req)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		}
+	}
+	requestBody, err = relaycommon.ApplyParamOverride(requestBody, relayInfo)
 	if err != nil {
-		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
 	}
 
 	if common.DebugEnabled {
 		println("Gemini request body: %s", string(requestBody))
 	}
 
-	resp, err := adaptor.DoRequest(c, relayInfo, bytes.NewReader(requestBody))
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, bytes.NewReader(requestBody))
 	if err != nil {
 		common.LogError(c, "Do gemini request failed: "+err.Error())
 		return types.NewError(err, types.ErrorCodeDoRequestFailed)
@@ -223,12 +347,65 @@ func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, openaiErr := adaptor.DoResponse(c, resp.(*http.Response), relayInfo)
+	var geminiWriter *gemini.GeminiResponseWriter
+	var guardrailWriter *service.GuardrailResponseWriter
+	if compatMode {
+		geminiWriter = gemini.NewGeminiResponseWriter(c, relayInfo)
+		// 输出护栏处理转换前的 OpenAI 格式响应
+		guardrailWriter = service.NewGuardrailResponseWriter(c, service.GuardrailFormatOpenAI)
+	} else {
+		guardrailWriter = service.NewGuardrailResponseWriter(c, service.GuardrailFormatGemini)
+	}
+	usage, openaiErr := doAdaptorResponse(c, adaptor, resp.(*http.Response), relayInfo)
+	if guardrailWriter != nil {
+		guardrailWriter.Finish()
+	}
+	if geminiWriter != nil {
+		if openaiErr != nil {
+			geminiWriter.Restore()
+		} else {
+			geminiWriter.Finish(usage.(*dto.Usage))
+		}
+	}
 	if openaiErr != nil {
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
+	if newAPIError = service.CheckFallbackRefusal(c, relayInfo.OriginModelName); newAPIError != nil {
+		return newAPIError
+	}
 
 	postConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "", req)
 	return nil
 }
+
+// isGeminiNativeChannel 判断渠道是否原生支持 Gemini generateContent 请求
+func isGeminiNativeChannel(info *relaycommon.RelayInfo) bool {
+	switch info.ApiType {
+	case constant.APITypeGemini:
+		return true
+	case constant.APITypeVertexAi:
+		return strings.HasPrefix(info.UpstreamModelName, "gemini")
+	}
+	return false
+}
+
+// convertGeminiRequestViaOpenAI 将 Gemini 请求转换为 OpenAI 格式，并按 chat completions 请求交给适配器处理
+func convertGeminiRequestViaOpenAI(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, request *gemini.GeminiChatRequest) (any, error) {
+	openAIRequest, err := gemini.GeminiToOpenAIRequest(request, info)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsStream && info.SupportStreamOptions {
+		openAIRequest.StreamOptions = &dto.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+	// 保留携带 usage 的最后一个数据块，其中可能包含 finish_reason
+	info.ShouldIncludeUsage = true
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	adaptor.Init(info)
+	return adaptor.ConvertOpenAIRequest(c, info, openAIRequest)
+}
@@ -0,0 +1,130 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/service"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGeminiCountTokens(t *testing.T) {
+	service.InitHttpClient()
+	service.InitTokenEncoders()
+	var upstreamPath, upstreamKey, upstreamBody string
+	upstreamStatus := http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamPath, upstreamKey, upstreamBody = r.URL.Path, r.Header.Get("x-goog-api-key"), string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(upstreamStatus)
+		if upstreamStatus != http.StatusOK {
+			_, _ = io.WriteString(w, `{"error":{"code":400,"message":"bad request","status":"INVALID_ARGUMENT"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"totalTokens":42,"promptTokensDetails":[{"modality":"TEXT","tokenCount":42}]}`)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name           string
+		channelType    int
+		model          string
+		modelMapping   map[string]string
+		body           string
+		upstreamStatus int
+		wantPath       string
+		wantBody       []string
+		wantResponse   string
+		wantErr        bool
+	}{
+		{
+			name:         "native channel forwards contents",
+			channelType:  constant.ChannelTypeGemini,
+			model:        "gemini-2.0-flash",
+			body:         `{"contents":[{"parts":[{"text":"hello"}]}]}`,
+			wantPath:     "/v1beta/models/gemini-2.0-flash:countTokens",
+			wantBody:     []string{`"contents":[{"parts":[{"text":"hello"}]}]`},
+			wantResponse: `"totalTokens":42,"promptTokensDetails"`,
+		},
+		{
+			name:         "native channel forwards mapped generateContentRequest",
+			channelType:  constant.ChannelTypeGemini,
+			model:        "gemini-alias",
+			modelMapping: map[string]string{"gemini-alias": "gemini-2.0-flash"},
+			body:         `{"generateContentRequest":{"model":"models/gemini-alias","contents":[{"parts":[{"text":"hello"}]}],"systemInstruction":{"parts":[{"text":"be brief"}]}}}`,
+			wantPath:     "/v1beta/models/gemini-2.0-flash:countTokens",
+			wantBody:     []string{`"model":"models/gemini-2.0-flash"`, `"systemInstruction":{"parts":[{"text":"be brief"}]}`},
+			wantResponse: `"totalTokens":42`,
+		},
+		{
+			name:           "native channel upstream error",
+			channelType:    constant.ChannelTypeGemini,
+			model:          "gemini-2.0-flash",
+			body:           `{"contents":[{"parts":[{"text":"hello"}]}]}`,
+			upstreamStatus: http.StatusBadRequest,
+			wantPath:       "/v1beta/models/gemini-2.0-flash:countTokens",
+			wantErr:        true,
+		},
+		{
+			name:         "embedding model estimates locally",
+			channelType:  constant.ChannelTypeGemini,
+			model:        "gemini-embedding-001",
+			body:         `{"contents":[{"parts":[{"text":"hello"}]}]}`,
+			wantResponse: `"totalTokens":1`,
+		},
+		{
+			name:         "openai channel estimates locally",
+			channelType:  constant.ChannelTypeOpenAI,
+			model:        "gpt-4o",
+			body:         `{"contents":[{"parts":[{"text":"hello"}]}]}`,
+			wantResponse: `"totalTokens":1`,
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamPath, upstreamKey, upstreamBody = "", "", ""
+			upstreamStatus = http.StatusOK
+			if tt.upstreamStatus != 0 {
+				upstreamStatus = tt.upstreamStatus
+			}
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/"+tt.model+":countTokens", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			common.SetContextKey(c, constant.ContextKeyChannelType, tt.channelType)
+			common.SetContextKey(c, constant.ContextKeyChannelBaseUrl, upstream.URL)
+			common.SetContextKey(c, constant.ContextKeyChannelKey, "test-key")
+			common.SetContextKey(c, constant.ContextKeyOriginalModel, tt.model)
+			if tt.modelMapping != nil {
+				mapping, _ := common.Marshal(tt.modelMapping)
+				common.SetContextKey(c, constant.ContextKeyChannelModelMapping, string(mapping))
+			}
+
+			newAPIError := GeminiHelper(c)
+			if (newAPIError != nil) != tt.wantErr {
+				t.Fatalf("GeminiHelper() error = %v, wantErr %v", newAPIError, tt.wantErr)
+			}
+			if upstreamPath != tt.wantPath {
+				t.Errorf("upstream path = %q, want %q", upstreamPath, tt.wantPath)
+			}
+			if tt.wantPath != "" && upstreamKey != "test-key" {
+				t.Errorf("upstream key = %q", upstreamKey)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(upstreamBody, want) {
+					t.Errorf("upstream body = %s, want %s", upstreamBody, want)
+				}
+			}
+			if !strings.Contains(recorder.Body.String(), tt.wantResponse) {
+				t.Errorf("response = %s, want %s", recorder.Body.String(), tt.wantResponse)
+			}
+		})
+	}
+}
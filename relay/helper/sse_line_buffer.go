@@ -0,0 +1,60 @@
+package helper
+
+import (
+	"bytes"
+	"strings"
+)
+
+// SSELineBuffer 供接管 c.Writer 的 writer 使用：流式响应按行处理，不完整的行留在缓冲区等待后续数据；
+// 非流式响应直接作为 bytes.Buffer 缓存完整的响应体
+type SSELineBuffer struct {
+	bytes.Buffer
+}
+
+// ProcessLines 依次处理缓冲区中完整的行，传入的行已去除行尾换行符
+func (b *SSELineBuffer) ProcessLines(handleLine func(line string)) {
+	for {
+		line, err := b.ReadString('\n')
+		if err != nil {
+			// 不完整的行放回缓冲区等待后续数据
+			rest := []byte(line)
+			b.Reset()
+			b.Write(rest)
+			return
+		}
+		handleLine(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// FlushLines 响应结束时处理剩余的行，包括最后一个没有换行符的行
+func (b *SSELineBuffer) FlushLines(handleLine func(line string)) {
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.ProcessLines(handleLine)
+}
+
+// ProcessData 依次处理缓冲区中完整的 data 行，跳过其他行、空数据和 [DONE]
+func (b *SSELineBuffer) ProcessData(handleData func(data string)) {
+	b.ProcessLines(func(line string) {
+		if data, ok := SSEData(line); ok && data != "" && data != "[DONE]" {
+			handleData(data)
+		}
+	})
+}
+
+// FlushData 响应结束时处理剩余的 data 行
+func (b *SSELineBuffer) FlushData(handleData func(data string)) {
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.ProcessData(handleData)
+}
+
+// SSEData 返回 data 行去除前缀和空白后的内容，不是 data 行时返回 false
+func SSEData(line string) (string, bool) {
+	if !strings.HasPrefix(line, "data:") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "data:")), true
+}
@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSSELineBuffer(t *testing.T) {
+	var buf SSELineBuffer
+	var lines []string
+	handleLine := func(line string) {
+		lines = append(lines, line)
+	}
+	// 行可能被拆分到多次写入中
+	for _, chunk := range []string{"event: a\r\nda", "ta: {\"x\":1}\n", "\n: ping\ndata: [DO", "NE]\ndata: tail"} {
+		buf.WriteString(chunk)
+		buf.ProcessLines(handleLine)
+	}
+	want := []string{"event: a", `data: {"x":1}`, "", ": ping", "data: [DONE]"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+	if buf.String() != "data: tail" {
+		t.Fatalf("pending = %q, want the incomplete line", buf.String())
+	}
+	buf.FlushLines(handleLine)
+	if lines[len(lines)-1] != "data: tail" || buf.Len() != 0 {
+		t.Fatalf("FlushLines() lines = %q, pending = %q", lines, buf.String())
+	}
+}
+
+func TestSSELineBufferProcessData(t *testing.T) {
+	var buf SSELineBuffer
+	var data []string
+	handleData := func(d string) {
+		data = append(data, d)
+	}
+	buf.WriteString("event: message\ndata: {\"a\":1}\n\ndata:\n: ping\ndata:{\"b\":2}\ndata: [DONE]\ndata: {\"c\":3}")
+	buf.ProcessData(handleData)
+	buf.FlushData(handleData)
+	want := []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}
+	if !reflect.DeepEqual(data, want) {
+		t.Fatalf("data = %q, want %q", data, want)
+	}
+}
+
+func TestSSEData(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   string
+		wantOk bool
+	}{
+		{line: "data: {}", want: "{}", wantOk: true},
+		{line: "data:{}", want: "{}", wantOk: true},
+		{line: "data:", want: "", wantOk: true},
+		{line: "event: data", wantOk: false},
+		{line: ": data", wantOk: false},
+	}
+	for _, tt := range tests {
+		if got, ok := SSEData(tt.line); got != tt.want || ok != tt.wantOk {
+			t.Errorf("SSEData(%q) = %q, %v, want %q, %v", tt.line, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package helper
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// StreamDisconnectModeCancel 客户端断开后立即中断上游，按已生成的内容计费
+	StreamDisconnectModeCancel = "cancel"
+	// StreamDisconnectModeDrain 客户端断开后继续读取上游，剩余内容缓存起来供客户端续传
+	StreamDisconnectModeDrain = "drain"
+)
+
+const streamResumeWriterKey = "stream_resume_writer"
+
+var (
+	ErrStreamResumeNotFound  = errors.New("stream not found or expired")
+	ErrStreamResumeTruncated = errors.New("stream resume buffer exceeded the size limit, the rest of the response was discarded")
+)
+
+var (
+	streamResumeBuffers     = make(map[string]*streamResumeBuffer)
+	streamResumeBuffersLock sync.Mutex
+	streamResumeCleanerOnce sync.Once
+)
+
+// streamResumeStore 保存客户端断开后写入的响应内容。未启用 Redis 时保存在当前节点内存中，
+// 续传请求需由同一节点处理；启用 Redis 时保存在 Redis 中，多节点部署下任意节点都能续传
+type streamResumeStore interface {
+	write(data []byte)
+	finish()
+	// read 返回 offset 之后的数据、是否结束、是否被截断，以及等待下一次写入的通道
+	read(offset int) ([]byte, bool, bool, <-chan struct{})
+}
+
+// newStreamResumeStore 为客户端已断开的请求创建续传存储
+func newStreamResumeStore(requestId string, userId int, contentType string) streamResumeStore {
+	if common.RedisEnabled {
+		return newRedisStreamResumeBuffer(requestId, userId, contentType)
+	}
+	buffer := &streamResumeBuffer{
+		userId:      userId,
+		contentType: contentType,
+		updated:     make(chan struct{}),
+		expireAt:    time.Now().Add(time.Duration(constant.StreamResumeTTL) * time.Second),
+	}
+	streamResumeBuffersLock.Lock()
+	streamResumeBuffers[requestId] = buffer
+	streamResumeBuffersLock.Unlock()
+	streamResumeCleanerOnce.Do(func() {
+		go cleanStreamResumeBuffers()
+	})
+	return buffer
+}
+
+// getStreamResumeStore 按请求 ID 查找续传存储，返回存储、所属用户和响应类型
+func getStreamResumeStore(requestId string) (streamResumeStore, int, string, bool) {
+	if common.RedisEnabled {
+		buffer, _, ok := getRedisStreamResumeBuffer(requestId)
+		if !ok {
+			return nil, 0, "", false
+		}
+		return buffer, buffer.userId, buffer.contentType, true
+	}
+	streamResumeBuffersLock.Lock()
+	buffer, ok := streamResumeBuffers[requestId]
+	streamResumeBuffersLock.Unlock()
+	if !ok {
+		return nil, 0, "", false
+	}
+	return buffer, buffer.userId, buffer.contentType, true
+}
+
+func deleteStreamResumeStore(requestId string) {
+	if common.RedisEnabled {
+		deleteRedisStreamResumeBuffer(requestId)
+		return
+	}
+	deleteStreamResumeBuffer(requestId)
+}
+
+// streamResumeBuffer 保存在当前节点内存中的续传内容
+type streamResumeBuffer struct {
+	mu          sync.Mutex
+	userId      int
+	contentType string
+	data        []byte
+	done        bool
+	truncated   bool
+	updated     chan struct{}
+	expireAt    time.Time
+}
+
+func (b *streamResumeBuffer) write(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// 超出上限后丢弃后续所有写入，避免续传内容中间缺失
+	if b.truncated {
+		return
+	}
+	if len(b.data)+len(data) > MaxScannerBufferSize {
+		b.truncated = true
+	} else {
+		b.data = append(b.data, data...)
+	}
+	b.expireAt = time.Now().Add(time.Duration(constant.StreamResumeTTL) * time.Second)
+	b.notify()
+}
+
+func (b *streamResumeBuffer) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.expireAt = time.Now().Add(time.Duration(constant.StreamResumeTTL) * time.Second)
+	b.notify()
+}
+
+// notify 唤醒等待新数据的续传请求，调用方需持有锁
+func (b *streamResumeBuffer) notify() {
+	close(b.updated)
+	b.updated = make(chan struct{})
+}
+
+func (b *streamResumeBuffer) read(offset int) ([]byte, bool, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var data []byte
+	if offset < len(b.data) {
+		data = b.data[offset:]
+	}
+	return data, b.done, b.truncated, b.updated
+}
+
+// StreamResumeWriter 客户端断开前原样写出响应，断开后将后续写入转存到续传缓冲区。
+// 需在其它响应包装（格式转换、缓存等）之前安装，续传内容与客户端原本收到的格式一致
+type StreamResumeWriter struct {
+	gin.ResponseWriter
+	c      *gin.Context
+	mu     sync.Mutex
+	buffer streamResumeStore
+}
+
+// NewStreamResumeWriter 断开处理模式为 drain 时接管 c.Writer，否则返回 nil
+func NewStreamResumeWriter(c *gin.Context) *StreamResumeWriter {
+	if constant.StreamDisconnectMode != StreamDisconnectModeDrain || c.GetString(common.RequestIdKey) == "" {
+		return nil
+	}
+	writer := &StreamResumeWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+	}
+	c.Writer = writer
+	c.Set(streamResumeWriterKey, writer)
+	return writer
+}
+
+// IsStreamDrainEnabled 判断当前请求在客户端断开后是否需要继续读取上游
+func IsStreamDrainEnabled(c *gin.Context) bool {
+	_, ok := c.Get(streamResumeWriterKey)
+	return ok
+}
+
+// detached 检查客户端是否已断开，首次发现断开时创建续传缓冲区，调用方需持有锁
+func (w *StreamResumeWriter) detached() bool {
+	if w.buffer != nil {
+		return true
+	}
+	if w.c.Request.Context().Err() == nil {
+		return false
+	}
+	w.buffer = newStreamResumeStore(w.c.GetString(common.RequestIdKey), w.c.GetInt("id"), w.ResponseWriter.Header().Get("Content-Type"))
+	common.LogInfo(w.c, "client disconnected, buffering remaining response for resume")
+	return true
+}
+
+func (w *StreamResumeWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.detached() {
+		w.buffer.write(data)
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *StreamResumeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *StreamResumeWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.detached() {
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Finish 请求处理结束时调用，标记续传内容已完整
+func (w *StreamResumeWriter) Finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buffer != nil {
+		w.buffer.finish()
+	}
+}
+
+func deleteStreamResumeBuffer(requestId string) {
+	streamResumeBuffersLock.Lock()
+	delete(streamResumeBuffers, requestId)
+	streamResumeBuffersLock.Unlock()
+}
+
+// ServeStreamResume 向重新连接的客户端输出断开后缓存的内容，上游仍在输出时持续推送直到结束。
+// 找不到请求或请求不属于该用户时返回 ErrStreamResumeNotFound，开始输出前缓冲区已被截断时返回
+// ErrStreamResumeTruncated；输出过程中发生截断时以错误事件结束流
+func ServeStreamResume(c *gin.Context, requestId string, userId int) error {
+	buffer, ownerId, contentType, ok := getStreamResumeStore(requestId)
+	if !ok || ownerId != userId {
+		return ErrStreamResumeNotFound
+	}
+	if _, _, truncated, _ := buffer.read(0); truncated {
+		deleteStreamResumeStore(requestId)
+		return ErrStreamResumeTruncated
+	}
+
+	if contentType == "" {
+		contentType = "text/event-stream"
+	}
+	c.Writer.Header().Set("Content-Type", contentType)
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	offset := 0
+	for {
+		data, done, truncated, updated := buffer.read(offset)
+		if len(data) > 0 {
+			if _, err := c.Writer.Write(data); err != nil {
+				return nil
+			}
+			c.Writer.Flush()
+			offset += len(data)
+		}
+		if truncated {
+			common.LogWarn(c, "stream resume buffer truncated for request "+requestId)
+			deleteStreamResumeStore(requestId)
+			// 已输出的内容不完整，告知客户端续传失败
+			_ = ObjectData(c, gin.H{
+				"error": dto.OpenAIError{
+					Message: ErrStreamResumeTruncated.Error(),
+					Type:    "server_error",
+					Code:    "stream_resume_truncated",
+				},
+			})
+			return nil
+		}
+		if done {
+			// 完整续传后释放缓冲区
+			deleteStreamResumeStore(requestId)
+			return nil
+		}
+		select {
+		case <-updated:
+		case <-c.Request.Context().Done():
+			return nil
+		}
+	}
+}
+
+func cleanStreamResumeBuffers() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		streamResumeBuffersLock.Lock()
+		for requestId, buffer := range streamResumeBuffers {
+			buffer.mu.Lock()
+			expired := now.After(buffer.expireAt)
+			buffer.mu.Unlock()
+			if expired {
+				delete(streamResumeBuffers, requestId)
+			}
+		}
+		streamResumeBuffersLock.Unlock()
+	}
+}
@@ -0,0 +1,134 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"strconv"
+	"time"
+)
+
+// 其它节点写入的内容无法通知，续传时按固定间隔轮询
+const streamResumePollInterval = 200 * time.Millisecond
+
+func getStreamResumeRedisKey(requestId string) string {
+	return fmt.Sprintf("stream_resume:%s", requestId)
+}
+
+func getStreamResumeRedisDataKey(requestId string) string {
+	return fmt.Sprintf("stream_resume:%s:data", requestId)
+}
+
+// redisStreamResumeBuffer 保存在 Redis 中的续传内容：状态保存在哈希中，响应内容追加到单独的字符串中。
+// 只有请求所在节点会写入，写入方在本地记录大小，超出上限后不再追加
+type redisStreamResumeBuffer struct {
+	requestId   string
+	userId      int
+	contentType string
+	size        int
+	truncated   bool
+}
+
+func newRedisStreamResumeBuffer(requestId string, userId int, contentType string) *redisStreamResumeBuffer {
+	b := &redisStreamResumeBuffer{requestId: requestId, userId: userId, contentType: contentType}
+	ctx := context.Background()
+	key := getStreamResumeRedisKey(requestId)
+	pipe := common.RDB.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userId, "content_type", contentType, "done", 0, "truncated", 0)
+	pipe.Expire(ctx, key, streamResumeTTL())
+	if _, err := pipe.Exec(ctx); err != nil {
+		common.SysError("failed to create stream resume buffer in redis: " + err.Error())
+		b.truncated = true
+	}
+	return b
+}
+
+func streamResumeTTL() time.Duration {
+	return time.Duration(constant.StreamResumeTTL) * time.Second
+}
+
+func (b *redisStreamResumeBuffer) write(data []byte) {
+	if b.truncated {
+		return
+	}
+	if b.size+len(data) > MaxScannerBufferSize {
+		b.markTruncated()
+		return
+	}
+	ctx := context.Background()
+	dataKey := getStreamResumeRedisDataKey(b.requestId)
+	pipe := common.RDB.TxPipeline()
+	pipe.Append(ctx, dataKey, string(data))
+	pipe.Expire(ctx, dataKey, streamResumeTTL())
+	pipe.Expire(ctx, getStreamResumeRedisKey(b.requestId), streamResumeTTL())
+	if _, err := pipe.Exec(ctx); err != nil {
+		// 追加失败后续传内容会缺失，按截断处理
+		common.SysError("failed to append stream resume buffer in redis: " + err.Error())
+		b.markTruncated()
+		return
+	}
+	b.size += len(data)
+}
+
+func (b *redisStreamResumeBuffer) markTruncated() {
+	b.truncated = true
+	b.setState("truncated")
+}
+
+func (b *redisStreamResumeBuffer) finish() {
+	b.setState("done")
+}
+
+func (b *redisStreamResumeBuffer) setState(field string) {
+	ctx := context.Background()
+	key := getStreamResumeRedisKey(b.requestId)
+	pipe := common.RDB.TxPipeline()
+	pipe.HSet(ctx, key, field, 1)
+	pipe.Expire(ctx, key, streamResumeTTL())
+	pipe.Expire(ctx, getStreamResumeRedisDataKey(b.requestId), streamResumeTTL())
+	if _, err := pipe.Exec(ctx); err != nil {
+		common.SysError(fmt.Sprintf("failed to mark stream resume buffer %s in redis: %s", field, err.Error()))
+	}
+}
+
+// read 先读取状态再读取内容，状态为已结束时读到的内容一定完整。缓冲区过期或读取失败时按截断处理
+func (b *redisStreamResumeBuffer) read(offset int) ([]byte, bool, bool, <-chan struct{}) {
+	updated := make(chan struct{})
+	time.AfterFunc(streamResumePollInterval, func() {
+		close(updated)
+	})
+	state, done, ok := getRedisStreamResumeBuffer(b.requestId)
+	if !ok {
+		return nil, false, true, updated
+	}
+	data, err := common.RDB.GetRange(context.Background(), getStreamResumeRedisDataKey(b.requestId), int64(offset), -1).Bytes()
+	if err != nil {
+		common.SysError("failed to read stream resume buffer from redis: " + err.Error())
+		return nil, false, true, updated
+	}
+	return data, done, state.truncated, updated
+}
+
+// getRedisStreamResumeBuffer 读取 Redis 中的续传缓冲区状态，返回缓冲区及是否已结束
+func getRedisStreamResumeBuffer(requestId string) (*redisStreamResumeBuffer, bool, bool) {
+	values, err := common.RDB.HGetAll(context.Background(), getStreamResumeRedisKey(requestId)).Result()
+	if err != nil || len(values) == 0 {
+		return nil, false, false
+	}
+	userId, _ := strconv.Atoi(values["user_id"])
+	buffer := &redisStreamResumeBuffer{
+		requestId:   requestId,
+		userId:      userId,
+		contentType: values["content_type"],
+		truncated:   values["truncated"] == "1",
+	}
+	return buffer, values["done"] == "1", true
+}
+
+func deleteRedisStreamResumeBuffer(requestId string) {
+	err := common.RDB.Del(context.Background(), getStreamResumeRedisKey(requestId), getStreamResumeRedisDataKey(requestId)).Err()
+	if err != nil {
+		common.SysError("failed to delete stream resume buffer from redis: " + err.Error())
+	}
+}
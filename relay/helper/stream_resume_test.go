@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamResumeBufferWrite(t *testing.T) {
+	big := bytes.Repeat([]byte("a"), MaxScannerBufferSize-10)
+	tests := []struct {
+		name          string
+		writes        [][]byte
+		wantLen       int
+		wantTruncated bool
+	}{
+		{name: "within limit", writes: [][]byte{[]byte("data: 1\n\n"), []byte("data: 2\n\n")}, wantLen: 18},
+		{name: "exceeds limit", writes: [][]byte{big, []byte("data: too long\n\n")}, wantLen: len(big), wantTruncated: true},
+		{name: "small write after truncation", writes: [][]byte{big, []byte("data: too long\n\n"), []byte("x")}, wantLen: len(big), wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := &streamResumeBuffer{updated: make(chan struct{})}
+			for _, data := range tt.writes {
+				buffer.write(data)
+			}
+			data, _, truncated, _ := buffer.read(0)
+			if len(data) != tt.wantLen {
+				t.Errorf("len(data) = %d, want %d", len(data), tt.wantLen)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestServeStreamResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// 未启用 Redis 时缓冲区保存在内存中
+	previousRedisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = previousRedisEnabled
+	})
+	tests := []struct {
+		name      string
+		userId    int
+		data      string
+		truncated bool
+		// 续传过程中被截断
+		truncateLater bool
+		wantErr       error
+		wantBody      string
+	}{
+		{name: "complete", userId: 1, data: "data: 1\n\n", wantBody: "data: 1\n\n"},
+		{name: "other user", userId: 2, data: "data: 1\n\n", wantErr: ErrStreamResumeNotFound},
+		{name: "truncated before resume", userId: 1, data: "data: 1\n\n", truncated: true, wantErr: ErrStreamResumeTruncated},
+		{name: "truncated during resume", userId: 1, data: "data: 1\n\n", truncateLater: true, wantBody: "data: 1\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := &streamResumeBuffer{
+				userId:      1,
+				contentType: "text/event-stream",
+				data:        []byte(tt.data),
+				done:        !tt.truncateLater,
+				truncated:   tt.truncated,
+				updated:     make(chan struct{}),
+			}
+			requestId := "resume-" + strings.ReplaceAll(tt.name, " ", "-")
+			streamResumeBuffersLock.Lock()
+			streamResumeBuffers[requestId] = buffer
+			streamResumeBuffersLock.Unlock()
+			t.Cleanup(func() {
+				deleteStreamResumeBuffer(requestId)
+			})
+
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/streams/"+requestId+"/resume", nil).WithContext(ctx)
+			if tt.truncateLater {
+				go buffer.write(bytes.Repeat([]byte("a"), MaxScannerBufferSize))
+			}
+
+			err := ServeStreamResume(c, requestId, tt.userId)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			body := recorder.Body.String()
+			if !strings.HasPrefix(body, tt.wantBody) {
+				t.Errorf("body = %q, want prefix %q", body, tt.wantBody)
+			}
+			hasError := strings.Contains(body, "stream_resume_truncated")
+			if hasError != tt.truncateLater {
+				t.Errorf("truncation error in body = %v, want %v", hasError, tt.truncateLater)
+			}
+		})
+	}
+}
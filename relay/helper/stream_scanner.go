@@ -97,6 +97,17 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		close(stopChan)
 	}()
 
+	// drain 模式下客户端断开后继续读取上游，剩余内容写入续传缓冲区
+	clientDone := c.Request.Context().Done()
+	if IsStreamDrainEnabled(c) {
+		clientDone = nil
+	}
+	defer func() {
+		if c.Request.Context().Err() != nil {
+			info.ClientDisconnected = true
+		}
+	}()
+
 	scanner.Buffer(make([]byte, InitialScannerBufferSize), MaxScannerBufferSize)
 	scanner.Split(bufio.ScanLines)
 	SetEventStreamHeaders(c)
@@ -190,7 +201,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				return
 			case <-ctx.Done():
 				return
-			case <-c.Request.Context().Done():
+			case <-clientDone:
 				return
 			default:
 			}
@@ -252,8 +263,11 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	case <-stopChan:
 		// 正常结束
 		common.LogInfo(c, "streaming finished")
-	case <-c.Request.Context().Done():
-		// 客户端断开连接
-		common.LogInfo(c, "client disconnected")
+	case <-clientDone:
+		// 客户端断开连接，立即关闭上游响应使上游停止生成，按已读取的内容计费
+		common.LogInfo(c, "client disconnected, cancel upstream")
+		if resp.Body != nil {
+			_ = resp.Body.Close()
+		}
 	}
 }
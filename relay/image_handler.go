@@ -15,7 +15,6 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
-	"one-api/setting"
 	"one-api/types"
 	"strings"
 
@@ -98,12 +97,8 @@ func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.
 		}
 	}
 
-	if setting.ShouldCheckPromptSensitive() {
-		words, err := service.CheckSensitiveInput(imageRequest.Prompt)
-		if err != nil {
-			common.LogWarn(c, fmt.Sprintf("user sensitive words detected: %s", strings.Join(words, ",")))
-			return nil, err
-		}
+	if newAPIError := service.CheckPromptGuardrails(c, &imageRequest.Prompt); newAPIError != nil {
+		return nil, newAPIError.Err
 	}
 	return imageRequest, nil
 }
@@ -170,7 +165,7 @@ func ImageHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		// reset model price
 		priceData.ModelPrice *= sizeRatio * qualityRatio * float64(imageRequest.N)
 		quota = int(priceData.ModelPrice * priceData.GroupRatioInfo.GroupRatio * common.QuotaPerUnit)
-		userQuota, err = model.GetUserQuota(relayInfo.UserId, false)
+		userQuota, err = model.GetPayerQuota(relayInfo.UserId, relayInfo.OrgId)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeQueryDataError)
 		}
@@ -198,6 +193,11 @@ func ImageHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 		}
+		// 图片编辑为 multipart 表单，仅对 JSON 请求体应用参数覆盖
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, relayInfo)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
+		}
 		requestBody = bytes.NewBuffer(jsonData)
 	}
 
@@ -207,7 +207,7 @@ func ImageHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -223,7 +223,7 @@ func ImageHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newAPIError := doAdaptorResponse(c, adaptor, httpResp, relayInfo)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
@@ -0,0 +1,1104 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/types"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// 单次提交的音频上限，与 Whisper 接口的文件大小限制一致
+	realtimeBridgeMaxAudioBytes = 25 << 20
+	// 合成音频按 100ms（24kHz pcm16）分片推送
+	realtimeBridgeAudioChunkBytes = 4800
+)
+
+// isRealtimeBridgeChannel 判断渠道是否需要桥接：只有 OpenAI 类型渠道原生支持 Realtime 协议，
+// 其余渠道以及开启了 realtime_bridge 设置的渠道通过 chat completions + 语音识别/合成模拟 Realtime 会话
+func isRealtimeBridgeChannel(info *relaycommon.RelayInfo) bool {
+	return info.ApiType != constant.APITypeOpenAI || info.ChannelSetting.RealtimeBridge
+}
+
+// realtimeBridge 接收 OpenAI Realtime 客户端事件，在普通渠道上依次执行语音识别、对话补全和语音合成，
+// 并生成对应的 Realtime 服务端事件
+type realtimeBridge struct {
+	c       *gin.Context
+	info    *relaycommon.RelayInfo
+	channel *model.Channel
+
+	session dto.RealtimeSession
+	items   []dto.RealtimeItem
+	audio   []byte
+
+	// 尚未结算的用量，每次 response.done 时预扣
+	usage    *dto.RealtimeUsage
+	sumUsage *dto.RealtimeUsage
+
+	// 进行中响应的上游请求上下文，response.cancel 时取消
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// bridgeResponse 单次 response.create 的输出状态
+type bridgeResponse struct {
+	id           string
+	audio        bool
+	instructions string
+	output       []*dto.RealtimeItem
+	message      *dto.RealtimeItem
+	text         strings.Builder
+	toolCalls    map[int]*dto.RealtimeItem
+	cancelled    bool
+	err          error
+}
+
+// realtimeBridgeHelper 以桥接模式处理整个 Realtime 会话，返回会话累计用量
+func realtimeBridgeHelper(c *gin.Context, info *relaycommon.RelayInfo) (*dto.RealtimeUsage, *types.NewAPIError) {
+	channel, err := model.CacheGetChannel(info.ChannelId)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeGetChannelFailed)
+	}
+	info.IsStream = true
+	b := &realtimeBridge{
+		c:       c,
+		info:    info,
+		channel: channel,
+		session: dto.RealtimeSession{
+			Id:                "sess_" + common.GetUUID(),
+			Object:            "realtime.session",
+			Model:             info.OriginModelName,
+			Modalities:        []string{"text", "audio"},
+			Voice:             "alloy",
+			InputAudioFormat:  info.InputAudioFormat,
+			OutputAudioFormat: info.OutputAudioFormat,
+		},
+		usage:    &dto.RealtimeUsage{},
+		sumUsage: &dto.RealtimeUsage{},
+	}
+	if err := b.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionCreated, Session: &b.session}); err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponse)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	for message := range b.readClient(done) {
+		event := &dto.RealtimeEvent{}
+		if err := common.Unmarshal(message, event); err != nil {
+			b.sendError("invalid_request_error", "invalid event: "+err.Error())
+			continue
+		}
+		if err := b.handleEvent(event); err != nil {
+			common.LogError(c, "realtime bridge error: "+err.Error())
+			break
+		}
+	}
+
+	if b.usage.TotalTokens != 0 {
+		if err := b.settle(); err != nil {
+			common.LogError(c, "realtime bridge: error consume usage: "+err.Error())
+		}
+	}
+	return b.sumUsage, nil
+}
+
+// readClient 在独立的协程中读取客户端消息，使响应进行中也能收到 response.cancel 并立即取消上游请求
+func (b *realtimeBridge) readClient(done <-chan struct{}) <-chan []byte {
+	messages := make(chan []byte, 16)
+	go func() {
+		defer close(messages)
+		for {
+			_, message, err := b.info.ClientWs.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					common.LogError(b.c, "realtime bridge: error reading from client: "+err.Error())
+				}
+				return
+			}
+			var event struct {
+				Type string `json:"type"`
+			}
+			if common.Unmarshal(message, &event) == nil && event.Type == dto.RealtimeEventTypeResponseCancel {
+				b.cancelResponse()
+			}
+			select {
+			case messages <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+// startResponse 为新的响应创建可取消的上游请求上下文，返回的函数在响应结束时调用
+func (b *realtimeBridge) startResponse() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(b.c.Request.Context())
+	b.mu.Lock()
+	b.ctx, b.cancel = ctx, cancel
+	b.mu.Unlock()
+	return ctx, func() {
+		b.mu.Lock()
+		b.ctx, b.cancel = nil, nil
+		b.mu.Unlock()
+		cancel()
+	}
+}
+
+func (b *realtimeBridge) cancelResponse() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// requestContext 返回上游请求使用的上下文，响应进行中时可被 response.cancel 取消
+func (b *realtimeBridge) requestContext() context.Context {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ctx != nil {
+		return b.ctx
+	}
+	return b.c.Request.Context()
+}
+
+// handleEvent 处理单个客户端事件，只有连接或计费失败时返回错误并结束会话
+func (b *realtimeBridge) handleEvent(event *dto.RealtimeEvent) error {
+	switch event.Type {
+	case dto.RealtimeEventTypeSessionUpdate:
+		return b.updateSession(event)
+	case dto.RealtimeEventInputAudioBufferAppend:
+		audio, err := base64.StdEncoding.DecodeString(event.Audio)
+		if err != nil {
+			b.sendError("invalid_request_error", "invalid audio: "+err.Error())
+			return nil
+		}
+		if len(b.audio)+len(audio) > realtimeBridgeMaxAudioBytes {
+			b.sendError("invalid_request_error", "input audio buffer is too large, commit it first")
+			return nil
+		}
+		b.audio = append(b.audio, audio...)
+		return nil
+	case dto.RealtimeEventInputAudioBufferCommit:
+		if len(b.audio) == 0 {
+			b.sendError("invalid_request_error", "input audio buffer is empty")
+			return nil
+		}
+		return b.commitAudio()
+	case dto.RealtimeEventInputAudioBufferClear:
+		b.audio = nil
+		return b.send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCleared})
+	case dto.RealtimeEventTypeConversationCreate:
+		if event.Item == nil {
+			b.sendError("invalid_request_error", "item is required")
+			return nil
+		}
+		return b.addItem(*event.Item)
+	case dto.RealtimeEventTypeConversationDelete:
+		for i, item := range b.items {
+			if item.Id == event.ItemId {
+				b.items = append(b.items[:i], b.items[i+1:]...)
+				return b.send(&dto.RealtimeEvent{Type: dto.RealtimeEventConversationItemDeleted, ItemId: event.ItemId})
+			}
+		}
+		b.sendError("invalid_request_error", "item not found: "+event.ItemId)
+		return nil
+	case dto.RealtimeEventTypeResponseCreate:
+		// 桥接模式不做服务端语音检测，创建响应前自动提交未提交的音频
+		if len(b.audio) > 0 {
+			if err := b.commitAudio(); err != nil {
+				return err
+			}
+		}
+		return b.createResponse(event.Response)
+	case dto.RealtimeEventTypeResponseCancel:
+		// 进行中的响应已在读取消息时取消，此处无需处理
+		return nil
+	default:
+		b.sendError("invalid_request_error", "unsupported event type in realtime bridge mode: "+event.Type)
+		return nil
+	}
+}
+
+func (b *realtimeBridge) updateSession(event *dto.RealtimeEvent) error {
+	session := event.Session
+	if session == nil {
+		return b.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: &b.session})
+	}
+	if session.Modalities != nil {
+		b.session.Modalities = session.Modalities
+	}
+	if session.Instructions != "" {
+		b.session.Instructions = session.Instructions
+	}
+	if session.Voice != "" {
+		b.session.Voice = session.Voice
+	}
+	if session.InputAudioFormat != "" {
+		b.session.InputAudioFormat = session.InputAudioFormat
+		b.info.InputAudioFormat = session.InputAudioFormat
+	}
+	if session.InputAudioTranscription.Model != "" {
+		b.session.InputAudioTranscription = session.InputAudioTranscription
+	}
+	if session.Tools != nil {
+		b.session.Tools = session.Tools
+		b.info.RealtimeTools = session.Tools
+	}
+	if session.ToolChoice != "" {
+		b.session.ToolChoice = session.ToolChoice
+	}
+	if session.Temperature != 0 {
+		b.session.Temperature = session.Temperature
+	}
+	// 合成音频固定为 pcm16，且不支持服务端语音检测，需由客户端提交音频
+	b.session.OutputAudioFormat = "pcm16"
+	b.session.TurnDetection = nil
+	return b.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: &b.session})
+}
+
+func (b *realtimeBridge) addItem(item dto.RealtimeItem) error {
+	if item.Id == "" {
+		item.Id = newRealtimeItemId()
+	}
+	item.Object = "realtime.item"
+	if item.Status == "" {
+		item.Status = "completed"
+	}
+	previousItemId := b.lastItemId()
+	b.items = append(b.items, item)
+	return b.send(&dto.RealtimeEvent{
+		Type:           dto.RealtimeEventConversationItemCreated,
+		PreviousItemId: previousItemId,
+		Item:           &item,
+	})
+}
+
+// commitAudio 将缓冲区音频识别为文本，作为用户消息加入对话
+func (b *realtimeBridge) commitAudio() error {
+	audio := b.audio
+	b.audio = nil
+
+	itemId := newRealtimeItemId()
+	err := b.send(&dto.RealtimeEvent{
+		Type:           dto.RealtimeEventInputAudioBufferCommitted,
+		PreviousItemId: b.lastItemId(),
+		ItemId:         itemId,
+	})
+	if err != nil {
+		return err
+	}
+	item := dto.RealtimeItem{
+		Id:      itemId,
+		Type:    "message",
+		Role:    "user",
+		Content: []dto.RealtimeContent{{Type: "input_audio"}},
+	}
+	transcript, err := b.transcribe(audio)
+	if err != nil {
+		common.LogError(b.c, "realtime bridge: transcription failed: "+err.Error())
+		item.Status = "incomplete"
+		if err := b.addItem(item); err != nil {
+			return err
+		}
+		return b.send(&dto.RealtimeEvent{
+			Type:         dto.RealtimeEventInputAudioTranscriptionFailed,
+			ItemId:       itemId,
+			ContentIndex: common.GetPointer(0),
+			Error:        &types.OpenAIError{Message: err.Error(), Type: "transcription_error"},
+		})
+	}
+	item.Content[0].Transcript = transcript
+	if err := b.addItem(item); err != nil {
+		return err
+	}
+	return b.send(&dto.RealtimeEvent{
+		Type:         dto.RealtimeEventInputAudioTranscriptionCompleted,
+		ItemId:       itemId,
+		ContentIndex: common.GetPointer(0),
+		Transcript:   transcript,
+	})
+}
+
+func (b *realtimeBridge) createResponse(options *dto.RealtimeResponse) error {
+	resp := &bridgeResponse{
+		id:           "resp_" + common.GetUUID(),
+		instructions: b.session.Instructions,
+		toolCalls:    make(map[int]*dto.RealtimeItem),
+	}
+	modalities := b.session.Modalities
+	if options != nil {
+		if options.Modalities != nil {
+			modalities = options.Modalities
+		}
+		if options.Instructions != "" {
+			resp.instructions = options.Instructions
+		}
+	}
+	resp.audio = common.StringsContains(modalities, "audio")
+	err := b.send(&dto.RealtimeEvent{
+		Type: dto.RealtimeEventResponseCreated,
+		Response: &dto.RealtimeResponse{
+			Id:         resp.id,
+			Object:     "realtime.response",
+			Status:     "in_progress",
+			Modalities: modalities,
+			Output:     []dto.RealtimeItem{},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, endResponse := b.startResponse()
+	defer endResponse()
+	usage, apiErr := b.runChat(resp)
+	if resp.err != nil {
+		return resp.err
+	}
+	status := "completed"
+	if ctx.Err() != nil {
+		status = "cancelled"
+		resp.cancelled = true
+	}
+	if apiErr != nil {
+		common.LogError(b.c, "realtime bridge: chat completion failed: "+apiErr.Error())
+		b.sendError("server_error", apiErr.Error())
+		status = "failed"
+	} else {
+		b.usage.InputTokenDetails.TextTokens += usage.PromptTokens
+		b.usage.InputTokens += usage.PromptTokens
+		b.usage.OutputTokenDetails.TextTokens += usage.CompletionTokens
+		b.usage.OutputTokens += usage.CompletionTokens
+		b.usage.TotalTokens += usage.PromptTokens + usage.CompletionTokens
+		if err := b.finishOutput(resp); err != nil {
+			return err
+		}
+	}
+
+	output := make([]dto.RealtimeItem, 0, len(resp.output))
+	for _, item := range resp.output {
+		output = append(output, *item)
+		b.items = append(b.items, *item)
+	}
+	responseUsage := *b.usage
+	if b.usage.TotalTokens != 0 {
+		if err := b.settle(); err != nil {
+			b.sendError("insufficient_quota", err.Error())
+			return err
+		}
+	}
+	return b.send(&dto.RealtimeEvent{
+		Type: dto.RealtimeEventTypeResponseDone,
+		Response: &dto.RealtimeResponse{
+			Id:         resp.id,
+			Object:     "realtime.response",
+			Status:     status,
+			Modalities: modalities,
+			Output:     output,
+			Usage:      &responseUsage,
+		},
+	})
+}
+
+// settle 预扣当前累计的用量并计入会话总用量，与原生 Realtime 在 response.done 时的计费方式一致
+func (b *realtimeBridge) settle() error {
+	usage := b.usage
+	b.usage = &dto.RealtimeUsage{}
+	b.sumUsage.TotalTokens += usage.TotalTokens
+	b.sumUsage.InputTokens += usage.InputTokens
+	b.sumUsage.OutputTokens += usage.OutputTokens
+	b.sumUsage.InputTokenDetails.TextTokens += usage.InputTokenDetails.TextTokens
+	b.sumUsage.InputTokenDetails.AudioTokens += usage.InputTokenDetails.AudioTokens
+	b.sumUsage.OutputTokenDetails.TextTokens += usage.OutputTokenDetails.TextTokens
+	b.sumUsage.OutputTokenDetails.AudioTokens += usage.OutputTokenDetails.AudioTokens
+	return service.PreWssConsumeQuota(b.c, b.info, usage)
+}
+
+// buildChatRequest 将会话中的对话项转换为 chat completions 请求
+func (b *realtimeBridge) buildChatRequest(resp *bridgeResponse) *dto.GeneralOpenAIRequest {
+	request := &dto.GeneralOpenAIRequest{
+		Model:  b.info.UpstreamModelName,
+		Stream: true,
+	}
+	if resp.instructions != "" {
+		request.Messages = append(request.Messages, dto.Message{Role: "system", Content: resp.instructions})
+	}
+	for _, item := range b.items {
+		switch item.Type {
+		case "message":
+			var parts []string
+			for _, content := range item.Content {
+				switch content.Type {
+				case "input_text", "text":
+					parts = append(parts, content.Text)
+				case "input_audio", "audio":
+					parts = append(parts, content.Transcript)
+				}
+			}
+			request.Messages = append(request.Messages, dto.Message{Role: item.Role, Content: strings.Join(parts, "\n")})
+		case "function_call":
+			toolCall := dto.ToolCallRequest{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      itemName(item),
+					Arguments: item.Arguments,
+				},
+			}
+			// 同一响应中的文本和函数调用合并到同一条助手消息中
+			last := len(request.Messages) - 1
+			if last >= 0 && request.Messages[last].Role == "assistant" {
+				toolCalls := request.Messages[last].ParseToolCalls()
+				request.Messages[last].SetToolCalls(append(toolCalls, toolCall))
+			} else {
+				message := dto.Message{Role: "assistant", Content: ""}
+				message.SetToolCalls([]dto.ToolCallRequest{toolCall})
+				request.Messages = append(request.Messages, message)
+			}
+		case "function_call_output":
+			request.Messages = append(request.Messages, dto.Message{Role: "tool", ToolCallId: item.CallId, Content: item.Output})
+		}
+	}
+	for _, tool := range b.session.Tools {
+		request.Tools = append(request.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if len(request.Tools) > 0 && b.session.ToolChoice != "" {
+		request.ToolChoice = b.session.ToolChoice
+	}
+	if b.session.Temperature != 0 {
+		request.Temperature = common.GetPointer(b.session.Temperature)
+	}
+	return request
+}
+
+// runChat 以流式 chat completions 请求会话渠道，边接收边推送文本及函数调用事件
+func (b *realtimeBridge) runChat(resp *bridgeResponse) (*dto.Usage, *types.NewAPIError) {
+	request := b.buildChatRequest(resp)
+	body, err := common.Marshal(request)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeJsonMarshalFailed)
+	}
+	writer, restore := b.swapRequest("/v1/chat/completions", "application/json", body)
+	defer restore()
+
+	info := relaycommon.GenRelayInfo(b.c)
+	info.UpstreamModelName = b.info.UpstreamModelName
+	info.IsModelMapped = b.info.IsModelMapped
+	info.IsStream = true
+	if info.SupportStreamOptions {
+		request.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	promptTokens, err := service.CountTokenMessages(info, request.Messages, info.UpstreamModelName, true)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeCountTokenFailed)
+	}
+	info.PromptTokens = promptTokens
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType)
+	}
+	adaptor.Init(info)
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(b.c, info, request)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+	jsonData, err = relaycommon.ApplyParamOverride(jsonData, info)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
+	}
+
+	// 客户端取消响应时按已生成的内容计费
+	cancelledUsage := func() (*dto.Usage, *types.NewAPIError) {
+		return service.ResponseText2Usage(resp.text.String(), info.UpstreamModelName, promptTokens), nil
+	}
+	result, err := doAdaptorRequest(b.c, adaptor, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		if b.requestContext().Err() != nil {
+			return cancelledUsage()
+		}
+		return nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	httpResp, ok := result.(*http.Response)
+	if !ok || httpResp == nil {
+		return nil, types.NewError(errors.New("invalid chat completions response"), types.ErrorCodeBadResponse)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, service.RelayErrorHandler(httpResp, false)
+	}
+
+	writer.onData = func(data string) {
+		var chunk dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+			common.LogError(b.c, "realtime bridge: error unmarshalling stream response: "+err.Error())
+			return
+		}
+		if len(chunk.Choices) > 0 {
+			b.handleDelta(resp, &chunk.Choices[0].Delta)
+		}
+	}
+	usage, apiErr := doAdaptorResponse(b.c, adaptor, httpResp, info)
+	if b.requestContext().Err() != nil {
+		return cancelledUsage()
+	}
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	// 上游忽略 stream 参数返回完整响应时，一次性推送
+	if !writer.isStream && writer.body.Len() > 0 {
+		var textResponse dto.OpenAITextResponse
+		if err := common.Unmarshal(writer.body.Bytes(), &textResponse); err == nil && len(textResponse.Choices) > 0 {
+			message := textResponse.Choices[0].Message
+			delta := dto.ChatCompletionsStreamResponseChoiceDelta{}
+			delta.SetContentString(message.StringContent())
+			for i, toolCall := range message.ParseToolCalls() {
+				delta.ToolCalls = append(delta.ToolCalls, dto.ToolCallResponse{
+					Index:    common.GetPointer(i),
+					ID:       toolCall.ID,
+					Type:     toolCall.Type,
+					Function: dto.FunctionResponse{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments},
+				})
+			}
+			b.handleDelta(resp, &delta)
+		}
+	}
+	// 上游未返回用量时按生成的文本估算
+	if chatUsage, ok := usage.(*dto.Usage); ok && chatUsage != nil {
+		return chatUsage, nil
+	}
+	return service.ResponseText2Usage(resp.text.String(), info.UpstreamModelName, promptTokens), nil
+}
+
+// handleDelta 将 chat completions 流式增量转换为 Realtime 输出事件
+func (b *realtimeBridge) handleDelta(resp *bridgeResponse, delta *dto.ChatCompletionsStreamResponseChoiceDelta) {
+	if content := delta.GetContentString(); content != "" {
+		if resp.message == nil {
+			resp.message = &dto.RealtimeItem{
+				Id:      newRealtimeItemId(),
+				Object:  "realtime.item",
+				Type:    "message",
+				Status:  "in_progress",
+				Role:    "assistant",
+				Content: []dto.RealtimeContent{},
+			}
+			b.addOutputItem(resp, resp.message)
+			b.sendResponseEvent(resp, &dto.RealtimeEvent{
+				Type:         dto.RealtimeEventResponseContentPartAdded,
+				ItemId:       resp.message.Id,
+				OutputIndex:  outputIndex(resp, resp.message),
+				ContentIndex: common.GetPointer(0),
+				Part:         resp.contentPart(),
+			})
+		}
+		resp.text.WriteString(content)
+		eventType := dto.RealtimeEventResponseTextDelta
+		if resp.audio {
+			eventType = dto.RealtimeEventResponseAudioTranscriptionDelta
+		}
+		b.sendResponseEvent(resp, &dto.RealtimeEvent{
+			Type:         eventType,
+			ItemId:       resp.message.Id,
+			OutputIndex:  outputIndex(resp, resp.message),
+			ContentIndex: common.GetPointer(0),
+			Delta:        content,
+		})
+	}
+	for _, toolCall := range delta.ToolCalls {
+		index := 0
+		if toolCall.Index != nil {
+			index = *toolCall.Index
+		}
+		item, ok := resp.toolCalls[index]
+		if !ok {
+			item = &dto.RealtimeItem{
+				Id:     newRealtimeItemId(),
+				Object: "realtime.item",
+				Type:   "function_call",
+				Status: "in_progress",
+				CallId: common.GetStringIfEmpty(toolCall.ID, "call_"+common.GetUUID()),
+				Name:   common.GetPointer(toolCall.Function.Name),
+			}
+			resp.toolCalls[index] = item
+			b.addOutputItem(resp, item)
+		} else if toolCall.Function.Name != "" && itemName(*item) == "" {
+			item.Name = common.GetPointer(toolCall.Function.Name)
+		}
+		if toolCall.Function.Arguments != "" {
+			item.Arguments += toolCall.Function.Arguments
+			b.sendResponseEvent(resp, &dto.RealtimeEvent{
+				Type:        dto.RealtimeEventResponseFunctionCallArgumentsDelta,
+				ItemId:      item.Id,
+				OutputIndex: outputIndex(resp, item),
+				CallId:      item.CallId,
+				Delta:       toolCall.Function.Arguments,
+			})
+		}
+	}
+}
+
+// finishOutput 补齐各输出项的结束事件，需要音频输出时先合成语音
+func (b *realtimeBridge) finishOutput(resp *bridgeResponse) error {
+	if message := resp.message; message != nil {
+		part := resp.contentPart()
+		if resp.audio {
+			part.Transcript = resp.text.String()
+			// 已取消的响应不再合成语音
+			if !resp.cancelled {
+				if err := b.speak(resp); err != nil {
+					common.LogError(b.c, "realtime bridge: speech synthesis failed: "+err.Error())
+					b.sendError("server_error", "speech synthesis failed: "+err.Error())
+				}
+			}
+			b.sendResponseEvent(resp, &dto.RealtimeEvent{
+				Type:         dto.RealtimeEventResponseAudioDone,
+				ItemId:       message.Id,
+				OutputIndex:  outputIndex(resp, message),
+				ContentIndex: common.GetPointer(0),
+			})
+			b.sendResponseEvent(resp, &dto.RealtimeEvent{
+				Type:         dto.RealtimeEventResponseAudioTranscriptionDone,
+				ItemId:       message.Id,
+				OutputIndex:  outputIndex(resp, message),
+				ContentIndex: common.GetPointer(0),
+				Transcript:   part.Transcript,
+			})
+		} else {
+			part.Text = resp.text.String()
+			b.sendResponseEvent(resp, &dto.RealtimeEvent{
+				Type:         dto.RealtimeEventResponseTextDone,
+				ItemId:       message.Id,
+				OutputIndex:  outputIndex(resp, message),
+				ContentIndex: common.GetPointer(0),
+				Text:         part.Text,
+			})
+		}
+		b.sendResponseEvent(resp, &dto.RealtimeEvent{
+			Type:         dto.RealtimeEventResponseContentPartDone,
+			ItemId:       message.Id,
+			OutputIndex:  outputIndex(resp, message),
+			ContentIndex: common.GetPointer(0),
+			Part:         part,
+		})
+		message.Content = []dto.RealtimeContent{*part}
+	}
+	for _, item := range resp.output {
+		if item.Type == "function_call" {
+			b.sendResponseEvent(resp, &dto.RealtimeEvent{
+				Type:        dto.RealtimeEventResponseFunctionCallArgumentsDone,
+				ItemId:      item.Id,
+				OutputIndex: outputIndex(resp, item),
+				CallId:      item.CallId,
+				Name:        itemName(*item),
+				Arguments:   item.Arguments,
+			})
+		}
+		item.Status = "completed"
+		b.sendResponseEvent(resp, &dto.RealtimeEvent{
+			Type:        dto.RealtimeEventResponseOutputItemDone,
+			OutputIndex: outputIndex(resp, item),
+			Item:        item,
+		})
+	}
+	return resp.err
+}
+
+func (b *realtimeBridge) addOutputItem(resp *bridgeResponse, item *dto.RealtimeItem) {
+	previousItemId := b.lastItemId()
+	if len(resp.output) > 0 {
+		previousItemId = resp.output[len(resp.output)-1].Id
+	}
+	resp.output = append(resp.output, item)
+	b.sendResponseEvent(resp, &dto.RealtimeEvent{
+		Type:        dto.RealtimeEventResponseOutputItemAdded,
+		OutputIndex: outputIndex(resp, item),
+		Item:        item,
+	})
+	b.sendResponseEvent(resp, &dto.RealtimeEvent{
+		Type:           dto.RealtimeEventConversationItemCreated,
+		PreviousItemId: previousItemId,
+		Item:           item,
+	})
+}
+
+func (resp *bridgeResponse) contentPart() *dto.RealtimeContent {
+	if resp.audio {
+		return &dto.RealtimeContent{Type: "audio"}
+	}
+	return &dto.RealtimeContent{Type: "text"}
+}
+
+func outputIndex(resp *bridgeResponse, item *dto.RealtimeItem) *int {
+	for i, output := range resp.output {
+		if output == item {
+			return common.GetPointer(i)
+		}
+	}
+	return common.GetPointer(0)
+}
+
+// transcribe 通过语音识别模型所在渠道将输入音频转为文本
+func (b *realtimeBridge) transcribe(audio []byte) (string, error) {
+	modelName := common.GetStringIfEmpty(b.session.InputAudioTranscription.Model, constant.RealtimeBridgeTranscriptionModel)
+	var body bytes.Buffer
+	formWriter := multipart.NewWriter(&body)
+	_ = formWriter.WriteField("model", modelName)
+	_ = formWriter.WriteField("response_format", "json")
+	part, err := formWriter.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(wavFile(audio, b.session.InputAudioFormat)); err != nil {
+		return "", err
+	}
+	if err := formWriter.Close(); err != nil {
+		return "", err
+	}
+
+	promptTokens, err := service.CountSTTToken(base64.StdEncoding.EncodeToString(audio), b.session.InputAudioFormat)
+	if err != nil {
+		return "", err
+	}
+	respBody, err := b.doAudioRequest("/v1/audio/transcriptions", formWriter.FormDataContentType(), body.Bytes(), modelName, promptTokens,
+		func(info *relaycommon.RelayInfo) dto.AudioRequest {
+			return dto.AudioRequest{Model: info.UpstreamModelName, ResponseFormat: "json"}
+		})
+	if err != nil {
+		return "", err
+	}
+	var audioResponse dto.AudioResponse
+	if err := common.Unmarshal(respBody, &audioResponse); err != nil {
+		return "", err
+	}
+	return audioResponse.Text, nil
+}
+
+// speak 通过语音合成模型所在渠道合成回复语音，按分片推送 response.audio.delta
+func (b *realtimeBridge) speak(resp *bridgeResponse) error {
+	text := resp.text.String()
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	modelName := constant.RealtimeBridgeSpeechModel
+	request := dto.AudioRequest{Model: modelName, Input: text, Voice: b.session.Voice, ResponseFormat: "pcm"}
+	body, err := common.Marshal(request)
+	if err != nil {
+		return err
+	}
+	promptTokens := service.CountTTSToken(text, modelName)
+	audio, err := b.doAudioRequest("/v1/audio/speech", "application/json", body, modelName, promptTokens,
+		func(info *relaycommon.RelayInfo) dto.AudioRequest {
+			request.Model = info.UpstreamModelName
+			return request
+		})
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(audio); start += realtimeBridgeAudioChunkBytes {
+		end := min(start+realtimeBridgeAudioChunkBytes, len(audio))
+		b.sendResponseEvent(resp, &dto.RealtimeEvent{
+			Type:         dto.RealtimeEventResponseAudioDelta,
+			ItemId:       resp.message.Id,
+			OutputIndex:  outputIndex(resp, resp.message),
+			ContentIndex: common.GetPointer(0),
+			Delta:        base64.StdEncoding.EncodeToString(audio[start:end]),
+		})
+		if resp.err != nil {
+			return resp.err
+		}
+	}
+	return nil
+}
+
+// doAudioRequest 选择支持指定模型的渠道发起音频请求并返回原始响应体，按该模型的价格单独计费，
+// 完成后恢复会话渠道的上下文
+func (b *realtimeBridge) doAudioRequest(path string, contentType string, body []byte, modelName string, promptTokens int,
+	buildRequest func(info *relaycommon.RelayInfo) dto.AudioRequest) (respBody []byte, err error) {
+	if common.GetContextKeyBool(b.c, constant.ContextKeyTokenModelLimitEnabled) {
+		limit, _ := common.GetContextKeyType[map[string]bool](b.c, constant.ContextKeyTokenModelLimit)
+		if !limit[modelName] {
+			return nil, fmt.Errorf("该令牌无权访问模型 %s", modelName)
+		}
+	}
+	autoGroup, hasAutoGroup := b.c.Get("auto_group")
+	defer func() {
+		if hasAutoGroup {
+			b.c.Set("auto_group", autoGroup)
+		}
+		if apiErr := middleware.SetupContextForSelectedChannel(b.c, b.channel, b.info.OriginModelName); apiErr != nil {
+			common.LogError(b.c, "realtime bridge: failed to restore channel context: "+apiErr.Error())
+		}
+	}()
+	channel, _, err := model.CacheGetRandomSatisfiedChannel(b.c, b.info.UsingGroup, modelName, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no available channel for model %s: %w", modelName, err)
+	}
+	if apiErr := middleware.SetupContextForSelectedChannel(b.c, channel, modelName); apiErr != nil {
+		return nil, apiErr
+	}
+
+	_, restore := b.swapRequest(path, contentType, body)
+	defer restore()
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		if err := b.c.Request.ParseMultipartForm(32 << 20); err != nil {
+			return nil, err
+		}
+	}
+	info := relaycommon.GenRelayInfoOpenAIAudio(b.c)
+	info.OriginModelName = modelName
+	info.UpstreamModelName = modelName
+	info.PromptTokens = promptTokens
+	priceData, err := helper.ModelPriceHelper(b.c, info, promptTokens, 0)
+	if err != nil {
+		return nil, err
+	}
+	preConsumedQuota, userQuota, apiErr := preConsumeQuota(b.c, priceData.ShouldPreConsumedQuota, info)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	defer func() {
+		if err != nil {
+			returnPreConsumedQuota(b.c, info, userQuota, preConsumedQuota)
+		}
+	}()
+	if err := helper.ModelMappedHelper(b.c, info, nil); err != nil {
+		return nil, err
+	}
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	requestBody, err := adaptor.ConvertAudioRequest(b.c, info, buildRequest(info))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doAdaptorRequest(b.c, adaptor, info, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return nil, errors.New("invalid audio response")
+	}
+	defer common.CloseResponseBodyGracefully(httpResp)
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, service.RelayErrorHandler(httpResp, false)
+	}
+	respBody, err = io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	usage := &dto.Usage{PromptTokens: promptTokens, TotalTokens: promptTokens}
+	postConsumeQuota(b.c, info, usage, preConsumedQuota, userQuota, priceData, "", buildRequest(info))
+	return respBody, nil
+}
+
+// swapRequest 用合成的 POST 请求替换 WebSocket 升级请求，使适配器按普通 HTTP 接口调用上游，
+// 同时截获写往客户端的响应，返回的函数用于恢复原请求
+func (b *realtimeBridge) swapRequest(path string, contentType string, body []byte) (*bridgeResponseWriter, func()) {
+	c := b.c
+	originRequest := c.Request
+	originWriter := c.Writer
+	originBody, hasOriginBody := c.Get(common.KeyRequestBody)
+
+	request := originRequest.Clone(b.requestContext())
+	request.Method = http.MethodPost
+	request.URL = &url.URL{Path: path}
+	request.RequestURI = path
+	request.Header = http.Header{}
+	request.Header.Set("Content-Type", contentType)
+	if path == "/v1/chat/completions" {
+		request.Header.Set("Accept", "text/event-stream")
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.Form = nil
+	request.PostForm = nil
+	request.MultipartForm = nil
+	c.Request = request
+	c.Set(common.KeyRequestBody, body)
+	// response.cancel 通过取消请求上下文中断上游
+	common.SetContextKey(c, constant.ContextKeyUpstreamCancellable, true)
+
+	writer := &bridgeResponseWriter{ResponseWriter: originWriter, header: http.Header{}, status: http.StatusOK}
+	c.Writer = writer
+	return writer, func() {
+		c.Request = originRequest
+		c.Writer = originWriter
+		common.SetContextKey(c, constant.ContextKeyUpstreamCancellable, false)
+		if hasOriginBody {
+			c.Set(common.KeyRequestBody, originBody)
+		} else {
+			c.Set(common.KeyRequestBody, nil)
+		}
+	}
+}
+
+func (b *realtimeBridge) lastItemId() string {
+	if len(b.items) == 0 {
+		return ""
+	}
+	return b.items[len(b.items)-1].Id
+}
+
+func (b *realtimeBridge) send(event *dto.RealtimeEvent) error {
+	if event.EventId == "" {
+		event.EventId = "event_" + common.GetUUID()
+	}
+	return helper.WssObject(b.c, b.info.ClientWs, event)
+}
+
+// sendResponseEvent 发送响应过程中的事件，写入失败后不再继续发送
+func (b *realtimeBridge) sendResponseEvent(resp *bridgeResponse, event *dto.RealtimeEvent) {
+	if resp.err != nil {
+		return
+	}
+	event.ResponseId = resp.id
+	if event.Type == dto.RealtimeEventConversationItemCreated {
+		event.ResponseId = ""
+	}
+	resp.err = b.send(event)
+}
+
+func (b *realtimeBridge) sendError(errorType string, message string) {
+	helper.WssError(b.c, b.info.ClientWs, types.OpenAIError{
+		Message: message,
+		Type:    errorType,
+	})
+}
+
+func itemName(item dto.RealtimeItem) string {
+	if item.Name == nil {
+		return ""
+	}
+	return *item.Name
+}
+
+func newRealtimeItemId() string {
+	return "item_" + common.GetUUID()
+}
+
+// wavFile 为原始音频加上 WAV 文件头，pcm16 为 24kHz 单声道，g711 为 8kHz 单声道
+func wavFile(audio []byte, format string) []byte {
+	audioFormat, sampleRate, bitsPerSample := uint16(1), uint32(24000), uint16(16)
+	switch format {
+	case "g711_ulaw":
+		audioFormat, sampleRate, bitsPerSample = 7, 8000, 8
+	case "g711_alaw":
+		audioFormat, sampleRate, bitsPerSample = 6, 8000, 8
+	}
+	blockAlign := bitsPerSample / 8
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(36+len(audio)))
+	buf.WriteString("WAVEfmt ")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(&buf, binary.LittleEndian, audioFormat)
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(1))
+	_ = binary.Write(&buf, binary.LittleEndian, sampleRate)
+	_ = binary.Write(&buf, binary.LittleEndian, sampleRate*uint32(blockAlign))
+	_ = binary.Write(&buf, binary.LittleEndian, blockAlign)
+	_ = binary.Write(&buf, binary.LittleEndian, bitsPerSample)
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(audio)))
+	buf.Write(audio)
+	return buf.Bytes()
+}
+
+// bridgeResponseWriter 截获适配器写往客户端的响应：流式响应逐行解析 SSE 数据，其余响应缓存在 body 中。
+// WebSocket 连接已被接管，不能再写入原始 ResponseWriter
+type bridgeResponseWriter struct {
+	gin.ResponseWriter
+	mu       sync.Mutex
+	header   http.Header
+	status   int
+	size     int
+	decided  bool
+	isStream bool
+	body     helper.SSELineBuffer
+	onData   func(data string)
+}
+
+func (w *bridgeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bridgeResponseWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bridgeResponseWriter) WriteHeaderNow() {}
+
+func (w *bridgeResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *bridgeResponseWriter) Size() int {
+	return w.size
+}
+
+func (w *bridgeResponseWriter) Written() bool {
+	return w.size > 0
+}
+
+func (w *bridgeResponseWriter) Flush() {}
+
+func (w *bridgeResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bridgeResponseWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.decided {
+		w.decided = true
+		w.isStream = strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream")
+	}
+	w.size += len(data)
+	w.body.Write(data)
+	if w.isStream {
+		w.body.ProcessData(func(data string) {
+			if w.onData != nil {
+				w.onData(data)
+			}
+		})
+	}
+	return len(data), nil
+}
@@ -0,0 +1,344 @@
+package relay
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/ratio_setting"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// bridgeUpstream 模拟 OpenAI 兼容的上游，chat 为 nil 时返回固定的流式回复
+type bridgeUpstream struct {
+	chat      func(w http.ResponseWriter, r *http.Request)
+	requests  chan string
+	cancelled chan struct{}
+}
+
+func (u *bridgeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.requests <- r.URL.Path
+	switch r.URL.Path {
+	case "/v1/chat/completions":
+		if u.chat != nil {
+			u.chat(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"It is", " sunny."} {
+			_, _ = fmt.Fprintf(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", content)
+		}
+		_, _ = io.WriteString(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":4,\"total_tokens\":16}}\n\n")
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	case "/v1/audio/transcriptions":
+		if err := r.ParseMultipartForm(1 << 20); err != nil || r.FormValue("model") != "whisper-1" {
+			http.Error(w, "bad transcription request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"text":"what is the weather"}`)
+	case "/v1/audio/speech":
+		w.Header().Set("Content-Type", "audio/pcm")
+		_, _ = w.Write(make([]byte, realtimeBridgeAudioChunkBytes+100))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setupBridgeTest 使用临时 SQLite 数据库创建开启桥接的 OpenAI 渠道，返回已连接到桥接会话的客户端
+func setupBridgeTest(t *testing.T, upstream *bridgeUpstream) *websocket.Conn {
+	t.Helper()
+	upstream.requests = make(chan string, 16)
+	upstreamServer := httptest.NewServer(upstream)
+	t.Cleanup(upstreamServer.Close)
+
+	previousDB, previousLogDB, previousPath := model.DB, model.LOG_DB, common.SQLitePath
+	previousMaster, previousRedis := common.IsMasterNode, common.RedisEnabled
+	t.Setenv("SQL_DSN", "local")
+	common.SQLitePath = filepath.Join(t.TempDir(), "bridge.db")
+	common.IsMasterNode, common.RedisEnabled = true, false
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	model.LOG_DB = model.DB
+	t.Cleanup(func() {
+		sqlDB, _ := model.DB.DB()
+		_ = sqlDB.Close()
+		model.DB, model.LOG_DB, common.SQLitePath = previousDB, previousLogDB, previousPath
+		common.IsMasterNode, common.RedisEnabled = previousMaster, previousRedis
+	})
+	ratio_setting.InitRatioSettings()
+	service.InitHttpClient()
+	constant.StreamingTimeout = 120
+	constant.RealtimeBridgeTranscriptionModel = "whisper-1"
+	constant.RealtimeBridgeSpeechModel = "tts-1"
+
+	token := &model.Token{Id: 1, UserId: 1, Key: "bridgetoken", Name: "bridge", Status: common.TokenStatusEnabled, UnlimitedQuota: true, ExpiredTime: -1}
+	baseURL := upstreamServer.URL
+	setting := `{"realtime_bridge":true}`
+	channel := &model.Channel{
+		Id:      1,
+		Type:    constant.ChannelTypeOpenAI,
+		Name:    "bridge",
+		Key:     "sk-upstream",
+		Status:  common.ChannelStatusEnabled,
+		BaseURL: &baseURL,
+		Models:  "gpt-4o-mini,whisper-1,tts-1",
+		Group:   "default",
+		Setting: &setting,
+	}
+	for _, value := range []any{&model.User{Id: 1, Username: "bridge", Quota: 10000000000, Group: "default", Status: common.UserStatusEnabled}, token, channel} {
+		if err := model.DB.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := channel.AddAbilities(); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	upgrader := websocket.Upgrader{}
+	finished := make(chan struct{})
+	router.GET("/v1/realtime", func(c *gin.Context) {
+		defer close(finished)
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+		if err := middleware.SetupContextForToken(c, token); err != nil {
+			t.Error(err)
+			return
+		}
+		common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+		if apiErr := middleware.SetupContextForSelectedChannel(c, channel, "gpt-4o-mini"); apiErr != nil {
+			t.Error(apiErr)
+			return
+		}
+		if apiErr := WssHelper(c, ws); apiErr != nil {
+			t.Error(apiErr)
+		}
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/realtime", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 会话结束计费后才能恢复数据库
+	t.Cleanup(func() {
+		_ = client.Close()
+		<-finished
+	})
+	if event := readBridgeEvent(t, client); event.Type != dto.RealtimeEventTypeSessionCreated {
+		t.Fatalf("first event = %s, want session.created", event.Type)
+	}
+	return client
+}
+
+func sendBridgeEvent(t *testing.T, client *websocket.Conn, event dto.RealtimeEvent) {
+	t.Helper()
+	if err := client.WriteJSON(event); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readBridgeEvent(t *testing.T, client *websocket.Conn) *dto.RealtimeEvent {
+	t.Helper()
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	event := &dto.RealtimeEvent{}
+	if err := client.ReadJSON(event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type == dto.RealtimeEventTypeError {
+		t.Fatalf("unexpected error event: %+v", event.Error)
+	}
+	return event
+}
+
+// readBridgeEventsUntil 读取事件直到收到指定类型，返回途中收到的全部事件
+func readBridgeEventsUntil(t *testing.T, client *websocket.Conn, eventType string) []*dto.RealtimeEvent {
+	t.Helper()
+	var events []*dto.RealtimeEvent
+	for {
+		event := readBridgeEvent(t, client)
+		events = append(events, event)
+		if event.Type == eventType {
+			return events
+		}
+	}
+}
+
+func countBridgeEvents(events []*dto.RealtimeEvent, eventType string) int {
+	count := 0
+	for _, event := range events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRealtimeBridgeSessionUpdate(t *testing.T) {
+	client := setupBridgeTest(t, &bridgeUpstream{})
+	sendBridgeEvent(t, client, dto.RealtimeEvent{
+		Type: dto.RealtimeEventTypeSessionUpdate,
+		Session: &dto.RealtimeSession{
+			Modalities:        []string{"text"},
+			Instructions:      "be brief",
+			Voice:             "echo",
+			OutputAudioFormat: "g711_ulaw",
+			TurnDetection:     map[string]any{"type": "server_vad"},
+		},
+	})
+	event := readBridgeEvent(t, client)
+	if event.Type != dto.RealtimeEventTypeSessionUpdated || event.Session == nil {
+		t.Fatalf("event = %s, want session.updated", event.Type)
+	}
+	session := event.Session
+	if session.Instructions != "be brief" || session.Voice != "echo" || len(session.Modalities) != 1 {
+		t.Errorf("session = %+v, want the updated fields", session)
+	}
+	// 桥接模式只能输出 pcm16，且不支持服务端语音检测
+	if session.OutputAudioFormat != "pcm16" || session.TurnDetection != nil {
+		t.Errorf("output_audio_format = %q, turn_detection = %v", session.OutputAudioFormat, session.TurnDetection)
+	}
+}
+
+func TestRealtimeBridgeResponseCreate(t *testing.T) {
+	client := setupBridgeTest(t, &bridgeUpstream{})
+	sendBridgeEvent(t, client, dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdate, Session: &dto.RealtimeSession{Modalities: []string{"text"}}})
+	readBridgeEventsUntil(t, client, dto.RealtimeEventTypeSessionUpdated)
+	sendBridgeEvent(t, client, dto.RealtimeEvent{
+		Type: dto.RealtimeEventTypeConversationCreate,
+		Item: &dto.RealtimeItem{Type: "message", Role: "user", Content: []dto.RealtimeContent{{Type: "input_text", Text: "weather?"}}},
+	})
+	readBridgeEventsUntil(t, client, dto.RealtimeEventConversationItemCreated)
+
+	sendBridgeEvent(t, client, dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseCreate})
+	events := readBridgeEventsUntil(t, client, dto.RealtimeEventTypeResponseDone)
+	if got := countBridgeEvents(events, dto.RealtimeEventResponseTextDelta); got != 2 {
+		t.Errorf("response.text.delta events = %d, want 2", got)
+	}
+	done := events[len(events)-1].Response
+	if done.Status != "completed" || len(done.Output) != 1 || done.Output[0].Content[0].Text != "It is sunny." {
+		t.Errorf("response.done = %+v, want the completed text", done)
+	}
+	if done.Usage == nil || done.Usage.InputTokens != 12 || done.Usage.OutputTokens != 4 {
+		t.Errorf("usage = %+v, want the upstream usage", done.Usage)
+	}
+}
+
+func TestRealtimeBridgeResponseCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		// 取消前上游输出的内容分片，OpenAI 流式处理会暂存最后一个分片
+		chunks     []string
+		waitFor    string
+		wantOutput bool
+	}{
+		{name: "before response headers", waitFor: dto.RealtimeEventResponseCreated},
+		{name: "while streaming", chunks: []string{"Once", " upon"}, waitFor: dto.RealtimeEventResponseTextDelta, wantOutput: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &bridgeUpstream{cancelled: make(chan struct{})}
+			upstream.chat = func(w http.ResponseWriter, r *http.Request) {
+				// 读完请求体后服务端才能感知连接断开
+				_, _ = io.Copy(io.Discard, r.Body)
+				if len(tt.chunks) > 0 {
+					w.Header().Set("Content-Type", "text/event-stream")
+					for _, content := range tt.chunks {
+						_, _ = fmt.Fprintf(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", content)
+					}
+					w.(http.Flusher).Flush()
+				}
+				// 直到请求被取消才结束
+				<-r.Context().Done()
+				close(upstream.cancelled)
+			}
+			client := setupBridgeTest(t, upstream)
+			sendBridgeEvent(t, client, dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdate, Session: &dto.RealtimeSession{Modalities: []string{"text"}}})
+			readBridgeEventsUntil(t, client, dto.RealtimeEventTypeSessionUpdated)
+
+			sendBridgeEvent(t, client, dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseCreate})
+			readBridgeEventsUntil(t, client, tt.waitFor)
+			// 等上游收到请求后再取消
+			<-upstream.requests
+			sendBridgeEvent(t, client, dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseCancel})
+			events := readBridgeEventsUntil(t, client, dto.RealtimeEventTypeResponseDone)
+			done := events[len(events)-1].Response
+			if done.Status != "cancelled" {
+				t.Errorf("response.done status = %q, want cancelled", done.Status)
+			}
+			// 已生成的内容按文本估算计费
+			if done.Usage == nil || done.Usage.InputTokens == 0 || (done.Usage.OutputTokens > 0) != tt.wantOutput {
+				t.Errorf("usage = %+v, want output billed = %v", done.Usage, tt.wantOutput)
+			}
+			select {
+			case <-upstream.cancelled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("upstream request was not cancelled")
+			}
+		})
+	}
+}
+
+func TestRealtimeBridgeAudioTurn(t *testing.T) {
+	upstream := &bridgeUpstream{}
+	client := setupBridgeTest(t, upstream)
+	sendBridgeEvent(t, client, dto.RealtimeEvent{
+		Type:  dto.RealtimeEventInputAudioBufferAppend,
+		Audio: base64.StdEncoding.EncodeToString(make([]byte, 4800)),
+	})
+	sendBridgeEvent(t, client, dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseCreate})
+	events := readBridgeEventsUntil(t, client, dto.RealtimeEventTypeResponseDone)
+
+	var transcript string
+	for _, event := range events {
+		if event.Type == dto.RealtimeEventInputAudioTranscriptionCompleted {
+			transcript = event.Transcript
+		}
+	}
+	if transcript != "what is the weather" {
+		t.Errorf("input transcript = %q, want the transcription result", transcript)
+	}
+	if got := countBridgeEvents(events, dto.RealtimeEventResponseAudioTranscriptionDelta); got != 2 {
+		t.Errorf("response.audio_transcript.delta events = %d, want 2", got)
+	}
+	// 合成音频按 100ms 分片推送
+	if got := countBridgeEvents(events, dto.RealtimeEventResponseAudioDelta); got != 2 {
+		t.Errorf("response.audio.delta events = %d, want 2", got)
+	}
+	done := events[len(events)-1].Response
+	if done.Status != "completed" || done.Output[0].Content[0].Transcript != "It is sunny." {
+		t.Errorf("response.done = %+v, want the spoken reply", done)
+	}
+
+	var paths []string
+	for len(upstream.requests) > 0 {
+		paths = append(paths, <-upstream.requests)
+	}
+	want := []string{"/v1/audio/transcriptions", "/v1/chat/completions", "/v1/audio/speech"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("upstream requests = %v, want %v", paths, want)
+	}
+}
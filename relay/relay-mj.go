@@ -170,6 +170,16 @@ func coverMidjourneyTaskDto(c *gin.Context, originTask *model.Midjourney) (midjo
 	return
 }
 
+// saveMidjourneyPayerCharge 记录任务扣费的组成，任务失败退款时原路退回
+func saveMidjourneyPayerCharge(task *model.Midjourney, relayInfo *relaycommon.RelayInfo) {
+	if task == nil || task.Id == 0 {
+		return
+	}
+	if err := task.SavePayerCharge(service.GetPayerCharge(relayInfo)); err != nil {
+		common.SysError("error saving midjourney task payer charge: " + err.Error())
+	}
+}
+
 func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	startTime := time.Now().UnixNano() / int64(time.Millisecond)
 	tokenId := c.GetInt("token_id")
@@ -189,7 +199,7 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 
 	priceData := helper.ModelPriceHelperPerCall(c, relayInfo)
 
-	userQuota, err := model.GetUserQuota(userId, false)
+	userQuota, err := model.GetPayerQuota(userId, relayInfo.OrgId)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -203,6 +213,12 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 			Description: "quota_not_enough",
 		}
 	}
+	if budgetErr := service.CheckTokenBudget(c, relayInfo, priceData.Quota); budgetErr != nil {
+		return &dto.MidjourneyResponse{
+			Code:        4,
+			Description: budgetErr.Error(),
+		}
+	}
 	requestURL := getMjRequestPath(c.Request.URL.String())
 	baseURL := c.GetString("base_url")
 	fullRequestURL := fmt.Sprintf("%s%s", baseURL, requestURL)
@@ -210,11 +226,14 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	if err != nil {
 		return &mjResp.Response
 	}
+	var midjourneyTask *model.Midjourney
 	defer func() {
 		if mjResp.StatusCode == 200 && mjResp.Response.Code == 1 {
 			err := service.PostConsumeQuota(relayInfo, priceData.Quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
+			} else {
+				saveMidjourneyPayerCharge(midjourneyTask, relayInfo)
 			}
 
 			tokenName := c.GetString("token_name")
@@ -236,8 +255,9 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 		}
 	}()
 	midjResponse := &mjResp.Response
-	midjourneyTask := &model.Midjourney{
+	midjourneyTask = &model.Midjourney{
 		UserId:      userId,
+		OrgId:       relayInfo.OrgId,
 		Code:        midjResponse.Code,
 		Action:      constant.MjActionSwapFace,
 		MjId:        midjResponse.Result,
@@ -496,7 +516,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 
 	priceData := helper.ModelPriceHelperPerCall(c, relayInfo)
 
-	userQuota, err := model.GetUserQuota(userId, false)
+	userQuota, err := model.GetPayerQuota(userId, relayInfo.OrgId)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -510,6 +530,14 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 			Description: "quota_not_enough",
 		}
 	}
+	if consumeQuota {
+		if budgetErr := service.CheckTokenBudget(c, relayInfo, priceData.Quota); budgetErr != nil {
+			return &dto.MidjourneyResponse{
+				Code:        4,
+				Description: budgetErr.Error(),
+			}
+		}
+	}
 
 	midjResponseWithStatus, responseBody, err := service.DoMidjourneyHttpRequest(c, time.Second*60, fullRequestURL)
 	if err != nil {
@@ -517,11 +545,14 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	}
 	midjResponse := &midjResponseWithStatus.Response
 
+	var midjourneyTask *model.Midjourney
 	defer func() {
 		if consumeQuota && midjResponseWithStatus.StatusCode == 200 {
 			err := service.PostConsumeQuota(relayInfo, priceData.Quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
+			} else {
+				saveMidjourneyPayerCharge(midjourneyTask, relayInfo)
 			}
 			tokenName := c.GetString("token_name")
 			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s，ID %s", priceData.ModelPrice, priceData.GroupRatioInfo.GroupRatio, midjRequest.Action, midjResponse.Result)
@@ -549,8 +580,9 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	// 23-队列已满，请稍后再试 {"code":23,"description":"队列已满，请稍后尝试","result":"14001929738841620","properties":{"discordInstanceId":"1118138338562560102"}}
 	// 24-prompt包含敏感词 {"code":24,"description":"可能包含敏感词","properties":{"promptEn":"nude body","bannedWord":"nude"}}
 	// other: 提交错误，description为错误描述
-	midjourneyTask := &model.Midjourney{
+	midjourneyTask = &model.Midjourney{
 		UserId:      userId,
+		OrgId:       relayInfo.OrgId,
 		Code:        midjResponse.Code,
 		Action:      midjRequest.Action,
 		MjId:        midjResponse.Result,
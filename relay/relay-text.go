@@ -16,7 +16,6 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
-	"one-api/setting"
 	"one-api/setting/model_setting"
 	"one-api/setting/operation_setting"
 	"one-api/types"
@@ -27,6 +26,7 @@ import (
 	"github.com/shopspring/decimal"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func getAndValidateTextRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
@@ -100,12 +100,8 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		c.Set("chat_completion_web_search_context_size", textRequest.WebSearchOptions.SearchContextSize)
 	}
 
-	if setting.ShouldCheckPromptSensitive() {
-		words, err := checkRequestSensitive(textRequest, relayInfo)
-		if err != nil {
-			common.LogWarn(c, fmt.Sprintf("user sensitive words detected: %s", strings.Join(words, ", ")))
-			return types.NewError(err, types.ErrorCodeSensitiveWordsDetected)
-		}
+	if newAPIError := checkRequestGuardrails(c, textRequest, relayInfo); newAPIError != nil {
+		return newAPIError
 	}
 
 	err = helper.ModelMappedHelper(c, relayInfo, textRequest)
@@ -119,7 +115,9 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		promptTokens = value.(int)
 		relayInfo.PromptTokens = promptTokens
 	} else {
+		_, endSpan := common.StartSpan(c, "count_prompt_tokens")
 		promptTokens, err = getPromptTokens(textRequest, relayInfo)
+		endSpan()
 		// count messages token error 计算promptTokens错误
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -142,6 +140,17 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
 		}
 	}()
+
+	// 响应缓存命中时直接返回缓存内容，并按折扣计费
+	cacheKey, cacheTTL := getResponseCacheKey(c, relayInfo)
+	if cacheKey != "" {
+		if entry, ok := service.GetResponseCache(cacheKey); ok {
+			writeCachedResponse(c, relayInfo, entry)
+			postConsumeQuota(c, relayInfo, entry.Usage, preConsumedQuota, userQuota, priceData, "", textRequest)
+			return nil
+		}
+	}
+
 	includeUsage := false
 	// 判断用户是否需要返回使用情况
 	if textRequest.StreamOptions != nil && textRequest.StreamOptions.IncludeUsage {
@@ -188,16 +197,9 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 
 		// apply param override
-		if len(relayInfo.ParamOverride) > 0 {
-			reqMap := make(map[string]interface{})
-			_ = common.Unmarshal(jsonData, &reqMap)
-			for key, value := range relayInfo.ParamOverride {
-				reqMap[key] = value
-			}
-			jsonData, err = common.Marshal(reqMap)
-			if err != nil {
-				return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
-			}
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, relayInfo)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
 		}
 
 		if common.DebugEnabled {
@@ -207,7 +209,7 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	}
 
 	var httpResp *http.Response
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, requestBody)
 
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
@@ -226,12 +228,31 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newApiErr := adaptor.DoResponse(c, httpResp, relayInfo)
+	var cacheWriter *service.ResponseCacheWriter
+	if cacheKey != "" {
+		cacheWriter = service.NewResponseCacheWriter(c)
+		defer cacheWriter.Restore()
+	}
+	var guardrailWriter *service.GuardrailResponseWriter
+	if relayInfo.RelayMode == relayconstant.RelayModeChatCompletions || relayInfo.RelayMode == relayconstant.RelayModeCompletions {
+		guardrailWriter = service.NewGuardrailResponseWriter(c, service.GuardrailFormatOpenAI)
+	}
+	usage, newApiErr := doAdaptorResponse(c, adaptor, httpResp, relayInfo)
+	if guardrailWriter != nil {
+		guardrailWriter.Finish()
+	}
 	if newApiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newApiErr, statusCodeMappingStr)
 		return newApiErr
 	}
+	if newApiErr = service.CheckFallbackRefusal(c, relayInfo.OriginModelName); newApiErr != nil {
+		return newApiErr
+	}
+	// 客户端中途断开时响应不完整，不写入缓存
+	if cacheWriter != nil && !relayInfo.ClientDisconnected && (guardrailWriter == nil || !guardrailWriter.Blocked()) {
+		saveCachedResponse(cacheWriter, cacheKey, cacheTTL, relayInfo.IsStream, usage.(*dto.Usage))
+	}
 
 	if strings.HasPrefix(relayInfo.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
@@ -263,25 +284,27 @@ func getPromptTokens(textRequest *dto.GeneralOpenAIRequest, info *relaycommon.Re
 	return promptTokens, err
 }
 
-func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo) ([]string, error) {
-	var err error
-	var words []string
+func checkRequestGuardrails(c *gin.Context, textRequest *dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo) *types.NewAPIError {
+	var newAPIError *types.NewAPIError
 	switch info.RelayMode {
 	case relayconstant.RelayModeChatCompletions:
-		words, err = service.CheckSensitiveMessages(textRequest.Messages)
+		newAPIError = service.CheckMessagesGuardrails(c, textRequest.Messages)
 	case relayconstant.RelayModeCompletions:
-		words, err = service.CheckSensitiveInput(textRequest.Prompt)
-	case relayconstant.RelayModeModerations:
-		words, err = service.CheckSensitiveInput(textRequest.Input)
-	case relayconstant.RelayModeEmbeddings:
-		words, err = service.CheckSensitiveInput(textRequest.Input)
+		textRequest.Prompt, newAPIError = service.CheckInputGuardrails(c, textRequest.Prompt)
+	case relayconstant.RelayModeModerations, relayconstant.RelayModeEmbeddings:
+		textRequest.Input, newAPIError = service.CheckInputGuardrails(c, textRequest.Input)
 	}
-	return words, err
+	return newAPIError
 }
 
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *types.NewAPIError) {
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	_, endSpan := common.StartSpan(c, "quota.pre_consume", attribute.Int("quota.pre_consumed", preConsumedQuota))
+	defer endSpan()
+	if newAPIError := service.CheckTokenBudget(c, relayInfo, preConsumedQuota); newAPIError != nil {
+		return 0, 0, newAPIError
+	}
+	userQuota, err := model.GetPayerQuota(relayInfo.UserId, relayInfo.OrgId)
 	if err != nil {
 		return 0, 0, types.NewError(err, types.ErrorCodeQueryDataError)
 	}
@@ -315,7 +338,7 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		if err != nil {
 			return 0, 0, types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden)
 		}
-		err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+		err = service.PreConsumePayerQuota(relayInfo, preConsumedQuota)
 		if err != nil {
 			return 0, 0, types.NewError(err, types.ErrorCodeUpdateDataError)
 		}
@@ -338,6 +361,8 @@ func returnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, us
 
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string, requestData interface{}) {
+	span, endSpan := common.StartSpan(ctx, "quota.settle", attribute.Int("quota.pre_consumed", preConsumedQuota))
+	defer endSpan()
 	if usage == nil {
 		usage = &dto.Usage{
 			PromptTokens:     relayInfo.PromptTokens,
@@ -471,10 +496,19 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			Mul(dQuotaPerUnit)
 	}
 
+	// 命中响应缓存时按折扣计费
+	var responseCacheRatio float64
+	if relayInfo.ResponseCacheHit {
+		responseCacheRatio = operation_setting.GetResponseCacheSetting().HitQuotaRatio
+		quotaCalculateDecimal = quotaCalculateDecimal.Mul(decimal.NewFromFloat(responseCacheRatio))
+		extraContent += fmt.Sprintf("响应缓存命中，按原价 %.2f 倍计费", responseCacheRatio)
+	}
+
 	// 如果预消费配额小于 0，说明预先消费的 token 数量不够，需要额外消费
 	quota = quotaCalculateDecimal.Ceil()
 
 	quotaDelta := quota.IntPart() - int64(preConsumedQuota)
+	span.SetAttributes(attribute.Int64("quota.consumed", quota.IntPart()))
 	if quotaDelta != 0 {
 		err := service.PostConsumeQuota(relayInfo, int(quotaDelta), preConsumedQuota, true)
 		if err != nil {
@@ -529,8 +563,15 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		other["audio_input_token_count"] = audioTokens
 		other["audio_input_price"] = audioInputPrice
 	}
+	// 命中响应缓存时没有使用渠道，日志中的渠道记为 0
+	channelId := relayInfo.ChannelId
+	if relayInfo.ResponseCacheHit {
+		channelId = 0
+		other["response_cache_hit"] = true
+		other["response_cache_ratio"] = responseCacheRatio
+	}
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
-		ChannelId:        relayInfo.ChannelId,
+		ChannelId:        channelId,
 		PromptTokens:     promptTokens,
 		CompletionTokens: completionTokens,
 		ModelName:        logModel,
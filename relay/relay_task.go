@@ -60,7 +60,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	} else {
 		ratio = modelPrice * groupRatio
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetPayerQuota(relayInfo.UserId, relayInfo.OrgId)
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 		return
@@ -70,6 +70,10 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 		taskErr = service.TaskErrorWrapperLocal(errors.New("user quota is not enough"), "quota_not_enough", http.StatusForbidden)
 		return
 	}
+	if budgetErr := service.CheckTokenBudget(c, relayInfo.RelayInfo, quota); budgetErr != nil {
+		taskErr = service.TaskErrorWrapperLocal(budgetErr.Err, string(budgetErr.GetErrorCode()), budgetErr.StatusCode)
+		return
+	}
 
 	if relayInfo.OriginTaskID != "" {
 		originTask, exist, err := model.GetByTaskId(relayInfo.UserId, relayInfo.OriginTaskID)
@@ -118,6 +122,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 		return
 	}
 
+	var task *model.Task
 	defer func() {
 		// release quota
 		if relayInfo.ConsumeQuota && taskErr == nil {
@@ -125,6 +130,10 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 			err := service.PostConsumeQuota(relayInfo.RelayInfo, quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
+			} else if task != nil {
+				if err := task.SavePayerCharge(service.GetPayerCharge(relayInfo.RelayInfo)); err != nil {
+					common.SysError("error saving task payer charge: " + err.Error())
+				}
 			}
 			if quota != 0 {
 				tokenName := c.GetString("token_name")
@@ -162,7 +171,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	}
 	relayInfo.ConsumeQuota = true
 	// insert task
-	task := model.InitTask(platform, relayInfo)
+	task = model.InitTask(platform, relayInfo)
 	task.TaskID = taskID
 	task.Quota = quota
 	task.Data = taskData
@@ -0,0 +1,44 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func adaptorSpanAttributes(c *gin.Context, info *relaycommon.RelayInfo) []attribute.KeyValue {
+	return append(common.RelaySpanAttributes(c),
+		attribute.String("relay.upstream_model", info.UpstreamModelName),
+		attribute.Bool("relay.stream", info.IsStream),
+	)
+}
+
+// doAdaptorRequest 调用适配器发送上游请求，并记录 adaptor.do_request span
+func doAdaptorRequest(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	span, endSpan := common.StartSpan(c, "adaptor.do_request", adaptorSpanAttributes(c, info)...)
+	defer endSpan()
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if err != nil {
+		common.SetSpanError(span, err)
+	} else if httpResp, ok := resp.(*http.Response); ok && httpResp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", httpResp.StatusCode))
+	}
+	return resp, err
+}
+
+// doAdaptorResponse 调用适配器处理上游响应，并记录 adaptor.do_response span
+func doAdaptorResponse(c *gin.Context, adaptor channel.Adaptor, resp *http.Response, info *relaycommon.RelayInfo) (any, *types.NewAPIError) {
+	span, endSpan := common.StartSpan(c, "adaptor.do_response", adaptorSpanAttributes(c, info)...)
+	defer endSpan()
+	usage, newAPIError := adaptor.DoResponse(c, resp, info)
+	if newAPIError != nil {
+		common.SetSpanError(span, newAPIError)
+	}
+	return usage, newAPIError
+}
@@ -78,11 +78,15 @@ func RerankHelper(c *gin.Context, relayMode int) (newAPIError *types.NewAPIError
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 	}
+	jsonData, err = relaycommon.ApplyParamOverride(jsonData, relayInfo)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
+	}
 	requestBody := bytes.NewBuffer(jsonData)
 	if common.DebugEnabled {
 		println(fmt.Sprintf("Rerank request body: %s", requestBody.String()))
 	}
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -99,7 +103,7 @@ func RerankHelper(c *gin.Context, relayMode int) (newAPIError *types.NewAPIError
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newAPIError := doAdaptorResponse(c, adaptor, httpResp, relayInfo)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
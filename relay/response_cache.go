@@ -0,0 +1,73 @@
+package relay
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// getResponseCacheKey 获取响应缓存键及缓存时间，不需要缓存时返回空字符串
+func getResponseCacheKey(c *gin.Context, relayInfo *relaycommon.RelayInfo) (string, time.Duration) {
+	if relayInfo.RelayMode != relayconstant.RelayModeChatCompletions && relayInfo.RelayMode != relayconstant.RelayModeCompletions {
+		return "", 0
+	}
+	tokenTTL := common.GetContextKeyInt(c, constant.ContextKeyTokenResponseCacheTTL)
+	ttl := operation_setting.GetResponseCacheTTL(tokenTTL, relayInfo.UsingGroup)
+	if ttl <= 0 {
+		return "", 0
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		common.LogError(c, "failed to read request body for response cache: "+err.Error())
+		return "", 0
+	}
+	key, err := service.GenerateResponseCacheKey(relayInfo.UserId, relayInfo.RelayMode, relayInfo.OriginModelName, requestBody)
+	if err != nil {
+		common.LogError(c, "failed to generate response cache key: "+err.Error())
+		return "", 0
+	}
+	return key, time.Duration(ttl) * time.Second
+}
+
+// writeCachedResponse 将缓存的响应原样返回给客户端
+func writeCachedResponse(c *gin.Context, relayInfo *relaycommon.RelayInfo, entry *service.ResponseCacheEntry) {
+	relayInfo.ResponseCacheHit = true
+	common.SetContextKey(c, constant.ContextKeyResponseCacheHit, true)
+	relayInfo.IsStream = entry.IsStream
+	relayInfo.SetFirstResponseTime()
+	c.Header("X-Response-Cache", "HIT")
+	if entry.IsStream {
+		helper.SetEventStreamHeaders(c)
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write(entry.Body)
+		c.Writer.Flush()
+		return
+	}
+	var textResponse dto.OpenAITextResponse
+	if err := common.Unmarshal(entry.Body, &textResponse); err == nil {
+		c.Set("response_data", &textResponse)
+	}
+	c.Data(http.StatusOK, entry.ContentType, entry.Body)
+}
+
+func saveCachedResponse(writer *service.ResponseCacheWriter, key string, ttl time.Duration, isStream bool, usage *dto.Usage) {
+	entry := writer.Entry(isStream, usage)
+	if entry == nil {
+		return
+	}
+	gopool.Go(func() {
+		if err := service.SetResponseCache(key, entry, ttl); err != nil {
+			common.SysError("failed to save response cache: " + err.Error())
+		}
+	})
+}
@@ -12,7 +12,6 @@ import (
 	relaycommon "one-api/relay/common"
 	"one-api/relay/helper"
 	"one-api/service"
-	"one-api/setting"
 	"one-api/setting/model_setting"
 	"one-api/types"
 	"strings"
@@ -36,9 +35,14 @@ func getAndValidateResponsesRequest(c *gin.Context) (*dto.OpenAIResponsesRequest
 
 }
 
-func checkInputSensitive(textRequest *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) ([]string, error) {
-	sensitiveWords, err := service.CheckSensitiveInput(textRequest.Input)
-	return sensitiveWords, err
+// checkInputGuardrails 对 input 中的文本执行输入护栏，脱敏结果写回请求
+func checkInputGuardrails(c *gin.Context, textRequest *dto.OpenAIResponsesRequest) *types.NewAPIError {
+	input, newAPIError := service.CheckResponsesInputGuardrails(c, textRequest.Input)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	textRequest.Input = input
+	return nil
 }
 
 func getInputTokens(req *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) int {
@@ -56,12 +60,8 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 
 	relayInfo := relaycommon.GenRelayInfoResponses(c, req)
 
-	if setting.ShouldCheckPromptSensitive() {
-		sensitiveWords, err := checkInputSensitive(req, relayInfo)
-		if err != nil {
-			common.LogWarn(c, fmt.Sprintf("user sensitive words detected: %s", strings.Join(sensitiveWords, ", ")))
-			return types.NewError(err, types.ErrorCodeSensitiveWordsDetected)
-		}
+	if newAPIError := checkInputGuardrails(c, req); newAPIError != nil {
+		return newAPIError
 	}
 
 	err = helper.ModelMappedHelper(c, relayInfo, req)
@@ -113,19 +113,9 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 		}
 		// apply param override
-		if len(relayInfo.ParamOverride) > 0 {
-			reqMap := make(map[string]interface{})
-			err = json.Unmarshal(jsonData, &reqMap)
-			if err != nil {
-				return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
-			}
-			for key, value := range relayInfo.ParamOverride {
-				reqMap[key] = value
-			}
-			jsonData, err = json.Marshal(reqMap)
-			if err != nil {
-				return types.NewError(err, types.ErrorCodeConvertRequestFailed)
-			}
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, relayInfo)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid)
 		}
 
 		if common.DebugEnabled {
@@ -135,7 +125,7 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	}
 
 	var httpResp *http.Response
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -153,12 +143,19 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	guardrailWriter := service.NewGuardrailResponseWriter(c, service.GuardrailFormatResponses)
+	usage, newAPIError := doAdaptorResponse(c, adaptor, httpResp, relayInfo)
+	if guardrailWriter != nil {
+		guardrailWriter.Finish()
+	}
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
 		return newAPIError
 	}
+	if newAPIError = service.CheckFallbackRefusal(c, relayInfo.OriginModelName); newAPIError != nil {
+		return newAPIError
+	}
 
 	if strings.HasPrefix(relayInfo.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
@@ -44,6 +44,17 @@ func WssHelper(c *gin.Context, ws *websocket.Conn) (newAPIError *types.NewAPIErr
 		}
 	}()
 
+	if isRealtimeBridgeChannel(relayInfo) {
+		var usage *dto.RealtimeUsage
+		usage, newAPIError = realtimeBridgeHelper(c, relayInfo)
+		if newAPIError != nil {
+			return newAPIError
+		}
+		service.PostWssConsumeQuota(c, relayInfo, relayInfo.UpstreamModelName, usage, preConsumedQuota,
+			userQuota, priceData, "Realtime 桥接")
+		return nil
+	}
+
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
 		return types.NewError(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), types.ErrorCodeInvalidApiType)
@@ -54,7 +65,7 @@ func WssHelper(c *gin.Context, ws *websocket.Conn) (newAPIError *types.NewAPIErr
 	//requestBody = bytes.NewBuffer(firstWssRequest.([]byte))
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
-	resp, err := adaptor.DoRequest(c, relayInfo, nil)
+	resp, err := doAdaptorRequest(c, adaptor, relayInfo, nil)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeDoRequestFailed)
 	}
@@ -64,7 +75,7 @@ func WssHelper(c *gin.Context, ws *websocket.Conn) (newAPIError *types.NewAPIErr
 		defer relayInfo.TargetWs.Close()
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, nil, relayInfo)
+	usage, newAPIError := doAdaptorResponse(c, adaptor, nil, relayInfo)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
@@ -64,6 +64,13 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.POST("/amount", controller.RequestAmount)
 				selfRoute.POST("/stripe/pay", middleware.CriticalRateLimit(), controller.RequestStripePay)
 				selfRoute.POST("/stripe/amount", controller.RequestStripeAmount)
+				selfRoute.GET("/subscription/plans", controller.GetEnabledSubscriptionPlans)
+				selfRoute.GET("/self/subscription", controller.GetSelfSubscription)
+				selfRoute.GET("/self/ledger", controller.GetSelfQuotaLedger)
+				selfRoute.GET("/self/statements", controller.GetSelfStatements)
+				selfRoute.GET("/self/statements/:period", controller.GetSelfStatement)
+				selfRoute.POST("/self/subscription/cancel", controller.CancelSelfSubscription)
+				selfRoute.POST("/subscription/stripe/pay", middleware.CriticalRateLimit(), controller.RequestStripeSubscription)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
 			}
@@ -80,6 +87,55 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.DELETE("/:id", controller.DeleteUser)
 			}
 		}
+		orgRoute := apiRouter.Group("/org")
+		orgRoute.Use(middleware.UserAuth())
+		{
+			orgRoute.GET("/self", controller.GetSelfOrganizations)
+			orgRoute.POST("/", controller.CreateOrganization)
+			orgRoute.GET("/:id", controller.GetOrganization)
+			orgRoute.PUT("/:id", controller.UpdateOrganization)
+			orgRoute.DELETE("/:id", controller.DeleteOrganization)
+			orgRoute.GET("/:id/members", controller.GetOrganizationMembers)
+			orgRoute.POST("/:id/members", controller.AddOrganizationMember)
+			orgRoute.PUT("/:id/members/:user_id", controller.UpdateOrganizationMember)
+			orgRoute.DELETE("/:id/members/:user_id", controller.RemoveOrganizationMember)
+			orgRoute.POST("/:id/transfer", controller.TransferOrganizationOwner)
+			orgRoute.POST("/:id/deposit", controller.DepositOrganizationQuota)
+			orgRoute.POST("/:id/allocate", controller.AllocateOrganizationQuota)
+			orgRoute.GET("/:id/tokens", controller.GetOrganizationTokens)
+			orgRoute.GET("/:id/logs", controller.GetOrganizationLogs)
+			orgRoute.GET("/:id/usage", controller.GetOrganizationUsage)
+
+			orgAdminRoute := orgRoute.Group("/")
+			orgAdminRoute.Use(middleware.AdminAuth())
+			{
+				orgAdminRoute.GET("/", controller.GetAllOrganizations)
+				orgAdminRoute.PUT("/manage", controller.ManageOrganization)
+			}
+		}
+		subscriptionRoute := apiRouter.Group("/subscription")
+		subscriptionRoute.Use(middleware.AdminAuth())
+		{
+			subscriptionRoute.GET("/plans", controller.GetSubscriptionPlans)
+			subscriptionRoute.POST("/plans", controller.AddSubscriptionPlan)
+			subscriptionRoute.PUT("/plans", controller.UpdateSubscriptionPlan)
+			subscriptionRoute.DELETE("/plans/:id", controller.DeleteSubscriptionPlan)
+			subscriptionRoute.GET("/", controller.GetAllUserSubscriptions)
+			subscriptionRoute.POST("/", controller.AssignUserSubscription)
+			subscriptionRoute.POST("/:id/cancel", controller.CancelUserSubscription)
+		}
+		statementRoute := apiRouter.Group("/statement")
+		statementRoute.Use(middleware.AdminAuth())
+		{
+			statementRoute.GET("/", controller.GetAllStatements)
+			statementRoute.GET("/:id", controller.GetStatement)
+			statementRoute.POST("/regenerate", controller.RegenerateStatements)
+		}
+		ledgerRoute := apiRouter.Group("/ledger")
+		{
+			ledgerRoute.GET("/", middleware.AdminAuth(), controller.GetQuotaLedger)
+			ledgerRoute.GET("/reconcile", middleware.RootAuth(), controller.ReconcileQuotaLedger)
+		}
 		optionRoute := apiRouter.Group("/option")
 		optionRoute.Use(middleware.RootAuth())
 		{
@@ -101,7 +157,15 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/health", controller.GetChannelHealth)
+			channelRoute.POST("/health/:id/reset", controller.ResetChannelHealth)
+			channelRoute.GET("/capabilities", controller.GetChannelCapabilities)
 			channelRoute.GET("/:id", controller.GetChannel)
+			channelRoute.GET("/:id/keys", controller.GetChannelKeyStatuses)
+			channelRoute.GET("/:id/capabilities", controller.GetChannelCapabilities)
+			channelRoute.POST("/:id/capabilities/probe", controller.ProbeChannelCapabilities)
+			channelRoute.POST("/:id/param_override/dry_run", controller.DryRunParamOverride)
+			channelRoute.POST("/:id/key/reveal", middleware.RootAuth(), controller.RevealChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
@@ -127,6 +191,7 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
+			tokenRoute.GET("/:id/budget", controller.GetTokenBudget)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
@@ -179,6 +244,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/chat-logs", controller.GetChatLogs)
 		apiRouter.GET("/chat-logs/duplicates", controller.GetDuplicatePrompts)
 		apiRouter.GET("/chat-logs/longest", controller.GetLongestConversations)
+		apiRouter.GET("/chat-logs/conversations", middleware.UserAuth(), controller.GetChatLogConversations)
 
 		// 管理员专用聊天日志路由
 		adminChatLogRouter := apiRouter.Group("/admin/chat-logs")
@@ -188,6 +254,8 @@ func SetApiRouter(router *gin.Engine) {
 			adminChatLogRouter.GET("/export", controller.AdminExportChatLogs)
 			adminChatLogRouter.GET("/stats", controller.AdminGetChatLogStats)
 			adminChatLogRouter.DELETE("/", controller.AdminDeleteChatLogs)
+			adminChatLogRouter.GET("/retention/preview", controller.AdminPreviewChatLogRetention)
+			adminChatLogRouter.POST("/retention/purge", controller.AdminPurgeChatLogs)
 		}
 	}
 }
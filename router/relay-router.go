@@ -25,14 +25,31 @@ func SetRelayRouter(router *gin.Engine) {
 		playgroundRouter.POST("/chat/completions", controller.Playground)
 	}
 	relayV1Router := router.Group("/v1")
-	relayV1Router.Use(middleware.TokenAuth())
-	relayV1Router.Use(middleware.ModelRequestRateLimit())
+	relayV1Router.Use(middleware.RelayTokenAuth()...)
 	{
 		// WebSocket 路由
 		wsRouter := relayV1Router.Group("")
 		wsRouter.Use(middleware.Distribute())
 		wsRouter.GET("/realtime", controller.WssRelay)
 	}
+	{
+		// 文件与批处理接口不绑定模型，无需渠道分发
+		fileRouter := relayV1Router.Group("")
+		fileRouter.GET("/files", controller.ListFiles)
+		fileRouter.POST("/files", controller.UploadFile)
+		fileRouter.DELETE("/files/:id", controller.DeleteFile)
+		fileRouter.GET("/files/:id", controller.RetrieveFile)
+		fileRouter.GET("/files/:id/content", controller.RetrieveFileContent)
+		fileRouter.POST("/batches", controller.CreateBatch)
+		fileRouter.GET("/batches", controller.ListBatches)
+		fileRouter.GET("/batches/:id", controller.RetrieveBatch)
+		fileRouter.POST("/batches/:id/cancel", controller.CancelBatch)
+	}
+	{
+		// 续传断开的流式响应，内容来自原请求的续传缓冲区，不经过渠道分发也不重复计费
+		streamRouter := relayV1Router.Group("/streams")
+		streamRouter.GET("/:request_id/resume", controller.ResumeStream)
+	}
 	{
 		//http router
 		httpRouter := relayV1Router.Group("")
@@ -50,11 +67,6 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/audio/translations", controller.Relay)
 		httpRouter.POST("/audio/speech", controller.Relay)
 		httpRouter.POST("/responses", controller.Relay)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
 		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
@@ -74,7 +86,7 @@ func SetRelayRouter(router *gin.Engine) {
 	//relayMjRouter.Use()
 
 	relaySunoRouter := router.Group("/suno")
-	relaySunoRouter.Use(middleware.TokenAuth(), middleware.Distribute())
+	relaySunoRouter.Use(middleware.TokenAuth(), middleware.TokenRequestRateLimit(), middleware.Distribute())
 	{
 		relaySunoRouter.POST("/submit/:action", controller.RelayTask)
 		relaySunoRouter.POST("/fetch", controller.RelayTask)
@@ -82,8 +94,7 @@ func SetRelayRouter(router *gin.Engine) {
 	}
 
 	relayGeminiRouter := router.Group("/v1beta")
-	relayGeminiRouter.Use(middleware.TokenAuth())
-	relayGeminiRouter.Use(middleware.ModelRequestRateLimit())
+	relayGeminiRouter.Use(middleware.RelayTokenAuth()...)
 	relayGeminiRouter.Use(middleware.Distribute())
 	{
 		// Gemini API 路径格式: /v1beta/models/{model_name}:{action}
@@ -93,7 +104,7 @@ func SetRelayRouter(router *gin.Engine) {
 
 func registerMjRouterGroup(relayMjRouter *gin.RouterGroup) {
 	relayMjRouter.GET("/image/:id", relay.RelayMidjourneyImage)
-	relayMjRouter.Use(middleware.TokenAuth(), middleware.Distribute())
+	relayMjRouter.Use(middleware.TokenAuth(), middleware.TokenRequestRateLimit(), middleware.Distribute())
 	{
 		relayMjRouter.POST("/submit/action", controller.RelayMidjourney)
 		relayMjRouter.POST("/submit/shorten", controller.RelayMidjourney)
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isChannelHealthFailure 判断错误是否应计入渠道健康统计，本地错误和普通客户端错误不计入
+func isChannelHealthFailure(err *types.NewAPIError) (failed bool, counted bool) {
+	if err == nil {
+		return false, true
+	}
+	if types.IsChannelError(err) {
+		return true, true
+	}
+	if types.IsLocalError(err) {
+		return false, false
+	}
+	switch {
+	case err.StatusCode >= 500,
+		err.StatusCode == http.StatusTooManyRequests,
+		err.StatusCode == http.StatusRequestTimeout,
+		err.StatusCode == http.StatusUnauthorized,
+		err.StatusCode == http.StatusForbidden:
+		return true, true
+	}
+	return false, false
+}
+
+// RecordChannelHealth 记录本次请求对当前渠道（及多密钥渠道的密钥）的健康统计
+func RecordChannelHealth(c *gin.Context, channelId int, err *types.NewAPIError, latency time.Duration) {
+	failed, counted := isChannelHealthFailure(err)
+	if !counted {
+		return
+	}
+	keyIndex := -1
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		keyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	// 流式请求的总耗时取决于输出长度，不参与延迟统计
+	if strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+		latency = 0
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	model.RecordChannelHealth(channelId, keyIndex, failed, latency, errMsg)
+}
+
+// ReleaseChannelHealth 请求没有发往上游（如命中响应缓存）时归还选中渠道及密钥的半开探测名额
+func ReleaseChannelHealth(c *gin.Context, channelId int) {
+	keyIndex := -1
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		keyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	model.ReleaseChannelHealth(channelId, keyIndex)
+}
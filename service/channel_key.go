@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isKeyRateLimitError 上游返回 429 且不是额度耗尽时，视为密钥被临时限流
+func isKeyRateLimitError(err *types.NewAPIError) bool {
+	if err == nil || types.IsLocalError(err) || err.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	oaiErr := err.ToOpenAIError()
+	return oaiErr.Type != "insufficient_quota" && oaiErr.Code != "insufficient_quota"
+}
+
+// RecordChannelKeyUsage 记录多密钥渠道本次所用密钥的 token 用量与错误，密钥被上游限流时进入冷却
+func RecordChannelKeyUsage(c *gin.Context, channelId int, err *types.NewAPIError) {
+	if !common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		return
+	}
+	keyIndex := common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	if err == nil {
+		usage, ok := common.GetContextKeyType[model.RecordConsumeLogParams](c, constant.ContextKeyConsumeLogParams)
+		if ok && usage.ChannelId == channelId {
+			model.RecordChannelKeyTokens(channelId, keyIndex, usage.PromptTokens+usage.CompletionTokens)
+		}
+		return
+	}
+	if types.IsLocalError(err) {
+		return
+	}
+	model.RecordChannelKeyError(channelId, keyIndex, err.Error())
+	if !isKeyRateLimitError(err) {
+		return
+	}
+	channelInfo, infoErr := model.CacheGetChannelInfo(channelId)
+	if infoErr != nil {
+		return
+	}
+	cooldown := model.CooldownChannelKey(channelId, keyIndex, err.RetryAfter, *channelInfo)
+	common.LogWarn(c, fmt.Sprintf("channel #%d key #%d rate limited by upstream, cooling down for %s", channelId, keyIndex, cooldown))
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"regexp"
+)
+
+// 常见服务商的密钥格式
+var apiKeyRegex = regexp.MustCompile(`sk-(?:ant-|proj-)?[A-Za-z0-9_\-]{16,}|AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_\-]{35}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9\-]{10,}|(?i:bearer)\s+[A-Za-z0-9._\-]{20,}`)
+
+// getChatLogRedactor 按对话日志设置构造脱敏函数，未配置任何规则时返回 nil
+func getChatLogRedactor() func(string) string {
+	chatLogSetting := operation_setting.GetChatLogSetting()
+	rules := chatLogSetting.RedactRules
+	patterns := chatLogSetting.CustomRedactPatterns
+	if len(rules) == 0 && len(patterns) == 0 {
+		return nil
+	}
+	return func(text string) string {
+		if text == "" {
+			return text
+		}
+		var matches []guardrailMatch
+		for _, detector := range piiDetectorOrder {
+			if common.StringsContains(rules, detector) {
+				matches = append(matches, detectPII(detector, text)...)
+			}
+		}
+		if common.StringsContains(rules, operation_setting.ChatLogRedactApiKey) {
+			for _, loc := range apiKeyRegex.FindAllStringIndex(text, -1) {
+				matches = append(matches, guardrailMatch{start: loc[0], end: loc[1], label: operation_setting.ChatLogRedactApiKey, pii: true})
+			}
+		}
+		for _, pattern := range patterns {
+			re, err := getGuardrailRegex(pattern)
+			if err != nil {
+				continue
+			}
+			for _, loc := range re.FindAllStringIndex(text, -1) {
+				if loc[0] == loc[1] {
+					continue
+				}
+				matches = append(matches, guardrailMatch{start: loc[0], end: loc[1], label: pattern})
+			}
+		}
+		if len(matches) == 0 {
+			return text
+		}
+		return redactGuardrailMatches(text, dedupeGuardrailMatches(matches))
+	}
+}
@@ -1,11 +1,13 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"one-api/common"
 	"one-api/dto"
 	"one-api/model"
 	relaycommon "one-api/relay/common"
+	"one-api/setting/operation_setting"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -39,6 +41,10 @@ func LogChatInteraction(c *gin.Context, relayInfo *relaycommon.RelayInfo, reques
 	if relayInfo.IsStream {
 		other["stream"] = true
 	}
+	skipContent := operation_setting.IsChatLogContentDisabled(relayInfo.UsingGroup)
+	if skipContent {
+		other["content_disabled"] = true
+	}
 
 	// Create chat log parameters
 	params := model.ChatLogParams{
@@ -49,6 +55,7 @@ func LogChatInteraction(c *gin.Context, relayInfo *relaycommon.RelayInfo, reques
 		ModelName:      relayInfo.OriginModelName,
 		ChannelID:      channelID,
 		ChannelName:    channelName,
+		Group:          relayInfo.UsingGroup,
 		RequestType:    requestType,
 		RequestData:    requestData,
 		ResponseData:   responseData,
@@ -60,6 +67,8 @@ func LogChatInteraction(c *gin.Context, relayInfo *relaycommon.RelayInfo, reques
 		UserIP:         c.ClientIP(),
 		UserAgent:      c.GetHeader("User-Agent"),
 		Other:          other,
+		RedactContent:  getChatLogRedactor(),
+		SkipContent:    skipContent,
 	}
 
 	// Create the chat log entry asynchronously to avoid blocking the response
@@ -131,6 +140,11 @@ func GetLongestConversations(userID int, limit, offset int) ([]model.ChatLog, er
 	return model.GetLongestChats(userID, limit, offset)
 }
 
+// GetChatLogConversations provides an API to retrieve a user's chat logs threaded by conversation
+func GetChatLogConversations(userID int, keyword, modelName string, limit, offset int) ([]model.ChatLogConversation, int64, error) {
+	return model.GetChatLogConversations(userID, keyword, modelName, limit, offset)
+}
+
 // GetAllChatLogsAdmin provides admin access to all users' chat logs with filtering
 func GetAllChatLogsAdmin(limit, offset int, conditions []string, args []interface{}) ([]model.ChatLog, int64, error) {
 	var logs []model.ChatLog
@@ -156,9 +170,15 @@ func GetAllChatLogsAdmin(limit, offset int, conditions []string, args []interfac
 		Limit(limit).Offset(offset).
 		Find(&logs).Error
 
+	model.DecryptChatLogs(logs)
 	return logs, total, err
 }
 
+// PurgeChatLogs applies the chat log retention policies, or only reports what would be purged when dryRun is set
+func PurgeChatLogs(ctx context.Context, dryRun bool) ([]model.ChatLogRetentionReport, error) {
+	return model.PurgeChatLogs(ctx, dryRun)
+}
+
 // GetChatLogStats provides statistics about chat logs for admin dashboard
 func GetChatLogStats(startTime, endTime int64) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeResponseWriter 将适配器输出的 OpenAI 格式响应（含流式）实时转换为 Claude Messages 格式，
+// 使非 Claude 渠道也能通过 /v1/messages 访问
+type ClaudeResponseWriter struct {
+	gin.ResponseWriter
+	c    *gin.Context
+	info *relaycommon.RelayInfo
+
+	isStream bool
+	decided  bool
+	buf      helper.SSELineBuffer
+
+	// 流式转换状态
+	started    bool
+	blockIndex int
+	blockType  string
+	// 尚未结束的内容块，并行的工具调用块同时保持打开
+	openBlocks []int
+	// 工具调用序号到内容块序号的映射
+	toolBlocks   map[int]int
+	finishReason string
+}
+
+func NewClaudeResponseWriter(c *gin.Context, info *relaycommon.RelayInfo) *ClaudeResponseWriter {
+	writer := &ClaudeResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		info:           info,
+		blockIndex:     -1,
+		toolBlocks:     make(map[int]int),
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *ClaudeResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.isStream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func (w *ClaudeResponseWriter) Write(data []byte) (int, error) {
+	w.decide()
+	w.buf.Write(data)
+	if w.isStream {
+		w.buf.ProcessLines(w.processLine)
+	}
+	return len(data), nil
+}
+
+func (w *ClaudeResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 非流式响应需要在转换完成后一次性写出，此时不向下游刷新
+func (w *ClaudeResponseWriter) Flush() {
+	w.decide()
+	if w.isStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *ClaudeResponseWriter) processLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		// SSE 注释（如 ping 保活）原样转发
+		_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		w.ResponseWriter.Flush()
+		return
+	}
+	data, ok := helper.SSEData(line)
+	if !ok || data == "" || data == "[DONE]" {
+		return
+	}
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+		common.LogError(w.c, "error unmarshalling stream response for claude conversion: "+err.Error())
+		return
+	}
+	w.handleChunk(&chunk)
+}
+
+func (w *ClaudeResponseWriter) send(resp *dto.ClaudeResponse) {
+	w.sendEvent(resp.Type, resp)
+}
+
+func (w *ClaudeResponseWriter) sendEvent(eventType string, payload any) {
+	jsonData, err := common.Marshal(payload)
+	if err != nil {
+		common.SysError("error marshalling claude stream response: " + err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, jsonData))
+	w.ResponseWriter.Flush()
+}
+
+func (w *ClaudeResponseWriter) start(id string, model string) {
+	if w.started {
+		return
+	}
+	w.started = true
+	if id == "" {
+		id = "msg_" + common.GetUUID()
+	}
+	if model == "" {
+		model = w.info.OriginModelName
+	}
+	msg := &dto.ClaudeMediaMessage{
+		Id:    id,
+		Type:  "message",
+		Role:  "assistant",
+		Model: model,
+		Usage: &dto.ClaudeUsage{
+			InputTokens: w.info.PromptTokens,
+		},
+	}
+	msg.SetContent(make([]any, 0))
+	w.send(&dto.ClaudeResponse{
+		Type:    "message_start",
+		Message: msg,
+	})
+}
+
+func (w *ClaudeResponseWriter) closeBlocks() {
+	for _, index := range w.openBlocks {
+		w.send(generateStopBlock(index))
+	}
+	w.openBlocks = w.openBlocks[:0]
+	w.blockType = ""
+}
+
+// openBlock 开启新的内容块，block 为 content_block 内容。上游可能交错返回多个工具调用的参数，
+// 连续的工具调用块保持打开，直到出现其他类型的内容块或响应结束
+func (w *ClaudeResponseWriter) openBlock(blockType string, block any) {
+	if blockType != "tool_use" || w.blockType != "tool_use" {
+		w.closeBlocks()
+	}
+	w.blockIndex++
+	w.blockType = blockType
+	w.openBlocks = append(w.openBlocks, w.blockIndex)
+	w.sendEvent("content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         w.blockIndex,
+		"content_block": block,
+	})
+}
+
+func (w *ClaudeResponseWriter) sendDelta(index int, delta *dto.ClaudeMediaMessage) {
+	resp := &dto.ClaudeResponse{
+		Type:  "content_block_delta",
+		Delta: delta,
+	}
+	resp.SetIndex(index)
+	w.send(resp)
+}
+
+func (w *ClaudeResponseWriter) handleChunk(chunk *dto.ChatCompletionsStreamResponse) {
+	w.start(chunk.Id, chunk.Model)
+	if len(chunk.Choices) == 0 {
+		return
+	}
+	choice := chunk.Choices[0]
+	if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+		if w.blockType != "thinking" {
+			w.openBlock("thinking", map[string]any{"type": "thinking", "thinking": ""})
+		}
+		w.sendDelta(w.blockIndex, &dto.ClaudeMediaMessage{Type: "thinking_delta", Thinking: reasoning})
+	}
+	if text := choice.Delta.GetContentString(); text != "" {
+		if w.blockType != "text" {
+			w.openBlock("text", map[string]any{"type": "text", "text": ""})
+		}
+		w.sendDelta(w.blockIndex, &dto.ClaudeMediaMessage{Type: "text_delta", Text: common.GetPointer[string](text)})
+	}
+	for i, toolCall := range choice.Delta.ToolCalls {
+		toolIndex := i
+		if toolCall.Index != nil {
+			toolIndex = *toolCall.Index
+		}
+		blockIndex, ok := w.toolBlocks[toolIndex]
+		if !ok {
+			id := toolCall.ID
+			if id == "" {
+				id = "toolu_" + common.GetUUID()
+			}
+			w.openBlock("tool_use", map[string]any{
+				"type":  "tool_use",
+				"id":    id,
+				"name":  toolCall.Function.Name,
+				"input": map[string]any{},
+			})
+			blockIndex = w.blockIndex
+			w.toolBlocks[toolIndex] = blockIndex
+		}
+		// 工具调用块已因其他内容块结束时无法继续追加参数
+		if toolCall.Function.Arguments != "" && slices.Contains(w.openBlocks, blockIndex) {
+			w.sendDelta(blockIndex, &dto.ClaudeMediaMessage{
+				Type:        "input_json_delta",
+				PartialJson: common.GetPointer[string](toolCall.Function.Arguments),
+			})
+		}
+	}
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		w.finishReason = *choice.FinishReason
+	}
+}
+
+func claudeUsageFromOpenAI(usage *dto.Usage) *dto.ClaudeUsage {
+	return &dto.ClaudeUsage{
+		InputTokens:              usage.PromptTokens,
+		OutputTokens:             usage.CompletionTokens,
+		CacheCreationInputTokens: usage.PromptTokensDetails.CachedCreationTokens,
+		CacheReadInputTokens:     usage.PromptTokensDetails.CachedTokens,
+	}
+}
+
+// Finish 写出剩余的 Claude 事件（流式）或转换后的完整响应（非流式），并恢复原始 Writer
+func (w *ClaudeResponseWriter) Finish(usage *dto.Usage) {
+	defer w.Restore()
+	if usage == nil {
+		usage = &dto.Usage{PromptTokens: w.info.PromptTokens}
+	}
+	w.decide()
+	if w.isStream {
+		w.buf.FlushLines(w.processLine)
+		w.start("", "")
+		w.closeBlocks()
+		w.send(&dto.ClaudeResponse{
+			Type:  "message_delta",
+			Usage: claudeUsageFromOpenAI(usage),
+			Delta: &dto.ClaudeMediaMessage{
+				StopReason: common.GetPointer[string](stopReasonOpenAI2Claude(w.finishReason)),
+			},
+		})
+		w.send(&dto.ClaudeResponse{Type: "message_stop"})
+		return
+	}
+
+	var openAIResponse dto.OpenAITextResponse
+	if err := common.Unmarshal(w.buf.Bytes(), &openAIResponse); err != nil {
+		common.LogError(w.c, "error unmarshalling response for claude conversion: "+err.Error())
+		w.writeBody(w.buf.Bytes())
+		return
+	}
+	claudeResponse := ResponseOpenAI2Claude(&openAIResponse, w.info)
+	claudeResponse.Usage = claudeUsageFromOpenAI(usage)
+	body, err := common.Marshal(claudeResponse)
+	if err != nil {
+		common.LogError(w.c, "error marshalling claude response: "+err.Error())
+		w.writeBody(w.buf.Bytes())
+		return
+	}
+	w.writeBody(body)
+}
+
+func (w *ClaudeResponseWriter) writeBody(body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// Restore 恢复原始 Writer，转换失败时由调用方按原逻辑返回错误
+func (w *ClaudeResponseWriter) Restore() {
+	w.c.Writer = w.ResponseWriter
+}
+
+// claudeToolChoiceToOpenAI 将 Claude 的 tool_choice 转换为 OpenAI 格式
+func claudeToolChoiceToOpenAI(toolChoice any) any {
+	choice, err := common.Any2Type[dto.ClaudeToolChoice](toolChoice)
+	if err != nil {
+		return nil
+	}
+	switch choice.Type {
+	case "auto":
+		return "auto"
+	case "any":
+		return "required"
+	case "none":
+		return "none"
+	case "tool":
+		return map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": choice.Name,
+			},
+		}
+	}
+	return nil
+}
+
+func parseToolArguments(arguments string) any {
+	var input map[string]any
+	if arguments == "" {
+		return map[string]any{}
+	}
+	if err := json.Unmarshal([]byte(arguments), &input); err != nil {
+		return arguments
+	}
+	return input
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type claudeTestEvent struct {
+	Event string
+	Data  map[string]any
+}
+
+// runClaudeWriter 依次写入 OpenAI 流式分片，返回转换后的 Claude 事件
+func runClaudeWriter(t *testing.T, chunks []string, usage *dto.Usage) []claudeTestEvent {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	writer := NewClaudeResponseWriter(c, &relaycommon.RelayInfo{OriginModelName: "gpt-4o", PromptTokens: 10})
+	c.Header("Content-Type", "text/event-stream")
+	for _, chunk := range chunks {
+		_, _ = c.Writer.WriteString(chunk)
+	}
+	writer.Finish(usage)
+
+	var events []claudeTestEvent
+	for _, block := range strings.Split(recorder.Body.String(), "\n\n") {
+		event, data, ok := strings.Cut(block, "\ndata: ")
+		if !ok {
+			continue
+		}
+		var payload map[string]any
+		if err := common.UnmarshalJsonStr(data, &payload); err != nil {
+			t.Fatalf("invalid event data %q: %v", data, err)
+		}
+		events = append(events, claudeTestEvent{Event: strings.TrimPrefix(event, "event: "), Data: payload})
+	}
+	return events
+}
+
+func openAIChunk(delta string, finishReason string) string {
+	finish := "null"
+	if finishReason != "" {
+		finish = `"` + finishReason + `"`
+	}
+	return `data: {"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":` + delta + `,"finish_reason":` + finish + `}]}` + "\n\n"
+}
+
+func TestClaudeResponseWriterStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		usage      *dto.Usage
+		events     []string
+		deltas     map[int]string
+		blocks     map[int]string
+		stopReason string
+	}{
+		{
+			name: "text",
+			chunks: []string{
+				openAIChunk(`{"role":"assistant","content":"Hel"}`, ""),
+				openAIChunk(`{"content":"lo"}`, ""),
+				openAIChunk(`{}`, "stop"),
+				"data: [DONE]\n\n",
+			},
+			events: []string{"message_start", "content_block_start", "content_block_delta", "content_block_delta",
+				"content_block_stop", "message_delta", "message_stop"},
+			deltas:     map[int]string{0: "Hello"},
+			blocks:     map[int]string{0: "text"},
+			stopReason: "end_turn",
+		},
+		{
+			name: "thinking then text",
+			chunks: []string{
+				openAIChunk(`{"reasoning_content":"let me think"}`, ""),
+				openAIChunk(`{"content":"42"}`, ""),
+				openAIChunk(`{}`, "length"),
+			},
+			events: []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop",
+				"content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			deltas:     map[int]string{0: "let me think", 1: "42"},
+			blocks:     map[int]string{0: "thinking", 1: "text"},
+			stopReason: "max_tokens",
+		},
+		{
+			name: "tool use",
+			chunks: []string{
+				openAIChunk(`{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}`, ""),
+				openAIChunk(`{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}`, ""),
+				openAIChunk(`{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}`, ""),
+				openAIChunk(`{}`, "tool_calls"),
+			},
+			events: []string{"message_start", "content_block_start", "content_block_delta", "content_block_delta",
+				"content_block_stop", "message_delta", "message_stop"},
+			deltas:     map[int]string{0: `{"city":"Paris"}`},
+			blocks:     map[int]string{0: "tool_use"},
+			stopReason: "tool_use",
+		},
+		{
+			name: "interleaved parallel tool calls",
+			chunks: []string{
+				openAIChunk(`{"content":"Checking."}`, ""),
+				openAIChunk(`{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}`, ""),
+				openAIChunk(`{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"get_time","arguments":""}}]}`, ""),
+				openAIChunk(`{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":\"Paris\"}"}}]}`, ""),
+				openAIChunk(`{"tool_calls":[{"index":1,"function":{"arguments":"{\"tz\":\"CET\"}"}}]}`, ""),
+				openAIChunk(`{}`, "tool_calls"),
+			},
+			events: []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop",
+				"content_block_start", "content_block_start", "content_block_delta", "content_block_delta",
+				"content_block_stop", "content_block_stop", "message_delta", "message_stop"},
+			deltas:     map[int]string{0: "Checking.", 1: `{"city":"Paris"}`, 2: `{"tz":"CET"}`},
+			blocks:     map[int]string{0: "text", 1: "tool_use", 2: "tool_use"},
+			stopReason: "tool_use",
+		},
+		{
+			name: "chunks split across writes",
+			chunks: func() []string {
+				full := openAIChunk(`{"content":"split"}`, "stop")
+				return []string{full[:20], full[20:]}
+			}(),
+			usage: &dto.Usage{PromptTokens: 12, CompletionTokens: 3},
+			events: []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop",
+				"message_delta", "message_stop"},
+			deltas:     map[int]string{0: "split"},
+			blocks:     map[int]string{0: "text"},
+			stopReason: "end_turn",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := tt.usage
+			if usage == nil {
+				usage = &dto.Usage{PromptTokens: 10, CompletionTokens: 5}
+			}
+			events := runClaudeWriter(t, tt.chunks, usage)
+			names := make([]string, len(events))
+			for i, event := range events {
+				names[i] = event.Event
+				if event.Data["type"] != event.Event {
+					t.Errorf("event %s has type %v", event.Event, event.Data["type"])
+				}
+			}
+			if !reflect.DeepEqual(names, tt.events) {
+				t.Fatalf("events = %v, want %v", names, tt.events)
+			}
+
+			blocks := make(map[int]string)
+			deltas := make(map[int]string)
+			stopped := make(map[int]bool)
+			for _, event := range events {
+				index := -1
+				if value, ok := event.Data["index"].(float64); ok {
+					index = int(value)
+				}
+				switch event.Event {
+				case "content_block_start":
+					blocks[index] = event.Data["content_block"].(map[string]any)["type"].(string)
+				case "content_block_delta":
+					if stopped[index] {
+						t.Errorf("delta for stopped block %d", index)
+					}
+					delta := event.Data["delta"].(map[string]any)
+					for _, key := range []string{"text", "thinking", "partial_json"} {
+						if value, ok := delta[key].(string); ok {
+							deltas[index] += value
+						}
+					}
+				case "content_block_stop":
+					stopped[index] = true
+				case "message_delta":
+					if reason := event.Data["delta"].(map[string]any)["stop_reason"]; reason != tt.stopReason {
+						t.Errorf("stop_reason = %v, want %s", reason, tt.stopReason)
+					}
+					eventUsage := event.Data["usage"].(map[string]any)
+					if int(eventUsage["input_tokens"].(float64)) != usage.PromptTokens ||
+						int(eventUsage["output_tokens"].(float64)) != usage.CompletionTokens {
+						t.Errorf("usage = %v, want %d/%d", eventUsage, usage.PromptTokens, usage.CompletionTokens)
+					}
+				}
+			}
+			if !reflect.DeepEqual(blocks, tt.blocks) {
+				t.Errorf("blocks = %v, want %v", blocks, tt.blocks)
+			}
+			if !reflect.DeepEqual(deltas, tt.deltas) {
+				t.Errorf("deltas = %v, want %v", deltas, tt.deltas)
+			}
+			for index := range blocks {
+				if !stopped[index] {
+					t.Errorf("block %d not stopped", index)
+				}
+			}
+		})
+	}
+}
+
+func TestClaudeResponseWriterBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	writer := NewClaudeResponseWriter(c, &relaycommon.RelayInfo{OriginModelName: "gpt-4o", PromptTokens: 10})
+	c.Header("Content-Type", "application/json")
+	_, _ = c.Writer.WriteString(`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"hi",` +
+		`"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`)
+	writer.Finish(&dto.Usage{PromptTokens: 10, CompletionTokens: 4})
+
+	var response dto.ClaudeResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.StopReason != "tool_use" {
+		t.Errorf("stop_reason = %s, want tool_use", response.StopReason)
+	}
+	if response.Usage == nil || response.Usage.InputTokens != 10 || response.Usage.OutputTokens != 4 {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+	if len(response.Content) != 2 || response.Content[0].Type != "text" || response.Content[1].Type != "tool_use" {
+		t.Fatalf("content = %+v", response.Content)
+	}
+	if response.Content[1].Name != "get_weather" {
+		t.Errorf("tool name = %s", response.Content[1].Name)
+	}
+}
@@ -62,7 +62,12 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 		}
 		openAITools = append(openAITools, openAITool)
 	}
-	openAIRequest.Tools = openAITools
+	if len(openAITools) > 0 {
+		openAIRequest.Tools = openAITools
+		if claudeRequest.ToolChoice != nil {
+			openAIRequest.ToolChoice = claudeToolChoiceToOpenAI(claudeRequest.ToolChoice)
+		}
+	}
 
 	// Convert messages
 	openAIMessages := make([]dto.Message, 0)
@@ -174,8 +179,19 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 				openAIMessage.SetToolCalls(toolCalls)
 			}
 
-			if len(mediaMessages) > 0 && len(toolCalls) == 0 {
-				openAIMessage.SetMediaContent(mediaMessages)
+			if len(mediaMessages) > 0 {
+				if len(toolCalls) > 0 {
+					// 带工具调用的消息只保留文本内容
+					text := ""
+					for _, mediaMessage := range mediaMessages {
+						text += mediaMessage.Text
+					}
+					if text != "" {
+						openAIMessage.SetStringContent(text)
+					}
+				} else {
+					openAIMessage.SetMediaContent(mediaMessages)
+				}
 			}
 		}
 		if len(openAIMessage.ParseContent()) > 0 || len(openAIMessage.ToolCalls) > 0 {
@@ -381,30 +397,46 @@ func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamRespon
 func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relaycommon.RelayInfo) *dto.ClaudeResponse {
 	var stopReason string
 	contents := make([]dto.ClaudeMediaMessage, 0)
+	id := openAIResponse.Id
+	if id == "" {
+		id = "msg_" + common.GetUUID()
+	}
 	claudeResponse := &dto.ClaudeResponse{
-		Id:    openAIResponse.Id,
+		Id:    id,
 		Type:  "message",
 		Role:  "assistant",
 		Model: openAIResponse.Model,
 	}
-	for _, choice := range openAIResponse.Choices {
+	if len(openAIResponse.Choices) > 0 {
+		choice := openAIResponse.Choices[0]
 		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
-		claudeContent := dto.ClaudeMediaMessage{}
-		if choice.FinishReason == "tool_calls" {
-			claudeContent.Type = "tool_use"
-			claudeContent.Id = choice.Message.ToolCallId
-			claudeContent.Name = choice.Message.ParseToolCalls()[0].Function.Name
-			var mapParams map[string]interface{}
-			if err := json.Unmarshal([]byte(choice.Message.ParseToolCalls()[0].Function.Arguments), &mapParams); err == nil {
-				claudeContent.Input = mapParams
-			} else {
-				claudeContent.Input = choice.Message.ParseToolCalls()[0].Function.Arguments
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			contents = append(contents, dto.ClaudeMediaMessage{
+				Type:     "thinking",
+				Thinking: reasoning,
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			claudeContent := dto.ClaudeMediaMessage{Type: "text"}
+			claudeContent.SetText(text)
+			contents = append(contents, claudeContent)
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			toolId := toolCall.ID
+			if toolId == "" {
+				toolId = "toolu_" + common.GetUUID()
 			}
-		} else {
-			claudeContent.Type = "text"
-			claudeContent.SetText(choice.Message.StringContent())
+			contents = append(contents, dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    toolId,
+				Name:  toolCall.Function.Name,
+				Input: parseToolArguments(toolCall.Function.Arguments),
+			})
 		}
-		contents = append(contents, claudeContent)
 	}
 	claudeResponse.Content = contents
 	claudeResponse.StopReason = stopReason
@@ -422,10 +454,14 @@ func stopReasonOpenAI2Claude(reason string) string {
 		return "end_turn"
 	case "stop_sequence":
 		return "stop_sequence"
-	case "max_tokens":
+	case "max_tokens", "length":
 		return "max_tokens"
-	case "tool_calls":
+	case "tool_calls", "function_call":
 		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	case "":
+		return "end_turn"
 	default:
 		return reason
 	}
@@ -11,6 +11,7 @@ import (
 	"one-api/types"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func MidjourneyErrorWrapper(code int, desc string) *dto.MidjourneyResponse {
@@ -79,11 +80,37 @@ func ClaudeErrorWrapperLocal(err error, code string, statusCode int) *dto.Claude
 	return claudeErr
 }
 
+// parseRetryAfter 解析 retry-after-ms 与 Retry-After（秒数或 HTTP 日期）响应头
+func parseRetryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	retryAfter := strings.TrimSpace(header.Get("Retry-After"))
+	if retryAfter == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(retryAfter, 64); err == nil {
+		if seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		return 0
+	}
+	if at, err := http.ParseTime(retryAfter); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
 func RelayErrorHandler(resp *http.Response, showBodyWhenFail bool) (newApiErr *types.NewAPIError) {
 	newApiErr = &types.NewAPIError{
 		StatusCode: resp.StatusCode,
 		ErrorType:  types.ErrorTypeOpenAIError,
 	}
+	defer func() {
+		newApiErr.RetryAfter = parseRetryAfter(resp.Header)
+	}()
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
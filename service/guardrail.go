@@ -0,0 +1,618 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/setting"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 外部审核在转发前同步执行，超时后按失败处理，避免审核渠道阻塞请求
+const guardrailModerationTimeout = 10 * time.Second
+
+// 未启用护栏时沿用原有的输入敏感词检查
+var legacyGuardrailStages = []operation_setting.GuardrailStage{
+	{
+		Type:   operation_setting.GuardrailStageKeyword,
+		Action: operation_setting.GuardrailActionBlock,
+		Scope:  operation_setting.GuardrailScopePrompt,
+	},
+}
+
+var (
+	piiEmailRegex      = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+	piiPhoneRegex      = regexp.MustCompile(`(?:\+?86[- ]?)?1[3-9]\d{9}|(?:\+?1[-. ]?)?\(?\d{3}\)?[-. ]\d{3}[-. ]\d{4}`)
+	piiIdCardRegex     = regexp.MustCompile(`[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]`)
+	piiCreditCardRegex = regexp.MustCompile(`\d(?:[ -]?\d){12,18}`)
+)
+
+// PII 检测顺序，重叠时先命中的优先
+var piiDetectorOrder = []string{
+	operation_setting.GuardrailPIIIdCard,
+	operation_setting.GuardrailPIICreditCard,
+	operation_setting.GuardrailPIIPhone,
+	operation_setting.GuardrailPIIEmail,
+}
+
+var guardrailRegexCache sync.Map
+
+type guardrailMatch struct {
+	start int
+	end   int
+	label string
+	pii   bool
+}
+
+// guardrailBlock 命中拦截规则的阶段
+type guardrailBlock struct {
+	stage  string
+	labels []string
+}
+
+func (b *guardrailBlock) toError() *types.NewAPIError {
+	if b.stage == operation_setting.GuardrailStageKeyword {
+		return types.NewError(errors.New("sensitive words detected"), types.ErrorCodeSensitiveWordsDetected)
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("request blocked by guardrail: %s", b.stage), types.ErrorCodeGuardrailBlocked, http.StatusBadRequest)
+}
+
+func getGuardrailRegex(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := guardrailRegexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	guardrailRegexCache.Store(pattern, re)
+	return re, nil
+}
+
+// getKeywordRegex 将关键词列表编译为忽略大小写的正则，较长的关键词优先匹配
+func getKeywordRegex(words []string) *regexp.Regexp {
+	sorted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word != "" {
+			sorted = append(sorted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	re, err := getGuardrailRegex("(?i)(?:" + strings.Join(sorted, "|") + ")")
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// digitBounded 判断匹配的前后是否不是数字，避免从更长的数字串中截取
+func digitBounded(text string, start, end int) bool {
+	if start > 0 && text[start-1] >= '0' && text[start-1] <= '9' {
+		return false
+	}
+	if end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		return false
+	}
+	return true
+}
+
+func digitsOnly(s string) string {
+	var builder strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// luhnValid 信用卡号 Luhn 校验
+func luhnValid(number string) bool {
+	if len(number) < 13 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// idCardValid 18 位身份证号校验码检查（ISO 7064 MOD 11-2）
+func idCardValid(id string) bool {
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	checkCodes := "10X98765432"
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(id[i]-'0') * weights[i]
+	}
+	return strings.ToUpper(id[17:]) == string(checkCodes[sum%11])
+}
+
+func detectPII(detector string, text string) []guardrailMatch {
+	var re *regexp.Regexp
+	switch detector {
+	case operation_setting.GuardrailPIIEmail:
+		re = piiEmailRegex
+	case operation_setting.GuardrailPIIPhone:
+		re = piiPhoneRegex
+	case operation_setting.GuardrailPIIIdCard:
+		re = piiIdCardRegex
+	case operation_setting.GuardrailPIICreditCard:
+		re = piiCreditCardRegex
+	default:
+		return nil
+	}
+	var matches []guardrailMatch
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		value := text[loc[0]:loc[1]]
+		switch detector {
+		case operation_setting.GuardrailPIIPhone:
+			if !digitBounded(text, loc[0], loc[1]) {
+				continue
+			}
+		case operation_setting.GuardrailPIIIdCard:
+			if !digitBounded(text, loc[0], loc[1]) || !idCardValid(value) {
+				continue
+			}
+		case operation_setting.GuardrailPIICreditCard:
+			if !digitBounded(text, loc[0], loc[1]) || !luhnValid(digitsOnly(value)) {
+				continue
+			}
+		}
+		matches = append(matches, guardrailMatch{start: loc[0], end: loc[1], label: detector, pii: true})
+	}
+	return matches
+}
+
+// detectGuardrailMatches 执行本地检查阶段，返回按位置排序且互不重叠的命中
+func detectGuardrailMatches(stage *operation_setting.GuardrailStage, text string) []guardrailMatch {
+	if text == "" {
+		return nil
+	}
+	var matches []guardrailMatch
+	switch stage.Type {
+	case operation_setting.GuardrailStageKeyword:
+		words := stage.Patterns
+		if len(words) == 0 {
+			words = setting.SensitiveWords
+		}
+		if re := getKeywordRegex(words); re != nil {
+			for _, loc := range re.FindAllStringIndex(text, -1) {
+				matches = append(matches, guardrailMatch{start: loc[0], end: loc[1], label: strings.ToLower(text[loc[0]:loc[1]])})
+			}
+		}
+	case operation_setting.GuardrailStageRegex:
+		for _, pattern := range stage.Patterns {
+			re, err := getGuardrailRegex(pattern)
+			if err != nil {
+				continue
+			}
+			for _, loc := range re.FindAllStringIndex(text, -1) {
+				if loc[0] == loc[1] {
+					continue
+				}
+				matches = append(matches, guardrailMatch{start: loc[0], end: loc[1], label: pattern})
+			}
+		}
+	case operation_setting.GuardrailStagePII:
+		detectors := stage.Detectors
+		for _, detector := range piiDetectorOrder {
+			if len(detectors) > 0 && !common.StringsContains(detectors, detector) {
+				continue
+			}
+			matches = append(matches, detectPII(detector, text)...)
+		}
+	}
+	return dedupeGuardrailMatches(matches)
+}
+
+func dedupeGuardrailMatches(matches []guardrailMatch) []guardrailMatch {
+	if len(matches) < 2 {
+		return matches
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].start < matches[j].start
+	})
+	result := matches[:1]
+	for _, m := range matches[1:] {
+		if m.start < result[len(result)-1].end {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func guardrailMatchLabels(matches []guardrailMatch) []string {
+	labels := make([]string, 0, len(matches))
+	for _, m := range matches {
+		labels = append(labels, m.label)
+	}
+	return RemoveDuplicate(labels)
+}
+
+// redactGuardrailMatches 将命中内容替换为占位符，PII 按类型标注
+func redactGuardrailMatches(text string, matches []guardrailMatch) string {
+	var builder strings.Builder
+	builder.Grow(len(text))
+	last := 0
+	for _, m := range matches {
+		builder.WriteString(text[last:m.start])
+		if m.pii {
+			builder.WriteString("[REDACTED_" + strings.ToUpper(m.label) + "]")
+		} else {
+			builder.WriteString("[REDACTED]")
+		}
+		last = m.end
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+type moderationResult struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// moderateText 调用指定渠道的 /v1/moderations 接口，返回是否命中及命中的类别
+func moderateText(ctx context.Context, stage *operation_setting.GuardrailStage, text string) (bool, []string, error) {
+	channel, err := model.CacheGetChannel(stage.ChannelId)
+	if err != nil {
+		return false, nil, err
+	}
+	key, _, apiErr := channel.PeekNextEnabledKey()
+	if apiErr != nil {
+		return false, nil, apiErr.Err
+	}
+	modelName := stage.Model
+	if modelName == "" {
+		modelName = "omni-moderation-latest"
+	}
+	body, err := common.Marshal(map[string]any{
+		"model": modelName,
+		"input": text,
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, guardrailModerationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(channel.GetBaseURL(), "/")+"/v1/moderations", bytes.NewReader(body))
+	if err != nil {
+		return false, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, fmt.Errorf("moderation request failed with status %d", resp.StatusCode)
+	}
+	var result moderationResult
+	if err := common.Unmarshal(respBody, &result); err != nil {
+		return false, nil, err
+	}
+	flagged := false
+	var categories []string
+	for _, r := range result.Results {
+		for category, hit := range r.Categories {
+			if !hit {
+				continue
+			}
+			if len(stage.Categories) > 0 && !common.StringsContains(stage.Categories, category) {
+				continue
+			}
+			categories = append(categories, category)
+		}
+		if len(stage.Categories) == 0 && r.Flagged {
+			flagged = true
+		}
+	}
+	if len(stage.Categories) > 0 {
+		flagged = len(categories) > 0
+	}
+	sort.Strings(categories)
+	return flagged, RemoveDuplicate(categories), nil
+}
+
+// runModerationStage 执行外部审核阶段，返回是否命中及命中的类别
+func runModerationStage(c *gin.Context, stage *operation_setting.GuardrailStage, text string) (bool, []string) {
+	if strings.TrimSpace(text) == "" {
+		return false, nil
+	}
+	flagged, categories, err := moderateText(c.Request.Context(), stage, text)
+	if err != nil {
+		common.LogError(c, "guardrail moderation failed: "+err.Error())
+		if stage.FailClosed {
+			return true, []string{"moderation_error"}
+		}
+		return false, nil
+	}
+	if flagged && len(categories) == 0 {
+		categories = []string{"flagged"}
+	}
+	return flagged, categories
+}
+
+// getGuardrailStages 获取当前请求生效的检查阶段
+func getGuardrailStages(c *gin.Context) []operation_setting.GuardrailStage {
+	if !operation_setting.GetGuardrailSetting().Enabled {
+		if setting.ShouldCheckPromptSensitive() {
+			return legacyGuardrailStages
+		}
+		return nil
+	}
+	return operation_setting.GetGuardrailStages(
+		common.GetContextKeyString(c, constant.ContextKeyTokenGuardrailPolicy),
+		common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+	)
+}
+
+func getScopedGuardrailStages(c *gin.Context, scope string) []operation_setting.GuardrailStage {
+	var stages []operation_setting.GuardrailStage
+	for _, stage := range getGuardrailStages(c) {
+		if stage.InScope(scope) {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// recordGuardrailHit 记录命中情况，写入消费日志
+func recordGuardrailHit(c *gin.Context, scope string, stage *operation_setting.GuardrailStage, labels []string) {
+	hit := fmt.Sprintf("%s:%s:%s", scope, stage.Type, stage.Action)
+	hits := common.GetContextKeyStringSlice(c, constant.ContextKeyGuardrailHits)
+	if !common.StringsContains(hits, hit) {
+		common.SetContextKey(c, constant.ContextKeyGuardrailHits, append(hits, hit))
+	}
+	common.LogWarn(c, fmt.Sprintf("guardrail %s stage hit on %s (%s): %s", stage.Type, scope, stage.Action, strings.Join(labels, ", ")))
+}
+
+// CheckPromptGuardrails 按顺序对请求文本执行输入护栏，命中脱敏规则的文本会被原地改写
+func CheckPromptGuardrails(c *gin.Context, texts ...*string) *types.NewAPIError {
+	stages := getScopedGuardrailStages(c, operation_setting.GuardrailScopePrompt)
+	if len(stages) == 0 {
+		return nil
+	}
+	for i := range stages {
+		stage := &stages[i]
+		if stage.Type == operation_setting.GuardrailStageModeration {
+			var builder strings.Builder
+			for _, text := range texts {
+				builder.WriteString(*text)
+				builder.WriteString("\n")
+			}
+			flagged, categories := runModerationStage(c, stage, builder.String())
+			if !flagged {
+				continue
+			}
+			recordGuardrailHit(c, operation_setting.GuardrailScopePrompt, stage, categories)
+			if stage.Action == operation_setting.GuardrailActionBlock {
+				return (&guardrailBlock{stage: stage.Type, labels: categories}).toError()
+			}
+			continue
+		}
+		for _, text := range texts {
+			matches := detectGuardrailMatches(stage, *text)
+			if len(matches) == 0 {
+				continue
+			}
+			labels := guardrailMatchLabels(matches)
+			recordGuardrailHit(c, operation_setting.GuardrailScopePrompt, stage, labels)
+			switch stage.Action {
+			case operation_setting.GuardrailActionBlock:
+				return (&guardrailBlock{stage: stage.Type, labels: labels}).toError()
+			case operation_setting.GuardrailActionRedact:
+				*text = redactGuardrailMatches(*text, matches)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckMessagesGuardrails 对消息中的文本执行输入护栏，脱敏结果写回消息
+func CheckMessagesGuardrails(c *gin.Context, messages []dto.Message) *types.NewAPIError {
+	if len(getScopedGuardrailStages(c, operation_setting.GuardrailScopePrompt)) == 0 {
+		return nil
+	}
+	var texts []*string
+	var writeBacks []func()
+	for i := range messages {
+		message := &messages[i]
+		if message.IsStringContent() {
+			text := message.StringContent()
+			original := text
+			texts = append(texts, &text)
+			writeBacks = append(writeBacks, func() {
+				if text != original {
+					message.SetStringContent(text)
+				}
+			})
+			continue
+		}
+		contents := message.ParseContent()
+		changed := make([]string, len(contents))
+		for j := range contents {
+			if contents[j].Type != dto.ContentTypeText || contents[j].Text == "" {
+				continue
+			}
+			changed[j] = contents[j].Text
+			texts = append(texts, &changed[j])
+		}
+		writeBacks = append(writeBacks, func() {
+			modified := false
+			for j := range contents {
+				if changed[j] != "" && changed[j] != contents[j].Text {
+					contents[j].Text = changed[j]
+					modified = true
+				}
+			}
+			if modified {
+				message.SetMediaContent(contents)
+			}
+		})
+	}
+	if apiErr := CheckPromptGuardrails(c, texts...); apiErr != nil {
+		return apiErr
+	}
+	for _, writeBack := range writeBacks {
+		writeBack()
+	}
+	return nil
+}
+
+// CheckInputGuardrails 对 prompt / input 等字段执行输入护栏，返回脱敏后的值
+func CheckInputGuardrails(c *gin.Context, input any) (any, *types.NewAPIError) {
+	switch v := input.(type) {
+	case nil:
+		return input, nil
+	case string:
+		if apiErr := CheckPromptGuardrails(c, &v); apiErr != nil {
+			return input, apiErr
+		}
+		return v, nil
+	case []string:
+		texts := make([]*string, len(v))
+		for i := range v {
+			texts[i] = &v[i]
+		}
+		if apiErr := CheckPromptGuardrails(c, texts...); apiErr != nil {
+			return input, apiErr
+		}
+		return v, nil
+	case []any:
+		strs := make([]string, len(v))
+		var texts []*string
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				strs[i] = s
+				texts = append(texts, &strs[i])
+			}
+		}
+		if apiErr := CheckPromptGuardrails(c, texts...); apiErr != nil {
+			return input, apiErr
+		}
+		for i, item := range v {
+			if _, ok := item.(string); ok {
+				v[i] = strs[i]
+			}
+		}
+		return v, nil
+	}
+	// 无法改写的结构只做检查
+	text := fmt.Sprintf("%v", input)
+	return input, CheckPromptGuardrails(c, &text)
+}
+
+// collectResponsesInputTexts 收集 Responses input 中的文本，返回文本指针与写回函数
+func collectResponsesInputTexts(node any, texts *[]*string, writeBacks *[]func()) {
+	switch v := node.(type) {
+	case []any:
+		for i := range v {
+			if s, ok := v[i].(string); ok {
+				text := s
+				*texts = append(*texts, &text)
+				*writeBacks = append(*writeBacks, func() { v[i] = text })
+				continue
+			}
+			collectResponsesInputTexts(v[i], texts, writeBacks)
+		}
+	case map[string]any:
+		for _, field := range []string{"content", "text", "output"} {
+			switch value := v[field].(type) {
+			case string:
+				text := value
+				*texts = append(*texts, &text)
+				*writeBacks = append(*writeBacks, func() { v[field] = text })
+			case []any:
+				collectResponsesInputTexts(value, texts, writeBacks)
+			}
+		}
+	}
+}
+
+// CheckResponsesInputGuardrails 解析 Responses 请求的 input 并对其中的文本执行输入护栏，返回脱敏后的 input
+func CheckResponsesInputGuardrails(c *gin.Context, input json.RawMessage) (json.RawMessage, *types.NewAPIError) {
+	if len(input) == 0 || len(getScopedGuardrailStages(c, operation_setting.GuardrailScopePrompt)) == 0 {
+		return input, nil
+	}
+	var root any
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+	if err := decoder.Decode(&root); err != nil {
+		return input, types.NewError(err, types.ErrorCodeInvalidRequest)
+	}
+	var texts []*string
+	var writeBacks []func()
+	if s, ok := root.(string); ok {
+		texts = append(texts, &s)
+		writeBacks = append(writeBacks, func() { root = s })
+	} else {
+		collectResponsesInputTexts(root, &texts, &writeBacks)
+	}
+	originals := make([]string, len(texts))
+	for i, text := range texts {
+		originals[i] = *text
+	}
+	if apiErr := CheckPromptGuardrails(c, texts...); apiErr != nil {
+		return input, apiErr
+	}
+	changed := false
+	for i, text := range texts {
+		if *text != originals[i] {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return input, nil
+	}
+	for _, writeBack := range writeBacks {
+		writeBack()
+	}
+	redacted, err := common.Marshal(root)
+	if err != nil {
+		return input, types.NewError(err, types.ErrorCodeInvalidRequest)
+	}
+	return redacted, nil
+}
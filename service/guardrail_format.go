@@ -0,0 +1,457 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+)
+
+// 输出护栏支持的响应格式
+const (
+	GuardrailFormatOpenAI    = "openai"
+	GuardrailFormatClaude    = "claude"
+	GuardrailFormatGemini    = "gemini"
+	GuardrailFormatResponses = "responses"
+)
+
+// guardrailPiece 流式分片或响应体中的一段输出文本
+type guardrailPiece struct {
+	// 输出标识，流式响应按此累计内容
+	key   string
+	index int
+	// 构造补充事件所需的字段
+	meta    map[string]any
+	text    string
+	hasText bool
+	// 该输出在当前分片结束
+	finished bool
+	// 完整内容（如 Responses 的 done 事件），只做脱敏，不参与累计与拦截
+	final bool
+	// 写回文本，流式结束分片没有文本字段时为 nil
+	set func(text string)
+	// 非流式响应被拦截时改写响应
+	block func()
+}
+
+// guardrailEvent 输出的 SSE 事件，event 为空时只输出 data 行
+type guardrailEvent struct {
+	event string
+	data  string
+}
+
+// guardrailFormat 解析不同接口格式的响应中的输出文本
+type guardrailFormat interface {
+	// streamPieces 解析流式分片，event 为分片的 SSE 事件名
+	streamPieces(event string, chunk map[string]any) []guardrailPiece
+	// textEvent 构造携带暂缓内容的事件
+	textEvent(piece guardrailPiece, text string) guardrailEvent
+	// blockEvents 拦截时结束流式响应的事件
+	blockEvents(piece guardrailPiece) []guardrailEvent
+	// bodyPieces 解析非流式响应
+	bodyPieces(response map[string]any) []guardrailPiece
+}
+
+func newGuardrailFormat(format string) guardrailFormat {
+	switch format {
+	case GuardrailFormatClaude:
+		return &claudeGuardrailFormat{}
+	case GuardrailFormatGemini:
+		return &geminiGuardrailFormat{}
+	case GuardrailFormatResponses:
+		return &responsesGuardrailFormat{}
+	default:
+		return &openAIGuardrailFormat{}
+	}
+}
+
+func guardrailEventOf(event string, data any) guardrailEvent {
+	out, err := common.Marshal(data)
+	if err != nil {
+		out = []byte("{}")
+	}
+	return guardrailEvent{event: event, data: string(out)}
+}
+
+func guardrailInt(value any) int {
+	if number, ok := value.(float64); ok {
+		return int(number)
+	}
+	return 0
+}
+
+// openAIGuardrailFormat chat/completions 与 completions 格式
+type openAIGuardrailFormat struct {
+	lastChunk map[string]any
+}
+
+// guardrailContentField 返回 choice 中文本内容所在的对象及字段名，兼容 chat 与 completions 格式
+func guardrailContentField(choice map[string]any, create bool) (map[string]any, string) {
+	if delta, ok := choice["delta"].(map[string]any); ok {
+		return delta, "content"
+	}
+	if message, ok := choice["message"].(map[string]any); ok {
+		return message, "content"
+	}
+	if _, ok := choice["text"]; ok {
+		return choice, "text"
+	}
+	if create {
+		delta := make(map[string]any)
+		choice["delta"] = delta
+		return delta, "content"
+	}
+	return nil, ""
+}
+
+func (f *openAIGuardrailFormat) isCompletions() bool {
+	object, _ := f.lastChunk["object"].(string)
+	return object == "text_completion"
+}
+
+func (f *openAIGuardrailFormat) choicePieces(response map[string]any) []guardrailPiece {
+	choices, _ := response["choices"].([]any)
+	pieces := make([]guardrailPiece, 0, len(choices))
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := guardrailInt(choice["index"])
+		piece := guardrailPiece{key: fmt.Sprint(index), index: index}
+		if container, key := guardrailContentField(choice, false); container != nil {
+			piece.text, piece.hasText = container[key].(string)
+		}
+		if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+			piece.finished = true
+		}
+		piece.set = func(text string) {
+			container, key := guardrailContentField(choice, true)
+			container[key] = text
+		}
+		piece.block = func() {
+			piece.set("")
+			choice["finish_reason"] = "content_filter"
+		}
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+func (f *openAIGuardrailFormat) streamPieces(event string, chunk map[string]any) []guardrailPiece {
+	f.lastChunk = chunk
+	return f.choicePieces(chunk)
+}
+
+// newChunkFrom 以最后一个分片为模板构造新的流式分片
+func (f *openAIGuardrailFormat) newChunkFrom(choice map[string]any) map[string]any {
+	chunk := map[string]any{
+		"choices": []any{choice},
+	}
+	for _, key := range []string{"id", "object", "created", "model"} {
+		if value, ok := f.lastChunk[key]; ok {
+			chunk[key] = value
+		}
+	}
+	return chunk
+}
+
+func (f *openAIGuardrailFormat) textEvent(piece guardrailPiece, text string) guardrailEvent {
+	choice := map[string]any{"index": piece.index}
+	if f.isCompletions() {
+		choice["text"] = text
+	} else {
+		choice["delta"] = map[string]any{"content": text}
+	}
+	return guardrailEventOf("", f.newChunkFrom(choice))
+}
+
+// blockEvents 以 content_filter 结束流式响应
+func (f *openAIGuardrailFormat) blockEvents(piece guardrailPiece) []guardrailEvent {
+	choice := map[string]any{
+		"index":         piece.index,
+		"finish_reason": "content_filter",
+	}
+	if f.isCompletions() {
+		choice["text"] = ""
+	} else {
+		choice["delta"] = map[string]any{}
+	}
+	return []guardrailEvent{
+		guardrailEventOf("", f.newChunkFrom(choice)),
+		{data: "[DONE]"},
+	}
+}
+
+func (f *openAIGuardrailFormat) bodyPieces(response map[string]any) []guardrailPiece {
+	return f.choicePieces(response)
+}
+
+// claudeGuardrailFormat Claude Messages 格式，按内容块累计文本
+type claudeGuardrailFormat struct{}
+
+func (f *claudeGuardrailFormat) streamPieces(event string, chunk map[string]any) []guardrailPiece {
+	index := guardrailInt(chunk["index"])
+	piece := guardrailPiece{key: fmt.Sprint(index), index: index}
+	switch chunk["type"] {
+	case "content_block_delta":
+		delta, ok := chunk["delta"].(map[string]any)
+		if !ok || delta["type"] != "text_delta" {
+			return nil
+		}
+		piece.text, piece.hasText = delta["text"].(string)
+		piece.set = func(text string) {
+			delta["text"] = text
+		}
+	case "content_block_stop":
+		piece.finished = true
+	default:
+		return nil
+	}
+	return []guardrailPiece{piece}
+}
+
+func (f *claudeGuardrailFormat) textEvent(piece guardrailPiece, text string) guardrailEvent {
+	return guardrailEventOf("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": piece.index,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	})
+}
+
+// blockEvents 结束当前内容块并以 refusal 结束消息
+func (f *claudeGuardrailFormat) blockEvents(piece guardrailPiece) []guardrailEvent {
+	return []guardrailEvent{
+		guardrailEventOf("content_block_stop", map[string]any{"type": "content_block_stop", "index": piece.index}),
+		guardrailEventOf("message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": "refusal", "stop_sequence": nil},
+			"usage": map[string]any{"output_tokens": 0},
+		}),
+		guardrailEventOf("message_stop", map[string]any{"type": "message_stop"}),
+	}
+}
+
+func (f *claudeGuardrailFormat) bodyPieces(response map[string]any) []guardrailPiece {
+	contents, _ := response["content"].([]any)
+	pieces := make([]guardrailPiece, 0, len(contents))
+	for i, item := range contents {
+		content, ok := item.(map[string]any)
+		if !ok || content["type"] != "text" {
+			continue
+		}
+		piece := guardrailPiece{key: fmt.Sprint(i), index: i}
+		piece.text, piece.hasText = content["text"].(string)
+		piece.set = func(text string) {
+			content["text"] = text
+		}
+		piece.block = func() {
+			content["text"] = ""
+			response["stop_reason"] = "refusal"
+		}
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+// geminiGuardrailFormat Gemini generateContent 格式，按候选累计文本，不检查思考内容
+type geminiGuardrailFormat struct{}
+
+func (f *geminiGuardrailFormat) candidatePieces(response map[string]any) []guardrailPiece {
+	candidates, _ := response["candidates"].([]any)
+	pieces := make([]guardrailPiece, 0, len(candidates))
+	for _, item := range candidates {
+		candidate, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := guardrailInt(candidate["index"])
+		piece := guardrailPiece{key: fmt.Sprint(index), index: index}
+		content, _ := candidate["content"].(map[string]any)
+		parts, _ := content["parts"].([]any)
+		var textParts []map[string]any
+		for _, p := range parts {
+			part, ok := p.(map[string]any)
+			if !ok {
+				continue
+			}
+			if thought, _ := part["thought"].(bool); thought {
+				continue
+			}
+			if text, ok := part["text"].(string); ok {
+				piece.text += text
+				piece.hasText = true
+				textParts = append(textParts, part)
+			}
+		}
+		if reason, ok := candidate["finishReason"].(string); ok && reason != "" {
+			piece.finished = true
+		}
+		piece.set = func(text string) {
+			if len(textParts) == 0 {
+				if content == nil {
+					content = map[string]any{"role": "model"}
+					candidate["content"] = content
+				}
+				parts, _ := content["parts"].([]any)
+				content["parts"] = append(parts, map[string]any{"text": text})
+				return
+			}
+			// 文本合并写入第一个文本片段
+			for i, part := range textParts {
+				if i == 0 {
+					part["text"] = text
+				} else {
+					part["text"] = ""
+				}
+			}
+		}
+		piece.block = func() {
+			if content != nil {
+				content["parts"] = []any{}
+			}
+			candidate["finishReason"] = "SAFETY"
+		}
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
+
+func (f *geminiGuardrailFormat) streamPieces(event string, chunk map[string]any) []guardrailPiece {
+	return f.candidatePieces(chunk)
+}
+
+func (f *geminiGuardrailFormat) textEvent(piece guardrailPiece, text string) guardrailEvent {
+	return guardrailEventOf("", map[string]any{
+		"candidates": []any{map[string]any{
+			"index":   piece.index,
+			"content": map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+		}},
+	})
+}
+
+// blockEvents 以 SAFETY 结束候选
+func (f *geminiGuardrailFormat) blockEvents(piece guardrailPiece) []guardrailEvent {
+	return []guardrailEvent{guardrailEventOf("", map[string]any{
+		"candidates": []any{map[string]any{
+			"index":        piece.index,
+			"content":      map[string]any{"role": "model", "parts": []any{}},
+			"finishReason": "SAFETY",
+		}},
+	})}
+}
+
+func (f *geminiGuardrailFormat) bodyPieces(response map[string]any) []guardrailPiece {
+	return f.candidatePieces(response)
+}
+
+// responsesGuardrailFormat Responses 格式，按输出项与内容序号累计文本，done 事件中的完整内容只做脱敏
+type responsesGuardrailFormat struct {
+	responseId any
+}
+
+// responsesOutputTextPieces 返回输出项中 output_text 内容的完整文本
+func responsesOutputTextPieces(item map[string]any) []guardrailPiece {
+	contents, _ := item["content"].([]any)
+	var pieces []guardrailPiece
+	for _, c := range contents {
+		content, ok := c.(map[string]any)
+		if !ok || content["type"] != "output_text" {
+			continue
+		}
+		pieces = append(pieces, responsesTextPiece(content, "text"))
+	}
+	return pieces
+}
+
+func responsesTextPiece(container map[string]any, field string) guardrailPiece {
+	piece := guardrailPiece{final: true}
+	piece.text, piece.hasText = container[field].(string)
+	piece.set = func(text string) {
+		container[field] = text
+	}
+	return piece
+}
+
+func responsesOutputPieces(response map[string]any) []guardrailPiece {
+	outputs, _ := response["output"].([]any)
+	var pieces []guardrailPiece
+	for _, o := range outputs {
+		if item, ok := o.(map[string]any); ok {
+			pieces = append(pieces, responsesOutputTextPieces(item)...)
+		}
+	}
+	return pieces
+}
+
+func (f *responsesGuardrailFormat) streamPieces(event string, chunk map[string]any) []guardrailPiece {
+	if response, ok := chunk["response"].(map[string]any); ok {
+		if id, ok := response["id"]; ok {
+			f.responseId = id
+		}
+	}
+	meta := map[string]any{
+		"item_id":       chunk["item_id"],
+		"output_index":  chunk["output_index"],
+		"content_index": chunk["content_index"],
+	}
+	key := fmt.Sprintf("%v:%v", chunk["item_id"], chunk["content_index"])
+	switch chunk["type"] {
+	case "response.output_text.delta":
+		piece := guardrailPiece{key: key, meta: meta}
+		piece.text, piece.hasText = chunk["delta"].(string)
+		piece.set = func(text string) {
+			chunk["delta"] = text
+		}
+		return []guardrailPiece{piece}
+	case "response.output_text.done":
+		return []guardrailPiece{{key: key, meta: meta, finished: true}, responsesTextPiece(chunk, "text")}
+	case "response.content_part.done":
+		if part, ok := chunk["part"].(map[string]any); ok && part["type"] == "output_text" {
+			return []guardrailPiece{responsesTextPiece(part, "text")}
+		}
+	case "response.output_item.done":
+		if item, ok := chunk["item"].(map[string]any); ok {
+			return responsesOutputTextPieces(item)
+		}
+	case "response.completed", "response.incomplete", "response.failed":
+		if response, ok := chunk["response"].(map[string]any); ok {
+			return responsesOutputPieces(response)
+		}
+	}
+	return nil
+}
+
+func (f *responsesGuardrailFormat) textEvent(piece guardrailPiece, text string) guardrailEvent {
+	data := map[string]any{"type": "response.output_text.delta", "delta": text}
+	for k, v := range piece.meta {
+		data[k] = v
+	}
+	return guardrailEventOf("response.output_text.delta", data)
+}
+
+// blockEvents 以 content_filter 原因结束响应
+func (f *responsesGuardrailFormat) blockEvents(piece guardrailPiece) []guardrailEvent {
+	return []guardrailEvent{guardrailEventOf("response.incomplete", map[string]any{
+		"type": "response.incomplete",
+		"response": map[string]any{
+			"id":                 f.responseId,
+			"object":             "response",
+			"status":             "incomplete",
+			"incomplete_details": map[string]any{"reason": "content_filter"},
+		},
+	})}
+}
+
+func (f *responsesGuardrailFormat) bodyPieces(response map[string]any) []guardrailPiece {
+	pieces := responsesOutputPieces(response)
+	for i := range pieces {
+		piece := &pieces[i]
+		piece.final = false
+		set := piece.set
+		piece.block = func() {
+			set("")
+			response["status"] = "incomplete"
+			response["incomplete_details"] = map[string]any{"reason": "content_filter"}
+		}
+	}
+	return pieces
+}
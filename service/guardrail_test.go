@@ -0,0 +1,68 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckResponsesInputGuardrails(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "string input",
+			input:    `"mail me at a@b.io"`,
+			contains: []string{"[REDACTED_EMAIL]"},
+			excludes: []string{"a@b.io"},
+		},
+		{
+			name:     "escaped characters are decoded before matching",
+			input:    `"line\nmail a@b.io"`,
+			contains: []string{"[REDACTED_EMAIL]"},
+			excludes: []string{"b.io"},
+		},
+		{
+			name:     "message items with content parts",
+			input:    `[{"role":"user","content":[{"type":"input_text","text":"x@y.com"},{"type":"input_image","image_url":"https://example.com/a.png"}]},{"role":"user","content":"c@d.org"}]`,
+			contains: []string{"[REDACTED_EMAIL]", "https://example.com/a.png"},
+			excludes: []string{"x@y.com", "c@d.org"},
+		},
+		{
+			name:     "unchanged input kept verbatim",
+			input:    `[{"role":"user","content":"hello", "extra": 12345678901234567890}]`,
+			contains: []string{`"extra": 12345678901234567890`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupGuardrailTest(t, redactEmailStages)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+			common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+			output, apiErr := CheckResponsesInputGuardrails(c, json.RawMessage(tt.input))
+			if apiErr != nil {
+				t.Fatalf("unexpected error: %v", apiErr)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(string(output), s) {
+					t.Errorf("output missing %q: %s", s, output)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(string(output), s) {
+					t.Errorf("output should not contain %q: %s", s, output)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/relay/helper"
+	"one-api/setting/operation_setting"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 流式检查时与已检查内容重叠的最小字符数，用于识别跨分片的命中
+const guardrailMinScanOverlap = 256
+
+// GuardrailResponseWriter 对适配器输出的响应执行输出护栏，响应格式由 guardrailFormat 解析。
+// 流式响应逐块检查，存在脱敏阶段时暂缓输出尾部内容，以便识别跨分片的命中；非流式响应在 Finish 时整体处理
+type GuardrailResponseWriter struct {
+	gin.ResponseWriter
+	c        *gin.Context
+	format   guardrailFormat
+	stages   []operation_setting.GuardrailStage
+	redact   bool
+	holdback int
+	overlap  int
+
+	isStream bool
+	decided  bool
+	buf      helper.SSELineBuffer
+	// 尚未输出的 event 行，与对应的 data 行一起输出
+	event string
+
+	outputs   map[string]*guardrailOutput
+	order     []string
+	hitStages map[int]bool
+	blocked   bool
+	done      bool
+}
+
+// guardrailOutput 流式响应中单个输出的累计内容，released 之前的部分已输出，scanned 之前的部分已检查
+type guardrailOutput struct {
+	piece    guardrailPiece
+	text     strings.Builder
+	released int
+	scanned  int
+}
+
+// NewGuardrailResponseWriter 当前请求存在输出护栏时接管 c.Writer，否则返回 nil。
+// format 为 GuardrailFormatOpenAI、GuardrailFormatClaude、GuardrailFormatGemini 或 GuardrailFormatResponses
+func NewGuardrailResponseWriter(c *gin.Context, format string) *GuardrailResponseWriter {
+	stages := getScopedGuardrailStages(c, operation_setting.GuardrailScopeCompletion)
+	if len(stages) == 0 {
+		return nil
+	}
+	holdback := operation_setting.GetGuardrailSetting().StreamHoldbackChars
+	writer := &GuardrailResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		format:         newGuardrailFormat(format),
+		stages:         stages,
+		holdback:       holdback,
+		overlap:        max(holdback, guardrailMinScanOverlap),
+		outputs:        make(map[string]*guardrailOutput),
+		hitStages:      make(map[int]bool),
+	}
+	for _, stage := range stages {
+		if stage.Action == operation_setting.GuardrailActionRedact {
+			writer.redact = true
+		}
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *GuardrailResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.isStream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func (w *GuardrailResponseWriter) Write(data []byte) (int, error) {
+	w.decide()
+	w.buf.Write(data)
+	if w.isStream {
+		w.buf.ProcessLines(w.processLine)
+	}
+	return len(data), nil
+}
+
+func (w *GuardrailResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 非流式响应需要在检查完成后一次性写出，此时不向下游刷新
+func (w *GuardrailResponseWriter) Flush() {
+	w.decide()
+	if w.isStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// Blocked 输出是否被护栏拦截
+func (w *GuardrailResponseWriter) Blocked() bool {
+	return w.blocked
+}
+
+func (w *GuardrailResponseWriter) processLine(line string) {
+	if w.blocked {
+		// 拦截后丢弃上游剩余输出，上游仍会被完整读取用于计费
+		return
+	}
+	if strings.HasPrefix(line, "event:") {
+		w.event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		return
+	}
+	data, ok := helper.SSEData(line)
+	if !ok {
+		w.writeEventLine()
+		_, _ = w.ResponseWriter.WriteString(line + "\n")
+		return
+	}
+	if data == "[DONE]" {
+		w.finishStream()
+		w.done = true
+		if !w.blocked {
+			w.writeEventLine()
+			_, _ = w.ResponseWriter.WriteString(line + "\n")
+		}
+		return
+	}
+	out, ok := w.handleChunk(data)
+	if !ok {
+		return
+	}
+	w.writeEventLine()
+	_, _ = w.ResponseWriter.WriteString("data: " + out + "\n")
+}
+
+func (w *GuardrailResponseWriter) writeEventLine() {
+	if w.event != "" {
+		_, _ = w.ResponseWriter.WriteString("event: " + w.event + "\n")
+		w.event = ""
+	}
+}
+
+func (w *GuardrailResponseWriter) writeEvent(event guardrailEvent) {
+	if event.event != "" {
+		_, _ = w.ResponseWriter.WriteString("event: " + event.event + "\n")
+	}
+	_, _ = w.ResponseWriter.WriteString("data: " + event.data + "\n\n")
+}
+
+func (w *GuardrailResponseWriter) output(piece guardrailPiece) *guardrailOutput {
+	output, ok := w.outputs[piece.key]
+	if !ok {
+		output = &guardrailOutput{}
+		w.outputs[piece.key] = output
+		w.order = append(w.order, piece.key)
+	}
+	output.piece = piece
+	return output
+}
+
+// handleChunk 检查并改写单个流式分片，返回 false 表示该分片不再输出
+func (w *GuardrailResponseWriter) handleChunk(data string) (string, bool) {
+	var chunk map[string]any
+	if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+		return data, true
+	}
+	modified := false
+	for _, piece := range w.format.streamPieces(w.event, chunk) {
+		if piece.final {
+			// 完整内容已经按分片检查过，这里只需与分片保持一致的脱敏
+			if w.redact && piece.hasText {
+				if redacted := w.redactText(piece.text); redacted != piece.text {
+					piece.set(redacted)
+					modified = true
+				}
+			}
+			continue
+		}
+		output := w.output(piece)
+		if piece.hasText {
+			output.text.WriteString(piece.text)
+		}
+		if w.checkStream(output) {
+			w.writeBlocked(piece)
+			return "", false
+		}
+		if !w.redact {
+			continue
+		}
+		released := w.release(output, piece.finished)
+		switch {
+		case piece.hasText:
+			piece.set(released)
+			modified = true
+		case released == "":
+		case piece.set != nil:
+			piece.set(released)
+			modified = true
+		default:
+			// 结束分片没有文本字段时，在其之前输出暂缓的内容，当前分片的 event 行仍随后输出
+			w.writeEvent(w.format.textEvent(piece, released))
+		}
+	}
+	if !modified {
+		return data, true
+	}
+	out, err := common.Marshal(chunk)
+	if err != nil {
+		return data, true
+	}
+	return string(out), true
+}
+
+// checkStream 对新增内容及与已检查内容的重叠部分执行本地拦截与标记阶段，返回是否需要拦截
+func (w *GuardrailResponseWriter) checkStream(output *guardrailOutput) bool {
+	text := output.text.String()
+	if output.scanned == len(text) {
+		return false
+	}
+	start := max(output.scanned-w.overlap, 0)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	window := text[start:]
+	output.scanned = len(text)
+	for i := range w.stages {
+		stage := &w.stages[i]
+		if stage.Type == operation_setting.GuardrailStageModeration || stage.Action == operation_setting.GuardrailActionRedact {
+			continue
+		}
+		if w.hitStages[i] {
+			continue
+		}
+		matches := detectGuardrailMatches(stage, window)
+		if len(matches) == 0 {
+			continue
+		}
+		w.hitStages[i] = true
+		recordGuardrailHit(w.c, operation_setting.GuardrailScopeCompletion, stage, guardrailMatchLabels(matches))
+		if stage.Action == operation_setting.GuardrailActionBlock {
+			return true
+		}
+	}
+	return false
+}
+
+// release 计算可以输出的内容并完成脱敏。未结束时保留尾部 holdback 个字符，
+// 且不会在尚未完整的命中中间截断
+func (w *GuardrailResponseWriter) release(output *guardrailOutput, finished bool) string {
+	pending := output.text.String()[output.released:]
+	end := len(pending)
+	if !finished {
+		end -= w.holdback
+		for end > 0 && end < len(pending) && !utf8.RuneStart(pending[end]) {
+			end--
+		}
+	}
+	if end <= 0 {
+		return ""
+	}
+	var matches []guardrailMatch
+	for i := range w.stages {
+		stage := &w.stages[i]
+		if stage.Action != operation_setting.GuardrailActionRedact || stage.Type == operation_setting.GuardrailStageModeration {
+			continue
+		}
+		stageMatches := detectGuardrailMatches(stage, pending)
+		if len(stageMatches) > 0 && !w.hitStages[i] {
+			w.hitStages[i] = true
+			recordGuardrailHit(w.c, operation_setting.GuardrailScopeCompletion, stage, guardrailMatchLabels(stageMatches))
+		}
+		matches = append(matches, stageMatches...)
+	}
+	matches = dedupeGuardrailMatches(matches)
+	if !finished {
+		// 命中可能随后续内容继续延长，延后到下一次输出
+		for _, m := range matches {
+			if m.end >= end && m.start < end {
+				end = m.start
+				break
+			}
+		}
+	}
+	settled := make([]guardrailMatch, 0, len(matches))
+	for _, m := range matches {
+		if m.end <= end {
+			settled = append(settled, m)
+		}
+	}
+	output.released += end
+	return redactGuardrailMatches(pending[:end], settled)
+}
+
+// redactText 对完整内容执行脱敏阶段
+func (w *GuardrailResponseWriter) redactText(text string) string {
+	for i := range w.stages {
+		stage := &w.stages[i]
+		if stage.Action != operation_setting.GuardrailActionRedact || stage.Type == operation_setting.GuardrailStageModeration {
+			continue
+		}
+		if matches := detectGuardrailMatches(stage, text); len(matches) > 0 {
+			text = redactGuardrailMatches(text, matches)
+		}
+	}
+	return text
+}
+
+// writeBlocked 按响应格式结束流式响应
+func (w *GuardrailResponseWriter) writeBlocked(piece guardrailPiece) {
+	w.blocked = true
+	w.event = ""
+	for _, event := range w.format.blockEvents(piece) {
+		w.writeEvent(event)
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finishStream 输出暂缓的剩余内容，并对完整内容执行外部审核。
+// 流式内容已经输出，审核命中时只能记录
+func (w *GuardrailResponseWriter) finishStream() {
+	if w.done || w.blocked {
+		return
+	}
+	for _, key := range w.order {
+		output := w.outputs[key]
+		if w.redact && output.released < output.text.Len() {
+			w.writeEvent(w.format.textEvent(output.piece, w.release(output, true)))
+		}
+		w.moderate(output.text.String())
+	}
+}
+
+func (w *GuardrailResponseWriter) moderate(text string) {
+	for i := range w.stages {
+		stage := &w.stages[i]
+		if stage.Type != operation_setting.GuardrailStageModeration {
+			continue
+		}
+		flagged, categories := runModerationStage(w.c, stage, text)
+		if !flagged {
+			continue
+		}
+		recordGuardrailHit(w.c, operation_setting.GuardrailScopeCompletion, stage, categories)
+	}
+}
+
+// checkText 对完整内容依次执行全部阶段，返回处理后的内容及是否拦截
+func (w *GuardrailResponseWriter) checkText(text string) (string, bool) {
+	for i := range w.stages {
+		stage := &w.stages[i]
+		if stage.Type == operation_setting.GuardrailStageModeration {
+			flagged, categories := runModerationStage(w.c, stage, text)
+			if !flagged {
+				continue
+			}
+			recordGuardrailHit(w.c, operation_setting.GuardrailScopeCompletion, stage, categories)
+			if stage.Action == operation_setting.GuardrailActionBlock {
+				return text, true
+			}
+			continue
+		}
+		matches := detectGuardrailMatches(stage, text)
+		if len(matches) == 0 {
+			continue
+		}
+		recordGuardrailHit(w.c, operation_setting.GuardrailScopeCompletion, stage, guardrailMatchLabels(matches))
+		switch stage.Action {
+		case operation_setting.GuardrailActionBlock:
+			return text, true
+		case operation_setting.GuardrailActionRedact:
+			text = redactGuardrailMatches(text, matches)
+		}
+	}
+	return text, false
+}
+
+// Finish 输出剩余内容（流式）或检查后的完整响应（非流式），并恢复原始 Writer
+func (w *GuardrailResponseWriter) Finish() {
+	defer func() {
+		w.c.Writer = w.ResponseWriter
+	}()
+	w.decide()
+	if w.isStream {
+		w.buf.FlushLines(w.processLine)
+		w.writeEventLine()
+		w.finishStream()
+		w.ResponseWriter.Flush()
+		return
+	}
+	if w.buf.Len() == 0 {
+		return
+	}
+
+	var response map[string]any
+	if err := common.Unmarshal(w.buf.Bytes(), &response); err != nil {
+		w.writeBody(w.buf.Bytes())
+		return
+	}
+	for _, piece := range w.format.bodyPieces(response) {
+		if !piece.hasText || piece.text == "" {
+			continue
+		}
+		checked, blocked := w.checkText(piece.text)
+		if blocked {
+			w.blocked = true
+			piece.block()
+			continue
+		}
+		if checked != piece.text {
+			piece.set(checked)
+		}
+	}
+	body, err := common.Marshal(response)
+	if err != nil {
+		w.writeBody(w.buf.Bytes())
+		return
+	}
+	w.writeBody(body)
+}
+
+func (w *GuardrailResponseWriter) writeBody(body []byte) {
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	_, _ = w.ResponseWriter.Write(body)
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/setting/operation_setting"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupGuardrailTest(t *testing.T, stages []operation_setting.GuardrailStage) {
+	t.Helper()
+	guardrail := operation_setting.GetGuardrailSetting()
+	previous := *guardrail
+	guardrail.Enabled = true
+	guardrail.Policies = map[string][]operation_setting.GuardrailStage{"test": stages}
+	guardrail.DefaultPolicy = "test"
+	guardrail.StreamHoldbackChars = 16
+	t.Cleanup(func() {
+		*guardrail = previous
+	})
+}
+
+// runGuardrailWriter 依次写入分片后返回下游收到的内容
+func runGuardrailWriter(t *testing.T, format string, contentType string, chunks []string) (string, *GuardrailResponseWriter) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+	writer := NewGuardrailResponseWriter(c, format)
+	if writer == nil {
+		t.Fatal("guardrail writer not created")
+	}
+	c.Header("Content-Type", contentType)
+	for _, chunk := range chunks {
+		_, _ = c.Writer.WriteString(chunk)
+	}
+	writer.Finish()
+	return recorder.Body.String(), writer
+}
+
+var redactEmailStages = []operation_setting.GuardrailStage{{
+	Type:      operation_setting.GuardrailStagePII,
+	Action:    operation_setting.GuardrailActionRedact,
+	Detectors: []string{operation_setting.GuardrailPIIEmail},
+}}
+
+var blockKeywordStages = []operation_setting.GuardrailStage{{
+	Type:     operation_setting.GuardrailStageKeyword,
+	Action:   operation_setting.GuardrailActionBlock,
+	Patterns: []string{"forbidden"},
+}}
+
+func TestGuardrailWriterStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		stages   []operation_setting.GuardrailStage
+		chunks   []string
+		contains []string
+		excludes []string
+		blocked  bool
+	}{
+		{
+			name:   "openai redact email split across chunks",
+			format: GuardrailFormatOpenAI,
+			stages: redactEmailStages,
+			chunks: []string{
+				`data: {"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"mail me at john.d"}}]}` + "\n\n",
+				`data: {"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"oe@example.com please"}}]}` + "\n\n",
+				`data: {"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n",
+				"data: [DONE]\n\n",
+			},
+			contains: []string{"[REDACTED_EMAIL]", "please", "data: [DONE]"},
+			excludes: []string{"example.com"},
+		},
+		{
+			name:   "openai block keyword after many chunks",
+			format: GuardrailFormatOpenAI,
+			stages: blockKeywordStages,
+			chunks: append(
+				[]string{strings.Repeat(`data: {"choices":[{"index":0,"delta":{"content":"lorem ipsum dolor "}}]}`+"\n\n", 50)},
+				`data: {"choices":[{"index":0,"delta":{"content":"forb"}}]}`+"\n\n",
+				`data: {"choices":[{"index":0,"delta":{"content":"idden secret"}}]}`+"\n\n",
+				"data: [DONE]\n\n",
+			),
+			contains: []string{`"finish_reason":"content_filter"`, "data: [DONE]"},
+			excludes: []string{"secret"},
+			blocked:  true,
+		},
+		{
+			name:   "claude redact flushed before content_block_stop",
+			format: GuardrailFormatClaude,
+			stages: redactEmailStages,
+			chunks: []string{
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n",
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"write to a@b.io\"}}\n\n",
+				"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n",
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			},
+			contains: []string{"write to [REDACTED_EMAIL]", "event: content_block_stop", "event: message_stop"},
+			excludes: []string{"a@b.io"},
+		},
+		{
+			name:   "claude block ends message with refusal",
+			format: GuardrailFormatClaude,
+			stages: blockKeywordStages,
+			chunks: []string{
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"this is forbidden\"}}\n\n",
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\" more\"}}\n\n",
+			},
+			contains: []string{`"stop_reason":"refusal"`, "event: message_stop"},
+			excludes: []string{"more"},
+			blocked:  true,
+		},
+		{
+			name:   "gemini redact",
+			format: GuardrailFormatGemini,
+			stages: redactEmailStages,
+			chunks: []string{
+				`data: {"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"contact x@y.com"}]}}]}` + "\n\n",
+				`data: {"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":" now"}]},"finishReason":"STOP"}]}` + "\n\n",
+			},
+			contains: []string{"[REDACTED_EMAIL]", "STOP"},
+			excludes: []string{"x@y.com"},
+		},
+		{
+			name:   "gemini block",
+			format: GuardrailFormatGemini,
+			stages: blockKeywordStages,
+			chunks: []string{
+				`data: {"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"forbidden"}]}}]}` + "\n\n",
+			},
+			contains: []string{`"finishReason":"SAFETY"`},
+			excludes: []string{`"text":"forbidden"`},
+			blocked:  true,
+		},
+		{
+			name:   "responses redact deltas and done events",
+			format: GuardrailFormatResponses,
+			stages: redactEmailStages,
+			chunks: []string{
+				"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"m1\",\"output_index\":0,\"content_index\":0,\"delta\":\"reach q@w.org\"}\n\n",
+				"event: response.output_text.done\ndata: {\"type\":\"response.output_text.done\",\"item_id\":\"m1\",\"output_index\":0,\"content_index\":0,\"text\":\"reach q@w.org\"}\n\n",
+				"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"r1\",\"output\":[{\"type\":\"message\",\"content\":[{\"type\":\"output_text\",\"text\":\"reach q@w.org\"}]}]}}\n\n",
+			},
+			contains: []string{"[REDACTED_EMAIL]", "event: response.completed"},
+			excludes: []string{"q@w.org"},
+		},
+		{
+			name:   "responses block",
+			format: GuardrailFormatResponses,
+			stages: blockKeywordStages,
+			chunks: []string{
+				"event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"r1\"}}\n\n",
+				"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"m1\",\"output_index\":0,\"content_index\":0,\"delta\":\"forbidden\"}\n\n",
+			},
+			contains: []string{"event: response.incomplete", `"reason":"content_filter"`, `"id":"r1"`},
+			excludes: []string{`"delta":"forbidden"`},
+			blocked:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupGuardrailTest(t, tt.stages)
+			body, writer := runGuardrailWriter(t, tt.format, "text/event-stream", tt.chunks)
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("output missing %q:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("output should not contain %q:\n%s", s, body)
+				}
+			}
+			if writer.Blocked() != tt.blocked {
+				t.Errorf("blocked = %v, want %v", writer.Blocked(), tt.blocked)
+			}
+		})
+	}
+}
+
+func TestGuardrailWriterBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		stages   []operation_setting.GuardrailStage
+		body     string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "openai redact",
+			format:   GuardrailFormatOpenAI,
+			stages:   redactEmailStages,
+			body:     `{"choices":[{"index":0,"message":{"role":"assistant","content":"mail a@b.io"},"finish_reason":"stop"}]}`,
+			contains: []string{"mail [REDACTED_EMAIL]"},
+			excludes: []string{"a@b.io"},
+		},
+		{
+			name:     "claude block",
+			format:   GuardrailFormatClaude,
+			stages:   blockKeywordStages,
+			body:     `{"content":[{"type":"text","text":"forbidden"}],"stop_reason":"end_turn"}`,
+			contains: []string{`"stop_reason":"refusal"`},
+			excludes: []string{"forbidden"},
+		},
+		{
+			name:     "gemini redact",
+			format:   GuardrailFormatGemini,
+			stages:   redactEmailStages,
+			body:     `{"candidates":[{"index":0,"content":{"parts":[{"text":"mail a@b.io"}]},"finishReason":"STOP"}]}`,
+			contains: []string{"mail [REDACTED_EMAIL]"},
+			excludes: []string{"a@b.io"},
+		},
+		{
+			name:     "responses block",
+			format:   GuardrailFormatResponses,
+			stages:   blockKeywordStages,
+			body:     `{"id":"r1","status":"completed","output":[{"type":"message","content":[{"type":"output_text","text":"forbidden"}]}]}`,
+			contains: []string{`"status":"incomplete"`, `"reason":"content_filter"`},
+			excludes: []string{"forbidden"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupGuardrailTest(t, tt.stages)
+			body, _ := runGuardrailWriter(t, tt.format, "application/json", []string{tt.body})
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("output missing %q:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("output should not contain %q:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
@@ -24,6 +24,15 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	if relayInfo.ReasoningEffort != "" {
 		other["reasoning_effort"] = relayInfo.ReasoningEffort
 	}
+	if hits := common.GetContextKeyStringSlice(ctx, constant.ContextKeyGuardrailHits); len(hits) > 0 {
+		other["guardrail_hits"] = hits
+	}
+	if fallbackFrom := common.GetContextKeyString(ctx, constant.ContextKeyFallbackFrom); fallbackFrom != "" {
+		other["fallback_from"] = fallbackFrom
+	}
+	if relayInfo.ClientDisconnected {
+		other["client_disconnected"] = true
+	}
 	if relayInfo.IsModelMapped {
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "newapi"
+
+var relayLabels = []string{"model", "channel_id", "channel_type", "group"}
+
+var (
+	relayAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_attempts_total",
+		Help:      "Upstream relay attempts, including retries.",
+	}, append(relayLabels, "attempt", "status"))
+	relayErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_errors_total",
+		Help:      "Failed relay attempts by error code.",
+	}, append(relayLabels, "attempt", "error_code", "status_code"))
+	relayRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_requests_total",
+		Help:      "Final outcome of relay requests after retries.",
+	}, append(relayLabels, "status", "error_code", "retried"))
+	relayUpstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_upstream_latency_seconds",
+		Help:      "Time from sending an upstream request to receiving its response headers.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, append(relayLabels, "status", "stream"))
+	relayTimeToFirstToken = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_time_to_first_token_seconds",
+		Help:      "Time to first token of streaming relay requests.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20, 30, 60},
+	}, relayLabels)
+	relayTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_tokens_total",
+		Help:      "Tokens consumed by relay requests.",
+	}, append(relayLabels, "type"))
+	relayQuotaTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_quota_total",
+		Help:      "Quota consumed by relay requests.",
+	}, relayLabels)
+)
+
+func init() {
+	prometheus.MustRegister(
+		relayAttemptsTotal,
+		relayErrorsTotal,
+		relayRequestsTotal,
+		relayUpstreamLatency,
+		relayTimeToFirstToken,
+		relayTokensTotal,
+		relayQuotaTotal,
+	)
+}
+
+// relayMetricLabels 从上下文中取出当前请求的模型、渠道和分组标签
+func relayMetricLabels(c *gin.Context) prometheus.Labels {
+	channelId, channelType := "", ""
+	if id := common.GetContextKeyInt(c, constant.ContextKeyChannelId); id != 0 {
+		channelId = strconv.Itoa(id)
+		channelType = strconv.Itoa(common.GetContextKeyInt(c, constant.ContextKeyChannelType))
+	}
+	return prometheus.Labels{
+		"model":        common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		"channel_id":   channelId,
+		"channel_type": channelType,
+		"group":        common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+	}
+}
+
+func withLabels(base prometheus.Labels, kv ...string) prometheus.Labels {
+	labels := make(prometheus.Labels, len(base)+len(kv)/2)
+	for k, v := range base {
+		labels[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		labels[kv[i]] = kv[i+1]
+	}
+	return labels
+}
+
+func metricStatus(err *types.NewAPIError) string {
+	if err == nil {
+		return "success"
+	}
+	return "error"
+}
+
+// RecordRelayAttemptMetrics 记录一次上游尝试（包括重试）的请求数、错误、上游延迟，以及成功时的用量。
+// 上游延迟只统计请求上游到收到响应头的耗时，不包括选择渠道、转换请求和读取响应体，没有请求上游时不记录
+func RecordRelayAttemptMetrics(c *gin.Context, retryIndex int, err *types.NewAPIError) {
+	if !constant.MetricsEnabled {
+		return
+	}
+	labels := relayMetricLabels(c)
+	attempt := "first"
+	if retryIndex > 0 {
+		attempt = "retry"
+	}
+	status := metricStatus(err)
+	relayAttemptsTotal.With(withLabels(labels, "attempt", attempt, "status", status)).Inc()
+
+	if err != nil {
+		relayErrorsTotal.With(withLabels(labels, "attempt", attempt,
+			"error_code", string(err.GetErrorCode()), "status_code", strconv.Itoa(err.StatusCode))).Inc()
+	}
+	if latency, _ := common.GetContextKeyType[time.Duration](c, constant.ContextKeyUpstreamLatency); latency > 0 {
+		isStream := strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream")
+		relayUpstreamLatency.With(withLabels(labels, "status", status, "stream", strconv.FormatBool(isStream))).Observe(latency.Seconds())
+	}
+	if err != nil {
+		return
+	}
+
+	usage, ok := common.GetContextKeyType[model.RecordConsumeLogParams](c, constant.ContextKeyConsumeLogParams)
+	if !ok {
+		return
+	}
+	if usage.IsStream {
+		if frt, ok := usage.Other["frt"].(float64); ok && frt > 0 {
+			relayTimeToFirstToken.With(labels).Observe(frt / 1000)
+		}
+	}
+	relayTokensTotal.With(withLabels(labels, "type", "prompt")).Add(float64(usage.PromptTokens))
+	relayTokensTotal.With(withLabels(labels, "type", "completion")).Add(float64(usage.CompletionTokens))
+	relayQuotaTotal.With(labels).Add(float64(usage.Quota))
+}
+
+// RecordRelayOutcomeMetrics 记录重试结束后请求的最终结果
+func RecordRelayOutcomeMetrics(c *gin.Context, retryIndex int, err *types.NewAPIError) {
+	if !constant.MetricsEnabled {
+		return
+	}
+	errorCode := ""
+	if err != nil {
+		errorCode = string(err.GetErrorCode())
+	}
+	relayRequestsTotal.With(withLabels(relayMetricLabels(c),
+		"status", metricStatus(err), "error_code", errorCode, "retried", strconv.FormatBool(retryIndex > 0))).Inc()
+}
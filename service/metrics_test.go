@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/types"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newMetricsTestContext 开启指标并创建带有模型、分组上下文的请求，每个测试使用不同的模型以免互相影响
+func newMetricsTestContext(t *testing.T, modelName string) *gin.Context {
+	t.Helper()
+	previous := constant.MetricsEnabled
+	constant.MetricsEnabled = true
+	t.Cleanup(func() { constant.MetricsEnabled = previous })
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+	return c
+}
+
+func TestRelayMetricLabels(t *testing.T) {
+	c := newMetricsTestContext(t, "metrics-labels")
+	want := prometheus.Labels{"model": "metrics-labels", "channel_id": "", "channel_type": "", "group": "default"}
+	if got := relayMetricLabels(c); !equalLabels(got, want) {
+		t.Fatalf("labels without channel = %v, want %v", got, want)
+	}
+
+	common.SetContextKey(c, constant.ContextKeyChannelId, 7)
+	common.SetContextKey(c, constant.ContextKeyChannelType, 14)
+	want["channel_id"], want["channel_type"] = "7", "14"
+	if got := relayMetricLabels(c); !equalLabels(got, want) {
+		t.Fatalf("labels = %v, want %v", got, want)
+	}
+}
+
+func equalLabels(got, want prometheus.Labels) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for k, v := range want {
+		if got[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRecordRelayAttemptAndOutcomeMetrics(t *testing.T) {
+	c := newMetricsTestContext(t, "metrics-attempts")
+	common.SetContextKey(c, constant.ContextKeyChannelId, 7)
+	common.SetContextKey(c, constant.ContextKeyChannelType, 1)
+	labels := relayMetricLabels(c)
+	apiErr := types.NewErrorWithStatusCode(errors.New("upstream error"), types.ErrorCodeBadResponseStatusCode, http.StatusBadGateway)
+
+	attempts := func(attempt, status string) float64 {
+		return testutil.ToFloat64(relayAttemptsTotal.With(withLabels(labels, "attempt", attempt, "status", status)))
+	}
+	outcomes := func(status, errorCode, retried string) float64 {
+		return testutil.ToFloat64(relayRequestsTotal.With(withLabels(labels, "status", status, "error_code", errorCode, "retried", retried)))
+	}
+
+	// 第一次尝试失败、重试成功，各记录一次尝试，不记录最终结果
+	RecordRelayAttemptMetrics(c, 0, apiErr)
+	RecordRelayAttemptMetrics(c, 1, nil)
+	if got := attempts("first", "error"); got != 1 {
+		t.Fatalf("first error attempts = %v, want 1", got)
+	}
+	if got := attempts("retry", "success"); got != 1 {
+		t.Fatalf("retry success attempts = %v, want 1", got)
+	}
+	errorLabels := withLabels(labels, "attempt", "first", "error_code", string(types.ErrorCodeBadResponseStatusCode), "status_code", "502")
+	if got := testutil.ToFloat64(relayErrorsTotal.With(errorLabels)); got != 1 {
+		t.Fatalf("errors = %v, want 1", got)
+	}
+	if got := outcomes("success", "", "true"); got != 0 {
+		t.Fatalf("outcomes after attempts = %v, want 0", got)
+	}
+
+	// 最终结果只记录一次，不影响尝试次数
+	RecordRelayOutcomeMetrics(c, 1, nil)
+	if got := outcomes("success", "", "true"); got != 1 {
+		t.Fatalf("success outcomes = %v, want 1", got)
+	}
+	if got := attempts("first", "error") + attempts("retry", "success"); got != 2 {
+		t.Fatalf("attempts after outcome = %v, want 2", got)
+	}
+}
+
+func TestRecordRelayAttemptUpstreamLatency(t *testing.T) {
+	c := newMetricsTestContext(t, "metrics-latency")
+	series := testutil.CollectAndCount(relayUpstreamLatency)
+
+	// 没有请求上游时不记录上游延迟
+	RecordRelayAttemptMetrics(c, 0, types.NewError(errors.New("no channel"), types.ErrorCodeDoRequestFailed))
+	if got := testutil.CollectAndCount(relayUpstreamLatency); got != series {
+		t.Fatalf("latency series = %d without upstream request, want %d", got, series)
+	}
+
+	common.SetContextKey(c, constant.ContextKeyUpstreamLatency, 200*time.Millisecond)
+	RecordRelayAttemptMetrics(c, 0, nil)
+	if got := testutil.CollectAndCount(relayUpstreamLatency); got != series+1 {
+		t.Fatalf("latency series = %d, want %d", got, series+1)
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/setting/model_setting"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetFallbackModels 获取请求模型的备用模型，已排除令牌无权访问的模型
+func GetFallbackModels(c *gin.Context, requestModel string) []string {
+	chain := model_setting.GetFallbackChain(requestModel)
+	if len(chain) == 0 {
+		return nil
+	}
+	// 指定渠道的令牌不切换模型
+	if _, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId); ok {
+		return nil
+	}
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return chain
+	}
+	limit, _ := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	models := make([]string, 0, len(chain))
+	for _, fallback := range chain {
+		if limit[fallback] {
+			models = append(models, fallback)
+		}
+	}
+	return models
+}
+
+// GetRemainingFallbackModels 获取当前服务模型之后还可以尝试的备用模型
+func GetRemainingFallbackModels(c *gin.Context, servedModel string) []string {
+	requestModel := common.GetContextKeyString(c, constant.ContextKeyFallbackFrom)
+	if requestModel == "" {
+		requestModel = servedModel
+	}
+	models := GetFallbackModels(c, requestModel)
+	for i, fallback := range models {
+		if fallback == servedModel {
+			return models[i+1:]
+		}
+	}
+	return models
+}
+
+// SwitchFallbackModel 记录从请求模型切换到备用模型，用于响应头与消费日志
+func SwitchFallbackModel(c *gin.Context, fromModel string, toModel string) {
+	requestModel := common.GetContextKeyString(c, constant.ContextKeyFallbackFrom)
+	if requestModel == "" {
+		requestModel = fromModel
+		common.SetContextKey(c, constant.ContextKeyFallbackFrom, requestModel)
+	}
+	common.LogInfo(c, fmt.Sprintf("model fallback: %s -> %s (requested %s)", fromModel, toModel, requestModel))
+}
+
+// ShouldFallback 判断请求模型的渠道均失败后是否切换备用模型
+func ShouldFallback(err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	switch err.GetErrorCode() {
+	case types.ErrorCodeGetChannelFailed, types.ErrorCodeChannelKeysRateLimited, types.ErrorCodeContentFiltered:
+		return true
+	}
+	if types.IsChannelError(err) {
+		return true
+	}
+	if types.IsLocalError(err) {
+		return false
+	}
+	if err.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	// 超时可能已被上游处理，与重试保持一致不切换
+	if err.StatusCode == http.StatusGatewayTimeout || err.StatusCode == 524 {
+		return false
+	}
+	return err.StatusCode/100 == 5
+}
+
+type fallbackRefusalResponse struct {
+	Choices []struct {
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	StopReason        string `json:"stop_reason"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Candidates []struct {
+		FinishReason string `json:"finishReason"`
+	} `json:"candidates"`
+}
+
+// isRefusalResponse 判断 OpenAI、Claude、Responses、Gemini 格式的响应是否因内容过滤被拒绝
+func isRefusalResponse(body []byte) bool {
+	var response fallbackRefusalResponse
+	if err := common.Unmarshal(body, &response); err != nil {
+		return false
+	}
+	for _, choice := range response.Choices {
+		if choice.FinishReason == "content_filter" {
+			return true
+		}
+	}
+	if response.StopReason == "refusal" {
+		return true
+	}
+	if response.IncompleteDetails != nil && response.IncompleteDetails.Reason == "content_filter" {
+		return true
+	}
+	for _, candidate := range response.Candidates {
+		switch candidate.FinishReason {
+		case "SAFETY", "PROHIBITED_CONTENT", "BLOCKLIST", "SPII":
+			return true
+		}
+	}
+	return false
+}
+
+// FallbackResponseWriter 暂存非流式响应，上游拒绝时丢弃响应以便切换备用模型。
+// 流式响应（text/event-stream）直接透传给客户端，不会被识别为拒绝，也不会触发降级
+type FallbackResponseWriter struct {
+	gin.ResponseWriter
+	c           *gin.Context
+	header      http.Header
+	buf         bytes.Buffer
+	decided     bool
+	passthrough bool
+}
+
+// NewFallbackResponseWriter 未开启内容过滤降级时返回 nil
+func NewFallbackResponseWriter(c *gin.Context) *FallbackResponseWriter {
+	if !model_setting.GetFallbackSettings().OnContentFilter {
+		return nil
+	}
+	writer := &FallbackResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		header:         c.Writer.Header().Clone(),
+	}
+	c.Writer = writer
+	common.SetContextKey(c, constant.ContextKeyFallbackWriter, writer)
+	return writer
+}
+
+// CheckFallbackRefusal 在结算前检查暂存的响应是否被上游内容过滤，被拒绝时返回错误，
+// 由调用方退还预扣额度后切换备用模型，避免被拒绝的请求与备用模型的请求重复计费
+func CheckFallbackRefusal(c *gin.Context, modelName string) *types.NewAPIError {
+	writer, ok := common.GetContextKeyType[*FallbackResponseWriter](c, constant.ContextKeyFallbackWriter)
+	if !ok || writer == nil || !writer.Refused() {
+		return nil
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("model %s refused the request due to content filtering", modelName), types.ErrorCodeContentFiltered, http.StatusBadRequest)
+}
+
+func (w *FallbackResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.passthrough = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func (w *FallbackResponseWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+func (w *FallbackResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 流式响应无法撤回，直接透传
+func (w *FallbackResponseWriter) Flush() {
+	w.decide()
+	if w.passthrough {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// Refused 暂存的响应是否被上游内容过滤，护栏自身拦截的输出不算
+func (w *FallbackResponseWriter) Refused() bool {
+	if w.passthrough || w.buf.Len() == 0 || w.Status() != http.StatusOK {
+		return false
+	}
+	for _, hit := range common.GetContextKeyStringSlice(w.c, constant.ContextKeyGuardrailHits) {
+		if strings.HasPrefix(hit, operation_setting.GuardrailScopeCompletion+":") && strings.HasSuffix(hit, ":"+operation_setting.GuardrailActionBlock) {
+			return false
+		}
+	}
+	return isRefusalResponse(w.buf.Bytes())
+}
+
+// Discard 丢弃暂存的响应并还原响应头
+func (w *FallbackResponseWriter) Discard() {
+	w.c.Writer = w.ResponseWriter
+	common.SetContextKey(w.c, constant.ContextKeyFallbackWriter, (*FallbackResponseWriter)(nil))
+	w.buf.Reset()
+	header := w.ResponseWriter.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range w.header {
+		header[key] = values
+	}
+}
+
+// Finish 写出暂存的响应
+func (w *FallbackResponseWriter) Finish() {
+	w.c.Writer = w.ResponseWriter
+	common.SetContextKey(w.c, constant.ContextKeyFallbackWriter, (*FallbackResponseWriter)(nil))
+	if w.buf.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+}
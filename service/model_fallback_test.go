@@ -0,0 +1,193 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShouldFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *types.NewAPIError
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "no channel", err: types.NewError(errors.New("no channel"), types.ErrorCodeGetChannelFailed), want: true},
+		{name: "keys rate limited", err: types.NewErrorWithStatusCode(errors.New("limited"), types.ErrorCodeChannelKeysRateLimited, http.StatusTooManyRequests), want: true},
+		{name: "content filtered", err: types.NewErrorWithStatusCode(errors.New("refused"), types.ErrorCodeContentFiltered, http.StatusBadRequest), want: true},
+		{name: "channel error", err: types.NewError(errors.New("no key"), types.ErrorCodeChannelNoAvailableKey), want: true},
+		{name: "local error", err: types.NewError(errors.New("bad body"), types.ErrorCodeInvalidRequest), want: false},
+		{name: "upstream 429", err: types.NewOpenAIError(errors.New("slow down"), types.ErrorCodeBadResponseStatusCode, http.StatusTooManyRequests), want: true},
+		{name: "upstream 500", err: types.NewOpenAIError(errors.New("boom"), types.ErrorCodeBadResponseStatusCode, http.StatusInternalServerError), want: true},
+		{name: "upstream 504", err: types.NewOpenAIError(errors.New("timeout"), types.ErrorCodeBadResponseStatusCode, http.StatusGatewayTimeout), want: false},
+		{name: "upstream 524", err: types.NewOpenAIError(errors.New("timeout"), types.ErrorCodeBadResponseStatusCode, 524), want: false},
+		{name: "upstream 400", err: types.NewOpenAIError(errors.New("bad request"), types.ErrorCodeBadResponseStatusCode, http.StatusBadRequest), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShouldFallback(tt.err); got != tt.want {
+				t.Errorf("ShouldFallback() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRefusalResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "openai content filter", body: `{"choices":[{"finish_reason":"stop"},{"finish_reason":"content_filter"}]}`, want: true},
+		{name: "openai stop", body: `{"choices":[{"finish_reason":"stop"}]}`, want: false},
+		{name: "claude refusal", body: `{"type":"message","stop_reason":"refusal"}`, want: true},
+		{name: "claude end turn", body: `{"type":"message","stop_reason":"end_turn"}`, want: false},
+		{name: "responses content filter", body: `{"status":"incomplete","incomplete_details":{"reason":"content_filter"}}`, want: true},
+		{name: "responses max tokens", body: `{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"}}`, want: false},
+		{name: "gemini safety", body: `{"candidates":[{"finishReason":"SAFETY"}]}`, want: true},
+		{name: "gemini prohibited", body: `{"candidates":[{"finishReason":"PROHIBITED_CONTENT"}]}`, want: true},
+		{name: "gemini stop", body: `{"candidates":[{"finishReason":"STOP"}]}`, want: false},
+		{name: "not json", body: `data: {"choices":[{"finish_reason":"content_filter"}]}`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRefusalResponse([]byte(tt.body)); got != tt.want {
+				t.Errorf("isRefusalResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// setFallbackSettings 临时替换降级配置，测试结束后恢复
+func setFallbackSettings(t *testing.T, settings model_setting.FallbackSettings) {
+	t.Helper()
+	current := model_setting.GetFallbackSettings()
+	previous := *current
+	*current = settings
+	t.Cleanup(func() {
+		*current = previous
+	})
+}
+
+func newFallbackTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c, recorder
+}
+
+func TestGetRemainingFallbackModels(t *testing.T) {
+	setFallbackSettings(t, model_setting.FallbackSettings{
+		Chains: map[string][]string{"model-a": {"model-b", "model-c"}},
+	})
+	tests := []struct {
+		name        string
+		servedModel string
+		setup       func(c *gin.Context)
+		want        []string
+	}{
+		{name: "requested model", servedModel: "model-a", want: []string{"model-b", "model-c"}},
+		{name: "after switching", servedModel: "model-b", setup: func(c *gin.Context) {
+			common.SetContextKey(c, constant.ContextKeyFallbackFrom, "model-a")
+		}, want: []string{"model-c"}},
+		{name: "last fallback", servedModel: "model-c", setup: func(c *gin.Context) {
+			common.SetContextKey(c, constant.ContextKeyFallbackFrom, "model-a")
+		}, want: []string{}},
+		{name: "no chain", servedModel: "model-b", want: nil},
+		{name: "token model limit", servedModel: "model-a", setup: func(c *gin.Context) {
+			common.SetContextKey(c, constant.ContextKeyTokenModelLimitEnabled, true)
+			common.SetContextKey(c, constant.ContextKeyTokenModelLimit, map[string]bool{"model-a": true, "model-c": true})
+		}, want: []string{"model-c"}},
+		{name: "token specific channel", servedModel: "model-a", setup: func(c *gin.Context) {
+			common.SetContextKey(c, constant.ContextKeyTokenSpecificChannelId, "1")
+		}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newFallbackTestContext()
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			if got := GetRemainingFallbackModels(c, tt.servedModel); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetRemainingFallbackModels() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackResponseWriter(t *testing.T) {
+	refusal := `{"choices":[{"finish_reason":"content_filter"}]}`
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+		guardrail   bool
+		wantRefused bool
+		wantWritten string
+	}{
+		{name: "refused", contentType: "application/json", status: http.StatusOK, body: refusal, wantRefused: true},
+		{name: "answered", contentType: "application/json", status: http.StatusOK, body: `{"choices":[{"finish_reason":"stop"}]}`, wantWritten: `{"choices":[{"finish_reason":"stop"}]}`},
+		{name: "error status", contentType: "application/json", status: http.StatusBadRequest, body: refusal, wantWritten: refusal},
+		{name: "blocked by output guardrail", contentType: "application/json", status: http.StatusOK, body: refusal, guardrail: true, wantWritten: refusal},
+		// 流式响应已写给客户端，无法撤回
+		{name: "stream passes through", contentType: "text/event-stream", status: http.StatusOK, body: "data: " + refusal + "\n\n", wantWritten: "data: " + refusal + "\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFallbackSettings(t, model_setting.FallbackSettings{OnContentFilter: true})
+			c, recorder := newFallbackTestContext()
+			writer := NewFallbackResponseWriter(c)
+			if tt.guardrail {
+				common.SetContextKey(c, constant.ContextKeyGuardrailHits, []string{"completion:keyword:block"})
+			}
+			c.Header("Content-Type", tt.contentType)
+			c.Status(tt.status)
+			_, _ = c.Writer.WriteString(tt.body)
+			c.Writer.Flush()
+			if tt.contentType != "text/event-stream" && recorder.Body.Len() != 0 {
+				t.Fatalf("non-stream body written before the refusal check: %s", recorder.Body.String())
+			}
+
+			refusalErr := CheckFallbackRefusal(c, "model-a")
+			if (refusalErr != nil) != tt.wantRefused {
+				t.Fatalf("CheckFallbackRefusal() = %v, want refused %v", refusalErr, tt.wantRefused)
+			}
+			if refusalErr != nil {
+				if refusalErr.GetErrorCode() != types.ErrorCodeContentFiltered {
+					t.Errorf("error code = %s", refusalErr.GetErrorCode())
+				}
+				writer.Discard()
+			} else {
+				writer.Finish()
+			}
+			if recorder.Body.String() != tt.wantWritten {
+				t.Errorf("body = %q, want %q", recorder.Body.String(), tt.wantWritten)
+			}
+			if c.Writer == writer {
+				t.Errorf("writer was not restored")
+			}
+		})
+	}
+}
+
+func TestNewFallbackResponseWriterDisabled(t *testing.T) {
+	setFallbackSettings(t, model_setting.FallbackSettings{OnContentFilter: false})
+	c, _ := newFallbackTestContext()
+	if writer := NewFallbackResponseWriter(c); writer != nil {
+		t.Errorf("NewFallbackResponseWriter() = %v, want nil when content filter fallback is disabled", writer)
+	}
+	if CheckFallbackRefusal(c, "model-a") != nil {
+		t.Errorf("CheckFallbackRefusal() returned an error without a fallback writer")
+	}
+}
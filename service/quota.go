@@ -20,6 +20,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TokenDetails struct {
@@ -80,7 +81,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetPayerQuota(relayInfo.UserId, relayInfo.OrgId)
 	if err != nil {
 		return err
 	}
@@ -228,6 +229,8 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
+	_, endSpan := common.StartSpan(ctx, "quota.settle", attribute.Int("quota.pre_consumed", preConsumedQuota))
+	defer endSpan()
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
@@ -340,6 +343,8 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData helper.PriceData
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
+	_, endSpan := common.StartSpan(ctx, "quota.settle", attribute.Int("quota.pre_consumed", preConsumedQuota))
+	defer endSpan()
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
@@ -445,33 +450,82 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", common.FormatQuota(token.RemainQuota), common.FormatQuota(quota))
 	}
-	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, consumeLedgerSource(relayInfo))
 	if err != nil {
 		return err
 	}
+	recordTokenQuotaUsage(relayInfo, quota)
 	return nil
 }
 
-func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
+func consumeLedgerSource(relayInfo *relaycommon.RelayInfo) model.LedgerSource {
+	return model.LedgerSource{
+		Reason:     model.LedgerReasonConsume,
+		SourceType: model.LedgerSourceRequest,
+		SourceId:   relayInfo.RequestId,
+		UserId:     relayInfo.UserId,
+	}
+}
+
+// GetPayerCharge 获取本次请求已扣额度的组成
+func GetPayerCharge(relayInfo *relaycommon.RelayInfo) model.PayerCharge {
+	return model.PayerCharge{
+		SubscriptionId:          relayInfo.ChargedSubscriptionId,
+		SubscriptionPeriodStart: relayInfo.ChargedSubscriptionPeriodStart,
+		SubscriptionQuota:       relayInfo.ChargedSubscriptionQuota,
+		Quota:                   relayInfo.ChargedQuota,
+	}
+}
 
+func setPayerCharge(relayInfo *relaycommon.RelayInfo, charge model.PayerCharge) {
+	relayInfo.ChargedSubscriptionId = charge.SubscriptionId
+	relayInfo.ChargedSubscriptionPeriodStart = charge.SubscriptionPeriodStart
+	relayInfo.ChargedSubscriptionQuota = charge.SubscriptionQuota
+	relayInfo.ChargedQuota = charge.Quota
+}
+
+// updatePayerQuota 扣除或退还付费方额度，并记录扣费组成，退款按组成原路退回
+func updatePayerQuota(relayInfo *relaycommon.RelayInfo, quota int, source model.LedgerSource) error {
+	charge := GetPayerCharge(relayInfo)
 	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
+		added, err := model.DecreasePayerQuota(relayInfo.UserId, relayInfo.OrgId, quota, source)
+		if err != nil {
+			return err
+		}
+		charge.Add(added)
 	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
+		refund := charge.Refund(-quota)
+		if err := model.IncreasePayerQuota(relayInfo.UserId, relayInfo.OrgId, refund, source); err != nil {
+			return err
+		}
+		charge.Sub(refund)
 	}
-	if err != nil {
+	setPayerCharge(relayInfo, charge)
+	return nil
+}
+
+// PreConsumePayerQuota 预扣付费方额度
+func PreConsumePayerQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	return updatePayerQuota(relayInfo, quota, consumeLedgerSource(relayInfo))
+}
+
+func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
+
+	source := consumeLedgerSource(relayInfo)
+	if err = updatePayerQuota(relayInfo, quota, source); err != nil {
 		return err
 	}
 
 	if !relayInfo.IsPlayground {
 		if quota > 0 {
-			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, source)
 		} else {
-			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota, source)
 		}
 		if err != nil {
 			return err
 		}
+		recordTokenQuotaUsage(relayInfo, quota)
 	}
 
 	if sendEmail {
@@ -484,6 +538,10 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 }
 
 func checkAndSendQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int) {
+	// 组织令牌消耗的是组织额度池，不提醒个人充值
+	if relayInfo.OrgId != 0 {
+		return
+	}
 	gopool.Go(func() {
 		userSetting := relayInfo.UserSetting
 		threshold := common.QuotaRemindThreshold
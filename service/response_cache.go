@@ -0,0 +1,230 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// ResponseCacheEntry 缓存的上游响应，流式响应保存完整的 SSE 数据
+type ResponseCacheEntry struct {
+	IsStream    bool       `json:"is_stream"`
+	ContentType string     `json:"content_type"`
+	Body        []byte     `json:"body"`
+	Usage       *dto.Usage `json:"usage"`
+	CreatedAt   int64      `json:"created_at"`
+}
+
+// GenerateResponseCacheKey 根据规范化后的完整请求体生成缓存键，缓存按用户隔离。
+// 请求体解析后重新序列化，字段顺序和空白不影响缓存键，任意参数不同都不会命中
+func GenerateResponseCacheKey(userId int, relayMode int, modelName string, requestBody []byte) (string, error) {
+	var request map[string]any
+	if err := common.Unmarshal(requestBody, &request); err != nil {
+		return "", err
+	}
+	if request == nil {
+		return "", errors.New("empty request body")
+	}
+	request["model"] = modelName
+	data, err := common.Marshal(map[string]any{
+		"relay_mode": relayMode,
+		"request":    request,
+	})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf("response_cache:%d:%s", userId, hex.EncodeToString(hash[:])), nil
+}
+
+type memoryResponseCacheItem struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+var memoryResponseCache = struct {
+	sync.Mutex
+	items map[string]memoryResponseCacheItem
+}{items: make(map[string]memoryResponseCacheItem)}
+
+func getMemoryResponseCache(key string) ([]byte, bool) {
+	memoryResponseCache.Lock()
+	defer memoryResponseCache.Unlock()
+	item, ok := memoryResponseCache.items[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(item.expiresAt) {
+		delete(memoryResponseCache.items, key)
+		return nil, false
+	}
+	return item.data, true
+}
+
+func setMemoryResponseCache(key string, data []byte, ttl time.Duration) {
+	maxEntries := operation_setting.GetResponseCacheSetting().MemoryMaxEntries
+	if maxEntries <= 0 {
+		return
+	}
+	now := time.Now()
+	memoryResponseCache.Lock()
+	defer memoryResponseCache.Unlock()
+	if _, exists := memoryResponseCache.items[key]; !exists && len(memoryResponseCache.items) >= maxEntries {
+		// 先清理过期条目，仍然不足时随机淘汰
+		for k, item := range memoryResponseCache.items {
+			if now.After(item.expiresAt) {
+				delete(memoryResponseCache.items, k)
+			}
+		}
+		for k := range memoryResponseCache.items {
+			if len(memoryResponseCache.items) < maxEntries {
+				break
+			}
+			delete(memoryResponseCache.items, k)
+		}
+	}
+	memoryResponseCache.items[key] = memoryResponseCacheItem{data: data, expiresAt: now.Add(ttl)}
+}
+
+// GetResponseCache 读取缓存响应，启用 Redis 时使用 Redis，否则使用内存缓存
+func GetResponseCache(key string) (*ResponseCacheEntry, bool) {
+	var data []byte
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				common.SysError("failed to get response cache: " + err.Error())
+			}
+			return nil, false
+		}
+		data = []byte(value)
+	} else {
+		var ok bool
+		data, ok = getMemoryResponseCache(key)
+		if !ok {
+			return nil, false
+		}
+	}
+	var entry ResponseCacheEntry
+	if err := common.Unmarshal(data, &entry); err != nil {
+		common.SysError("failed to unmarshal response cache: " + err.Error())
+		return nil, false
+	}
+	return &entry, true
+}
+
+func SetResponseCache(key string, entry *ResponseCacheEntry, ttl time.Duration) error {
+	data, err := common.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		return common.RedisSet(key, string(data), ttl)
+	}
+	setMemoryResponseCache(key, data, ttl)
+	return nil
+}
+
+// ResponseCacheWriter 在写出响应的同时记录响应内容，用于写入响应缓存
+type ResponseCacheWriter struct {
+	gin.ResponseWriter
+	c        *gin.Context
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func NewResponseCacheWriter(c *gin.Context) *ResponseCacheWriter {
+	writer := &ResponseCacheWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		limit:          operation_setting.GetResponseCacheSetting().MaxEntrySizeKB << 10,
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *ResponseCacheWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *ResponseCacheWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *ResponseCacheWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Restore 恢复被替换的原始 ResponseWriter，请求失败时也需要调用
+func (w *ResponseCacheWriter) Restore() {
+	w.c.Writer = w.ResponseWriter
+}
+
+// Entry 返回可缓存的响应，响应失败、超过大小限制或流式响应未正常结束时返回 nil
+func (w *ResponseCacheWriter) Entry(isStream bool, usage *dto.Usage) *ResponseCacheEntry {
+	if w.overflow || w.body.Len() == 0 || w.Status() != http.StatusOK {
+		return nil
+	}
+	if isStream && !streamFinished(w.body.Bytes()) {
+		return nil
+	}
+	return &ResponseCacheEntry{
+		IsStream:    isStream,
+		ContentType: w.Header().Get("Content-Type"),
+		Body:        bytes.Clone(w.body.Bytes()),
+		Usage:       usage,
+		CreatedAt:   common.GetTimestamp(),
+	}
+}
+
+// streamFinished 判断 SSE 响应是否正常结束：收到 [DONE] 或带有 finish_reason 的分片，
+// 客户端断开或上游中断时截断的流不能写入缓存
+func streamFinished(body []byte) bool {
+	lines := bytes.Split(body, []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := bytes.TrimSpace(lines[i])
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		data := bytes.TrimSpace(line[len("data:"):])
+		if string(data) == "[DONE]" {
+			return true
+		}
+		var chunk struct {
+			Choices []struct {
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := common.Unmarshal(data, &chunk); err != nil {
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package service
+
+import "testing"
+
+func TestGenerateResponseCacheKey(t *testing.T) {
+	base := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5}`
+	baseKey, err := GenerateResponseCacheKey(1, 1, "gpt-4o", []byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		userId    int
+		modelName string
+		body      string
+		same      bool
+	}{
+		{name: "field order and whitespace", userId: 1, modelName: "gpt-4o", body: `{ "temperature": 0.5, "messages": [{"content":"hi","role":"user"}], "model": "gpt-4o" }`, same: true},
+		{name: "model taken from argument", userId: 1, modelName: "gpt-4o", body: `{"model":"alias","messages":[{"role":"user","content":"hi"}],"temperature":0.5}`, same: true},
+		{name: "different user", userId: 2, modelName: "gpt-4o", body: base},
+		{name: "different model", userId: 1, modelName: "gpt-4o-mini", body: base},
+		{name: "seed", userId: 1, modelName: "gpt-4o", body: `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5,"seed":7}`},
+		{name: "presence penalty", userId: 1, modelName: "gpt-4o", body: `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5,"presence_penalty":1}`},
+		{name: "logit bias", userId: 1, modelName: "gpt-4o", body: `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5,"logit_bias":{"50256":-100}}`},
+		{name: "logprobs", userId: 1, modelName: "gpt-4o", body: `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5,"logprobs":true,"top_logprobs":3}`},
+		{name: "modalities", userId: 1, modelName: "gpt-4o", body: `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5,"modalities":["text","audio"]}`},
+		{name: "user", userId: 1, modelName: "gpt-4o", body: `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0.5,"user":"u1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := GenerateResponseCacheKey(tt.userId, 1, tt.modelName, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (key == baseKey) != tt.same {
+				t.Errorf("key equal = %v, want %v", key == baseKey, tt.same)
+			}
+		})
+	}
+	if _, err := GenerateResponseCacheKey(1, 1, "gpt-4o", []byte("null")); err == nil {
+		t.Error("expected error for empty request body")
+	}
+}
+
+func TestStreamFinished(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{
+			name: "done",
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n",
+			want: true,
+		},
+		{
+			name: "finish reason without done",
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n",
+			want: true,
+		},
+		{
+			name: "usage chunk after finish reason",
+			body: "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"length\"}]}\n\ndata: {\"choices\":[],\"usage\":{\"total_tokens\":3}}\n\n",
+			want: true,
+		},
+		{
+			name: "cut short",
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"},\"finish_reason\":null}]}\n\ndata: {\"choices\":[{\"delta\":{\"content\":\" the\"}}]}\n\n",
+			want: false,
+		},
+		{
+			name: "partial line",
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: {\"choices\":[{\"delta\":{},\"finish_",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := streamFinished([]byte(tt.body)); got != tt.want {
+				t.Errorf("streamFinished() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+var tokenBudgetPeriodNames = map[string]string{
+	model.TokenBudgetPeriodDay:   "每日",
+	model.TokenBudgetPeriodWeek:  "每周",
+	model.TokenBudgetPeriodMonth: "每月",
+}
+
+// CheckTokenBudget 检查令牌各统计周期的额度上限，quota 为本次预计消耗的额度。
+// 检查与用量累加不在同一事务中，并发请求可能同时通过检查，因此上限为软限制，最多超出并发请求的预扣额度
+func CheckTokenBudget(c *gin.Context, relayInfo *relaycommon.RelayInfo, quota int) *types.NewAPIError {
+	if !relayInfo.TokenQuotaBudget || relayInfo.IsPlayground {
+		return nil
+	}
+	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError)
+	}
+	usage, err := model.GetTokenQuotaUsage(token.Id)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError)
+	}
+	period, used, limit, exceeded := exceededTokenBudget(token, usage, quota)
+	if !exceeded {
+		return nil
+	}
+	now := time.Now()
+	resetAt := model.GetTokenBudgetPeriodEnd(period, now)
+	c.Header("Retry-After", strconv.FormatInt(resetAt-now.Unix(), 10))
+	// 每个周期只在第一次拒绝时记录日志并通知用户，之后的请求直接拒绝
+	if shouldNotifyTokenBudgetExceeded(token.Id, period, resetAt, now) {
+		common.LogWarn(c, fmt.Sprintf("token %d %s quota budget exceeded, used: %d, limit: %d", token.Id, period, used, limit))
+		notifyTokenBudgetExceeded(relayInfo, token.Name, period, used, limit)
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("token %s quota budget exceeded, used: %s, limit: %s, need quota: %s, resets at %s",
+		period, common.FormatQuota(used), common.FormatQuota(limit), common.FormatQuota(quota),
+		time.Unix(resetAt, 0).Format("2006-01-02 15:04:05")), types.ErrorCodeTokenBudgetExceeded, http.StatusTooManyRequests)
+}
+
+// exceededTokenBudget 返回第一个已用尽或本次请求会超出上限的统计周期，及该周期的已用额度和上限
+func exceededTokenBudget(token *model.Token, usage map[string]int, quota int) (string, int, int, bool) {
+	for _, period := range model.TokenBudgetPeriods {
+		limit := token.GetQuotaBudgetLimit(period)
+		if limit <= 0 {
+			continue
+		}
+		used := usage[period]
+		if used < limit && used+quota <= limit {
+			continue
+		}
+		return period, used, limit, true
+	}
+	return "", 0, 0, false
+}
+
+// 已发送额度用尽通知的令牌周期，未启用 Redis 时使用，值为周期结束时间
+var tokenBudgetNotified sync.Map
+
+// shouldNotifyTokenBudgetExceeded 每个令牌在每个统计周期内只通知一次，以周期结束时间区分不同周期
+func shouldNotifyTokenBudgetExceeded(tokenId int, period string, resetAt int64, now time.Time) bool {
+	key := fmt.Sprintf("token_budget_notified:%d:%s:%d", tokenId, period, resetAt)
+	if common.RedisEnabled {
+		ok, err := common.RDB.SetNX(context.Background(), key, "1", time.Duration(resetAt-now.Unix())*time.Second).Result()
+		if err != nil {
+			common.SysError("failed to set token budget notify marker: " + err.Error())
+			return false
+		}
+		return ok
+	}
+	if _, loaded := tokenBudgetNotified.LoadOrStore(key, resetAt); loaded {
+		return false
+	}
+	// 清理已结束周期的记录
+	tokenBudgetNotified.Range(func(key, value any) bool {
+		if value.(int64) <= now.Unix() {
+			tokenBudgetNotified.Delete(key)
+		}
+		return true
+	})
+	return true
+}
+
+// recordTokenQuotaUsage 累加令牌的周期用量，quota 为负数时表示退还
+func recordTokenQuotaUsage(relayInfo *relaycommon.RelayInfo, quota int) {
+	if !relayInfo.TokenQuotaBudget || relayInfo.IsPlayground {
+		return
+	}
+	if err := model.IncreaseTokenQuotaUsage(relayInfo.TokenId, quota); err != nil {
+		common.SysError(fmt.Sprintf("failed to record quota usage of token %d: %s", relayInfo.TokenId, err.Error()))
+	}
+}
+
+func notifyTokenBudgetExceeded(relayInfo *relaycommon.RelayInfo, tokenName string, period string, used int, limit int) {
+	userId, userEmail, userSetting := relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting
+	gopool.Go(func() {
+		prompt := fmt.Sprintf("令牌 %s 的%s额度已用尽", tokenName, tokenBudgetPeriodNames[period])
+		content := "{{value}}，已用额度 {{value}}，额度上限 {{value}}，在下一个统计周期开始前该令牌的请求将被拒绝。"
+		err := NotifyUser(userId, userEmail, userSetting, dto.NewNotify(dto.NotifyTypeQuotaExceed, prompt, content, []interface{}{prompt, common.FormatQuota(used), common.FormatQuota(limit)}))
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to send token budget notify to user %d: %s", userId, err.Error()))
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"testing"
+	"time"
+)
+
+func TestExceededTokenBudget(t *testing.T) {
+	token := &model.Token{DailyQuotaLimit: 100, MonthlyQuotaLimit: 1000}
+	tests := []struct {
+		name       string
+		usage      map[string]int
+		quota      int
+		wantPeriod string
+		wantUsed   int
+		wantLimit  int
+	}{
+		{name: "under limit", usage: map[string]int{model.TokenBudgetPeriodDay: 50, model.TokenBudgetPeriodMonth: 500}, quota: 50},
+		{name: "request would exceed the day", usage: map[string]int{model.TokenBudgetPeriodDay: 50}, quota: 51, wantPeriod: model.TokenBudgetPeriodDay, wantUsed: 50, wantLimit: 100},
+		{name: "day used up", usage: map[string]int{model.TokenBudgetPeriodDay: 100}, quota: 0, wantPeriod: model.TokenBudgetPeriodDay, wantUsed: 100, wantLimit: 100},
+		{name: "month used up", usage: map[string]int{model.TokenBudgetPeriodDay: 0, model.TokenBudgetPeriodMonth: 1000}, quota: 1, wantPeriod: model.TokenBudgetPeriodMonth, wantUsed: 1000, wantLimit: 1000},
+		// 未设置周上限时不检查周用量
+		{name: "week without limit", usage: map[string]int{model.TokenBudgetPeriodWeek: 1 << 30}, quota: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			period, used, limit, exceeded := exceededTokenBudget(token, tt.usage, tt.quota)
+			if exceeded != (tt.wantPeriod != "") || period != tt.wantPeriod || used != tt.wantUsed || limit != tt.wantLimit {
+				t.Errorf("exceededTokenBudget() = %q, %d, %d, %v, want %q, %d, %d", period, used, limit, exceeded, tt.wantPeriod, tt.wantUsed, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestShouldNotifyTokenBudgetExceededOncePerPeriod(t *testing.T) {
+	previousRedisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() { common.RedisEnabled = previousRedisEnabled })
+
+	const tokenId = 42
+	now := time.Now()
+	resetAt := model.GetTokenBudgetPeriodEnd(model.TokenBudgetPeriodDay, now)
+	if !shouldNotifyTokenBudgetExceeded(tokenId, model.TokenBudgetPeriodDay, resetAt, now) {
+		t.Fatal("first rejection should notify")
+	}
+	if shouldNotifyTokenBudgetExceeded(tokenId, model.TokenBudgetPeriodDay, resetAt, now) {
+		t.Fatal("second rejection in the same period should not notify")
+	}
+	if !shouldNotifyTokenBudgetExceeded(tokenId, model.TokenBudgetPeriodMonth, model.GetTokenBudgetPeriodEnd(model.TokenBudgetPeriodMonth, now), now) {
+		t.Fatal("another period should notify")
+	}
+	// 下一个周期重新通知，并清理已结束周期的记录
+	next := time.Unix(resetAt, 0).Add(time.Hour)
+	nextResetAt := model.GetTokenBudgetPeriodEnd(model.TokenBudgetPeriodDay, next)
+	if !shouldNotifyTokenBudgetExceeded(tokenId, model.TokenBudgetPeriodDay, nextResetAt, next) {
+		t.Fatal("rejection in the next period should notify")
+	}
+	if _, ok := tokenBudgetNotified.Load(fmt.Sprintf("token_budget_notified:%d:%s:%d", tokenId, model.TokenBudgetPeriodDay, resetAt)); ok {
+		t.Error("marker of the finished period should be cleaned")
+	}
+}
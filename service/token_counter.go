@@ -447,6 +447,18 @@ func CountAudioTokenInput(audioBase64 string, audioFormat string) (int, error) {
 	return int(duration / 60 * 100 / 0.06), nil
 }
 
+// CountSTTToken 按音频时长统计语音识别的 token 数量，与语音识别接口一致，每分钟计 1k tokens
+func CountSTTToken(audioBase64 string, audioFormat string) (int, error) {
+	if audioBase64 == "" {
+		return 0, nil
+	}
+	duration, err := parseAudio(audioBase64, audioFormat)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(math.Ceil(duration) / 60.0 * 1000)), nil
+}
+
 func CountAudioTokenOutput(audioBase64 string, audioFormat string) (int, error) {
 	if audioBase64 == "" {
 		return 0, nil
@@ -0,0 +1,55 @@
+package model_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/setting/config"
+)
+
+type FallbackSettings struct {
+	// 模型 -> 按顺序尝试的备用模型，请求模型的所有渠道均失败时依次切换
+	Chains map[string][]string `json:"chains"`
+	// 上游因内容过滤拒绝时是否切换备用模型，仅对非流式请求生效
+	OnContentFilter bool `json:"on_content_filter"`
+}
+
+// 默认配置
+var fallbackSettings = FallbackSettings{
+	Chains:          map[string][]string{},
+	OnContentFilter: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback", &fallbackSettings)
+}
+
+func GetFallbackSettings() *FallbackSettings {
+	return &fallbackSettings
+}
+
+// GetFallbackChain 获取模型的备用模型列表，备用模型自身的降级链不会继续展开
+func GetFallbackChain(model string) []string {
+	return fallbackSettings.Chains[model]
+}
+
+// CheckFallbackChains 校验降级链配置
+func CheckFallbackChains(jsonStr string) error {
+	chains := make(map[string][]string)
+	if err := json.Unmarshal([]byte(jsonStr), &chains); err != nil {
+		return err
+	}
+	for model, chain := range chains {
+		seen := map[string]bool{model: true}
+		for _, fallback := range chain {
+			if fallback == "" {
+				return fmt.Errorf("模型 %s 的备用模型不能为空", model)
+			}
+			if seen[fallback] {
+				return fmt.Errorf("模型 %s 的降级链中 %s 重复", model, fallback)
+			}
+			seen[fallback] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type ChannelHealthSetting struct {
+	// 启用熔断器，熔断中的渠道/密钥暂时不参与分配，不修改渠道状态
+	CircuitBreakerEnabled bool `json:"circuit_breaker_enabled"`
+	// 滚动统计窗口（秒）
+	WindowSeconds int `json:"window_seconds"`
+	// 窗口内请求数达到该值后才按错误率判断
+	MinRequests int `json:"min_requests"`
+	// 错误率（含慢请求）达到该值时熔断，取值 0-1
+	ErrorRateThreshold float64 `json:"error_rate_threshold"`
+	// 连续失败达到该次数时直接熔断，0 表示不启用
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// 非流式请求耗时超过该值（毫秒）记为慢请求，0 表示不统计
+	SlowRequestMs int `json:"slow_request_ms"`
+	// 熔断持续时间（秒），到期后进入半开状态放行探测请求
+	OpenSeconds int `json:"open_seconds"`
+	// 半开状态下连续探测成功该次数后恢复
+	HalfOpenSuccesses int `json:"half_open_successes"`
+	// 按健康分调整渠道权重
+	HealthWeightEnabled bool `json:"health_weight_enabled"`
+}
+
+// 默认配置
+var channelHealthSetting = ChannelHealthSetting{
+	CircuitBreakerEnabled: false,
+	WindowSeconds:         60,
+	MinRequests:           10,
+	ErrorRateThreshold:    0.5,
+	ConsecutiveFailures:   5,
+	SlowRequestMs:         0,
+	OpenSeconds:           30,
+	HalfOpenSuccesses:     2,
+	HealthWeightEnabled:   false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_health_setting", &channelHealthSetting)
+}
+
+func GetChannelHealthSetting() *ChannelHealthSetting {
+	return &channelHealthSetting
+}
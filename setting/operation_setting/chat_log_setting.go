@@ -0,0 +1,142 @@
+package operation_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/setting/config"
+	"regexp"
+	"strconv"
+)
+
+// 对话日志内置脱敏规则，除 api_key 外与护栏 PII 检测项一致
+const ChatLogRedactApiKey = "api_key"
+
+type ChatLogSetting struct {
+	// 写入前执行的内置脱敏规则：email、phone、id_card、credit_card、api_key
+	RedactRules []string `json:"redact_rules"`
+	// 自定义脱敏正则，命中内容替换为 [REDACTED]
+	CustomRedactPatterns []string `json:"custom_redact_patterns"`
+	// 不记录对话内容的分组，仍记录用量等元数据
+	ContentDisabledGroups []string `json:"content_disabled_groups"`
+	// 默认保留策略
+	ContentRetentionDays  int `json:"content_retention_days"`
+	MetadataRetentionDays int `json:"metadata_retention_days"`
+	// 分组、用户级保留策略，优先级：用户 > 分组 > 默认
+	GroupRetention map[string]ChatLogRetention `json:"group_retention"`
+	UserRetention  map[int]ChatLogRetention    `json:"user_retention"`
+	// 后台清理每批处理的记录数
+	RetentionBatchSize int `json:"retention_batch_size"`
+}
+
+type ChatLogRetention struct {
+	// 对话内容保留天数，到期后清空内容，0 表示永久保留
+	ContentDays int `json:"content_days"`
+	// 整条记录保留天数，到期后删除，0 表示永久保留
+	MetadataDays int `json:"metadata_days"`
+}
+
+// 默认配置
+var chatLogSetting = ChatLogSetting{
+	RedactRules:           []string{},
+	CustomRedactPatterns:  []string{},
+	ContentDisabledGroups: []string{},
+	GroupRetention:        map[string]ChatLogRetention{},
+	UserRetention:         map[int]ChatLogRetention{},
+	RetentionBatchSize:    500,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("chat_log_setting", &chatLogSetting)
+}
+
+func GetChatLogSetting() *ChatLogSetting {
+	return &chatLogSetting
+}
+
+// IsChatLogContentDisabled 判断分组是否关闭了对话内容记录
+func IsChatLogContentDisabled(group string) bool {
+	for _, g := range chatLogSetting.ContentDisabledGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckChatLogRedactRules 校验内置脱敏规则
+func CheckChatLogRedactRules(jsonStr string) error {
+	var rules []string
+	if err := json.Unmarshal([]byte(jsonStr), &rules); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		switch rule {
+		case GuardrailPIIEmail, GuardrailPIIPhone, GuardrailPIIIdCard, GuardrailPIICreditCard, ChatLogRedactApiKey:
+		default:
+			return fmt.Errorf("未知的脱敏规则 %s", rule)
+		}
+	}
+	return nil
+}
+
+// CheckChatLogRedactPatterns 校验自定义脱敏正则
+func CheckChatLogRedactPatterns(jsonStr string) error {
+	var patterns []string
+	if err := json.Unmarshal([]byte(jsonStr), &patterns); err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("正则表达式 %s 无效: %s", pattern, err.Error())
+		}
+	}
+	return nil
+}
+
+// CheckChatLogRetentionDays 校验默认保留天数
+func CheckChatLogRetentionDays(value string) error {
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if days < 0 {
+		return fmt.Errorf("保留天数不能为负数")
+	}
+	return nil
+}
+
+// CheckChatLogGroupRetention 校验分组保留策略
+func CheckChatLogGroupRetention(jsonStr string) error {
+	retention := make(map[string]ChatLogRetention)
+	if err := json.Unmarshal([]byte(jsonStr), &retention); err != nil {
+		return err
+	}
+	for group, r := range retention {
+		if err := checkChatLogRetention(r); err != nil {
+			return fmt.Errorf("分组 %s 保留策略错误: %s", group, err.Error())
+		}
+	}
+	return nil
+}
+
+// CheckChatLogUserRetention 校验用户保留策略
+func CheckChatLogUserRetention(jsonStr string) error {
+	retention := make(map[int]ChatLogRetention)
+	if err := json.Unmarshal([]byte(jsonStr), &retention); err != nil {
+		return err
+	}
+	for userId, r := range retention {
+		if err := checkChatLogRetention(r); err != nil {
+			return fmt.Errorf("用户 %d 保留策略错误: %s", userId, err.Error())
+		}
+	}
+	return nil
+}
+
+func checkChatLogRetention(r ChatLogRetention) error {
+	if r.ContentDays < 0 || r.MetadataDays < 0 {
+		return fmt.Errorf("保留天数不能为负数")
+	}
+	return nil
+}
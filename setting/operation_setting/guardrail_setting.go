@@ -0,0 +1,186 @@
+package operation_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/setting/config"
+	"regexp"
+)
+
+// 护栏检查阶段类型
+const (
+	GuardrailStageKeyword    = "keyword"
+	GuardrailStageRegex      = "regex"
+	GuardrailStagePII        = "pii"
+	GuardrailStageModeration = "moderation"
+)
+
+// 命中后的处理方式
+const (
+	GuardrailActionBlock  = "block"
+	GuardrailActionRedact = "redact"
+	GuardrailActionFlag   = "flag"
+)
+
+// 检查范围
+const (
+	GuardrailScopePrompt     = "prompt"
+	GuardrailScopeCompletion = "completion"
+	GuardrailScopeBoth       = "both"
+)
+
+// PII 检测项
+const (
+	GuardrailPIIEmail      = "email"
+	GuardrailPIIPhone      = "phone"
+	GuardrailPIIIdCard     = "id_card"
+	GuardrailPIICreditCard = "credit_card"
+)
+
+// GuardrailPolicyNone 令牌或分组指定该策略时不做任何检查
+const GuardrailPolicyNone = "none"
+
+type GuardrailStage struct {
+	Type   string `json:"type"`
+	Action string `json:"action"`
+	// prompt、completion 或 both，为空时等同 both
+	Scope string `json:"scope,omitempty"`
+	// keyword 为关键词（为空时使用全局敏感词），regex 为正则表达式
+	Patterns []string `json:"patterns,omitempty"`
+	// pii 检测项，为空时检测全部
+	Detectors []string `json:"detectors,omitempty"`
+	// moderation 调用的渠道与模型
+	ChannelId int    `json:"channel_id,omitempty"`
+	Model     string `json:"model,omitempty"`
+	// moderation 仅对这些类别生效，为空时使用接口返回的 flagged
+	Categories []string `json:"categories,omitempty"`
+	// moderation 接口调用失败时是否拦截
+	FailClosed bool `json:"fail_closed,omitempty"`
+}
+
+// InScope 判断阶段是否作用于指定范围
+func (s *GuardrailStage) InScope(scope string) bool {
+	return s.Scope == "" || s.Scope == GuardrailScopeBoth || s.Scope == scope
+}
+
+type GuardrailSetting struct {
+	Enabled bool `json:"enabled"`
+	// 策略名 -> 按顺序执行的检查阶段
+	Policies      map[string][]GuardrailStage `json:"policies"`
+	DefaultPolicy string                      `json:"default_policy"`
+	// 分组 -> 策略名，优先级低于令牌配置
+	GroupPolicies map[string]string `json:"group_policies"`
+	// 流式输出需要脱敏时暂缓输出的尾部字符数，用于识别跨分片的内容
+	StreamHoldbackChars int `json:"stream_holdback_chars"`
+}
+
+// 默认配置
+var guardrailSetting = GuardrailSetting{
+	Enabled:             false,
+	Policies:            map[string][]GuardrailStage{},
+	DefaultPolicy:       "",
+	GroupPolicies:       map[string]string{},
+	StreamHoldbackChars: 64,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("guardrail_setting", &guardrailSetting)
+}
+
+func GetGuardrailSetting() *GuardrailSetting {
+	return &guardrailSetting
+}
+
+// HasGuardrailPolicy 判断策略是否存在，none 视为存在
+func HasGuardrailPolicy(name string) bool {
+	if name == GuardrailPolicyNone {
+		return true
+	}
+	_, ok := guardrailSetting.Policies[name]
+	return ok
+}
+
+// GetGuardrailStages 按令牌、分组、默认策略的顺序获取生效的检查阶段，未启用时返回 nil；
+// 令牌指定的策略不存在时忽略，不会跳过分组与默认策略
+func GetGuardrailStages(tokenPolicy string, group string) []GuardrailStage {
+	if !guardrailSetting.Enabled {
+		return nil
+	}
+	name := tokenPolicy
+	if name != "" && !HasGuardrailPolicy(name) {
+		name = ""
+	}
+	if name == "" {
+		name = guardrailSetting.GroupPolicies[group]
+	}
+	if name == "" {
+		name = guardrailSetting.DefaultPolicy
+	}
+	if name == "" || name == GuardrailPolicyNone {
+		return nil
+	}
+	return guardrailSetting.Policies[name]
+}
+
+// CheckGuardrailPolicies 校验策略配置
+func CheckGuardrailPolicies(jsonStr string) error {
+	policies := make(map[string][]GuardrailStage)
+	if err := json.Unmarshal([]byte(jsonStr), &policies); err != nil {
+		return err
+	}
+	for name, stages := range policies {
+		if name == GuardrailPolicyNone {
+			return fmt.Errorf("策略名 %s 为保留名称", name)
+		}
+		for i, stage := range stages {
+			if err := checkGuardrailStage(stage); err != nil {
+				return fmt.Errorf("策略 %s 第 %d 个阶段配置错误: %s", name, i+1, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func checkGuardrailStage(stage GuardrailStage) error {
+	switch stage.Action {
+	case GuardrailActionBlock, GuardrailActionRedact, GuardrailActionFlag:
+	default:
+		return fmt.Errorf("未知的处理方式 %s", stage.Action)
+	}
+	switch stage.Scope {
+	case "", GuardrailScopePrompt, GuardrailScopeCompletion, GuardrailScopeBoth:
+	default:
+		return fmt.Errorf("未知的检查范围 %s", stage.Scope)
+	}
+	switch stage.Type {
+	case GuardrailStageKeyword:
+	case GuardrailStageRegex:
+		if len(stage.Patterns) == 0 {
+			return fmt.Errorf("正则表达式不能为空")
+		}
+		for _, pattern := range stage.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("正则表达式 %s 无效: %s", pattern, err.Error())
+			}
+		}
+	case GuardrailStagePII:
+		for _, detector := range stage.Detectors {
+			switch detector {
+			case GuardrailPIIEmail, GuardrailPIIPhone, GuardrailPIIIdCard, GuardrailPIICreditCard:
+			default:
+				return fmt.Errorf("未知的 PII 检测项 %s", detector)
+			}
+		}
+	case GuardrailStageModeration:
+		if stage.ChannelId == 0 {
+			return fmt.Errorf("审核渠道不能为空")
+		}
+		if stage.Action == GuardrailActionRedact {
+			return fmt.Errorf("审核阶段不支持脱敏")
+		}
+	default:
+		return fmt.Errorf("未知的阶段类型 %s", stage.Type)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type ResponseCacheSetting struct {
+	Enabled bool `json:"enabled"`
+	// 默认缓存时间（秒），0 表示仅对单独配置了缓存时间的令牌/分组生效
+	DefaultTTL int `json:"default_ttl"`
+	// 分组缓存时间（秒），优先级低于令牌配置，小于 0 表示该分组不缓存
+	GroupTTL map[string]int `json:"group_ttl"`
+	// 命中缓存时按原价的该比例计费
+	HitQuotaRatio float64 `json:"hit_quota_ratio"`
+	// 单条缓存响应的最大大小（KB），超过则不缓存
+	MaxEntrySizeKB int `json:"max_entry_size_kb"`
+	// 未启用 Redis 时内存缓存的最大条目数
+	MemoryMaxEntries int `json:"memory_max_entries"`
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:          false,
+	DefaultTTL:       0,
+	GroupTTL:         map[string]int{},
+	HitQuotaRatio:    0.1,
+	MaxEntrySizeKB:   512,
+	MemoryMaxEntries: 10000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
+
+// GetResponseCacheTTL 按令牌、分组、默认值的顺序获取缓存时间（秒），返回 0 表示不缓存
+func GetResponseCacheTTL(tokenTTL int, group string) int {
+	if !responseCacheSetting.Enabled {
+		return 0
+	}
+	if tokenTTL != 0 {
+		return max(tokenTTL, 0)
+	}
+	if ttl, ok := responseCacheSetting.GroupTTL[group]; ok {
+		return max(ttl, 0)
+	}
+	return max(responseCacheSetting.DefaultTTL, 0)
+}
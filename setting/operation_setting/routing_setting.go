@@ -0,0 +1,51 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// 同一优先级内的渠道选择策略
+const (
+	RoutingStrategyWeightedRandom = "weighted_random"
+	RoutingStrategyLeastLatency   = "least_latency"
+	RoutingStrategyLeastCost      = "least_cost"
+	RoutingStrategyRoundRobin     = "round_robin"
+)
+
+type RoutingSetting struct {
+	DefaultStrategy string `json:"default_strategy"`
+	// 分组 -> 策略，未配置的分组使用默认策略
+	GroupStrategies map[string]string `json:"group_strategies"`
+}
+
+// 默认配置
+var routingSetting = RoutingSetting{
+	DefaultStrategy: RoutingStrategyWeightedRandom,
+	GroupStrategies: map[string]string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("routing_setting", &routingSetting)
+}
+
+func GetRoutingSetting() *RoutingSetting {
+	return &routingSetting
+}
+
+func IsValidRoutingStrategy(strategy string) bool {
+	switch strategy {
+	case RoutingStrategyWeightedRandom, RoutingStrategyLeastLatency, RoutingStrategyLeastCost, RoutingStrategyRoundRobin:
+		return true
+	}
+	return false
+}
+
+// GetGroupRoutingStrategy 获取分组的渠道选择策略
+func GetGroupRoutingStrategy(group string) string {
+	if strategy, ok := routingSetting.GroupStrategies[group]; ok && IsValidRoutingStrategy(strategy) {
+		return strategy
+	}
+	if IsValidRoutingStrategy(routingSetting.DefaultStrategy) {
+		return routingSetting.DefaultStrategy
+	}
+	return RoutingStrategyWeightedRandom
+}
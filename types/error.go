@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type OpenAIError struct {
@@ -35,6 +36,7 @@ type ErrorCode string
 const (
 	ErrorCodeInvalidRequest         ErrorCode = "invalid_request"
 	ErrorCodeSensitiveWordsDetected ErrorCode = "sensitive_words_detected"
+	ErrorCodeGuardrailBlocked       ErrorCode = "guardrail_blocked"
 
 	// new api error
 	ErrorCodeCountTokenFailed  ErrorCode = "count_token_failed"
@@ -44,6 +46,9 @@ const (
 	ErrorCodeDoRequestFailed   ErrorCode = "do_request_failed"
 	ErrorCodeGetChannelFailed  ErrorCode = "get_channel_failed"
 
+	// 多密钥渠道的密钥均在冷却或达到速率上限，不应禁用渠道
+	ErrorCodeChannelKeysRateLimited ErrorCode = "channel_keys_rate_limited"
+
 	// channel error
 	ErrorCodeChannelNoAvailableKey       ErrorCode = "channel:no_available_key"
 	ErrorCodeChannelParamOverrideInvalid ErrorCode = "channel:param_override_invalid"
@@ -62,6 +67,7 @@ const (
 	ErrorCodeBadResponseStatusCode  ErrorCode = "bad_response_status_code"
 	ErrorCodeBadResponse            ErrorCode = "bad_response"
 	ErrorCodeBadResponseBody        ErrorCode = "bad_response_body"
+	ErrorCodeContentFiltered        ErrorCode = "content_filtered"
 
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
@@ -70,6 +76,7 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+	ErrorCodeTokenBudgetExceeded        ErrorCode = "token_budget_exceeded"
 )
 
 type NewAPIError struct {
@@ -78,6 +85,8 @@ type NewAPIError struct {
 	ErrorType  ErrorType
 	errorCode  ErrorCode
 	StatusCode int
+	// 上游通过 Retry-After 等响应头指定的重试等待时间
+	RetryAfter time.Duration
 }
 
 func (e *NewAPIError) GetErrorCode() ErrorCode {
@@ -7,6 +7,7 @@ import {
   renderGroupOption,
   renderQuotaWithPrompt,
   getModelCategories,
+  isAdmin,
 } from '../../helpers';
 import { useIsMobile } from '../../hooks/useIsMobile.js';
 import {
@@ -42,6 +43,7 @@ const EditToken = (props) => {
   const formApiRef = useRef(null);
   const [models, setModels] = useState([]);
   const [groups, setGroups] = useState([]);
+  const [organizations, setOrganizations] = useState([]);
   const isEdit = props.editingToken.id !== undefined;
 
   const getInitValues = () => ({
@@ -53,6 +55,13 @@ const EditToken = (props) => {
     model_limits: [],
     allow_ips: '',
     group: '',
+    response_cache_ttl: 0,
+    daily_quota_limit: 0,
+    weekly_quota_limit: 0,
+    monthly_quota_limit: 0,
+    rpm_limit: 0,
+    org_id: 0,
+    guardrail_policy: '',
     tokenCount: 1,
   });
 
@@ -130,6 +139,17 @@ const EditToken = (props) => {
     }
   };
 
+  const loadOrganizations = async () => {
+    let res = await API.get(`/api/org/self`);
+    const { success, data } = res.data;
+    if (success) {
+      setOrganizations([
+        { label: t('个人'), value: 0 },
+        ...(data || []).map((org) => ({ label: org.name, value: org.id })),
+      ]);
+    }
+  };
+
   const loadToken = async () => {
     setLoading(true);
     let res = await API.get(`/api/token/${props.editingToken.id}`);
@@ -160,6 +180,7 @@ const EditToken = (props) => {
     }
     loadModels();
     loadGroups();
+    loadOrganizations();
   }, [props.editingToken.id]);
 
   useEffect(() => {
@@ -444,6 +465,18 @@ const EditToken = (props) => {
                   </div>
                 </div>
                 <Row gutter={12}>
+                  {organizations.length > 1 && (
+                    <Col span={24}>
+                      <Form.Select
+                        field='org_id'
+                        label={t('所属组织')}
+                        optionList={organizations}
+                        disabled={isEdit}
+                        extraText={t('组织令牌从组织的共享额度池扣费，成员退出组织后令牌转移给组织所有者')}
+                        style={{ width: '100%' }}
+                      />
+                    </Col>
+                  )}
                   <Col span={24}>
                     <Form.AutoComplete
                       field='remain_quota'
@@ -471,6 +504,42 @@ const EditToken = (props) => {
                       extraText={t('令牌的额度仅用于限制令牌本身的最大额度使用量，实际的使用受到账户的剩余额度限制')}
                     />
                   </Col>
+                  <Col span={8}>
+                    <Form.InputNumber
+                      field='daily_quota_limit'
+                      label={t('每日额度上限')}
+                      min={0}
+                      extraText={renderQuotaWithPrompt(values.daily_quota_limit)}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={8}>
+                    <Form.InputNumber
+                      field='weekly_quota_limit'
+                      label={t('每周额度上限')}
+                      min={0}
+                      extraText={renderQuotaWithPrompt(values.weekly_quota_limit)}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={8}>
+                    <Form.InputNumber
+                      field='monthly_quota_limit'
+                      label={t('每月额度上限')}
+                      min={0}
+                      extraText={renderQuotaWithPrompt(values.monthly_quota_limit)}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  <Col span={24}>
+                    <Form.InputNumber
+                      field='rpm_limit'
+                      label={t('每分钟请求数上限')}
+                      min={0}
+                      extraText={t('额度上限按自然日、自然周（周一开始）、自然月自动重置，0 表示不限制，超出后请求返回 429')}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
                 </Row>
               </Card>
 
@@ -512,6 +581,27 @@ const EditToken = (props) => {
                       style={{ width: '100%' }}
                     />
                   </Col>
+                  <Col span={24}>
+                    <Form.InputNumber
+                      field='response_cache_ttl'
+                      label={t('响应缓存时间（秒）')}
+                      min={-1}
+                      extraText={t('相同请求在缓存时间内直接返回缓存结果并按折扣计费，0 表示跟随分组配置，-1 表示不缓存')}
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
+                  {isAdmin() && (
+                    <Col span={24}>
+                      <Form.Input
+                        field='guardrail_policy'
+                        label={t('护栏策略')}
+                        placeholder={t('留空跟随分组配置')}
+                        extraText={t('对请求与响应内容执行的检查策略名称，none 表示不检查')}
+                        showClear
+                        style={{ width: '100%' }}
+                      />
+                    </Col>
+                  )}
                 </Row>
               </Card>
             </div>